@@ -4,7 +4,39 @@ type contextKey string
 type sessionKey string
 
 const isAuthenticatedContextKey = contextKey("isAuthenticated")
+const userRoleContextKey = contextKey("userRole")
 
 const authenticatedUserID = sessionKey("authenticatedUserID")
 const redirectAfterLogin = sessionKey("redirectAfterLogin")
 const flash = sessionKey("flash")
+
+// Session keys identifying the user_sessions record for a logged in session,
+// and when it was last updated (as a Unix timestamp).
+const sessionID = sessionKey("sessionID")
+const sessionLastSeen = sessionKey("sessionLastSeen")
+
+// Session keys for a login that is waiting for the user's second factor. The
+// expiry is stored as a Unix timestamp.
+const twoFactorUserID = sessionKey("twoFactorUserID")
+const twoFactorExpiry = sessionKey("twoFactorExpiry")
+const twoFactorAttempts = sessionKey("twoFactorAttempts")
+const twoFactorRememberMe = sessionKey("twoFactorRememberMe")
+
+// Session keys for a login with an OpenID Connect provider, from when the user
+// is sent to the provider until they come back.
+const oidcProviderName = sessionKey("oidcProvider")
+const oidcState = sessionKey("oidcState")
+const oidcNonce = sessionKey("oidcNonce")
+const oidcVerifier = sessionKey("oidcVerifier")
+
+// Session keys for the WebAuthn session data of a passkey registration or
+// login, from when it begins until the browser returns the credential.
+const passkeyRegistration = sessionKey("passkeyRegistration")
+const passkeyLogin = sessionKey("passkeyLogin")
+
+// Session key for the TOTP secret being enrolled, until the user confirms it.
+const totpSetupSecret = sessionKey("totpSetupSecret")
+
+// Session key for the ID of the team the user has switched to. New snippets
+// are added to it by default.
+const currentTeamID = sessionKey("currentTeamID")
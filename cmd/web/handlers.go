@@ -1,14 +1,32 @@
 package main
 
 import (
+	"archive/zip"
+	"bytes"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"regexp"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/kvnloughead/snippetbox/internal/avatar"
+	"github.com/kvnloughead/snippetbox/internal/blob"
+	"github.com/kvnloughead/snippetbox/internal/bundle"
+	"github.com/kvnloughead/snippetbox/internal/diff"
+	"github.com/kvnloughead/snippetbox/internal/markdown"
 	"github.com/kvnloughead/snippetbox/internal/models"
+	"github.com/kvnloughead/snippetbox/internal/totp"
 	"github.com/kvnloughead/snippetbox/internal/validator"
+	"github.com/skip2/go-qrcode"
 )
 
 //
@@ -28,12 +46,33 @@ func (app *application) home(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	mostStarred, err := app.snippets.MostStarred(time.Now().AddDate(0, 0, -7), 5)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
 	data := app.newTemplateData(r)
 	data.Snippets = snippets
+	data.MostStarred = mostStarred
 
 	app.render(w, r, http.StatusOK, "home.tmpl", data)
 }
 
+// Displays the snippets with the most views in the last trendingPeriod.
+func (app *application) trending(w http.ResponseWriter, r *http.Request) {
+	snippets, err := app.snippets.Trending(time.Now().Add(-trendingPeriod), 10)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Snippets = snippets
+
+	app.render(w, r, http.StatusOK, "trending.tmpl", data)
+}
+
 // Displays about page in response to GET /about.
 func (app *application) about(w http.ResponseWriter, r *http.Request) {
 	data := app.newTemplateData(r)
@@ -46,26 +85,212 @@ func (app *application) about(w http.ResponseWriter, r *http.Request) {
 
 // Struct containing form fields for the /snippet/create form.
 type snippetCreateForm struct {
-	Title               string     `form:"title"`
-	Content             string     `form:"content"`
-	Expires             int        `form:"expires"`
-	validator.Validator `form:"-"` // "-" tells formDecoder to ignore the field
+	Title               string            `form:"title"`
+	Format              string            `form:"format"` // plain if empty
+	Files               []snippetFileForm `form:"files"`
+	Expires             int               `form:"expires"`
+	Tags                string            `form:"tags"`    // comma separated
+	Team                int               `form:"team"`    // ID of the team, or 0 if public
+	Parent              int               `form:"parent"`  // ID of the forked snippet
+	AddFile             bool              `form:"addFile"` // true if "Add file" was clicked
+	validator.Validator `form:"-"`        // "-" tells formDecoder to ignore the field
+}
+
+// Struct containing the fields of one of the files in the snippet create and
+// edit forms. The form decoder fills a slice of these from indexed fields, such
+// as files[0].name.
+type snippetFileForm struct {
+	Name     string `form:"name"`
+	Language string `form:"language"`
+	Content  string `form:"content"`
+}
+
+// The languages that a snippet's files can be written in.
+var languages = []string{
+	"text", "c", "cpp", "css", "dockerfile", "go", "html", "java", "javascript",
+	"json", "markdown", "python", "ruby", "rust", "shell", "sql", "toml",
+	"typescript", "yaml",
+}
+
+// The maximum number of files a snippet can have, and the maximum length of
+// each file's name and content.
+const (
+	maxFiles             = 10
+	maxFileNameLength    = 100
+	maxFileContentLength = 100000
+)
+
+// Returns the files in a snippet create or edit form, with their names
+// trimmed. Files whose name and content are both blank are dropped, which is
+// how files are removed from a snippet.
+func parseFiles(forms []snippetFileForm) []snippetFileForm {
+	var files []snippetFileForm
+	for _, f := range forms {
+		f.Name = strings.TrimSpace(f.Name)
+		if f.Name != "" || validator.NotBlank(f.Content) {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// Converts the files in a snippet create or edit form to snippet files.
+func snippetFiles(forms []snippetFileForm) []models.SnippetFile {
+	files := make([]models.SnippetFile, len(forms))
+	for i, f := range forms {
+		files[i] = models.SnippetFile(f)
+	}
+	return files
+}
+
+// Converts snippet files to the files in a snippet create or edit form.
+func snippetFileForms(files []models.SnippetFile) []snippetFileForm {
+	forms := make([]snippetFileForm, len(files))
+	for i, f := range files {
+		forms[i] = snippetFileForm(f)
+	}
+	return forms
+}
+
+// The maximum number of tags a snippet can have, and the maximum length of
+// each tag.
+const (
+	maxTags      = 5
+	maxTagLength = 30
+)
+
+// Splits a comma separated list of tags into a slice of tags. Tags are trimmed
+// and lowercased, and empty and duplicate tags are dropped.
+func parseTags(s string) []string {
+	var tags []string
+	for _, tag := range strings.Split(s, ",") {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag != "" && !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+/*
+Validates the title and files shared by the snippet create and edit forms.
+
+Errors for a file's fields are added with the same names as the fields in the
+form, e.g. files[0].name.
+*/
+func checkSnippet(v *validator.Validator, title string, files []models.SnippetFile) {
+	v.CheckField(validator.NotBlank(title), "title", "This field can't be blank.")
+	v.CheckField(validator.MaxChars(title, 100), "title", "This can't contain more than 100 characters.")
+
+	v.CheckField(len(files) > 0, "files", "A snippet must contain at least one file.")
+	v.CheckField(validator.MaxItems(files, maxFiles), "files", fmt.Sprintf("This can't contain more than %d files.", maxFiles))
+
+	seen := make(map[string]bool)
+	for i, f := range files {
+		field := func(name string) string {
+			return fmt.Sprintf("files[%d].%s", i, name)
+		}
+
+		v.CheckField(validator.NotBlank(f.Name), field("name"), "This field can't be blank.")
+		v.CheckField(validator.MaxChars(f.Name, maxFileNameLength), field("name"), fmt.Sprintf("This can't contain more than %d characters.", maxFileNameLength))
+		v.CheckField(validator.Matches(f.Name, validator.FileNameRX) && !validator.PermittedValue(f.Name, ".", ".."), field("name"), "File names can only contain letters, numbers, dots, hyphens and underscores.")
+		v.CheckField(!seen[f.Name], field("name"), "Each file must have a different name.")
+		v.CheckField(validator.PermittedValue(f.Language, languages...), field("language"), "This field must be one of the listed languages.")
+		v.CheckField(validator.NotBlank(f.Content), field("content"), "This field can't be blank.")
+		v.CheckField(validator.MaxChars(f.Content, maxFileContentLength), field("content"), fmt.Sprintf("This can't contain more than %d characters.", maxFileContentLength))
+		seen[f.Name] = true
+	}
+}
+
+// The formats that a snippet can be written in.
+var formats = []string{models.FormatPlain, models.FormatMarkdown}
+
+// Validates a snippet's format.
+func checkFormat(v *validator.Validator, format string) {
+	v.CheckField(validator.PermittedValue(format, formats...), "format", "This field must equal plain or markdown.")
+}
+
+// Validates a snippet's tags, as returned by parseTags.
+func checkTags(v *validator.Validator, tags []string) {
+	v.CheckField(validator.MaxItems(tags, maxTags), "tags", fmt.Sprintf("This can't contain more than %d tags.", maxTags))
+	v.CheckField(validator.All(tags, func(tag string) bool {
+		return validator.MaxChars(tag, maxTagLength)
+	}), "tags", fmt.Sprintf("Tags can't contain more than %d characters.", maxTagLength))
+	v.CheckField(validator.All(tags, func(tag string) bool {
+		return validator.Matches(tag, validator.TagRX)
+	}), "tags", "Tags can only contain letters, numbers, hyphens and underscores.")
 }
 
 // View page for the snippet with the given ID.
 // If there's no matching snippet a 404 NotFound response is sent.
 func (app *application) snippetView(w http.ResponseWriter, r *http.Request) {
-	// Params are stored by httprouter in the request context.
+	snippet, ok := app.snippetFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	app.views.Record(snippet.ID, app.viewer(r))
+
+	app.renderSnippet(w, r, http.StatusOK, snippet, commentForm{})
+}
+
+// Renders the view page of a snippet, with its comments. The form is the
+// comment form, which has errors if a comment couldn't be posted.
+func (app *application) renderSnippet(w http.ResponseWriter, r *http.Request, status int, snippet models.Snippet, form commentForm) {
+	comments, err := app.comments.GetForSnippet(snippet.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Snippet = snippet
+	data.Comments = comments
+	data.Form = form
+
+	data.IsOwner, err = app.canManage(r, snippet)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if data.IsAuthenticated {
+		data.Starred, err = app.stars.Exists(data.UserID, snippet.ID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	// Markdown snippets are rendered here, rather than in the template, so that
+	// their heading IDs are unique across the snippet's files.
+	if snippet.Format == models.FormatMarkdown {
+		contents := make([]string, len(snippet.Files))
+		for i, f := range snippet.Files {
+			contents[i] = f.Content
+		}
+		data.Markdown, err = markdown.Render(contents...)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	app.render(w, r, status, "view.tmpl", data)
+}
+
+// Sends the content of one of a snippet's files as plain text. If there's no
+// matching snippet or file, a 404 NotFound response is sent.
+func (app *application) snippetRaw(w http.ResponseWriter, r *http.Request) {
 	params := httprouter.ParamsFromContext(r.Context())
 
-	// Once parsed, params are available by params.ByName().
 	id, err := strconv.Atoi(params.ByName("id"))
 	if err != nil || id < 1 {
 		app.notFound(w)
 		return
 	}
 
-	snippet, err := app.snippets.Get(id)
+	snippet, err := app.getSnippet(r, id)
 	if err != nil {
 		if errors.Is(err, models.ErrNoRecord) {
 			app.notFound(w)
@@ -75,15 +300,64 @@ func (app *application) snippetView(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	data := app.newTemplateData(r)
-	data.Snippet = snippet
+	file, ok := snippet.File(params.ByName("name"))
+	if !ok {
+		app.notFound(w)
+		return
+	}
 
-	app.render(w, r, http.StatusOK, "view.tmpl", data)
+	// Files are always sent as plain text, so that they can't be rendered as
+	// HTML by the browser.
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(file.Content))
 }
 
+/*
+Displays the form to create a snippet.
+
+If the fork query parameter contains the ID of a snippet, the form is pre-filled
+with that snippet's title, content and tags, and the new snippet is recorded as
+a fork of it. If there's no matching snippet, a 404 NotFound response is sent.
+
+The snippet is added to the user's current team by default. Forks are added to
+the team of the snippet they were forked from.
+*/
 func (app *application) snippetCreate(w http.ResponseWriter, r *http.Request) {
 	data := app.newTemplateData(r)
-	data.Form = snippetCreateForm{Expires: 365}
+
+	form := snippetCreateForm{
+		Format:  models.FormatPlain,
+		Files:   []snippetFileForm{{Language: "text"}},
+		Expires: 365,
+		Team:    data.CurrentTeamID,
+	}
+
+	if fork := r.URL.Query().Get("fork"); fork != "" {
+		id, err := strconv.Atoi(fork)
+		if err != nil || id < 1 {
+			app.notFound(w)
+			return
+		}
+
+		parent, err := app.getSnippet(r, id)
+		if err != nil {
+			if errors.Is(err, models.ErrNoRecord) {
+				app.notFound(w)
+			} else {
+				app.serverError(w, r, err)
+			}
+			return
+		}
+
+		form.Title = parent.Title
+		form.Format = parent.Format
+		form.Files = snippetFileForms(parent.Files)
+		form.Tags = strings.Join(parent.Tags, ", ")
+		form.Team = parent.TeamID
+		form.Parent = parent.ID
+	}
+
+	data.Form = form
 	app.render(w, r, http.StatusOK, "create.tmpl", data)
 }
 
@@ -107,12 +381,56 @@ func (app *application) snippetCreatePost(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	// The "Add file" button submits the form, so that it works without
+	// JavaScript. The form is displayed again with an extra, empty file.
+	if form.AddFile {
+		form.Files = append(form.Files, snippetFileForm{Language: "text"})
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusOK, "create.tmpl", data)
+		return
+	}
+
 	// Validate all form fields.
-	form.CheckField(validator.NotBlank(form.Title), "title", "This field can't be blank.")
-	form.CheckField(validator.MaxChars(form.Title, 100), "title", "This can't contain more than 100 characters.")
-	form.CheckField(validator.NotBlank(form.Content), "content", "This field can't be blank.")
+	if form.Format == "" {
+		form.Format = models.FormatPlain
+	}
+	form.Files = parseFiles(form.Files)
+	files := snippetFiles(form.Files)
+	tags := parseTags(form.Tags)
+	checkSnippet(&form.Validator, form.Title, files)
+	checkFormat(&form.Validator, form.Format)
+	checkTags(&form.Validator, tags)
 	form.CheckField(validator.PermittedValue(form.Expires, 1, 7, 365), "expires", "This field must equal 1, 7, or 365.")
 
+	// Snippets can only be added to teams that the user is a member of.
+	if form.Team != 0 {
+		_, err = app.teamRole(r, form.Team)
+		if err != nil {
+			if !errors.Is(err, models.ErrNoRecord) {
+				app.serverError(w, r, err)
+				return
+			}
+			form.AddFieldError("team", "You aren't a member of this team.")
+		}
+	}
+
+	// A fork's parent may have expired since the form was displayed. Forks of
+	// team snippets have to stay in the team, so that they stay private.
+	if form.Parent != 0 {
+		parent, err := app.getSnippet(r, form.Parent)
+		if err != nil {
+			if !errors.Is(err, models.ErrNoRecord) {
+				app.serverError(w, r, err)
+				return
+			}
+			form.AddNonFieldError("The snippet you are forking no longer exists.")
+			form.Parent = 0
+		} else if parent.TeamID != 0 && form.Team != parent.TeamID {
+			form.AddFieldError("team", "Forks of team snippets must stay in the team.")
+		}
+	}
+
 	// If there are any validation errors, render the page again with the errors.
 	if !form.Valid() {
 		data := app.newTemplateData(r)
@@ -121,8 +439,18 @@ func (app *application) snippetCreatePost(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	// Insert new record or respond with a server error.
-	id, err := app.snippets.Insert(form.Title, form.Content, form.Expires)
+	// Insert new record or respond with a server error. The logged in user is
+	// the snippet's owner.
+	userID := app.sessionManager.GetInt(r.Context(), string(authenticatedUserID))
+	snippet := models.NewSnippet{
+		TeamID:  form.Team,
+		Title:   form.Title,
+		Format:  form.Format,
+		Files:   files,
+		Tags:    tags,
+		Expires: form.Expires,
+	}
+	id, err := app.snippets.Insert(userID, snippet, form.Parent)
 	if err != nil {
 		app.serverError(w, r, err)
 		return
@@ -137,165 +465,1845 @@ func (app *application) snippetCreatePost(w http.ResponseWriter, r *http.Request
 	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", id), http.StatusSeeOther)
 }
 
-//
-// User handlers
+// Struct containing form fields for the /snippet/edit/:id form.
+type snippetEditForm struct {
+	Title               string            `form:"title"`
+	Files               []snippetFileForm `form:"files"`
+	AddFile             bool              `form:"addFile"`
+	validator.Validator `form:"-"`
+}
 
-// Struct containing form fields for the /user/signup form.
-type userSignupForm struct {
-	Name                string     `form:"name"`
-	Email               string     `form:"email"`
-	Password            string     `form:"password"`
-	validator.Validator `form:"-"` // "-" tells formDecoder to ignore the field
+// Returns true if the snippet is owned by the logged in user. Snippets with no
+// owner aren't owned by anyone.
+func (app *application) isOwner(r *http.Request, snippet models.Snippet) bool {
+	userID := app.sessionManager.GetInt(r.Context(), string(authenticatedUserID))
+	return snippet.UserID != 0 && app.isAuthenticated(r) && snippet.UserID == userID
 }
 
-func (app *application) userSignup(w http.ResponseWriter, r *http.Request) {
-	data := app.newTemplateData(r)
-	data.Form = userSignupForm{}
-	app.render(w, r, http.StatusOK, "signup.tmpl", data)
+/*
+Returns the snippet with the given ID, if the logged in user can see it. Team
+snippets can only be seen by the team's members.
+
+If there's no matching snippet, or the user can't see it, a models.ErrNoRecord
+error is returned, so that non-members can't tell whether a team snippet exists.
+*/
+func (app *application) getSnippet(r *http.Request, id int) (models.Snippet, error) {
+	snippet, err := app.snippets.Get(id)
+	if err != nil {
+		return models.Snippet{}, err
+	}
+
+	if snippet.TeamID != 0 {
+		_, err = app.teamRole(r, snippet.TeamID)
+		if err != nil {
+			return models.Snippet{}, err
+		}
+	}
+
+	return snippet, nil
 }
 
-func (app *application) userSignupPost(w http.ResponseWriter, r *http.Request) {
-	var form userSignupForm
-	err := app.decodePostForm(r, &form)
+// Returns true if the logged in user can edit the snippet and moderate its
+// comments. Its owner can, and so can the owners and admins of its team.
+func (app *application) canManage(r *http.Request, snippet models.Snippet) (bool, error) {
+	if app.isOwner(r, snippet) {
+		return true, nil
+	}
+	if snippet.TeamID == 0 {
+		return false, nil
+	}
+
+	role, err := app.teamRole(r, snippet.TeamID)
 	if err != nil {
-		app.clientError(w, http.StatusBadRequest)
-		return
+		if errors.Is(err, models.ErrNoRecord) {
+			return false, nil
+		}
+		return false, err
 	}
 
-	form.CheckField(validator.NotBlank(form.Name), "name", "This field can't be blank.")
-	form.CheckField(validator.NotBlank(form.Email), "email", "This field can't be blank.")
-	form.CheckField(validator.NotBlank(form.Password), "password", "This field can't be blank.")
-	form.CheckField(validator.MinChars(form.Password, 8), "password", "Password must be at least 8 characters.")
-	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "Invalid email.")
+	return isTeamAdmin(role), nil
+}
 
-	if !form.Valid() {
-		data := app.newTemplateData(r)
-		data.Form = form
-		app.render(w, r, http.StatusUnprocessableEntity, "signup.tmpl", data)
-		return
+// Returns the snippet whose ID is in the URL. If there's no matching snippet, a
+// 404 NotFound response is sent and ok is false.
+func (app *application) snippetFromURL(w http.ResponseWriter, r *http.Request) (snippet models.Snippet, ok bool) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return models.Snippet{}, false
 	}
 
-	err = app.users.Insert(form.Name, form.Email, form.Password)
+	snippet, err = app.getSnippet(r, id)
 	if err != nil {
-		if errors.Is(err, models.ErrDuplicateEmail) {
-			form.AddFieldError("email", "That email is already in use.")
-			data := app.newTemplateData(r)
-			data.Form = form
-			app.render(w, r, http.StatusUnprocessableEntity, "signup.tmpl", data)
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
 		} else {
 			app.serverError(w, r, err)
 		}
-		return
+		return models.Snippet{}, false
 	}
 
-	app.sessionManager.Put(r.Context(), string(flash), "Your signup was successful, please log in.")
-
-	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+	return snippet, true
 }
 
-// Struct containing form fields for the /user/login form.
-type userLoginForm struct {
-	Email               string     `form:"email"`
-	Password            string     `form:"password"`
-	validator.Validator `form:"-"` // "-" tells formDecoder to ignore the field
+/*
+Returns the snippet whose ID is in the URL, if the logged in user can manage
+it, as its owner or an owner or admin of its team. Otherwise, an error response
+is sent and ok is false: 404 NotFound if there's no matching snippet, or 403
+Forbidden if it belongs to someone else.
+*/
+func (app *application) ownedSnippet(w http.ResponseWriter, r *http.Request) (snippet models.Snippet, ok bool) {
+	snippet, ok = app.snippetFromURL(w, r)
+	if !ok {
+		return models.Snippet{}, false
+	}
+
+	canManage, err := app.canManage(r, snippet)
+	if err != nil {
+		app.serverError(w, r, err)
+		return models.Snippet{}, false
+	}
+
+	if !canManage {
+		app.clientError(w, http.StatusForbidden)
+		return models.Snippet{}, false
+	}
+
+	return snippet, true
 }
 
-func (app *application) userLogin(w http.ResponseWriter, r *http.Request) {
+// Displays the form to edit a snippet. Only the snippet's owner, or an owner or
+// admin of its team, can edit it.
+func (app *application) snippetEdit(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.ownedSnippet(w, r)
+	if !ok {
+		return
+	}
+
 	data := app.newTemplateData(r)
-	data.Form = userLoginForm{}
-	app.render(w, r, http.StatusOK, "login.tmpl", data)
+	data.Snippet = snippet
+	data.Form = snippetEditForm{Title: snippet.Title, Files: snippetFileForms(snippet.Files)}
+	app.render(w, r, http.StatusOK, "edit.tmpl", data)
 }
 
-func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
-	var form userLoginForm
+// Changes a snippet's title and files, recording the change as a new revision.
+// If nothing was changed, no revision is recorded.
+func (app *application) snippetEditPost(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.ownedSnippet(w, r)
+	if !ok {
+		return
+	}
 
+	var form snippetEditForm
 	err := app.decodePostForm(r, &form)
 	if err != nil {
 		app.clientError(w, http.StatusBadRequest)
 		return
 	}
 
-	form.CheckField(validator.NotBlank(form.Email), "email", "This field can't be blank.")
-	form.CheckField(validator.NotBlank(form.Password), "password", "This field can't be blank.")
-	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "Invalid email.")
+	if form.AddFile {
+		form.Files = append(form.Files, snippetFileForm{Language: "text"})
+		data := app.newTemplateData(r)
+		data.Snippet = snippet
+		data.Form = form
+		app.render(w, r, http.StatusOK, "edit.tmpl", data)
+		return
+	}
+
+	form.Files = parseFiles(form.Files)
+	files := snippetFiles(form.Files)
+	checkSnippet(&form.Validator, form.Title, files)
 
 	if !form.Valid() {
 		data := app.newTemplateData(r)
+		data.Snippet = snippet
 		data.Form = form
-		app.render(w, r, http.StatusUnprocessableEntity, "login.tmpl", data)
+		app.render(w, r, http.StatusUnprocessableEntity, "edit.tmpl", data)
 		return
 	}
 
-	// Try to authenticate user. If the user's credentials are invalid, the
-	// login page is re-rendered with a non-field error.
-	id, err := app.users.Authenticate(form.Email, form.Password)
-	if err != nil {
-		if errors.Is(err, models.ErrInvalidCredentials) {
-			form.AddNonFieldError("Email or password is incorrect.")
-			data := app.newTemplateData(r)
-			data.Form = form
-			app.render(w, r, http.StatusUnauthorized, "login.tmpl", data)
-		} else {
+	if form.Title != snippet.Title || !slices.Equal(files, snippet.Files) {
+		userID := app.sessionManager.GetInt(r.Context(), string(authenticatedUserID))
+		err = app.snippets.Update(snippet.ID, userID, form.Title, files)
+		if err != nil {
 			app.serverError(w, r, err)
+			return
 		}
 	}
 
-	// When authentication state or privilege levels change, the session ID should
-	// be changed, via the RenewToken method.
-	err = app.sessionManager.RenewToken(r.Context())
-	if err != nil {
-		app.serverError(w, r, err)
-		return
-	}
+	app.sessionManager.Put(r.Context(), string(flash), "Snippet successfully updated!")
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", snippet.ID), http.StatusSeeOther)
+}
 
-	// Add user ID to session data to indicate their logged in status.
-	app.sessionManager.Put(r.Context(), string(authenticatedUserID), id)
+// A unified diff between two revisions of a snippet.
+type revisionDiff struct {
+	From  models.SnippetRevision
+	To    models.SnippetRevision
+	Files []fileDiff // only the files that changed
+}
 
-	app.sessionManager.Put(r.Context(), string(flash), "Login successful.")
+// A unified diff between two versions of one of a snippet's files. If the file
+// was added, OldName is empty, and if it was removed, NewName is empty. If the
+// versions differ by too many lines to diff, TooManyChanges is true and there
+// are no Hunks.
+type fileDiff struct {
+	OldName        string
+	NewName        string
+	Hunks          []diff.Hunk
+	TooManyChanges bool
+}
 
-	dest := app.sessionManager.PopString(r.Context(), string(redirectAfterLogin))
-	if dest != "" {
-		http.Redirect(w, r, dest, http.StatusSeeOther)
-		return
+// Returns the diff between two versions of a file's content.
+func newFileDiff(oldName, newName, a, b string) fileDiff {
+	hunks, err := diff.Hunks(a, b, 3)
+	return fileDiff{
+		OldName:        oldName,
+		NewName:        newName,
+		Hunks:          hunks,
+		TooManyChanges: errors.Is(err, diff.ErrTooManyChanges),
 	}
-	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
 }
 
-func (app *application) userLogoutPost(w http.ResponseWriter, r *http.Request) {
-	// When authentication state or privilege levels change, the session ID should
-	// be changed, via the RenewToken method.
-	err := app.sessionManager.RenewToken(r.Context())
-	if err != nil {
-		app.serverError(w, r, err)
-		return
+// Returns the diffs of the files that differ between two revisions. Files are
+// matched by name. Files in the new revision come first, in order, followed by
+// files that were removed.
+func diffFiles(from, to []models.SnippetFile) []fileDiff {
+	old := make(map[string]string)
+	for _, f := range from {
+		old[f.Name] = f.Content
 	}
 
-	// "Logout" user by removing the authenticatedUserID, and flash success.
-	app.sessionManager.Remove(r.Context(), string(authenticatedUserID))
-	app.sessionManager.Put(r.Context(), string(flash), "You have succesfully logged out.")
+	var diffs []fileDiff
+	for _, f := range to {
+		content, existed := old[f.Name]
+		if !existed {
+			diffs = append(diffs, newFileDiff("", f.Name, "", f.Content))
+			continue
+		}
 
-	// Redirect to home.
-	http.Redirect(w, r, "/", http.StatusSeeOther)
-}
+		delete(old, f.Name)
+		if d := newFileDiff(f.Name, f.Name, content, f.Content); len(d.Hunks) > 0 || d.TooManyChanges {
+			diffs = append(diffs, d)
+		}
+	}
 
-//
-// Account handlers
-//
+	for _, f := range from {
+		if _, removed := old[f.Name]; removed {
+			diffs = append(diffs, newFileDiff(f.Name, "", f.Content, ""))
+		}
+	}
 
-type accountPasswordUpdateForm struct {
-	CurrentPassword     string     `form:"currentPassword"`
-	NewPassword         string     `form:"newPassword"`
-	ConfirmPassword     string     `form:"confirmPassword"`
-	validator.Validator `form:"-"` // "-" tells formDecoder to ignore the field
+	return diffs
 }
 
-func (app *application) accountPasswordUpdate(w http.ResponseWriter, r *http.Request) {
-	data := app.newTemplateData(r)
-	data.Form = accountPasswordUpdateForm{}
-	app.render(w, r, http.StatusOK, "password.tmpl", data)
-}
+/*
+Displays the revisions of a snippet, latest first. If the from and to query
+parameters contain the IDs of two of its revisions, the diff between them is
+displayed too.
 
-func (app *application) accountPasswordUpdatePost(w http.ResponseWriter, r *http.Request) {
+If there's no matching snippet, or the query parameters aren't valid, a 404
+NotFound response is sent.
+*/
+func (app *application) snippetHistory(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	snippet, err := app.getSnippet(r, id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	revisions, err := app.snippets.GetRevisions(id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Snippet = snippet
+	data.Revisions = revisions
+
+	data.IsOwner, err = app.canManage(r, snippet)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	query := r.URL.Query()
+	if query.Has("from") || query.Has("to") {
+		var d revisionDiff
+
+		for _, rev := range []struct {
+			param string
+			dst   *models.SnippetRevision
+		}{{"from", &d.From}, {"to", &d.To}} {
+			revisionID, err := strconv.Atoi(query.Get(rev.param))
+			if err != nil || revisionID < 1 {
+				app.notFound(w)
+				return
+			}
+
+			*rev.dst, err = app.snippets.GetRevision(id, revisionID)
+			if err != nil {
+				if errors.Is(err, models.ErrNoRecord) {
+					app.notFound(w)
+				} else {
+					app.serverError(w, r, err)
+				}
+				return
+			}
+		}
+
+		d.Files = diffFiles(d.From.Files, d.To.Files)
+		data.Diff = &d
+	}
+
+	app.render(w, r, http.StatusOK, "history.tmpl", data)
+}
+
+// Struct containing form fields for restoring a revision.
+type snippetRestoreForm struct {
+	Revision int `form:"revision"`
+}
+
+// Restores a snippet's title and files to those of an earlier revision,
+// recording this as a new revision. Only the snippet's owner, or an owner or
+// admin of its team, can do this.
+func (app *application) snippetRestorePost(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.ownedSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	var form snippetRestoreForm
+	err := app.decodePostForm(r, &form)
+	if err != nil || form.Revision < 1 {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	revision, err := app.snippets.GetRevision(snippet.ID, form.Revision)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	userID := app.sessionManager.GetInt(r.Context(), string(authenticatedUserID))
+	err = app.snippets.Update(snippet.ID, userID, revision.Title, revision.Files)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), string(flash), fmt.Sprintf("Revision #%d restored.", revision.ID))
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", snippet.ID), http.StatusSeeOther)
+}
+
+// Struct containing the errors of the /snippet/import form. The file itself is
+// read from the multipart form, rather than decoded.
+type snippetImportForm struct {
+	validator.Validator `form:"-"`
+}
+
+// Displays the form to import snippets from a file.
+func (app *application) snippetImport(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = snippetImportForm{}
+	app.render(w, r, http.StatusOK, "import.tmpl", data)
+}
+
+/*
+Imports the snippets in an uploaded JSON array, gist export or zip archive of
+text files. The logged in user owns the imported snippets.
+
+Each snippet is validated like those created with the snippet create form. If
+any are invalid, none are imported, and the form is rendered again with a 422
+status code, listing the errors of each invalid snippet.
+*/
+func (app *application) snippetImportPost(w http.ResponseWriter, r *http.Request) {
+	var form snippetImportForm
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		if !errors.Is(err, http.ErrMissingFile) {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+		form.AddFieldError("file", "Please choose a file to import.")
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "import.tmpl", data)
+		return
+	}
+	defer file.Close()
+
+	content, err := io.ReadAll(io.LimitReader(file, maxImportSize+1))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	var ids []int
+	var importErrors []importError
+	if len(content) > maxImportSize {
+		form.AddFieldError("file", fmt.Sprintf("This file can't be larger than %d MB.", maxImportSize>>20))
+	} else {
+		ids, importErrors, err = app.importSnippets(app.sessionManager.GetInt(r.Context(), string(authenticatedUserID)), content)
+		var fileErr importFileError
+		switch {
+		case errors.Is(err, errInvalidImport):
+			form.AddNonFieldError("None of the snippets were imported, because some of them are invalid.")
+		case errors.As(err, &fileErr):
+			form.AddFieldError("file", fileErr.Error())
+		case err != nil:
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		data.ImportErrors = importErrors
+		app.render(w, r, http.StatusUnprocessableEntity, "import.tmpl", data)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), string(flash), fmt.Sprintf("Imported %d snippets.", len(ids)))
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// The number of snippets fetched at a time when writing an archive, and how
+// long writing it can take.
+const (
+	downloadPageSize     = 50
+	downloadWriteTimeout = 10 * time.Minute
+)
+
+/*
+Returns a bundle.Pager for the snippets returned by getPage, which returns a
+page of snippets, latest first. first is the page at offset 0, which has
+already been fetched.
+
+Pages are fetched by offset, so if snippets are added while the archive is
+being written, a snippet can appear in two pages. Snippets that are no older
+than the last one returned are skipped.
+*/
+func pageSnippets(first []models.Snippet, getPage func(limit, offset int) ([]models.Snippet, error)) bundle.Pager {
+	offset, lastID := 0, 0
+	return func() ([]models.Snippet, error) {
+		for {
+			page := first
+			if offset > 0 {
+				var err error
+				page, err = getPage(downloadPageSize, offset)
+				if err != nil {
+					return nil, err
+				}
+			}
+			if len(page) == 0 {
+				return nil, nil
+			}
+			offset += len(page)
+
+			var snippets []models.Snippet
+			for _, s := range page {
+				if lastID == 0 || s.ID < lastID {
+					snippets = append(snippets, s)
+					lastID = s.ID
+				}
+			}
+			if len(snippets) > 0 {
+				return snippets, nil
+			}
+		}
+	}
+}
+
+/*
+Streams a zip or tar.gz archive of snippets in response to GET /download. The
+snippets are chosen by one of the following query parameters:
+
+  - id: the snippet with the given ID
+  - tag: the unexpired snippets with the given tag
+  - owner: the unexpired snippets owned by the user with the given ID, if
+    their profile is visible to the logged in user
+
+The format query parameter is either zip (the default) or tar.gz. If no
+snippets match, a 404 NotFound response is sent.
+*/
+func (app *application) snippetDownload(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	format := bundle.Format(query.Get("format"))
+	if format == "" {
+		format = bundle.Zip
+	}
+	if !validator.PermittedValue(format, bundle.Zip, bundle.TarGz) {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// The name of the archive, which is also the name of its root directory,
+	// and a function returning a page of the snippets in it.
+	var name string
+	var getPage func(limit, offset int) ([]models.Snippet, error)
+
+	switch {
+	case query.Has("id"):
+		id, err := strconv.Atoi(query.Get("id"))
+		if err != nil || id < 1 {
+			app.notFound(w)
+			return
+		}
+
+		snippet, err := app.getSnippet(r, id)
+		if err != nil {
+			if errors.Is(err, models.ErrNoRecord) {
+				app.notFound(w)
+			} else {
+				app.serverError(w, r, err)
+			}
+			return
+		}
+
+		name = bundle.Dir(snippet)
+		getPage = func(limit, offset int) ([]models.Snippet, error) {
+			if offset > 0 {
+				return nil, nil
+			}
+			return []models.Snippet{snippet}, nil
+		}
+
+	case query.Has("tag"):
+		tag := query.Get("tag")
+		if !validator.MaxChars(tag, maxTagLength) || !validator.Matches(tag, validator.TagRX) {
+			app.notFound(w)
+			return
+		}
+
+		name = "tag-" + tag
+		getPage = func(limit, offset int) ([]models.Snippet, error) {
+			return app.snippets.GetPageByTag(tag, limit, offset)
+		}
+
+	case query.Has("owner"):
+		owner, err := strconv.Atoi(query.Get("owner"))
+		if err != nil || owner < 1 {
+			app.notFound(w)
+			return
+		}
+
+		// As on the owner's profile, the snippets of disabled users and of
+		// users who have hidden their profile can't be downloaded.
+		user, err := app.users.Get(owner)
+		if err != nil {
+			if errors.Is(err, models.ErrNoRecord) {
+				app.notFound(w)
+			} else {
+				app.serverError(w, r, err)
+			}
+			return
+		}
+
+		self := user.ID == app.sessionManager.GetInt(r.Context(), string(authenticatedUserID))
+		if user.Disabled || (!user.ProfilePublic && !self) {
+			app.notFound(w)
+			return
+		}
+
+		// Unlike the owner's own export, expired and team snippets aren't
+		// included.
+		name = fmt.Sprintf("user-%d", owner)
+		getPage = func(limit, offset int) ([]models.Snippet, error) {
+			return app.snippets.GetPublicForUser(owner, limit, offset)
+		}
+
+	default:
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	first, err := getPage(downloadPageSize, 0)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if len(first) == 0 {
+		app.notFound(w)
+		return
+	}
+
+	// Large archives can take longer to send than the server's write timeout.
+	err = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(downloadWriteTimeout))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, name, format))
+
+	// Unlike render, the archive is written straight to the client. So if an
+	// error occurs, part of the response has already been sent, and all we can
+	// do is log it.
+	err = bundle.Write(w, format, name, pageSnippets(first, getPage), time.Now())
+	if err != nil {
+		app.logger.Error(err.Error(), "method", r.Method, "uri", r.URL.RequestURI())
+	}
+}
+
+// Struct containing form fields for starring or unstarring a snippet.
+type snippetStarForm struct {
+	Starred bool `form:"starred"`
+}
+
+// Stars or unstars the snippet whose ID is in the URL for the logged in user,
+// depending on the starred form field.
+func (app *application) snippetStarPost(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.snippetFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	var form snippetStarForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	userID := app.sessionManager.GetInt(r.Context(), string(authenticatedUserID))
+	if form.Starred {
+		err = app.stars.Star(userID, snippet.ID)
+	} else {
+		err = app.stars.Unstar(userID, snippet.ID)
+	}
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", snippet.ID), http.StatusSeeOther)
+}
+
+//
+// Comment handlers
+//
+
+// Struct containing form fields for the comment form, on the snippet view
+// page, and the comment edit form.
+type commentForm struct {
+	Body                string `form:"body"`
+	validator.Validator `form:"-"`
+}
+
+// The maximum length of a comment.
+const maxCommentLength = 2000
+
+// Validates the comment form.
+func checkComment(form *commentForm) {
+	form.CheckField(validator.NotBlank(form.Body), "body", "This field can't be blank.")
+	form.CheckField(validator.MaxChars(form.Body, maxCommentLength), "body", fmt.Sprintf("This can't contain more than %d characters.", maxCommentLength))
+}
+
+// Returns the URL of a comment, on its snippet's view page.
+func commentURL(comment models.Comment) string {
+	return fmt.Sprintf("/snippet/view/%d#comment-%d", comment.SnippetID, comment.ID)
+}
+
+/*
+Posts a comment by the logged in user on the snippet whose ID is in the URL.
+If comments are disabled on the snippet, a 403 Forbidden response is sent.
+
+If the comment is invalid, the snippet's view page is rendered again with a 422
+status code, displaying the errors.
+*/
+func (app *application) snippetCommentPost(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.snippetFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	if snippet.CommentsDisabled {
+		app.clientError(w, http.StatusForbidden)
+		return
+	}
+
+	var form commentForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	checkComment(&form)
+	if !form.Valid() {
+		app.renderSnippet(w, r, http.StatusUnprocessableEntity, snippet, form)
+		return
+	}
+
+	userID := app.sessionManager.GetInt(r.Context(), string(authenticatedUserID))
+	id, err := app.comments.Insert(snippet.ID, userID, form.Body)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), string(flash), "Comment posted.")
+	http.Redirect(w, r, commentURL(models.Comment{ID: id, SnippetID: snippet.ID}), http.StatusSeeOther)
+}
+
+// Struct containing form fields for disabling or enabling comments on a
+// snippet.
+type snippetCommentsForm struct {
+	Disabled bool `form:"disabled"`
+}
+
+// Disables or enables comments on a snippet. Only the snippet's owner, or an
+// owner or admin of its team, can do this. Existing comments are kept either
+// way.
+func (app *application) snippetCommentsPost(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.ownedSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	var form snippetCommentsForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = app.snippets.SetCommentsDisabled(snippet.ID, form.Disabled)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if form.Disabled {
+		app.sessionManager.Put(r.Context(), string(flash), "Comments disabled.")
+	} else {
+		app.sessionManager.Put(r.Context(), string(flash), "Comments enabled.")
+	}
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", snippet.ID), http.StatusSeeOther)
+}
+
+/*
+Returns the comment whose ID is in the URL, and the snippet it is on. If there's
+no matching comment, or its snippet has expired, a 404 NotFound response is
+sent and ok is false.
+*/
+func (app *application) commentFromURL(w http.ResponseWriter, r *http.Request) (comment models.Comment, snippet models.Snippet, ok bool) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return models.Comment{}, models.Snippet{}, false
+	}
+
+	comment, err = app.comments.Get(id)
+	if err == nil {
+		snippet, err = app.getSnippet(r, comment.SnippetID)
+	}
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return models.Comment{}, models.Snippet{}, false
+	}
+
+	return comment, snippet, true
+}
+
+/*
+Returns the comment whose ID is in the URL, if the logged in user wrote it and
+it can be edited. Otherwise, an error response is sent and ok is false: 404
+NotFound if there's no matching comment, or 403 Forbidden if someone else wrote
+it or comments are disabled on its snippet.
+*/
+func (app *application) editableComment(w http.ResponseWriter, r *http.Request) (comment models.Comment, ok bool) {
+	comment, snippet, ok := app.commentFromURL(w, r)
+	if !ok {
+		return models.Comment{}, false
+	}
+
+	userID := app.sessionManager.GetInt(r.Context(), string(authenticatedUserID))
+	if comment.UserID != userID || snippet.CommentsDisabled {
+		app.clientError(w, http.StatusForbidden)
+		return models.Comment{}, false
+	}
+
+	return comment, true
+}
+
+// Displays the form to edit a comment. Only the comment's author can edit it.
+func (app *application) commentEdit(w http.ResponseWriter, r *http.Request) {
+	comment, ok := app.editableComment(w, r)
+	if !ok {
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Comment = comment
+	data.Form = commentForm{Body: comment.Body}
+	app.render(w, r, http.StatusOK, "comment_edit.tmpl", data)
+}
+
+// Changes the body of a comment. Only the comment's author can edit it. If the
+// comment is invalid, the form is rendered again with a 422 status code.
+func (app *application) commentEditPost(w http.ResponseWriter, r *http.Request) {
+	comment, ok := app.editableComment(w, r)
+	if !ok {
+		return
+	}
+
+	var form commentForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	checkComment(&form)
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Comment = comment
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "comment_edit.tmpl", data)
+		return
+	}
+
+	// Saving an unchanged comment doesn't mark it as edited.
+	if form.Body != comment.Body {
+		err = app.comments.Update(comment.ID, form.Body)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	app.sessionManager.Put(r.Context(), string(flash), "Comment updated.")
+	http.Redirect(w, r, commentURL(comment), http.StatusSeeOther)
+}
+
+// Deletes a comment. Comments can be deleted by their author, and by whoever
+// can manage the snippet they're on, even if comments are disabled on it.
+func (app *application) commentDeletePost(w http.ResponseWriter, r *http.Request) {
+	comment, snippet, ok := app.commentFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	canManage, err := app.canManage(r, snippet)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	userID := app.sessionManager.GetInt(r.Context(), string(authenticatedUserID))
+	if comment.UserID != userID && !canManage {
+		app.clientError(w, http.StatusForbidden)
+		return
+	}
+
+	err = app.comments.Delete(comment.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), string(flash), "Comment deleted.")
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d#comments", snippet.ID), http.StatusSeeOther)
+}
+
+//
+// Team handlers
+//
+
+// How long an invitation link to a team can be used for.
+const teamInvitationTTL = 7 * 24 * time.Hour
+
+// The maximum length of a team's name.
+const maxTeamNameLength = 100
+
+// Struct containing form fields for the team create form.
+type teamCreateForm struct {
+	Name                string `form:"name"`
+	validator.Validator `form:"-"`
+}
+
+// Struct containing form fields for creating an invitation link to a team.
+type teamInviteForm struct {
+	Role string `form:"role"`
+}
+
+// Struct containing form fields for changing a member's role, or removing them
+// from a team.
+type teamMemberForm struct {
+	UserID int    `form:"user"`
+	Role   string `form:"role"`
+}
+
+// Struct containing form fields for the nav's team switcher.
+type teamSwitchForm struct {
+	Team int `form:"team"` // 0 for no team
+}
+
+/*
+Returns the team whose ID is in the URL, with the logged in user's role in it.
+If there's no matching team, or the user isn't a member of it, a 404 NotFound
+response is sent and ok is false.
+*/
+func (app *application) teamFromURL(w http.ResponseWriter, r *http.Request) (team models.Team, ok bool) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return models.Team{}, false
+	}
+
+	team, err = app.teams.Get(id)
+	if err == nil {
+		team.Role, err = app.teamRole(r, id)
+	}
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return models.Team{}, false
+	}
+
+	return team, true
+}
+
+// Returns the team whose ID is in the URL, if the logged in user is one of its
+// owners or admins. Otherwise, an error response is sent and ok is false: 404
+// NotFound if the user isn't a member, or 403 Forbidden if they are only a
+// member.
+func (app *application) administeredTeam(w http.ResponseWriter, r *http.Request) (team models.Team, ok bool) {
+	team, ok = app.teamFromURL(w, r)
+	if !ok {
+		return models.Team{}, false
+	}
+
+	if !isTeamAdmin(team.Role) {
+		app.clientError(w, http.StatusForbidden)
+		return models.Team{}, false
+	}
+
+	return team, true
+}
+
+// Lists the logged in user's teams, with a form to create a new one.
+func (app *application) teamList(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = teamCreateForm{}
+	app.render(w, r, http.StatusOK, "teams.tmpl", data)
+}
+
+// Creates a team, with the logged in user as its owner, and switches to it.
+func (app *application) teamCreatePost(w http.ResponseWriter, r *http.Request) {
+	var form teamCreateForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.Name = strings.TrimSpace(form.Name)
+	form.CheckField(validator.NotBlank(form.Name), "name", "This field can't be blank.")
+	form.CheckField(validator.MaxChars(form.Name, maxTeamNameLength), "name", fmt.Sprintf("This can't contain more than %d characters.", maxTeamNameLength))
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "teams.tmpl", data)
+		return
+	}
+
+	userID := app.sessionManager.GetInt(r.Context(), string(authenticatedUserID))
+	id, err := app.teams.Insert(form.Name, userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), string(currentTeamID), id)
+	app.sessionManager.Put(r.Context(), string(flash), "Team created. Invite your teammates below.")
+	http.Redirect(w, r, fmt.Sprintf("/team/view/%d", id), http.StatusSeeOther)
+}
+
+// Renders a team's page, with its snippets and members. Owners and admins can
+// also create invitation links, and manage the team's members. invitationURL is
+// the link to a newly created invitation, if there is one.
+func (app *application) renderTeam(w http.ResponseWriter, r *http.Request, team models.Team, invitationURL string) {
+	snippets, err := app.snippets.GetForTeam(team.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	members, err := app.teams.Members(team.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Team = team
+	data.Snippets = snippets
+	data.TeamMembers = members
+	data.InvitationURL = invitationURL
+	app.render(w, r, http.StatusOK, "team.tmpl", data)
+}
+
+// Displays a team's page. Only the team's members can see it.
+func (app *application) teamView(w http.ResponseWriter, r *http.Request) {
+	team, ok := app.teamFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	app.renderTeam(w, r, team, "")
+}
+
+// Creates an invitation link to a team, and displays it on the team's page.
+// Only the team's owners and admins can invite people, and they can only invite
+// them as members or admins.
+func (app *application) teamInvitePost(w http.ResponseWriter, r *http.Request) {
+	team, ok := app.administeredTeam(w, r)
+	if !ok {
+		return
+	}
+
+	var form teamInviteForm
+	err := app.decodePostForm(r, &form)
+	if err != nil || !validator.PermittedValue(form.Role, models.TeamRoleMember, models.TeamRoleAdmin) {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	inv, err := app.teams.NewInvitation(team.ID, form.Role, teamInvitationTTL)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.renderTeam(w, r, team, fmt.Sprintf("%s/team/join/%s", app.baseURL, inv.Plaintext))
+}
+
+/*
+Changes the role of one of a team's members. Owners can change anyone's role,
+and admins can change the roles of members and other admins, but can't make
+anyone an owner. A team's last owner can't be demoted.
+*/
+func (app *application) teamMemberRolePost(w http.ResponseWriter, r *http.Request) {
+	team, ok := app.administeredTeam(w, r)
+	if !ok {
+		return
+	}
+
+	var form teamMemberForm
+	err := app.decodePostForm(r, &form)
+	if err != nil || !validator.PermittedValue(form.Role, models.TeamRoleOwner, models.TeamRoleAdmin, models.TeamRoleMember) {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	role, err := app.teams.Role(team.ID, form.UserID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	if team.Role != models.TeamRoleOwner && (role == models.TeamRoleOwner || form.Role == models.TeamRoleOwner) {
+		app.clientError(w, http.StatusForbidden)
+		return
+	}
+
+	err = app.teams.SetRole(team.ID, form.UserID, form.Role)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrLastOwner):
+			app.sessionManager.Put(r.Context(), string(flash), "A team must have at least one owner.")
+		case errors.Is(err, models.ErrNoRecord):
+			app.notFound(w)
+			return
+		default:
+			app.serverError(w, r, err)
+			return
+		}
+	} else {
+		app.sessionManager.Put(r.Context(), string(flash), "Role updated.")
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/team/view/%d", team.ID), http.StatusSeeOther)
+}
+
+/*
+Removes a member from a team. Any member can remove themselves, which is how
+they leave the team. Owners can remove anyone, and admins can remove members
+and other admins. A team's last owner can't be removed.
+*/
+func (app *application) teamMemberRemovePost(w http.ResponseWriter, r *http.Request) {
+	team, ok := app.teamFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	var form teamMemberForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	userID := app.sessionManager.GetInt(r.Context(), string(authenticatedUserID))
+	leaving := form.UserID == userID
+
+	if !leaving {
+		role, err := app.teams.Role(team.ID, form.UserID)
+		if err != nil {
+			if errors.Is(err, models.ErrNoRecord) {
+				app.notFound(w)
+			} else {
+				app.serverError(w, r, err)
+			}
+			return
+		}
+
+		if !isTeamAdmin(team.Role) || (team.Role != models.TeamRoleOwner && role == models.TeamRoleOwner) {
+			app.clientError(w, http.StatusForbidden)
+			return
+		}
+	}
+
+	err = app.teams.RemoveMember(team.ID, form.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrLastOwner):
+			app.sessionManager.Put(r.Context(), string(flash), "A team must have at least one owner. Make someone else an owner first, or delete the team.")
+			http.Redirect(w, r, fmt.Sprintf("/team/view/%d", team.ID), http.StatusSeeOther)
+		case errors.Is(err, models.ErrNoRecord):
+			app.notFound(w)
+		default:
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	if leaving {
+		app.sessionManager.Put(r.Context(), string(flash), fmt.Sprintf("You have left %s.", team.Name))
+		http.Redirect(w, r, "/teams", http.StatusSeeOther)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), string(flash), "Member removed.")
+	http.Redirect(w, r, fmt.Sprintf("/team/view/%d", team.ID), http.StatusSeeOther)
+}
+
+// Deletes a team, along with its snippets. Only the team's owners can delete
+// it.
+func (app *application) teamDeletePost(w http.ResponseWriter, r *http.Request) {
+	team, ok := app.teamFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	if team.Role != models.TeamRoleOwner {
+		app.clientError(w, http.StatusForbidden)
+		return
+	}
+
+	err := app.teams.Delete(team.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), string(flash), fmt.Sprintf("%s has been deleted.", team.Name))
+	http.Redirect(w, r, "/teams", http.StatusSeeOther)
+}
+
+// Redirects to the teams page with a flash explaining that the invitation link
+// can't be used.
+func (app *application) invalidTeamInvitation(w http.ResponseWriter, r *http.Request) {
+	app.sessionManager.Put(r.Context(), string(flash), "That invitation link is invalid or has expired.")
+	http.Redirect(w, r, "/teams", http.StatusSeeOther)
+}
+
+// Displays the team that an invitation link is for, with a button to join it.
+func (app *application) teamJoin(w http.ResponseWriter, r *http.Request) {
+	token := httprouter.ParamsFromContext(r.Context()).ByName("token")
+
+	inv, err := app.teams.GetInvitation(token)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.invalidTeamInvitation(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Invitation = inv
+	app.render(w, r, http.StatusOK, "team_join.tmpl", data)
+}
+
+// Adds the logged in user to the team that an invitation link is for, and
+// switches to it.
+func (app *application) teamJoinPost(w http.ResponseWriter, r *http.Request) {
+	token := httprouter.ParamsFromContext(r.Context()).ByName("token")
+	userID := app.sessionManager.GetInt(r.Context(), string(authenticatedUserID))
+
+	id, err := app.teams.AcceptInvitation(token, userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.invalidTeamInvitation(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), string(currentTeamID), id)
+	app.sessionManager.Put(r.Context(), string(flash), "Welcome to the team!")
+	http.Redirect(w, r, fmt.Sprintf("/team/view/%d", id), http.StatusSeeOther)
+}
+
+// Switches the logged in user to one of their teams, or to no team if the team
+// is 0, and redirects to the team's page or the home page.
+func (app *application) teamSwitchPost(w http.ResponseWriter, r *http.Request) {
+	var form teamSwitchForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if form.Team == 0 {
+		app.sessionManager.Remove(r.Context(), string(currentTeamID))
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	_, err = app.teamRole(r, form.Team)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), string(currentTeamID), form.Team)
+	http.Redirect(w, r, fmt.Sprintf("/team/view/%d", form.Team), http.StatusSeeOther)
+}
+
+//
+// Tag handlers
+//
+
+// Lists every tag in use, with the number of snippets that have it.
+func (app *application) tagList(w http.ResponseWriter, r *http.Request) {
+	tags, err := app.snippets.AllTags()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Tags = tags
+
+	app.render(w, r, http.StatusOK, "tags.tmpl", data)
+}
+
+// Lists the snippets with the given tag. If the tag isn't valid, a 404
+// NotFound response is sent.
+func (app *application) tagView(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	tag := params.ByName("name")
+	if !validator.MaxChars(tag, maxTagLength) || !validator.Matches(tag, validator.TagRX) {
+		app.notFound(w)
+		return
+	}
+
+	snippets, err := app.snippets.GetByTag(tag)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Tag = tag
+	data.Snippets = snippets
+
+	app.render(w, r, http.StatusOK, "tag.tmpl", data)
+}
+
+//
+// User handlers
+
+// Struct containing form fields for the /user/signup form.
+type userSignupForm struct {
+	Name                string     `form:"name"`
+	Email               string     `form:"email"`
+	Password            string     `form:"password"`
+	validator.Validator `form:"-"` // "-" tells formDecoder to ignore the field
+}
+
+func (app *application) userSignup(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = userSignupForm{}
+	app.render(w, r, http.StatusOK, "signup.tmpl", data)
+}
+
+func (app *application) userSignupPost(w http.ResponseWriter, r *http.Request) {
+	var form userSignupForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Name), "name", "This field can't be blank.")
+	form.CheckField(validator.NotBlank(form.Email), "email", "This field can't be blank.")
+	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "Invalid email.")
+	form.CheckPassword("password", form.Password, app.passwordPolicy, form.Email, form.Name)
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "signup.tmpl", data)
+		return
+	}
+
+	id, err := app.users.Insert(form.Name, form.Email, form.Password)
+	if err != nil {
+		if errors.Is(err, models.ErrDuplicateEmail) {
+			form.AddFieldError("email", "That email is already in use.")
+			data := app.newTemplateData(r)
+			data.Form = form
+			app.render(w, r, http.StatusUnprocessableEntity, "signup.tmpl", data)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	err = app.recordAudit(r, models.AuditSignup, models.AuditUserTarget(id), "")
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), string(flash), "Your signup was successful, please log in.")
+
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// Struct containing form fields for the /user/login form.
+type userLoginForm struct {
+	Email               string     `form:"email"`
+	Password            string     `form:"password"`
+	RememberMe          bool       `form:"rememberMe"`
+	validator.Validator `form:"-"` // "-" tells formDecoder to ignore the field
+}
+
+func (app *application) userLogin(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = userLoginForm{}
+	app.render(w, r, http.StatusOK, "login.tmpl", data)
+}
+
+func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
+	var form userLoginForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Email), "email", "This field can't be blank.")
+	form.CheckField(validator.NotBlank(form.Password), "password", "This field can't be blank.")
+	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "Invalid email.")
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "login.tmpl", data)
+		return
+	}
+
+	// Try to authenticate user. If the user's credentials are invalid, or their
+	// account has been disabled, the login page is re-rendered with a non-field
+	// error.
+	id, err := app.users.Authenticate(form.Email, form.Password)
+	if errors.Is(err, models.ErrInvalidCredentials) || errors.Is(err, models.ErrAccountDisabled) {
+		reason := "incorrect password"
+		if errors.Is(err, models.ErrAccountDisabled) {
+			reason = "account disabled"
+		}
+
+		auditErr := app.recordFailedLogin(r, form.Email, reason)
+		if auditErr != nil {
+			app.serverError(w, r, auditErr)
+			return
+		}
+	}
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			form.AddNonFieldError("Email or password is incorrect.")
+			data := app.newTemplateData(r)
+			data.Form = form
+			app.render(w, r, http.StatusUnauthorized, "login.tmpl", data)
+		} else if errors.Is(err, models.ErrAccountDisabled) {
+			form.AddNonFieldError("Your account has been disabled.")
+			data := app.newTemplateData(r)
+			data.Form = form
+			app.render(w, r, http.StatusForbidden, "login.tmpl", data)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.beginLogin(w, r, id, form.RememberMe)
+}
+
+// How long a user has to enter their second factor after their password.
+const twoFactorTTL = 5 * time.Minute
+
+// Number of incorrect codes allowed before the user has to start again by
+// entering their password.
+const maxTwoFactorAttempts = 5
+
+// Struct containing the form field for the TOTP code forms.
+type totpCodeForm struct {
+	Code                string     `form:"code"`
+	validator.Validator `form:"-"` // "-" tells formDecoder to ignore the field
+}
+
+// Returns the ID of the user who has entered their password, but not yet their
+// second factor. Returns 0 if there is no such user, or if they took too long.
+func (app *application) pendingTwoFactorUserID(r *http.Request) int {
+	id := app.sessionManager.GetInt(r.Context(), string(twoFactorUserID))
+	expiry := app.sessionManager.GetInt64(r.Context(), string(twoFactorExpiry))
+	if id == 0 || time.Now().Unix() > expiry {
+		return 0
+	}
+	return id
+}
+
+// Removes all trace of a pending two-factor login from the session.
+func (app *application) clearPendingTwoFactor(r *http.Request) {
+	app.sessionManager.Remove(r.Context(), string(twoFactorUserID))
+	app.sessionManager.Remove(r.Context(), string(twoFactorExpiry))
+	app.sessionManager.Remove(r.Context(), string(twoFactorAttempts))
+	app.sessionManager.Remove(r.Context(), string(twoFactorRememberMe))
+}
+
+/*
+Checks the code entered as the user's second factor, which can either be a code
+from their authenticator app or one of their recovery codes. Either kind of
+code can only be used once.
+
+Returns false if the code is incorrect. An error is only returned if something
+went wrong.
+*/
+func (app *application) verifySecondFactor(userID int, code string) (bool, error) {
+	enrollment, err := app.totp.Get(userID)
+	if err != nil {
+		return false, err
+	}
+
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+
+	if step, ok := totp.Validate(enrollment.Secret, code, time.Now()); ok {
+		err = app.totp.UseStep(userID, step)
+	} else {
+		err = app.totp.UseRecoveryCode(userID, code)
+	}
+
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Displays the second step of the login form, for users with two-factor
+// authentication enabled.
+func (app *application) userLoginTOTP(w http.ResponseWriter, r *http.Request) {
+	if app.pendingTwoFactorUserID(r) == 0 {
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = totpCodeForm{}
+	app.render(w, r, http.StatusOK, "login_totp.tmpl", data)
+}
+
+// Completes the login of a user with two-factor authentication enabled, if
+// the code they entered is correct.
+func (app *application) userLoginTOTPPost(w http.ResponseWriter, r *http.Request) {
+	id := app.pendingTwoFactorUserID(r)
+	if id == 0 {
+		app.clearPendingTwoFactor(r)
+		app.sessionManager.Put(r.Context(), string(flash), "Your login has expired, please log in again.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	var form totpCodeForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Code), "code", "This field can't be blank.")
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "login_totp.tmpl", data)
+		return
+	}
+
+	ok, err := app.verifySecondFactor(id, form.Code)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if !ok {
+		err = app.recordAudit(r, models.AuditLoginFailed, models.AuditUserTarget(id), "incorrect code")
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		attempts := app.sessionManager.GetInt(r.Context(), string(twoFactorAttempts)) + 1
+		if attempts >= maxTwoFactorAttempts {
+			app.clearPendingTwoFactor(r)
+			app.sessionManager.Put(r.Context(), string(flash), "Too many incorrect codes, please log in again.")
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+			return
+		}
+		app.sessionManager.Put(r.Context(), string(twoFactorAttempts), attempts)
+
+		form.AddNonFieldError("Code is incorrect.")
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnauthorized, "login_totp.tmpl", data)
+		return
+	}
+
+	remember := app.sessionManager.GetBool(r.Context(), string(twoFactorRememberMe))
+	app.clearPendingTwoFactor(r)
+	app.completeLogin(w, r, id, remember)
+}
+
+func (app *application) userLogoutPost(w http.ResponseWriter, r *http.Request) {
+	// The event is recorded first, while the user is still the actor.
+	id := app.sessionManager.GetInt(r.Context(), string(authenticatedUserID))
+	err := app.recordAudit(r, models.AuditLogout, models.AuditUserTarget(id), "")
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// "Logout" user by removing the authenticatedUserID, and flash success.
+	err = app.logOut(w, r)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.sessionManager.Put(r.Context(), string(flash), "You have succesfully logged out.")
+
+	// Redirect to home.
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// How long a password reset link remains valid.
+const passwordResetTTL = 30 * time.Minute
+
+// Struct containing form fields for the /user/password/forgot form.
+type userPasswordForgotForm struct {
+	Email               string     `form:"email"`
+	validator.Validator `form:"-"` // "-" tells formDecoder to ignore the field
+}
+
+func (app *application) userPasswordForgot(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = userPasswordForgotForm{}
+	app.render(w, r, http.StatusOK, "forgot.tmpl", data)
+}
+
+/*
+Emails a password reset link to the user with the submitted email address.
+
+The response is the same whether or not an account with that email exists, so
+the form can't be used to discover who has an account.
+*/
+func (app *application) userPasswordForgotPost(w http.ResponseWriter, r *http.Request) {
+	var form userPasswordForgotForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Email), "email", "This field can't be blank.")
+	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "Invalid email.")
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "forgot.tmpl", data)
+		return
+	}
+
+	user, err := app.users.GetByEmail(form.Email)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if err == nil {
+		// Only the most recently requested link should work.
+		err = app.tokens.DeleteAllForUser(models.ScopePasswordReset, user.ID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		token, err := app.tokens.New(user.ID, passwordResetTTL, models.ScopePasswordReset)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		// Send the email in the background, so that response time doesn't reveal
+		// whether the account exists.
+		app.background(func() {
+			data := map[string]any{
+				"name":     user.Name,
+				"resetURL": app.baseURL + "/user/password/reset/" + token.Plaintext,
+				"ttl":      passwordResetTTL.String(),
+			}
+
+			err := app.mailer.Send(user.Email, "password_reset.tmpl", data)
+			if err != nil {
+				app.logger.Error(err.Error())
+			}
+		})
+	}
+
+	app.sessionManager.Put(r.Context(), string(flash), "If an account with that email exists, we've sent it a link to reset the password.")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// Struct containing form fields for the /user/password/reset/:token form.
+type userPasswordResetForm struct {
+	NewPassword         string     `form:"newPassword"`
+	ConfirmPassword     string     `form:"confirmPassword"`
+	Token               string     `form:"-"`
+	validator.Validator `form:"-"` // "-" tells formDecoder to ignore the field
+}
+
+// Redirects to the forgot password page with a flash explaining that the
+// reset link can't be used.
+func (app *application) invalidPasswordResetToken(w http.ResponseWriter, r *http.Request) {
+	app.sessionManager.Put(r.Context(), string(flash), "That password reset link is invalid or has expired.")
+	http.Redirect(w, r, "/user/password/forgot", http.StatusSeeOther)
+}
+
+// Displays the form to choose a new password, if the token in the URL is valid.
+func (app *application) userPasswordReset(w http.ResponseWriter, r *http.Request) {
+	token := httprouter.ParamsFromContext(r.Context()).ByName("token")
+
+	_, err := app.tokens.GetUserID(models.ScopePasswordReset, token)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.invalidPasswordResetToken(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = userPasswordResetForm{Token: token}
+	app.render(w, r, http.StatusOK, "reset.tmpl", data)
+}
+
+/*
+Sets a new password for the user the reset token belongs to. The token is used
+up, and all of the user's sessions are destroyed, so anyone who had access to
+the account is logged out.
+*/
+func (app *application) userPasswordResetPost(w http.ResponseWriter, r *http.Request) {
+	form := userPasswordResetForm{
+		Token: httprouter.ParamsFromContext(r.Context()).ByName("token"),
+	}
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	id, err := app.tokens.GetUserID(models.ScopePasswordReset, form.Token)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.invalidPasswordResetToken(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.users.Get(id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.checkNewPassword(&form.Validator, form.NewPassword, form.ConfirmPassword, user)
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "reset.tmpl", data)
+		return
+	}
+
+	id, err = app.tokens.Consume(models.ScopePasswordReset, form.Token)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.invalidPasswordResetToken(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	err = app.users.PasswordUpdate(id, form.NewPassword)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.tokens.DeleteAllForUser(models.ScopePasswordReset, id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.recordAudit(r, models.AuditPasswordReset, models.AuditUserTarget(id), "")
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Log the user out everywhere.
+	err = app.sessions.DeleteAllForUser(id, "")
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.rememberTokens.DeleteAllForUser(id, "")
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), string(flash), "Your password has been reset, please log in.")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+//
+// Account handlers
+//
+
+// The number of audit events shown on the account page.
+const accountAuditLimit = 20
+
+type accountPasswordUpdateForm struct {
+	CurrentPassword     string     `form:"currentPassword"`
+	NewPassword         string     `form:"newPassword"`
+	ConfirmPassword     string     `form:"confirmPassword"`
+	validator.Validator `form:"-"` // "-" tells formDecoder to ignore the field
+}
+
+// Validates the new and confirmation password fields shared by the password
+// update and password reset forms. The new password must meet the password
+// policy for the user whose password it is.
+func (app *application) checkNewPassword(v *validator.Validator, newPassword, confirmPassword string, user models.User) {
+	v.CheckPassword("newPassword", newPassword, app.passwordPolicy, user.Email, user.Name)
+	v.CheckField(validator.NotBlank(confirmPassword), "confirmPassword", "This field can't be blank.")
+	v.CheckField(newPassword == confirmPassword, "confirmPassword", "Passwords don't match.")
+}
+
+func (app *application) accountPasswordUpdate(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = accountPasswordUpdateForm{}
+	app.render(w, r, http.StatusOK, "password.tmpl", data)
+}
+
+func (app *application) accountPasswordUpdatePost(w http.ResponseWriter, r *http.Request) {
 	var form accountPasswordUpdateForm
 
 	err := app.decodePostForm(r, &form)
@@ -304,73 +2312,1423 @@ func (app *application) accountPasswordUpdatePost(w http.ResponseWriter, r *http
 		return
 	}
 
-	form.CheckField(validator.NotBlank(form.NewPassword), "newPassword", "This field can't be blank.")
-	form.CheckField(validator.NotBlank(form.ConfirmPassword), "confirmPassword", "This field can't be blank.")
-	form.CheckField(validator.MinChars(form.NewPassword, 8), "newPassword", "Password must be at least 8 characters.")
-	form.CheckField(validator.MinChars(form.ConfirmPassword, 8), "confirmPassword", "Password must be at least 8 characters.")
-	form.CheckField(form.NewPassword == form.ConfirmPassword, "confirmPassword", "Passwords don't match.")
+	// Get ID from session data to retrieve user's data.
+	id := app.sessionManager.GetInt(r.Context(), string(authenticatedUserID))
+	user, err := app.users.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.checkNewPassword(&form.Validator, form.NewPassword, form.ConfirmPassword, user)
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "password.tmpl", data)
+		return
+	}
+
+	// Verify that user entered the correct password.
+	_, err = app.users.Authenticate(user.Email, form.CurrentPassword)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			form.AddNonFieldError("Password is incorrect.")
+			data := app.newTemplateData(r)
+			data.Form = form
+			app.render(w, r, http.StatusUnauthorized, "password.tmpl", data)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	// If validated and authenticated, update the password.
+	err = app.users.PasswordUpdate(id, form.NewPassword)
+	if err != nil {
+		form.AddNonFieldError("Failed to update password.")
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.recordAudit(r, models.AuditPasswordChange, models.AuditUserTarget(id), "")
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Anyone else logged in with the old password is logged out.
+	current := app.sessionManager.GetString(r.Context(), string(sessionID))
+	err = app.sessions.DeleteAllForUser(id, current)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	currentSeries, _, _ := readRememberMeCookie(r)
+	err = app.rememberTokens.DeleteAllForUser(id, currentSeries)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), string(flash), "Password successfully updated. Your other sessions have been logged out.")
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+// How long a link to confirm a new email address can be used for.
+const emailChangeTTL = 24 * time.Hour
+
+type accountEditForm struct {
+	Name                string     `form:"name"`
+	Handle              string     `form:"handle"`
+	ProfilePublic       bool       `form:"profilePublic"`
+	Email               string     `form:"email"`
+	CurrentPassword     string     `form:"currentPassword"`
+	validator.Validator `form:"-"` // "-" tells formDecoder to ignore the field
+}
+
+func (app *application) accountEdit(w http.ResponseWriter, r *http.Request) {
+	id := app.sessionManager.GetInt(r.Context(), string(authenticatedUserID))
+	user, err := app.users.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.User = user
+	data.Form = accountEditForm{
+		Name:          user.Name,
+		Handle:        user.Handle,
+		ProfilePublic: user.ProfilePublic,
+		Email:         user.Email,
+	}
+	app.render(w, r, http.StatusOK, "account_edit.tmpl", data)
+}
+
+/*
+Updates the logged in user's name, handle, profile visibility and email. All
+but the email take effect immediately. A new email requires the user's current
+password, and only takes effect once the user follows the confirmation link
+that is emailed to it, so that an account can't be moved to an address its
+owner doesn't control. Submitting the current email again cancels a pending
+change, and its link stops working.
+*/
+func (app *application) accountEditPost(w http.ResponseWriter, r *http.Request) {
+	var form accountEditForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	id := app.sessionManager.GetInt(r.Context(), string(authenticatedUserID))
+	user, err := app.users.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	form.Name = strings.TrimSpace(form.Name)
+	form.Handle = strings.ToLower(strings.TrimSpace(form.Handle))
+	form.Email = strings.TrimSpace(form.Email)
+	emailChanged := form.Email != user.Email
+
+	form.CheckField(validator.NotBlank(form.Name), "name", "This field can't be blank.")
+	form.CheckField(validator.MaxChars(form.Name, 255), "name", "This field can't be more than 255 characters long.")
+	form.CheckField(validator.Matches(form.Handle, validator.HandleRX), "handle", "Handles must be 3 to 30 lowercase letters, digits, hyphens or underscores.")
+	form.CheckField(validator.NotBlank(form.Email), "email", "This field can't be blank.")
+	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "Invalid email.")
+	if emailChanged {
+		form.CheckField(validator.NotBlank(form.CurrentPassword), "currentPassword", "Your password is required to change your email.")
+	}
+
+	renderForm := func(status int) {
+		data := app.newTemplateData(r)
+		data.User = user
+		data.Form = form
+		app.render(w, r, status, "account_edit.tmpl", data)
+	}
+
+	// The handle and email are checked before anything is changed, so that a
+	// taken handle or email doesn't leave the other changes half done.
+	if form.Handle != user.Handle && form.Valid() {
+		other, err := app.users.GetByHandle(form.Handle)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			app.serverError(w, r, err)
+			return
+		}
+		form.CheckField(err != nil || other.ID == id, "handle", "That handle is already taken.")
+	}
+
+	if emailChanged && form.Valid() {
+		other, err := app.users.GetByEmail(form.Email)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			app.serverError(w, r, err)
+			return
+		}
+		form.CheckField(err != nil || other.ID == id, "email", "That email is already in use.")
+	}
+
+	if !form.Valid() {
+		renderForm(http.StatusUnprocessableEntity)
+		return
+	}
+
+	if emailChanged {
+		_, err = app.users.Authenticate(user.Email, form.CurrentPassword)
+		if err != nil {
+			if errors.Is(err, models.ErrInvalidCredentials) {
+				form.AddFieldError("currentPassword", "Password is incorrect.")
+				renderForm(http.StatusUnauthorized)
+			} else {
+				app.serverError(w, r, err)
+			}
+			return
+		}
+	}
+
+	if form.Handle != user.Handle {
+		err = app.users.SetHandle(id, form.Handle)
+		if err != nil {
+			if errors.Is(err, models.ErrDuplicateHandle) {
+				form.AddFieldError("handle", "That handle is already taken.")
+				renderForm(http.StatusUnprocessableEntity)
+			} else {
+				app.serverError(w, r, err)
+			}
+			return
+		}
+	}
+
+	if form.Name != user.Name {
+		err = app.users.NameUpdate(id, form.Name)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	if form.ProfilePublic != user.ProfilePublic {
+		err = app.users.SetProfilePublic(id, form.ProfilePublic)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	if !emailChanged {
+		// Going back to the current email cancels a pending change.
+		if user.PendingEmail != "" {
+			err = app.tokens.DeleteAllForUser(models.ScopeEmailChange, id)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+
+			err = app.users.ClearPendingEmail(id)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+		}
+
+		app.sessionManager.Put(r.Context(), string(flash), "Your account has been updated.")
+		http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+		return
+	}
+
+	// The new email is recorded after the other changes, so that if one of
+	// them fails, it isn't left pending without a confirmation link.
+	err = app.users.SetPendingEmail(id, form.Email)
+	if err != nil {
+		if errors.Is(err, models.ErrDuplicateEmail) {
+			form.AddFieldError("email", "That email is already in use.")
+			renderForm(http.StatusUnprocessableEntity)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	// Only the link for the most recently requested email should work.
+	err = app.tokens.DeleteAllForUser(models.ScopeEmailChange, id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	token, err := app.tokens.New(id, emailChangeTTL, models.ScopeEmailChange)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.background(func() {
+		data := map[string]any{
+			"name":       form.Name,
+			"email":      form.Email,
+			"confirmURL": app.baseURL + "/user/email/confirm/" + token.Plaintext,
+			"ttl":        emailChangeTTL.String(),
+		}
+
+		err := app.mailer.Send(form.Email, "email_change.tmpl", data)
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	})
+
+	app.sessionManager.Put(r.Context(), string(flash), fmt.Sprintf("We've sent a confirmation link to %s. Your email will change once you follow it.", form.Email))
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+// Redirects to the home page with a flash explaining that the email
+// confirmation link can't be used.
+func (app *application) invalidEmailChangeToken(w http.ResponseWriter, r *http.Request) {
+	app.sessionManager.Put(r.Context(), string(flash), "That email confirmation link is invalid or has expired.")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// Displays the new email that a confirmation link is for, with a button to
+// confirm it.
+func (app *application) userEmailConfirm(w http.ResponseWriter, r *http.Request) {
+	token := httprouter.ParamsFromContext(r.Context()).ByName("token")
+
+	id, err := app.tokens.GetUserID(models.ScopeEmailChange, token)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.invalidEmailChangeToken(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.users.Get(id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if user.PendingEmail == "" {
+		app.invalidEmailChangeToken(w, r)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.User = user
+	data.Token = token
+	app.render(w, r, http.StatusOK, "email_confirm.tmpl", data)
+}
+
+/*
+Replaces the email of the user the confirmation token belongs to with their
+pending email. The token is used up, and a notice is sent to the old email, so
+that the owner finds out if someone else changed it.
+*/
+func (app *application) userEmailConfirmPost(w http.ResponseWriter, r *http.Request) {
+	token := httprouter.ParamsFromContext(r.Context()).ByName("token")
+
+	id, err := app.tokens.Consume(models.ScopeEmailChange, token)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.invalidEmailChangeToken(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.users.Get(id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	email, err := app.users.ConfirmPendingEmail(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrNoRecord):
+			app.invalidEmailChangeToken(w, r)
+		case errors.Is(err, models.ErrDuplicateEmail):
+			app.sessionManager.Put(r.Context(), string(flash), "That email is now in use by another account, so your email hasn't been changed.")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+		default:
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	err = app.recordAudit(r, models.AuditEmailChange, models.AuditUserTarget(id), email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.background(func() {
+		data := map[string]any{
+			"name":     user.Name,
+			"newEmail": email,
+		}
+
+		err := app.mailer.Send(user.Email, "email_changed.tmpl", data)
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	})
+
+	app.sessionManager.Put(r.Context(), string(flash), "Your email has been changed to "+email+".")
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+// The number of snippets on each page of a user's profile.
+const profilePageSize = 20
+
+// The pages before and after the current page of a paginated list.
+type pagination struct {
+	Page int // the current page, starting from 1
+	Prev int // the previous page, or 0 if this is the first
+	Next int // the next page, or 0 if this is the last
+}
+
+/*
+Displays a user's profile, with their name, when they joined, and a page of
+their public snippets. The page is given by the "page" query parameter, and
+defaults to the first.
+
+Profiles that their users have hidden can only be viewed by those users, and
+profiles of disabled users can't be viewed at all.
+*/
+func (app *application) userProfile(w http.ResponseWriter, r *http.Request) {
+	handle := httprouter.ParamsFromContext(r.Context()).ByName("handle")
+
+	user, err := app.users.GetByHandle(handle)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	self := user.ID == app.sessionManager.GetInt(r.Context(), string(authenticatedUserID))
+	if user.Disabled || (!user.ProfilePublic && !self) {
+		app.notFound(w)
+		return
+	}
+
+	page := 1
+	if p := r.URL.Query().Get("page"); p != "" {
+		page, err = strconv.Atoi(p)
+		if err != nil || page < 1 {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+	}
+
+	// One more snippet than fits on the page is fetched, to find out whether
+	// there's a next page.
+	snippets, err := app.snippets.GetPublicForUser(user.ID, profilePageSize+1, (page-1)*profilePageSize)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	pages := pagination{Page: page, Prev: page - 1}
+	if len(snippets) > profilePageSize {
+		snippets = snippets[:profilePageSize]
+		pages.Next = page + 1
+	}
+
+	data := app.newTemplateData(r)
+	data.User = user
+	data.Snippets = snippets
+	data.Pagination = pages
+	app.render(w, r, http.StatusOK, "profile.tmpl", data)
+}
+
+// Lists the unexpired snippets that the logged in user has starred.
+func (app *application) accountStarred(w http.ResponseWriter, r *http.Request) {
+	id := app.sessionManager.GetInt(r.Context(), string(authenticatedUserID))
+	snippets, err := app.snippets.Starred(id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Snippets = snippets
+
+	app.render(w, r, http.StatusOK, "starred.tmpl", data)
+}
+
+// Displays account page in response to GET /account/view.
+func (app *application) accountView(w http.ResponseWriter, r *http.Request) {
+	app.renderAccount(w, r, http.StatusOK, accountAvatarForm{})
+}
+
+// Renders the logged in user's account page. The form is the avatar upload
+// form, which is rendered with its errors if an upload was invalid.
+func (app *application) renderAccount(w http.ResponseWriter, r *http.Request, status int, form accountAvatarForm) {
+	id := app.sessionManager.GetInt(r.Context(), string(authenticatedUserID))
+	user, err := app.users.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	_, err = app.totp.Get(id)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(w, r, err)
+		return
+	}
+
+	totpEnabled := err == nil
+
+	events, err := app.audit.ForUser(id, accountAuditLimit)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	identities, err := app.identities.ForUser(id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.User = user
+	data.TOTPEnabled = totpEnabled
+	data.AuditEvents = events
+	data.Identities = identities
+	data.Form = form
+
+	app.render(w, r, status, "account.tmpl", data)
+}
+
+// The largest avatar image that can be uploaded.
+const maxAvatarSize = 2 << 20
+
+// The avatar upload form. The image is read from the multipart form, rather
+// than decoded.
+type accountAvatarForm struct {
+	validator.Validator `form:"-"`
+}
+
+// Returns the key in the blob store of the avatar with the given ID, resized
+// to the given size.
+func avatarKey(avatarID string, size int) string {
+	return fmt.Sprintf("avatars/%s-%d.png", avatarID, size)
+}
+
+// Deletes the images of the avatar with the given ID from the blob store. The
+// avatar is no longer used, so failures are only logged.
+func (app *application) deleteAvatar(avatarID string) {
+	if avatarID == "" {
+		return
+	}
+
+	for _, size := range avatar.Sizes {
+		err := app.blobs.Delete(avatarKey(avatarID, size))
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	}
+}
+
+/*
+Sets the logged in user's avatar to an uploaded PNG, JPEG or GIF image. The
+image is resized to each of avatar.Sizes, and stored in the blob store under a
+new random ID, so that the URLs of the old avatar's images can be cached
+forever. The old avatar's images are deleted.
+
+If the image is missing, too large or in another format, the account page is
+rendered again with a 422 status code.
+*/
+func (app *application) accountAvatarPost(w http.ResponseWriter, r *http.Request) {
+	var form accountAvatarForm
+
+	file, _, err := r.FormFile("avatar")
+	if err != nil {
+		if !errors.Is(err, http.ErrMissingFile) {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+		form.AddFieldError("avatar", "Please choose an image to upload.")
+		app.renderAccount(w, r, http.StatusUnprocessableEntity, form)
+		return
+	}
+	defer file.Close()
+
+	content, err := io.ReadAll(io.LimitReader(file, maxAvatarSize+1))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	var images map[int][]byte
+	if len(content) > maxAvatarSize {
+		form.AddFieldError("avatar", fmt.Sprintf("This image can't be larger than %d MB.", maxAvatarSize>>20))
+	} else {
+		images, err = avatar.Process(content)
+		switch {
+		case errors.Is(err, avatar.ErrUnsupportedFormat):
+			form.AddFieldError("avatar", "Avatars must be PNG, JPEG or GIF images.")
+		case errors.Is(err, avatar.ErrTooLarge):
+			form.AddFieldError("avatar", fmt.Sprintf("Avatars can't be wider or taller than %d pixels.", avatar.MaxDimension))
+		case err != nil:
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	if !form.Valid() {
+		app.renderAccount(w, r, http.StatusUnprocessableEntity, form)
+		return
+	}
+
+	id := app.sessionManager.GetInt(r.Context(), string(authenticatedUserID))
+	user, err := app.users.Get(id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	b := make([]byte, 16)
+	_, err = rand.Read(b)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	avatarID := hex.EncodeToString(b)
+
+	for size, img := range images {
+		err = app.blobs.Put(avatarKey(avatarID, size), bytes.NewReader(img))
+		if err != nil {
+			app.deleteAvatar(avatarID)
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	err = app.users.SetAvatar(id, avatarID)
+	if err != nil {
+		app.deleteAvatar(avatarID)
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.deleteAvatar(user.Avatar)
+
+	app.sessionManager.Put(r.Context(), string(flash), "Your avatar has been updated.")
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+// Removes the logged in user's avatar, and deletes its images.
+func (app *application) accountAvatarDeletePost(w http.ResponseWriter, r *http.Request) {
+	id := app.sessionManager.GetInt(r.Context(), string(authenticatedUserID))
+	user, err := app.users.Get(id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.users.SetAvatar(id, "")
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.deleteAvatar(user.Avatar)
+
+	app.sessionManager.Put(r.Context(), string(flash), "Your avatar has been removed.")
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+// Matches the file names of avatar images, capturing the size.
+var avatarFileRX = regexp.MustCompile(`^[0-9a-f]{32}-([0-9]+)\.png$`)
+
+/*
+Serves an avatar image in response to GET /avatars/:file, where the file name
+is the avatar's ID and size, such as 1f2e...-96.png.
+
+An avatar's images never change, since a new avatar gets a new ID, so they're
+cached for a year without being revalidated.
+*/
+func (app *application) avatarView(w http.ResponseWriter, r *http.Request) {
+	name := httprouter.ParamsFromContext(r.Context()).ByName("file")
+
+	m := avatarFileRX.FindStringSubmatch(name)
+	if m == nil {
+		app.notFound(w)
+		return
+	}
+	size, err := strconv.Atoi(m[1])
+	if err != nil || !slices.Contains(avatar.Sizes, size) {
+		app.notFound(w)
+		return
+	}
+
+	f, err := app.blobs.Open("avatars/" + name)
+	if err != nil {
+		if errors.Is(err, blob.ErrNotFound) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	_, err = io.Copy(w, f)
+	if err != nil {
+		app.logger.Error(err.Error())
+	}
+}
+
+// Displays the form to enable two-factor authentication. A new TOTP secret is
+// generated and kept in the session until the user confirms it by entering a
+// code from their authenticator app.
+func (app *application) accountTOTPSetup(w http.ResponseWriter, r *http.Request) {
+	id := app.sessionManager.GetInt(r.Context(), string(authenticatedUserID))
+
+	_, err := app.totp.Get(id)
+	if err == nil {
+		app.sessionManager.Put(r.Context(), string(flash), "Two-factor authentication is already enabled.")
+		http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+		return
+	} else if !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(w, r, err)
+		return
+	}
+
+	secret := app.sessionManager.GetString(r.Context(), string(totpSetupSecret))
+	if secret == "" {
+		secret, err = totp.GenerateSecret()
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		app.sessionManager.Put(r.Context(), string(totpSetupSecret), secret)
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = totpCodeForm{}
+	data.TOTPSecret = secret
+	app.render(w, r, http.StatusOK, "totp_setup.tmpl", data)
+}
+
+// Responds with a PNG QR code of the TOTP secret being enrolled, for scanning
+// with an authenticator app.
+func (app *application) accountTOTPQRCode(w http.ResponseWriter, r *http.Request) {
+	secret := app.sessionManager.GetString(r.Context(), string(totpSetupSecret))
+	if secret == "" {
+		app.notFound(w)
+		return
+	}
+
+	id := app.sessionManager.GetInt(r.Context(), string(authenticatedUserID))
+	user, err := app.users.Get(id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	png, err := qrcode.Encode(totp.URI("Snippetbox", user.Email, secret), qrcode.Medium, 256)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(png)
+}
+
+// Enables two-factor authentication if the code entered matches the secret
+// being enrolled, and displays the user's recovery codes.
+func (app *application) accountTOTPSetupPost(w http.ResponseWriter, r *http.Request) {
+	secret := app.sessionManager.GetString(r.Context(), string(totpSetupSecret))
+	if secret == "" {
+		http.Redirect(w, r, "/account/totp/setup", http.StatusSeeOther)
+		return
+	}
+
+	var form totpCodeForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Code), "code", "This field can't be blank.")
+
+	step, ok := totp.Validate(secret, strings.ReplaceAll(form.Code, " ", ""), time.Now())
+	form.CheckField(ok, "code", "Code is incorrect.")
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		data.TOTPSecret = secret
+		app.render(w, r, http.StatusUnprocessableEntity, "totp_setup.tmpl", data)
+		return
+	}
+
+	id := app.sessionManager.GetInt(r.Context(), string(authenticatedUserID))
+	codes, err := app.totp.Enable(id, secret)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// The code used to confirm the secret can't be used again to log in.
+	err = app.totp.UseStep(id, step)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Remove(r.Context(), string(totpSetupSecret))
+
+	// The recovery codes are rendered directly, rather than after a redirect,
+	// because this is the only time they are available.
+	data := app.newTemplateData(r)
+	data.Flash = "Two-factor authentication enabled."
+	data.RecoveryCodes = codes
+	app.render(w, r, http.StatusOK, "totp_codes.tmpl", data)
+}
+
+// Struct containing form fields for the /account/totp/disable form.
+type accountTOTPDisableForm struct {
+	Password            string     `form:"password"`
+	validator.Validator `form:"-"` // "-" tells formDecoder to ignore the field
+}
+
+func (app *application) accountTOTPDisable(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = accountTOTPDisableForm{}
+	app.render(w, r, http.StatusOK, "totp_disable.tmpl", data)
+}
+
+// Disables two-factor authentication, after confirming the user's password.
+func (app *application) accountTOTPDisablePost(w http.ResponseWriter, r *http.Request) {
+	var form accountTOTPDisableForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Password), "password", "This field can't be blank.")
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "totp_disable.tmpl", data)
+		return
+	}
+
+	id := app.sessionManager.GetInt(r.Context(), string(authenticatedUserID))
+	user, err := app.users.Get(id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	_, err = app.users.Authenticate(user.Email, form.Password)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			form.AddNonFieldError("Password is incorrect.")
+			data := app.newTemplateData(r)
+			data.Form = form
+			app.render(w, r, http.StatusUnauthorized, "totp_disable.tmpl", data)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	err = app.totp.Disable(id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), string(flash), "Two-factor authentication disabled.")
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+// Displays the user's active sessions in response to GET /account/sessions.
+func (app *application) accountSessions(w http.ResponseWriter, r *http.Request) {
+	id := app.sessionManager.GetInt(r.Context(), string(authenticatedUserID))
+
+	sessions, err := app.sessions.GetAllForUser(id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Sessions = sessions
+	data.CurrentSessionID = app.sessionManager.GetString(r.Context(), string(sessionID))
+
+	app.render(w, r, http.StatusOK, "sessions.tmpl", data)
+}
+
+// Struct containing form fields for the session revocation form.
+type accountSessionRevokeForm struct {
+	ID string `form:"id"`
+}
+
+// Revokes one of the user's sessions. If it's the current session, the user
+// is logged out.
+func (app *application) accountSessionRevokePost(w http.ResponseWriter, r *http.Request) {
+	var form accountSessionRevokeForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if form.ID == app.sessionManager.GetString(r.Context(), string(sessionID)) {
+		err = app.logOut(w, r)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		app.sessionManager.Put(r.Context(), string(flash), "You have succesfully logged out.")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	id := app.sessionManager.GetInt(r.Context(), string(authenticatedUserID))
+	sessions, err := app.sessions.GetAllForUser(id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	for _, session := range sessions {
+		if session.ID != form.ID {
+			continue
+		}
+
+		err = app.sessions.Delete(session.ID, id)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		// Otherwise the device would be logged straight back in.
+		if session.RememberSeries != "" {
+			err = app.rememberTokens.Delete(session.RememberSeries)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+		}
+	}
+
+	app.sessionManager.Put(r.Context(), string(flash), "Session logged out.")
+	http.Redirect(w, r, "/account/sessions", http.StatusSeeOther)
+}
+
+// Revokes all of the user's sessions and remember me tokens, including the
+// current ones.
+func (app *application) accountSessionRevokeAllPost(w http.ResponseWriter, r *http.Request) {
+	id := app.sessionManager.GetInt(r.Context(), string(authenticatedUserID))
+
+	err := app.sessions.DeleteAllForUser(id, "")
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.rememberTokens.DeleteAllForUser(id, "")
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.logOut(w, r)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), string(flash), "You have been logged out everywhere.")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// Struct containing form fields for the /account/delete form.
+type accountDeleteForm struct {
+	Password            string     `form:"password"`
+	DeleteSnippets      bool       `form:"deleteSnippets"`
+	validator.Validator `form:"-"` // "-" tells formDecoder to ignore the field
+}
+
+func (app *application) accountDelete(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = accountDeleteForm{}
+	app.render(w, r, http.StatusOK, "delete.tmpl", data)
+}
+
+/*
+Deletes the user's account, after confirming their password, and logs them out.
+
+The user's sessions, tokens and two-factor authentication settings are deleted
+along with their account. Their personal snippets are only deleted if they ask
+for them to be, otherwise they are kept without an owner. Their team snippets
+are kept by their teams.
+
+A team's last owner can't delete their account, since the team would be left
+without an owner.
+*/
+func (app *application) accountDeletePost(w http.ResponseWriter, r *http.Request) {
+	var form accountDeleteForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Password), "password", "This field can't be blank.")
 
 	if !form.Valid() {
 		data := app.newTemplateData(r)
 		data.Form = form
-		app.render(w, r, http.StatusUnprocessableEntity, "password.tmpl", data)
+		app.render(w, r, http.StatusUnprocessableEntity, "delete.tmpl", data)
 		return
 	}
 
-	// Get ID from session data to retrieve user's data.
 	id := app.sessionManager.GetInt(r.Context(), string(authenticatedUserID))
 	user, err := app.users.Get(id)
 	if err != nil {
-		if errors.Is(err, models.ErrNoRecord) {
-			app.notFound(w)
+		app.serverError(w, r, err)
+		return
+	}
+
+	_, err = app.users.Authenticate(user.Email, form.Password)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			form.AddNonFieldError("Password is incorrect.")
+			data := app.newTemplateData(r)
+			data.Form = form
+			app.render(w, r, http.StatusUnauthorized, "delete.tmpl", data)
 		} else {
 			app.serverError(w, r, err)
 		}
 		return
 	}
 
-	// Verify that user entered the correct password.
-	_, err = app.users.Authenticate(user.Email, form.CurrentPassword)
+	err = app.users.Delete(id, form.DeleteSnippets)
 	if err != nil {
-		if errors.Is(err, models.ErrInvalidCredentials) {
-			form.AddNonFieldError("Password is incorrect.")
+		if errors.Is(err, models.ErrLastOwner) {
+			form.AddNonFieldError("You're the last owner of a team. Make someone else an owner first, or delete the team.")
 			data := app.newTemplateData(r)
 			data.Form = form
-			app.render(w, r, http.StatusUnauthorized, "password.tmpl", data)
+			app.render(w, r, http.StatusConflict, "delete.tmpl", data)
 		} else {
 			app.serverError(w, r, err)
 		}
 		return
 	}
 
-	// If validated and authenticated, update the password.
-	err = app.users.PasswordUpdate(id, form.NewPassword)
+	app.deleteAvatar(user.Avatar)
+
+	err = app.logOut(w, r)
 	if err != nil {
-		form.AddNonFieldError("Failed to update password.")
 		app.serverError(w, r, err)
 		return
 	}
 
-	app.sessionManager.Put(r.Context(), string(flash), "Password successfully updated.")
-	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+	app.sessionManager.Put(r.Context(), string(flash), "Your account has been deleted.")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
-// Displays account page in response to GET /account/view.
-func (app *application) accountView(w http.ResponseWriter, r *http.Request) {
+// The user's data, as exported by /account/export.
+type accountExport struct {
+	Profile  accountExportProfile   `json:"profile"`
+	Snippets []accountExportSnippet `json:"snippets"`
+}
+
+type accountExportProfile struct {
+	Name    string    `json:"name"`
+	Email   string    `json:"email"`
+	Created time.Time `json:"created"`
+}
+
+type accountExportSnippet struct {
+	ID      int                  `json:"id"`
+	Title   string               `json:"title"`
+	Format  string               `json:"format"`
+	Files   []models.SnippetFile `json:"files"`
+	Created time.Time            `json:"created"`
+	Expires time.Time            `json:"expires"`
+}
+
+/*
+Sends the user a copy of their profile and snippets in response to
+GET /account/export.
+
+By default the data is sent as a single JSON document. With ?format=zip it is
+sent as a zip archive containing the same JSON document, and each snippet's
+files in a directory named after the snippet's ID.
+*/
+func (app *application) accountExport(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if !validator.PermittedValue(format, "", "json", "zip") {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
 
 	id := app.sessionManager.GetInt(r.Context(), string(authenticatedUserID))
 	user, err := app.users.Get(id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	snippets, err := app.snippets.GetAllForUser(id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	export := accountExport{
+		Profile: accountExportProfile{
+			Name:    user.Name,
+			Email:   user.Email,
+			Created: user.Created,
+		},
+		Snippets: []accountExportSnippet{},
+	}
+	for _, s := range snippets {
+		export.Snippets = append(export.Snippets, accountExportSnippet{
+			ID:      s.ID,
+			Title:   s.Title,
+			Format:  s.Format,
+			Files:   s.Files,
+			Created: s.Created,
+			Expires: s.Expires,
+		})
+	}
+
+	js, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if format != "zip" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="snippetbox-export.json"`)
+		w.Write(js)
+		return
+	}
+
+	// Build the archive in memory, so that a server error can still be sent if
+	// something goes wrong.
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+
+	f, err := zw.Create("snippetbox-export/account.json")
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	f.Write(js)
+
+	for _, s := range snippets {
+		for _, file := range s.Files {
+			f, err := zw.Create(fmt.Sprintf("snippetbox-export/snippets/%d/%s", s.ID, file.Name))
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+			f.Write([]byte(file.Content))
+		}
+	}
+
+	err = zw.Close()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="snippetbox-export.zip"`)
+	buf.WriteTo(w)
+}
+
+//
+// Admin handlers
+//
+
+// The number of audit events shown on the admin audit log page.
+const adminAuditLimit = 100
+
+// The maximum number of audit events in a CSV export of the audit log.
+const adminAuditExportLimit = 10000
+
+// Struct containing form fields for disabling or re-enabling a user.
+type adminUserDisableForm struct {
+	Disabled bool `form:"disabled"`
+}
+
+// Struct containing form fields for changing a user's role.
+type adminUserRoleForm struct {
+	Role string `form:"role"`
+}
+
+/*
+Returns the user whose ID is in the URL, for an admin to act on. If there's no
+matching user, a 404 NotFound response is sent and ok is false. Admins can't
+act on their own accounts, so that they can't lock themselves out, and if they
+try, a 403 Forbidden response is sent.
+*/
+func (app *application) adminUserFromURL(w http.ResponseWriter, r *http.Request) (user models.User, ok bool) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return models.User{}, false
+	}
+
+	if id == app.sessionManager.GetInt(r.Context(), string(authenticatedUserID)) {
+		app.clientError(w, http.StatusForbidden)
+		return models.User{}, false
+	}
+
+	user, err = app.users.Get(id)
 	if err != nil {
 		if errors.Is(err, models.ErrNoRecord) {
 			app.notFound(w)
 		} else {
 			app.serverError(w, r, err)
 		}
+		return models.User{}, false
+	}
+
+	return user, true
+}
+
+// Lists all users, with forms to change their roles and to disable them.
+func (app *application) adminUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := app.users.All()
+	if err != nil {
+		app.serverError(w, r, err)
 		return
 	}
 
 	data := app.newTemplateData(r)
-	data.User = user
+	data.Users = users
+	app.render(w, r, http.StatusOK, "admin_users.tmpl", data)
+}
+
+// Disables or re-enables a user's account. Disabling an account also logs the
+// user out everywhere.
+func (app *application) adminUserDisablePost(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.adminUserFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	var form adminUserDisableForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = app.users.SetDisabled(user.ID, form.Disabled)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	action, message := models.AuditUserEnabled, "Account enabled."
+	if form.Disabled {
+		action, message = models.AuditUserDisabled, "Account disabled."
+
+		err = app.sessions.DeleteAllForUser(user.ID, "")
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		err = app.rememberTokens.DeleteAllForUser(user.ID, "")
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	err = app.recordAudit(r, action, models.AuditUserTarget(user.ID), "")
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), string(flash), message)
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// Changes a user's role.
+func (app *application) adminUserRolePost(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.adminUserFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	var form adminUserRoleForm
+	err := app.decodePostForm(r, &form)
+	if err != nil || !validator.PermittedValue(form.Role, models.RoleUser, models.RoleModerator, models.RoleAdmin) {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = app.users.SetRole(user.ID, form.Role)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.recordAudit(r, models.AuditUserRole, models.AuditUserTarget(user.ID), form.Role)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), string(flash), "Role updated.")
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// Deletes any snippet, such as one that is abusive. Moderators and admins can
+// delete snippets in teams they aren't members of, so the snippet is looked up
+// directly instead of with app.getSnippet.
+func (app *application) adminSnippetDeletePost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	snippet, err := app.snippets.Get(id)
+	if err == nil {
+		err = app.snippets.Delete(id)
+	}
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	err = app.recordAudit(r, models.AuditSnippetDelete, fmt.Sprintf("snippet:%d", id), snippet.Title)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), string(flash), "Snippet deleted.")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// Returns the audit log filter in the request's query string.
+func auditFilterFromQuery(r *http.Request) models.AuditFilter {
+	qs := r.URL.Query()
+	return models.AuditFilter{
+		Action: qs.Get("action"),
+		Actor:  strings.TrimSpace(qs.Get("actor")),
+		Target: strings.TrimSpace(qs.Get("target")),
+	}
+}
+
+// Displays the latest events in the audit log, filtered by the action, actor
+// and target in the query string, if any.
+func (app *application) adminAudit(w http.ResponseWriter, r *http.Request) {
+	filter := auditFilterFromQuery(r)
+
+	events, err := app.audit.Latest(filter, adminAuditLimit)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.AuditEvents = events
+	data.AuditFilter = filter
+	app.render(w, r, http.StatusOK, "admin_audit.tmpl", data)
+}
+
+// Returns s, escaped so that spreadsheet apps don't treat it as a formula.
+// Audit events contain text from clients, such as user agents, so they can't
+// be trusted.
+func csvSafe(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// Downloads the audit log as a CSV file, filtered like the audit log page.
+func (app *application) adminAuditExport(w http.ResponseWriter, r *http.Request) {
+	events, err := app.audit.Latest(auditFilterFromQuery(r), adminAuditExportLimit)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Write to a buffer first, so that an error can still be sent as a 500.
+	buf := new(bytes.Buffer)
+	cw := csv.NewWriter(buf)
+	cw.Write([]string{"id", "time", "actor_id", "actor", "action", "target", "detail", "ip", "user_agent"})
+	for _, e := range events {
+		cw.Write([]string{
+			strconv.Itoa(e.ID),
+			e.Created.UTC().Format(time.RFC3339),
+			strconv.Itoa(e.ActorID),
+			csvSafe(e.ActorName),
+			e.Action,
+			csvSafe(e.Target),
+			csvSafe(e.Detail),
+			e.IP,
+			csvSafe(e.UserAgent),
+		})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		app.serverError(w, r, err)
+		return
+	}
 
-	app.render(w, r, http.StatusOK, "account.tmpl", data)
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit-log.csv"`)
+	buf.WriteTo(w)
 }
@@ -1,13 +1,29 @@
 package main
 
 import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"image"
+	"image/png"
 	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
 	"regexp"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	assert "github.com/kvnloughead/snippetbox/internal"
+	"github.com/kvnloughead/snippetbox/internal/avatar"
+	"github.com/kvnloughead/snippetbox/internal/diff"
+	"github.com/kvnloughead/snippetbox/internal/models"
+	"github.com/kvnloughead/snippetbox/internal/models/mocks"
+	"github.com/kvnloughead/snippetbox/internal/totp"
+	"github.com/kvnloughead/snippetbox/internal/viewcount"
 )
 
 func TestPing(t *testing.T) {
@@ -59,6 +75,146 @@ func TestSnippetCreate(t *testing.T) {
 		assert.Equal(t, code, http.StatusOK)
 		assert.StringContainsMatch(t, body, formTag)
 	})
+
+	t.Run("Tags", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			tags     string
+			wantCode int
+			wantBody string
+		}{
+			{
+				name:     "Valid",
+				tags:     "SQL, shell, sql, ",
+				wantCode: http.StatusSeeOther,
+			},
+			{
+				name:     "Too many",
+				tags:     "a, b, c, d, e, f",
+				wantCode: http.StatusUnprocessableEntity,
+				wantBody: "This can&#39;t contain more than 5 tags.",
+			},
+			{
+				name:     "Too long",
+				tags:     strings.Repeat("a", 31),
+				wantCode: http.StatusUnprocessableEntity,
+				wantBody: "Tags can&#39;t contain more than 30 characters.",
+			},
+			{
+				name:     "Invalid characters",
+				tags:     "two words",
+				wantCode: http.StatusUnprocessableEntity,
+				wantBody: "Tags can only contain letters, numbers, hyphens and underscores.",
+			},
+		}
+
+		for _, sub := range tests {
+			t.Run(sub.name, func(t *testing.T) {
+				_, _, body := ts.get(t, "/snippet/create")
+
+				form := url.Values{}
+				form.Add("title", "Title")
+				form.Add("files[0].name", "snippet.txt")
+				form.Add("files[0].language", "text")
+				form.Add("files[0].content", "Content")
+				form.Add("expires", "7")
+				form.Add("tags", sub.tags)
+				form.Add("csrf_token", extractCSRFToken(t, body))
+
+				code, _, body := ts.post(t, "/snippet/create", form)
+				assert.Equal(t, code, sub.wantCode)
+				if sub.wantBody != "" {
+					assert.StringContains(t, body, sub.wantBody)
+				}
+			})
+		}
+	})
+
+	t.Run("Format", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			format   string
+			wantCode int
+		}{
+			{name: "Plain", format: "plain", wantCode: http.StatusSeeOther},
+			{name: "Markdown", format: "markdown", wantCode: http.StatusSeeOther},
+			{name: "Default", format: "", wantCode: http.StatusSeeOther},
+			{name: "Invalid", format: "html", wantCode: http.StatusUnprocessableEntity},
+		}
+
+		for _, sub := range tests {
+			t.Run(sub.name, func(t *testing.T) {
+				_, _, body := ts.get(t, "/snippet/create")
+
+				form := url.Values{}
+				form.Add("title", "Title")
+				form.Add("format", sub.format)
+				form.Add("files[0].name", "notes.md")
+				form.Add("files[0].language", "markdown")
+				form.Add("files[0].content", "# Notes")
+				form.Add("expires", "7")
+				form.Add("csrf_token", extractCSRFToken(t, body))
+
+				code, _, body := ts.post(t, "/snippet/create", form)
+				assert.Equal(t, code, sub.wantCode)
+				if sub.wantCode == http.StatusUnprocessableEntity {
+					assert.StringContains(t, body, "This field must equal plain or markdown.")
+				}
+			})
+		}
+	})
+}
+
+func TestParseTags(t *testing.T) {
+	tags := parseTags(" Go, sql,,go , shell-scripts ")
+	assert.Equal(t, strings.Join(tags, " "), "go sql shell-scripts")
+}
+
+func TestTagView(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Tag list",
+			urlPath:  "/tags",
+			wantCode: http.StatusOK,
+			wantBody: `<a class="tag" href="/tags/mock">mock</a>`,
+		},
+		{
+			name:     "Existing tag",
+			urlPath:  "/tags/mock",
+			wantCode: http.StatusOK,
+			wantBody: `<a href="/snippet/view/1">Mock snippet</a>`,
+		},
+		{
+			name:     "Unused tag",
+			urlPath:  "/tags/unused",
+			wantCode: http.StatusOK,
+			wantBody: "There are no snippets with this tag.",
+		},
+		{
+			name:     "Invalid tag",
+			urlPath:  "/tags/Not%20Valid",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, sub := range tests {
+		t.Run(sub.name, func(t *testing.T) {
+			code, _, body := ts.get(t, sub.urlPath)
+			assert.Equal(t, code, sub.wantCode)
+			if sub.wantBody != "" {
+				assert.StringContains(t, body, sub.wantBody)
+			}
+		})
+	}
 }
 
 func TestSnippetView(t *testing.T) {
@@ -119,6 +275,21 @@ func TestSnippetView(t *testing.T) {
 			}
 		})
 	}
+
+	t.Run("Markdown", func(t *testing.T) {
+		code, _, body := ts.get(t, "/snippet/view/4")
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, `<h1 id="md-mock-notes">Mock notes<a href="#md-mock-notes" class="anchor"`)
+		assert.StringContains(t, body, `<pre><code class="language-go">fmt.Println()`)
+		if strings.Contains(body, "<script>alert(1)</script>") {
+			t.Errorf("expected raw HTML in Markdown to be removed")
+		}
+	})
+
+	t.Run("Plain", func(t *testing.T) {
+		_, _, body := ts.get(t, "/snippet/view/1")
+		assert.StringContains(t, body, `<pre><code class="language-text">This is a mock snippet.</code></pre>`)
+	})
 }
 
 func TestUserSignup(t *testing.T) {
@@ -147,6 +318,7 @@ func TestUserSignup(t *testing.T) {
 		csrfToken    string
 		wantCode     int
 		wantFormTag  *regexp.Regexp
+		wantError    string
 	}{
 		{
 			name:         "Valid submission",
@@ -205,6 +377,24 @@ func TestUserSignup(t *testing.T) {
 			csrfToken:    csrfToken,
 			wantCode:     http.StatusUnprocessableEntity,
 		},
+		{
+			name:         "Common password",
+			userName:     validName,
+			userEmail:    validEmail,
+			userPassword: "password123",
+			csrfToken:    csrfToken,
+			wantCode:     http.StatusUnprocessableEntity,
+			wantError:    "This password is too common. Please choose another.",
+		},
+		{
+			name:         "Password contains name",
+			userName:     "Alice Smith",
+			userEmail:    validEmail,
+			userPassword: "smith-pa$$word",
+			csrfToken:    csrfToken,
+			wantCode:     http.StatusUnprocessableEntity,
+			wantError:    "Password can&#39;t contain your name.",
+		},
 		{
 			name:         "Duplicate email",
 			userName:     validName,
@@ -230,6 +420,2373 @@ func TestUserSignup(t *testing.T) {
 			if sub.wantFormTag != nil {
 				assert.StringContainsMatch(t, body, sub.wantFormTag)
 			}
+			if sub.wantError != "" {
+				assert.StringContains(t, body, sub.wantError)
+			}
+		})
+	}
+}
+
+func TestUserPasswordForgot(t *testing.T) {
+	tests := []struct {
+		name       string
+		email      string
+		wantCode   int
+		wantEmails int
+	}{
+		{
+			name:       "Existing email",
+			email:      "testuser@mail.com",
+			wantCode:   http.StatusSeeOther,
+			wantEmails: 1,
+		},
+		{
+			// The response must not reveal that the account doesn't exist.
+			name:       "Non-existing email",
+			email:      "nobody@mail.com",
+			wantCode:   http.StatusSeeOther,
+			wantEmails: 0,
+		},
+		{
+			name:       "Invalid email",
+			email:      "bad@email.",
+			wantCode:   http.StatusUnprocessableEntity,
+			wantEmails: 0,
+		},
+	}
+
+	for _, sub := range tests {
+		t.Run(sub.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			_, _, body := ts.get(t, "/user/password/forgot")
+			csrfToken := extractCSRFToken(t, body)
+
+			form := url.Values{}
+			form.Add("email", sub.email)
+			form.Add("csrf_token", csrfToken)
+
+			code, header, _ := ts.post(t, "/user/password/forgot", form)
+			assert.Equal(t, code, sub.wantCode)
+			if code == http.StatusSeeOther {
+				assert.Equal(t, header.Get("Location"), "/user/login")
+			}
+
+			app.wg.Wait()
+			sent := app.mailer.(*mockMailer).sent
+			assert.Equal(t, len(sent), sub.wantEmails)
+			if len(sent) > 0 {
+				assert.Equal(t, sent[0].recipient, sub.email)
+				resetURL := sent[0].data.(map[string]any)["resetURL"].(string)
+				assert.StringContains(t, resetURL, "https://snippetbox.test/user/password/reset/")
+			}
+		})
+	}
+}
+
+func TestUserPasswordReset(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	validPath := "/user/password/reset/" + mocks.ValidPasswordResetToken
+	formTag := regexp.MustCompile(`<form[^>]*action="` + validPath + `"`)
+
+	t.Run("Invalid token", func(t *testing.T) {
+		code, header, _ := ts.get(t, "/user/password/reset/bad-token")
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/user/password/forgot")
+	})
+
+	_, _, body := ts.get(t, validPath)
+	assert.StringContainsMatch(t, body, formTag)
+	csrfToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name            string
+		path            string
+		newPassword     string
+		confirmPassword string
+		wantCode        int
+		wantLocation    string
+	}{
+		{
+			name:            "Mismatched passwords",
+			path:            validPath,
+			newPassword:     "new-pa$$word",
+			confirmPassword: "other-pa$$word",
+			wantCode:        http.StatusUnprocessableEntity,
+		},
+		{
+			name:            "Short password",
+			path:            validPath,
+			newPassword:     "pass",
+			confirmPassword: "pass",
+			wantCode:        http.StatusUnprocessableEntity,
+		},
+		{
+			name:            "Common password",
+			path:            validPath,
+			newPassword:     "qwerty123",
+			confirmPassword: "qwerty123",
+			wantCode:        http.StatusUnprocessableEntity,
+		},
+		{
+			// The token belongs to testuser@mail.com.
+			name:            "Password contains email",
+			path:            validPath,
+			newPassword:     "TestUser-pa$$word",
+			confirmPassword: "TestUser-pa$$word",
+			wantCode:        http.StatusUnprocessableEntity,
+		},
+		{
+			name:            "Invalid token",
+			path:            "/user/password/reset/bad-token",
+			newPassword:     "new-pa$$word",
+			confirmPassword: "new-pa$$word",
+			wantCode:        http.StatusSeeOther,
+			wantLocation:    "/user/password/forgot",
+		},
+		{
+			name:            "Valid submission",
+			path:            validPath,
+			newPassword:     "new-pa$$word",
+			confirmPassword: "new-pa$$word",
+			wantCode:        http.StatusSeeOther,
+			wantLocation:    "/user/login",
+		},
+	}
+
+	for _, sub := range tests {
+		t.Run(sub.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("newPassword", sub.newPassword)
+			form.Add("confirmPassword", sub.confirmPassword)
+			form.Add("csrf_token", csrfToken)
+
+			code, header, _ := ts.post(t, sub.path, form)
+			assert.Equal(t, code, sub.wantCode)
+			assert.Equal(t, header.Get("Location"), sub.wantLocation)
+		})
+	}
+}
+
+func TestUserLoginTOTP(t *testing.T) {
+	// Returns the current code for the mock user's TOTP secret.
+	currentCode := func(t *testing.T) string {
+		code, err := totp.Code(mocks.TOTPSecret, totp.Step(time.Now()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	tests := []struct {
+		name         string
+		code         func(t *testing.T) string
+		wantCode     int
+		wantLocation string
+	}{
+		{
+			name:     "Incorrect code",
+			code:     func(t *testing.T) string { return "000000" },
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "Empty code",
+			code:     func(t *testing.T) string { return "" },
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:         "Valid code",
+			code:         currentCode,
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/account/view",
+		},
+		{
+			name:         "Recovery code",
+			code:         func(t *testing.T) string { return mocks.RecoveryCode },
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/account/view",
+		},
+	}
+
+	for _, sub := range tests {
+		t.Run(sub.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			// Entering the password only gets the user as far as the second step.
+			_, _, body := ts.get(t, "/user/login")
+			form := url.Values{}
+			form.Add("email", "totpuser@mail.com")
+			form.Add("password", "pa$$word")
+			form.Add("csrf_token", extractCSRFToken(t, body))
+
+			code, header, _ := ts.post(t, "/user/login", form)
+			assert.Equal(t, code, http.StatusSeeOther)
+			assert.Equal(t, header.Get("Location"), "/user/login/totp")
+
+			// The user isn't logged in yet. Once they are, they should be sent on
+			// to the page they tried to access.
+			code, header, _ = ts.get(t, "/account/view")
+			assert.Equal(t, code, http.StatusSeeOther)
+			assert.Equal(t, header.Get("Location"), "/user/login")
+
+			_, _, body = ts.get(t, "/user/login/totp")
+			form = url.Values{}
+			form.Add("code", sub.code(t))
+			form.Add("csrf_token", extractCSRFToken(t, body))
+
+			code, header, _ = ts.post(t, "/user/login/totp", form)
+			assert.Equal(t, code, sub.wantCode)
+			assert.Equal(t, header.Get("Location"), sub.wantLocation)
+		})
+	}
+
+	t.Run("Without password", func(t *testing.T) {
+		app := newTestApplication(t)
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		code, header, _ := ts.get(t, "/user/login/totp")
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/user/login")
+	})
+}
+
+func TestAccountTOTPSetup(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "testuser@mail.com", "pa$$word")
+
+	code, _, body := ts.get(t, "/account/totp/setup")
+	assert.Equal(t, code, http.StatusOK)
+	csrfToken := extractCSRFToken(t, body)
+
+	matches := regexp.MustCompile(`<code>([A-Z2-7]+)</code>`).FindStringSubmatch(body)
+	if len(matches) < 2 {
+		t.Fatal("no TOTP secret found in body")
+	}
+	secret := matches[1]
+
+	code, header, _ := ts.get(t, "/account/totp/qrcode")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, header.Get("Content-Type"), "image/png")
+
+	t.Run("Incorrect code", func(t *testing.T) {
+		form := url.Values{}
+		form.Add("code", "000000")
+		form.Add("csrf_token", csrfToken)
+
+		code, _, body := ts.post(t, "/account/totp/setup", form)
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+		assert.StringContains(t, body, secret)
+	})
+
+	t.Run("Valid code", func(t *testing.T) {
+		totpCode, err := totp.Code(secret, totp.Step(time.Now()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		form := url.Values{}
+		form.Add("code", totpCode)
+		form.Add("csrf_token", csrfToken)
+
+		code, _, body := ts.post(t, "/account/totp/setup", form)
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, mocks.RecoveryCode)
+	})
+}
+
+func TestAccountSessions(t *testing.T) {
+	app := newTestApplication(t)
+
+	// Two test servers sharing the same application act as two devices with
+	// their own cookie jars.
+	device1 := newTestServer(t, app.routes())
+	defer device1.Close()
+	device2 := newTestServer(t, app.routes())
+	defer device2.Close()
+
+	device1.login(t, "testuser@mail.com", "pa$$word")
+	device2.login(t, "testuser@mail.com", "pa$$word")
+
+	// Returns the status code of a request to a protected page.
+	accountStatus := func(ts *testServer) int {
+		code, _, _ := ts.get(t, "/account/view")
+		return code
+	}
+
+	code, _, body := device1.get(t, "/account/sessions")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "This session")
+	assert.StringContains(t, body, "Revoke")
+	csrfToken := extractCSRFToken(t, body)
+
+	t.Run("Revoke other session", func(t *testing.T) {
+		sessions, _ := app.sessions.GetAllForUser(1)
+		assert.Equal(t, len(sessions), 2)
+
+		// The mock numbers sessions in the order they were created.
+		form := url.Values{}
+		form.Add("id", "SESSION2")
+		form.Add("csrf_token", csrfToken)
+
+		code, header, _ := device1.post(t, "/account/sessions/revoke", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/account/sessions")
+
+		assert.Equal(t, accountStatus(device1), http.StatusOK)
+		assert.Equal(t, accountStatus(device2), http.StatusSeeOther)
+	})
+
+	t.Run("Password change", func(t *testing.T) {
+		device2.login(t, "testuser@mail.com", "pa$$word")
+		assert.Equal(t, accountStatus(device2), http.StatusOK)
+
+		_, _, body := device1.get(t, "/account/password/update")
+		form := url.Values{}
+		form.Add("currentPassword", "pa$$word")
+		form.Add("newPassword", "new-pa$$word")
+		form.Add("confirmPassword", "new-pa$$word")
+		form.Add("csrf_token", extractCSRFToken(t, body))
+
+		code, _, _ := device1.post(t, "/account/password/update", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+
+		assert.Equal(t, accountStatus(device1), http.StatusOK)
+		assert.Equal(t, accountStatus(device2), http.StatusSeeOther)
+	})
+
+	t.Run("Log out everywhere", func(t *testing.T) {
+		device2.login(t, "testuser@mail.com", "pa$$word")
+
+		_, _, body := device1.get(t, "/account/sessions")
+		form := url.Values{}
+		form.Add("csrf_token", extractCSRFToken(t, body))
+
+		code, header, _ := device1.post(t, "/account/sessions/revoke-all", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/user/login")
+
+		assert.Equal(t, accountStatus(device1), http.StatusSeeOther)
+		assert.Equal(t, accountStatus(device2), http.StatusSeeOther)
+	})
+}
+
+func TestRememberMe(t *testing.T) {
+	app := newTestApplication(t)
+
+	// Returns a new test server for the app, acting as a separate device.
+	newDevice := func() *testServer {
+		ts := newTestServer(t, app.routes())
+		t.Cleanup(ts.Close)
+		return ts
+	}
+
+	// Returns the status code of a request to a protected page.
+	accountStatus := func(ts *testServer) int {
+		code, _, _ := ts.get(t, "/account/view")
+		return code
+	}
+
+	// Logs in with the remember me box checked, and returns the cookie value.
+	loginRemembered := func(ts *testServer) string {
+		_, _, body := ts.get(t, "/user/login")
+		form := url.Values{}
+		form.Add("email", "testuser@mail.com")
+		form.Add("password", "pa$$word")
+		form.Add("rememberMe", "true")
+		form.Add("csrf_token", extractCSRFToken(t, body))
+
+		code, _, _ := ts.post(t, "/user/login", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+
+		cookie := ts.cookie(t, rememberMeCookie)
+		if cookie == "" {
+			t.Fatal("no remember me cookie set")
+		}
+		return cookie
+	}
+
+	t.Run("Without remember me", func(t *testing.T) {
+		ts := newDevice()
+		ts.login(t, "testuser@mail.com", "pa$$word")
+		assert.Equal(t, ts.cookie(t, rememberMeCookie), "")
+	})
+
+	t.Run("Silent login and theft detection", func(t *testing.T) {
+		original := loginRemembered(newDevice())
+
+		// A device with only the cookie, e.g. after the session expired, is
+		// logged back in, and gets a new token.
+		device := newDevice()
+		device.setCookie(t, rememberMeCookie, original)
+		assert.Equal(t, accountStatus(device), http.StatusOK)
+		rotated := device.cookie(t, rememberMeCookie)
+		if rotated == original {
+			t.Error("remember me token wasn't rotated")
+		}
+
+		// Another request sent with the same cookie at the same time, such as
+		// from another tab, is logged in too, without changing the cookie.
+		tab := newDevice()
+		tab.setCookie(t, rememberMeCookie, original)
+		assert.Equal(t, accountStatus(tab), http.StatusOK)
+		assert.Equal(t, tab.cookie(t, rememberMeCookie), original)
+
+		later := newDevice()
+		later.setCookie(t, rememberMeCookie, rotated)
+		assert.Equal(t, accountStatus(later), http.StatusOK)
+		latest := later.cookie(t, rememberMeCookie)
+
+		// Presenting an older token means it was copied, so the user is
+		// logged out everywhere.
+		thief := newDevice()
+		thief.setCookie(t, rememberMeCookie, original)
+		assert.Equal(t, accountStatus(thief), http.StatusSeeOther)
+		assert.Equal(t, accountStatus(device), http.StatusSeeOther)
+		assert.Equal(t, accountStatus(later), http.StatusSeeOther)
+
+		stale := newDevice()
+		stale.setCookie(t, rememberMeCookie, latest)
+		assert.Equal(t, accountStatus(stale), http.StatusSeeOther)
+	})
+
+	t.Run("Logout revokes token", func(t *testing.T) {
+		device := newDevice()
+		cookie := loginRemembered(device)
+
+		_, _, body := device.get(t, "/account/view")
+		form := url.Values{}
+		form.Add("csrf_token", extractCSRFToken(t, body))
+		code, _, _ := device.post(t, "/user/logout", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, device.cookie(t, rememberMeCookie), "")
+
+		other := newDevice()
+		other.setCookie(t, rememberMeCookie, cookie)
+		assert.Equal(t, accountStatus(other), http.StatusSeeOther)
+	})
+}
+
+func TestAccountDelete(t *testing.T) {
+	tests := []struct {
+		name         string
+		email        string
+		password     string
+		wantCode     int
+		wantLocation string
+		wantBody     string
+	}{
+		{
+			name:     "Empty password",
+			email:    "otheruser@mail.com",
+			password: "",
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "Incorrect password",
+			email:    "otheruser@mail.com",
+			password: "wrong-pa$$word",
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "Correct password",
+			email:        "otheruser@mail.com",
+			password:     "pa$$word",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/",
+		},
+		{
+			name:     "Last owner of a team",
+			email:    "testuser@mail.com",
+			password: "pa$$word",
+			wantCode: http.StatusConflict,
+			wantBody: "You&#39;re the last owner of a team.",
+		},
+	}
+
+	for _, sub := range tests {
+		t.Run(sub.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			ts.login(t, sub.email, "pa$$word")
+
+			_, _, body := ts.get(t, "/account/delete")
+			form := url.Values{}
+			form.Add("password", sub.password)
+			form.Add("deleteSnippets", "true")
+			form.Add("csrf_token", extractCSRFToken(t, body))
+
+			code, header, body := ts.post(t, "/account/delete", form)
+			assert.Equal(t, code, sub.wantCode)
+			assert.Equal(t, header.Get("Location"), sub.wantLocation)
+			if sub.wantBody != "" {
+				assert.StringContains(t, body, sub.wantBody)
+			}
+
+			// The user is only logged out if their account was deleted.
+			code, _, _ = ts.get(t, "/account/view")
+			if sub.wantCode == http.StatusSeeOther {
+				assert.Equal(t, code, http.StatusSeeOther)
+			} else {
+				assert.Equal(t, code, http.StatusOK)
+			}
+		})
+	}
+}
+
+func TestAccountEdit(t *testing.T) {
+	tests := []struct {
+		name         string
+		userName     string
+		handle       string
+		hidden       bool
+		pending      string
+		email        string
+		password     string
+		wantCode     int
+		wantEmails   int
+		wantName     string
+		wantHandle   string
+		wantHidden   bool
+		wantPending  string
+		wantLocation string
+	}{
+		{
+			name:         "Name only",
+			userName:     "New Name",
+			handle:       "user",
+			email:        "testuser@mail.com",
+			wantCode:     http.StatusSeeOther,
+			wantName:     "New Name",
+			wantHandle:   "user",
+			wantLocation: "/account/view",
+		},
+		{
+			name:       "Blank name",
+			userName:   "  ",
+			handle:     "user",
+			email:      "testuser@mail.com",
+			wantCode:   http.StatusUnprocessableEntity,
+			wantName:   "User",
+			wantHandle: "user",
+		},
+		{
+			name:       "Invalid email",
+			userName:   "User",
+			handle:     "user",
+			email:      "bad@email.",
+			password:   "pa$$word",
+			wantCode:   http.StatusUnprocessableEntity,
+			wantName:   "User",
+			wantHandle: "user",
+		},
+		{
+			name:       "Email without password",
+			userName:   "New Name",
+			handle:     "user",
+			email:      "new@mail.com",
+			wantCode:   http.StatusUnprocessableEntity,
+			wantName:   "User",
+			wantHandle: "user",
+		},
+		{
+			name:       "Email with incorrect password",
+			userName:   "New Name",
+			handle:     "user",
+			email:      "new@mail.com",
+			password:   "wrong-pa$$word",
+			wantCode:   http.StatusUnauthorized,
+			wantName:   "User",
+			wantHandle: "user",
+		},
+		{
+			// Submitting the current email cancels a pending change.
+			name:         "Email change cancelled",
+			userName:     "User",
+			handle:       "user",
+			pending:      "new@mail.com",
+			email:        "testuser@mail.com",
+			wantCode:     http.StatusSeeOther,
+			wantName:     "User",
+			wantHandle:   "user",
+			wantLocation: "/account/view",
+		},
+		{
+			// No email is left pending if the handle is taken.
+			name:       "Email and taken handle",
+			userName:   "User",
+			handle:     "other-user",
+			email:      "new@mail.com",
+			password:   "pa$$word",
+			wantCode:   http.StatusUnprocessableEntity,
+			wantName:   "User",
+			wantHandle: "user",
+		},
+		{
+			// Nothing changes if the email is taken.
+			name:       "Duplicate email",
+			userName:   "New Name",
+			handle:     "user",
+			email:      "otheruser@mail.com",
+			password:   "pa$$word",
+			wantCode:   http.StatusUnprocessableEntity,
+			wantName:   "User",
+			wantHandle: "user",
+		},
+		{
+			// The name changes at once, but the email waits for confirmation.
+			name:         "Name and email",
+			userName:     "New Name",
+			handle:       "user",
+			email:        "new@mail.com",
+			password:     "pa$$word",
+			wantCode:     http.StatusSeeOther,
+			wantEmails:   1,
+			wantName:     "New Name",
+			wantHandle:   "user",
+			wantPending:  "new@mail.com",
+			wantLocation: "/account/view",
+		},
+		{
+			name:         "Handle and visibility",
+			userName:     "User",
+			handle:       " New-Handle ",
+			hidden:       true,
+			email:        "testuser@mail.com",
+			wantCode:     http.StatusSeeOther,
+			wantName:     "User",
+			wantHandle:   "new-handle",
+			wantHidden:   true,
+			wantLocation: "/account/view",
+		},
+		{
+			name:       "Invalid handle",
+			userName:   "User",
+			handle:     "no",
+			email:      "testuser@mail.com",
+			wantCode:   http.StatusUnprocessableEntity,
+			wantName:   "User",
+			wantHandle: "user",
+		},
+		{
+			// Nothing changes if the handle is taken.
+			name:       "Taken handle",
+			userName:   "New Name",
+			handle:     "other-user",
+			email:      "testuser@mail.com",
+			wantCode:   http.StatusUnprocessableEntity,
+			wantName:   "User",
+			wantHandle: "user",
+		},
+	}
+
+	for _, sub := range tests {
+		t.Run(sub.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			ts.login(t, "testuser@mail.com", "pa$$word")
+
+			if sub.pending != "" {
+				err := app.users.SetPendingEmail(1, sub.pending)
+				assert.IsNil(t, err)
+			}
+
+			_, _, body := ts.get(t, "/account/edit")
+			assert.StringContains(t, body, `value="testuser@mail.com"`)
+
+			form := url.Values{}
+			form.Add("name", sub.userName)
+			form.Add("handle", sub.handle)
+			if !sub.hidden {
+				form.Add("profilePublic", "true")
+			}
+			form.Add("email", sub.email)
+			form.Add("currentPassword", sub.password)
+			form.Add("csrf_token", extractCSRFToken(t, body))
+
+			code, header, _ := ts.post(t, "/account/edit", form)
+			assert.Equal(t, code, sub.wantCode)
+			assert.Equal(t, header.Get("Location"), sub.wantLocation)
+
+			user, err := app.users.Get(1)
+			assert.IsNil(t, err)
+			assert.Equal(t, user.Name, sub.wantName)
+			assert.Equal(t, user.Handle, sub.wantHandle)
+			assert.Equal(t, user.ProfilePublic, !sub.wantHidden)
+			assert.Equal(t, user.Email, "testuser@mail.com")
+			assert.Equal(t, user.PendingEmail, sub.wantPending)
+
+			app.wg.Wait()
+			sent := app.mailer.(*mockMailer).sent
+			assert.Equal(t, len(sent), sub.wantEmails)
+			if len(sent) > 0 {
+				assert.Equal(t, sent[0].recipient, sub.email)
+				confirmURL := sent[0].data.(map[string]any)["confirmURL"].(string)
+				assert.Equal(t, confirmURL, "https://snippetbox.test/user/email/confirm/"+mocks.ValidEmailChangeToken)
+			}
+		})
+	}
+}
+
+func TestUserProfile(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		path     string
+		wantCode int
+		wantBody []string
+	}{
+		{"Public profile", "/u/user", http.StatusOK, []string{"@user", "Mock notes", "Mock snippet"}},
+		{"Hidden profile", "/u/other-user", http.StatusNotFound, nil},
+		{"Disabled user", "/u/disabled-user", http.StatusNotFound, nil},
+		{"Non-existent handle", "/u/nobody", http.StatusNotFound, nil},
+		{"Page past the end", "/u/user?page=2", http.StatusOK, []string{"There are no snippets on this page.", `href="?page=1"`}},
+		{"Invalid page", "/u/user?page=0", http.StatusBadRequest, nil},
+	}
+
+	for _, sub := range tests {
+		t.Run(sub.name, func(t *testing.T) {
+			code, _, body := ts.get(t, sub.path)
+			assert.Equal(t, code, sub.wantCode)
+			for _, want := range sub.wantBody {
+				assert.StringContains(t, body, want)
+			}
+		})
+	}
+
+	// Users can see their own hidden profile.
+	t.Run("Own hidden profile", func(t *testing.T) {
+		ts.login(t, "otheruser@mail.com", "pa$$word")
+		code, _, body := ts.get(t, "/u/other-user")
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "Your profile is hidden")
+	})
+}
+
+func TestUserEmailConfirm(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	validPath := "/user/email/confirm/" + mocks.ValidEmailChangeToken
+
+	// Without a pending email, the token can't be used.
+	code, header, _ := ts.get(t, validPath)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/")
+
+	err := app.users.SetPendingEmail(1, "new@mail.com")
+	assert.IsNil(t, err)
+
+	t.Run("Invalid token", func(t *testing.T) {
+		code, header, _ := ts.get(t, "/user/email/confirm/bad-token")
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/")
+	})
+
+	code, _, body := ts.get(t, validPath)
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "new@mail.com")
+
+	form := url.Values{}
+	form.Add("csrf_token", extractCSRFToken(t, body))
+
+	code, header, _ = ts.post(t, validPath, form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/account/view")
+
+	user, err := app.users.Get(1)
+	assert.IsNil(t, err)
+	assert.Equal(t, user.Email, "new@mail.com")
+	assert.Equal(t, user.PendingEmail, "")
+	assert.Equal(t, slices.Contains(app.audit.(*mocks.AuditModel).Actions(), models.AuditEmailChange), true)
+
+	// The old email is told about the change.
+	app.wg.Wait()
+	sent := app.mailer.(*mockMailer).sent
+	assert.Equal(t, len(sent), 1)
+	assert.Equal(t, sent[0].recipient, "testuser@mail.com")
+	assert.Equal(t, sent[0].templateFile, "email_changed.tmpl")
+}
+
+func TestAccountAvatar(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "testuser@mail.com", "pa$$word")
+
+	_, _, body := ts.get(t, "/account/view")
+	form := url.Values{"csrf_token": {extractCSRFToken(t, body)}}
+
+	img := image.NewRGBA(image.Rect(0, 0, 120, 80))
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	assert.IsNil(t, err)
+
+	tests := []struct {
+		name     string
+		field    string
+		content  []byte
+		wantBody string
+	}{
+		{"Missing image", "other", buf.Bytes(), "Please choose an image to upload."},
+		{"Not an image", "avatar", []byte("<svg></svg>"), "Avatars must be PNG, JPEG or GIF images."},
+		{"Too large", "avatar", bytes.Repeat([]byte("a"), maxAvatarSize+1), "This image can&#39;t be larger than 2 MB."},
+	}
+
+	for _, sub := range tests {
+		t.Run(sub.name, func(t *testing.T) {
+			code, _, body := ts.postFile(t, "/account/avatar", form, sub.field, "avatar.png", sub.content)
+			assert.Equal(t, code, http.StatusUnprocessableEntity)
+			assert.StringContains(t, body, sub.wantBody)
+		})
+	}
+
+	upload := func() string {
+		code, header, _ := ts.postFile(t, "/account/avatar", form, "avatar", "avatar.png", buf.Bytes())
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/account/view")
+
+		user, err := app.users.Get(1)
+		assert.IsNil(t, err)
+		return user.Avatar
+	}
+
+	first := upload()
+	assert.Equal(t, len(first), 32)
+
+	_, _, body = ts.get(t, "/account/view")
+	assert.StringContains(t, body, "/avatars/"+first+"-96.png")
+
+	for _, size := range avatar.Sizes {
+		code, header, body := ts.get(t, fmt.Sprintf("/avatars/%s-%d.png", first, size))
+		assert.Equal(t, code, http.StatusOK)
+		assert.Equal(t, header.Get("Content-Type"), "image/png")
+		assert.Equal(t, header.Get("Cache-Control"), "public, max-age=31536000, immutable")
+
+		img, err := png.Decode(strings.NewReader(body))
+		assert.IsNil(t, err)
+		assert.Equal(t, img.Bounds().Dx(), size)
+	}
+
+	for _, path := range []string{"/avatars/" + first + "-50.png", "/avatars/../uploads", "/avatars/nothing.png"} {
+		code, _, _ := ts.get(t, path)
+		assert.Equal(t, code, http.StatusNotFound)
+	}
+
+	// A new avatar gets a new ID, and the old one's images are deleted.
+	second := upload()
+	assert.Equal(t, second != first, true)
+	code, _, _ := ts.get(t, "/avatars/"+first+"-96.png")
+	assert.Equal(t, code, http.StatusNotFound)
+
+	code, header, _ := ts.post(t, "/account/avatar/delete", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/account/view")
+
+	user, err := app.users.Get(1)
+	assert.IsNil(t, err)
+	assert.Equal(t, user.Avatar, "")
+	code, _, _ = ts.get(t, "/avatars/"+second+"-96.png")
+	assert.Equal(t, code, http.StatusNotFound)
+}
+
+func TestAccountExport(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "testuser@mail.com", "pa$$word")
+
+	t.Run("JSON", func(t *testing.T) {
+		code, header, body := ts.get(t, "/account/export")
+		assert.Equal(t, code, http.StatusOK)
+		assert.Equal(t, header.Get("Content-Type"), "application/json")
+
+		var export accountExport
+		err := json.Unmarshal([]byte(body), &export)
+		assert.IsNil(t, err)
+		assert.Equal(t, export.Profile.Email, "testuser@mail.com")
+		assert.Equal(t, len(export.Snippets), 1)
+		assert.Equal(t, export.Snippets[0].Files[0].Content, "This is a mock snippet.")
+	})
+
+	t.Run("Zip", func(t *testing.T) {
+		code, header, body := ts.get(t, "/account/export?format=zip")
+		assert.Equal(t, code, http.StatusOK)
+		assert.Equal(t, header.Get("Content-Type"), "application/zip")
+
+		zr, err := zip.NewReader(strings.NewReader(body), int64(len(body)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var names []string
+		for _, f := range zr.File {
+			names = append(names, f.Name)
+		}
+		assert.Equal(t, strings.Join(names, ","), "snippetbox-export/account.json,snippetbox-export/snippets/1/mock.txt")
+	})
+
+	t.Run("Invalid format", func(t *testing.T) {
+		code, _, _ := ts.get(t, "/account/export?format=pdf")
+		assert.Equal(t, code, http.StatusBadRequest)
+	})
+}
+
+func TestSnippetEdit(t *testing.T) {
+	app := newTestApplication(t)
+
+	owner := newTestServer(t, app.routes())
+	defer owner.Close()
+	owner.login(t, "testuser@mail.com", "pa$$word")
+
+	other := newTestServer(t, app.routes())
+	defer other.Close()
+	other.login(t, "otheruser@mail.com", "pa$$word")
+
+	t.Run("Edit link", func(t *testing.T) {
+		_, _, body := owner.get(t, "/snippet/view/1")
+		assert.StringContains(t, body, `<a href="/snippet/edit/1">Edit</a>`)
+
+		_, _, body = other.get(t, "/snippet/view/1")
+		if strings.Contains(body, `href="/snippet/edit/1"`) {
+			t.Errorf("edit link shown to user who doesn't own the snippet")
+		}
+	})
+
+	t.Run("Not owner", func(t *testing.T) {
+		code, _, _ := other.get(t, "/snippet/edit/1")
+		assert.Equal(t, code, http.StatusForbidden)
+	})
+
+	t.Run("Non-existing", func(t *testing.T) {
+		code, _, _ := owner.get(t, "/snippet/edit/999")
+		assert.Equal(t, code, http.StatusNotFound)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, _, body := owner.get(t, "/snippet/edit/1")
+		assert.StringContains(t, body, "This is a mock snippet.")
+
+		form := url.Values{}
+		form.Add("title", "Title")
+		form.Add("files[0].name", "mock.txt")
+		form.Add("files[0].language", "text")
+		form.Add("files[0].content", "  ")
+		form.Add("csrf_token", extractCSRFToken(t, body))
+
+		code, _, body := owner.post(t, "/snippet/edit/1", form)
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+		assert.StringContains(t, body, "This field can&#39;t be blank.")
+	})
+
+	t.Run("Valid", func(t *testing.T) {
+		_, _, body := owner.get(t, "/snippet/edit/1")
+
+		form := url.Values{}
+		form.Add("title", "New title")
+		form.Add("files[0].name", "mock.txt")
+		form.Add("files[0].language", "text")
+		form.Add("files[0].content", "New content")
+		form.Add("csrf_token", extractCSRFToken(t, body))
+
+		code, header, _ := owner.post(t, "/snippet/edit/1", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/snippet/view/1")
+	})
+}
+
+func TestSnippetHistory(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Revisions",
+			urlPath:  "/snippet/history/1",
+			wantCode: http.StatusOK,
+			wantBody: "#1 Mock snippet",
+		},
+		{
+			name:     "Diff",
+			urlPath:  "/snippet/history/1?from=1&to=2",
+			wantCode: http.StatusOK,
+			wantBody: `<span class="delete">-This is an old mock snippet.</span>
+<span class="insert">&#43;This is a mock snippet.</span>`,
+		},
+		{
+			name:     "Same revision",
+			urlPath:  "/snippet/history/1?from=2&to=2",
+			wantCode: http.StatusOK,
+			wantBody: "The files of revisions #2 and #2 are the same.",
+		},
+		{
+			name:     "Non-existing revision",
+			urlPath:  "/snippet/history/1?from=1&to=3",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Missing revision",
+			urlPath:  "/snippet/history/1?from=1",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Non-existing snippet",
+			urlPath:  "/snippet/history/999",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, sub := range tests {
+		t.Run(sub.name, func(t *testing.T) {
+			code, _, body := ts.get(t, sub.urlPath)
+			assert.Equal(t, code, sub.wantCode)
+			if sub.wantBody != "" {
+				assert.StringContains(t, body, sub.wantBody)
+			}
+		})
+	}
+}
+
+func TestSnippetRestore(t *testing.T) {
+	app := newTestApplication(t)
+
+	owner := newTestServer(t, app.routes())
+	defer owner.Close()
+	owner.login(t, "testuser@mail.com", "pa$$word")
+
+	other := newTestServer(t, app.routes())
+	defer other.Close()
+	other.login(t, "otheruser@mail.com", "pa$$word")
+
+	restore := func(ts *testServer, revision string) (int, http.Header) {
+		_, _, body := ts.get(t, "/snippet/history/1")
+
+		form := url.Values{}
+		form.Add("revision", revision)
+		form.Add("csrf_token", extractCSRFToken(t, body))
+
+		code, header, _ := ts.post(t, "/snippet/restore/1", form)
+		return code, header
+	}
+
+	t.Run("Not owner", func(t *testing.T) {
+		code, _ := restore(other, "1")
+		assert.Equal(t, code, http.StatusForbidden)
+	})
+
+	t.Run("Non-existing revision", func(t *testing.T) {
+		code, _ := restore(owner, "3")
+		assert.Equal(t, code, http.StatusNotFound)
+	})
+
+	t.Run("Valid", func(t *testing.T) {
+		code, header := restore(owner, "1")
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/snippet/view/1")
+
+		_, _, body := owner.get(t, "/snippet/view/1")
+		assert.StringContains(t, body, "Revision #1 restored.")
+	})
+}
+
+func TestSnippetFork(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Fork count", func(t *testing.T) {
+		_, _, body := ts.get(t, "/snippet/view/1")
+		assert.StringContains(t, body, "<span>1 fork</span>")
+
+		// The fork link is only shown to users who can create snippets.
+		if strings.Contains(body, `href="/snippet/create?fork=1"`) {
+			t.Errorf("fork link shown to unauthenticated user")
+		}
+	})
+
+	t.Run("Forked from", func(t *testing.T) {
+		_, _, body := ts.get(t, "/snippet/view/2")
+		assert.StringContains(t, body, `Forked from <a href="/snippet/view/1">#1</a>`)
+		assert.StringContains(t, body, "<span>0 forks</span>")
+	})
+
+	ts.login(t, "otheruser@mail.com", "pa$$word")
+
+	t.Run("Pre-filled form", func(t *testing.T) {
+		code, _, body := ts.get(t, "/snippet/create?fork=1")
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, `<input type="hidden" name="parent" value="1" />`)
+		assert.StringContains(t, body, ">This is a mock snippet.</textarea>")
+		assert.StringContains(t, body, `value="mock"`)
+	})
+
+	t.Run("Non-existing", func(t *testing.T) {
+		code, _, _ := ts.get(t, "/snippet/create?fork=999")
+		assert.Equal(t, code, http.StatusNotFound)
+	})
+
+	t.Run("Parent no longer exists", func(t *testing.T) {
+		_, _, body := ts.get(t, "/snippet/create")
+
+		form := url.Values{}
+		form.Add("title", "Title")
+		form.Add("files[0].name", "snippet.txt")
+		form.Add("files[0].language", "text")
+		form.Add("files[0].content", "Content")
+		form.Add("expires", "7")
+		form.Add("parent", "999")
+		form.Add("csrf_token", extractCSRFToken(t, body))
+
+		code, _, body := ts.post(t, "/snippet/create", form)
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+		assert.StringContains(t, body, "The snippet you are forking no longer exists.")
+	})
+
+	t.Run("Valid", func(t *testing.T) {
+		_, _, body := ts.get(t, "/snippet/create?fork=1")
+
+		form := url.Values{}
+		form.Add("title", "Title")
+		form.Add("files[0].name", "snippet.txt")
+		form.Add("files[0].language", "text")
+		form.Add("files[0].content", "Content")
+		form.Add("expires", "7")
+		form.Add("parent", "1")
+		form.Add("csrf_token", extractCSRFToken(t, body))
+
+		code, header, _ := ts.post(t, "/snippet/create", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/snippet/view/3")
+	})
+}
+
+func TestSnippetCreateFiles(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	ts.login(t, "testuser@mail.com", "pa$$word")
+
+	type file struct{ name, language, content string }
+
+	post := func(t *testing.T, files []file, extra url.Values) (int, http.Header, string) {
+		_, _, body := ts.get(t, "/snippet/create")
+
+		form := url.Values{}
+		form.Add("title", "Title")
+		form.Add("expires", "7")
+		form.Add("csrf_token", extractCSRFToken(t, body))
+		for i, f := range files {
+			form.Add(fmt.Sprintf("files[%d].name", i), f.name)
+			form.Add(fmt.Sprintf("files[%d].language", i), f.language)
+			form.Add(fmt.Sprintf("files[%d].content", i), f.content)
+		}
+		for k, v := range extra {
+			form[k] = v
+		}
+
+		return ts.post(t, "/snippet/create", form)
+	}
+
+	t.Run("Add file", func(t *testing.T) {
+		code, _, body := post(t, []file{{"Dockerfile", "dockerfile", "FROM golang"}},
+			url.Values{"addFile": {"true"}})
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, `value="Dockerfile"`)
+		assert.StringContains(t, body, `name="files[1].name"`)
+	})
+
+	tests := []struct {
+		name     string
+		files    []file
+		wantCode int
+		wantBody string
+	}{
+		{
+			name: "Valid",
+			files: []file{
+				{"Dockerfile", "dockerfile", "FROM golang"},
+				{"", "text", ""}, // removed
+				{"run.sh", "shell", "go run ."},
+			},
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "No files",
+			files:    []file{{"", "text", "  "}},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "A snippet must contain at least one file.",
+		},
+		{
+			name:     "Duplicate names",
+			files:    []file{{"a.go", "go", "a"}, {"a.go", "go", "b"}},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "Each file must have a different name.",
+		},
+		{
+			name:     "Invalid name",
+			files:    []file{{"../a.go", "go", "a"}},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "File names can only contain letters, numbers, dots, hyphens and underscores.",
+		},
+		{
+			name:     "Content too long",
+			files:    []file{{"a.txt", "text", strings.Repeat("a", maxFileContentLength+1)}},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This can&#39;t contain more than 100000 characters.",
+		},
+		{
+			name:     "Unknown language",
+			files:    []file{{"a.cob", "cobol", "a"}},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field must be one of the listed languages.",
+		},
+	}
+
+	for _, sub := range tests {
+		t.Run(sub.name, func(t *testing.T) {
+			code, _, body := post(t, sub.files, nil)
+			assert.Equal(t, code, sub.wantCode)
+			if sub.wantBody != "" {
+				assert.StringContains(t, body, sub.wantBody)
+			}
+		})
+	}
+}
+
+func TestSnippetRaw(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Raw link", func(t *testing.T) {
+		_, _, body := ts.get(t, "/snippet/view/1")
+		assert.StringContains(t, body, `<a href="/snippet/raw/1/mock.txt">Raw</a>`)
+	})
+
+	t.Run("Existing", func(t *testing.T) {
+		code, header, body := ts.get(t, "/snippet/raw/1/mock.txt")
+		assert.Equal(t, code, http.StatusOK)
+		assert.Equal(t, header.Get("Content-Type"), "text/plain; charset=utf-8")
+		assert.Equal(t, body, "This is a mock snippet.")
+	})
+
+	t.Run("Non-existing file", func(t *testing.T) {
+		code, _, _ := ts.get(t, "/snippet/raw/1/other.txt")
+		assert.Equal(t, code, http.StatusNotFound)
+	})
+
+	t.Run("Non-existing snippet", func(t *testing.T) {
+		code, _, _ := ts.get(t, "/snippet/raw/999/mock.txt")
+		assert.Equal(t, code, http.StatusNotFound)
+	})
+}
+
+func TestDiffFiles(t *testing.T) {
+	from := []models.SnippetFile{
+		{Name: "same.txt", Content: "same"},
+		{Name: "changed.txt", Content: "old"},
+		{Name: "removed.txt", Content: "removed"},
+	}
+	to := []models.SnippetFile{
+		{Name: "added.txt", Content: "added"},
+		{Name: "changed.txt", Content: "new"},
+		{Name: "same.txt", Content: "same"},
+	}
+
+	var names []string
+	for _, d := range diffFiles(from, to) {
+		names = append(names, d.OldName+">"+d.NewName)
+	}
+	assert.Equal(t, strings.Join(names, " "), ">added.txt changed.txt>changed.txt removed.txt>")
+
+	// Files that changed too much are listed without their differences.
+	from = []models.SnippetFile{{Name: "big.txt", Content: strings.Repeat("a\n", diff.MaxEdits)}}
+	to = []models.SnippetFile{{Name: "big.txt", Content: strings.Repeat("b\n", diff.MaxEdits)}}
+	diffs := diffFiles(from, to)
+	assert.Equal(t, len(diffs), 1)
+	assert.Equal(t, diffs[0].TooManyChanges, true)
+}
+
+func TestPageSnippets(t *testing.T) {
+	snippets := func(ids ...int) []models.Snippet {
+		var s []models.Snippet
+		for _, id := range ids {
+			s = append(s, models.Snippet{ID: id})
+		}
+		return s
+	}
+
+	// A snippet was added after the first page was fetched, which pushed 4
+	// into the second page.
+	pages := map[int][]models.Snippet{
+		0: snippets(6, 5, 4),
+		3: snippets(4, 3, 2),
+		6: snippets(1),
+	}
+	next := pageSnippets(pages[0], func(limit, offset int) ([]models.Snippet, error) {
+		return pages[offset], nil
+	})
+
+	var ids []string
+	for {
+		page, err := next()
+		assert.IsNil(t, err)
+		if len(page) == 0 {
+			break
+		}
+		for _, s := range page {
+			ids = append(ids, fmt.Sprint(s.ID))
+		}
+	}
+	assert.Equal(t, strings.Join(ids, ","), "6,5,4,3,2,1")
+}
+
+func TestSnippetDownload(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name            string
+		query           string
+		wantCode        int
+		wantType        string
+		wantDisposition string
+	}{
+		{
+			name:            "Snippet",
+			query:           "id=1",
+			wantCode:        http.StatusOK,
+			wantType:        "application/zip",
+			wantDisposition: `attachment; filename="1-mock-snippet.zip"`,
+		},
+		{
+			name:            "Tag",
+			query:           "tag=mock&format=tar.gz",
+			wantCode:        http.StatusOK,
+			wantType:        "application/gzip",
+			wantDisposition: `attachment; filename="tag-mock.tar.gz"`,
+		},
+		{
+			name:            "Owner",
+			query:           "owner=1&format=zip",
+			wantCode:        http.StatusOK,
+			wantType:        "application/zip",
+			wantDisposition: `attachment; filename="user-1.zip"`,
+		},
+		{
+			name:     "Non-existing snippet",
+			query:    "id=999",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Unused tag",
+			query:    "tag=unused",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Owner without snippets",
+			query:    "owner=2",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Owner with hidden profile",
+			query:    "owner=3",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Non-existing owner",
+			query:    "owner=999",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Unknown format",
+			query:    "id=1&format=rar",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Nothing selected",
+			query:    "",
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, sub := range tests {
+		t.Run(sub.name, func(t *testing.T) {
+			code, header, _ := ts.get(t, "/download?"+sub.query)
+			assert.Equal(t, code, sub.wantCode)
+			if sub.wantCode == http.StatusOK {
+				assert.Equal(t, header.Get("Content-Type"), sub.wantType)
+				assert.Equal(t, header.Get("Content-Disposition"), sub.wantDisposition)
+			}
+		})
+	}
+
+	t.Run("Contents", func(t *testing.T) {
+		_, _, body := ts.get(t, "/download?id=1")
+
+		zr, err := zip.NewReader(strings.NewReader(body), int64(len(body)))
+		assert.IsNil(t, err)
+
+		var names []string
+		for _, f := range zr.File {
+			names = append(names, f.Name)
+		}
+		assert.Equal(t, strings.Join(names, ","), "1-mock-snippet/1-mock-snippet/mock.txt,1-mock-snippet/manifest.json")
+	})
+
+	t.Run("Own hidden profile", func(t *testing.T) {
+		other := newTestServer(t, app.routes())
+		defer other.Close()
+		other.login(t, "otheruser@mail.com", "pa$$word")
+
+		code, header, _ := other.get(t, "/download?owner=3")
+		assert.Equal(t, code, http.StatusOK)
+		assert.Equal(t, header.Get("Content-Disposition"), `attachment; filename="user-3.zip"`)
+	})
+
+	t.Run("Disabled owner", func(t *testing.T) {
+		err := app.users.SetDisabled(1, true)
+		assert.IsNil(t, err)
+
+		code, _, _ := ts.get(t, "/download?owner=1")
+		assert.Equal(t, code, http.StatusNotFound)
+	})
+}
+
+func TestSnippetImport(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Unauthenticated", func(t *testing.T) {
+		code, header, _ := ts.get(t, "/snippet/import")
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/user/login")
+	})
+
+	ts.login(t, "testuser@mail.com", "pa$$word")
+
+	tests := []struct {
+		name     string
+		file     string // no file is sent if empty
+		wantCode int
+		wantBody []string
+	}{
+		{
+			name:     "Valid",
+			file:     `[{"title": "A", "content": "a"}, {"title": "B", "content": "b", "tags": ["Go"]}]`,
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Invalid snippets",
+			file:     `[{"title": "A", "content": "a"}, {"title": "", "content": "b", "expires": 30}]`,
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: []string{
+				"None of the snippets were imported",
+				"#2",
+				"expires: This field must equal 1, 7, or 365.",
+				"title: This field can&#39;t be blank.",
+			},
+		},
+		{
+			name:     "Unknown format",
+			file:     "hello",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: []string{"This file isn&#39;t a JSON array, gist or zip archive."},
+		},
+		{
+			name:     "Empty",
+			file:     "[]",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: []string{"This file doesn&#39;t contain any snippets."},
+		},
+		{
+			name:     "No file",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: []string{"Please choose a file to import."},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, body := ts.get(t, "/snippet/import")
+			form := url.Values{"csrf_token": {extractCSRFToken(t, body)}}
+
+			field := ""
+			if tt.file != "" {
+				field = "file"
+			}
+			code, header, body := ts.postFile(t, "/snippet/import", form, field, "snippets.json", []byte(tt.file))
+			assert.Equal(t, code, tt.wantCode)
+			for _, want := range tt.wantBody {
+				assert.StringContains(t, body, want)
+			}
+			if tt.wantCode == http.StatusSeeOther {
+				assert.Equal(t, header.Get("Location"), "/")
+			}
+		})
+	}
+
+	t.Run("Too large", func(t *testing.T) {
+		_, _, body := ts.get(t, "/snippet/import")
+		form := url.Values{"csrf_token": {extractCSRFToken(t, body)}}
+
+		content := bytes.Repeat([]byte("a"), maxImportSize+2<<20)
+		code, _, _ := ts.postFile(t, "/snippet/import", form, "file", "big.json", content)
+		assert.Equal(t, code, http.StatusBadRequest)
+	})
+}
+
+func TestImportFile(t *testing.T) {
+	app := newTestApplication(t)
+
+	path := filepath.Join(t.TempDir(), "snippets.json")
+	err := os.WriteFile(path, []byte(`[{"title": "A", "content": "a"}, {"title": "", "content": "b"}]`), 0600)
+	assert.IsNil(t, err)
+
+	var stdout, stderr bytes.Buffer
+	code := app.importFile("testuser@mail.com", path, &stdout, &stderr)
+	assert.Equal(t, code, 1)
+	assert.StringContains(t, stderr.String(), `item 2 (""): title: This field can't be blank.`)
+
+	err = os.WriteFile(path, []byte(`[{"title": "A", "content": "a"}]`), 0600)
+	assert.IsNil(t, err)
+
+	stdout.Reset()
+	stderr.Reset()
+	code = app.importFile("testuser@mail.com", path, &stdout, &stderr)
+	assert.Equal(t, code, 0)
+	assert.StringContains(t, stdout.String(), "imported 1 snippets")
+
+	code = app.importFile("nobody@mail.com", path, &stdout, &stderr)
+	assert.Equal(t, code, 1)
+	assert.StringContains(t, stderr.String(), "no user with email nobody@mail.com")
+}
+
+func TestComments(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// Posts a form with the CSRF token from the given page.
+	post := func(t *testing.T, page, endpoint string, form url.Values) (int, http.Header, string) {
+		_, _, body := ts.get(t, page)
+		form.Set("csrf_token", extractCSRFToken(t, body))
+		return ts.post(t, endpoint, form)
+	}
+
+	t.Run("Unauthenticated", func(t *testing.T) {
+		code, _, body := ts.get(t, "/snippet/view/1")
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "This is a mock comment.")
+		assert.StringContains(t, body, "(edited)")
+		assert.StringContains(t, body, "to comment.")
+
+		code, header, _ := post(t, "/user/login", "/snippet/comment/1", url.Values{"body": {"Hi"}})
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/user/login")
+	})
+
+	// The first mock user owns snippets 1 and 4, and wrote comment 1.
+	ts.login(t, "testuser@mail.com", "pa$$word")
+
+	tests := []struct {
+		name         string
+		page         string
+		endpoint     string
+		form         url.Values
+		wantCode     int
+		wantLocation string
+		wantBody     string
+	}{
+		{
+			name:         "Post",
+			page:         "/snippet/view/1",
+			endpoint:     "/snippet/comment/1",
+			form:         url.Values{"body": {"Nice snippet."}},
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/snippet/view/1#comment-3",
+		},
+		{
+			name:     "Post blank",
+			page:     "/snippet/view/1",
+			endpoint: "/snippet/comment/1",
+			form:     url.Values{"body": {"  "}},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field can&#39;t be blank.",
+		},
+		{
+			name:     "Post too long",
+			page:     "/snippet/view/1",
+			endpoint: "/snippet/comment/1",
+			form:     url.Values{"body": {strings.Repeat("a", 2001)}},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This can&#39;t contain more than 2000 characters.",
+		},
+		{
+			name:     "Post when disabled",
+			page:     "/snippet/view/4",
+			endpoint: "/snippet/comment/4",
+			form:     url.Values{"body": {"Hi"}},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "Post on missing snippet",
+			page:     "/snippet/view/1",
+			endpoint: "/snippet/comment/999",
+			form:     url.Values{"body": {"Hi"}},
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:         "Edit own",
+			page:         "/comment/edit/1",
+			endpoint:     "/comment/edit/1",
+			form:         url.Values{"body": {"Edited."}},
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/snippet/view/1#comment-1",
+		},
+		{
+			name:     "Edit someone else's",
+			page:     "/snippet/view/1",
+			endpoint: "/comment/edit/2",
+			form:     url.Values{"body": {"Edited."}},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:         "Delete on own snippet",
+			page:         "/snippet/view/1",
+			endpoint:     "/comment/delete/2",
+			form:         url.Values{},
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/snippet/view/1#comments",
+		},
+		{
+			name:     "Delete missing",
+			page:     "/snippet/view/1",
+			endpoint: "/comment/delete/999",
+			form:     url.Values{},
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:         "Disable",
+			page:         "/snippet/view/1",
+			endpoint:     "/snippet/comments/1",
+			form:         url.Values{"disabled": {"true"}},
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/snippet/view/1",
+		},
+		{
+			name:     "Disable on someone else's snippet",
+			page:     "/snippet/view/2",
+			endpoint: "/snippet/comments/2",
+			form:     url.Values{"disabled": {"true"}},
+			wantCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, header, body := post(t, tt.page, tt.endpoint, tt.form)
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantLocation != "" {
+				assert.Equal(t, header.Get("Location"), tt.wantLocation)
+			}
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+
+	t.Run("Edit form", func(t *testing.T) {
+		code, _, body := ts.get(t, "/comment/edit/1")
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "This is a mock comment.")
+
+		code, _, _ = ts.get(t, "/comment/edit/2")
+		assert.Equal(t, code, http.StatusForbidden)
+	})
+
+	t.Run("Owner controls", func(t *testing.T) {
+		_, _, body := ts.get(t, "/snippet/view/1")
+		assert.StringContains(t, body, "Disable comments")
+		assert.StringContains(t, body, `action="/comment/delete/2"`)
+
+		_, _, body = ts.get(t, "/snippet/view/4")
+		assert.StringContains(t, body, "Comments are disabled on this snippet.")
+		assert.StringContains(t, body, "Enable comments")
+	})
+}
+
+func TestCommentModeration(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// The third mock user wrote comment 2, on the first mock user's snippet.
+	ts.login(t, "otheruser@mail.com", "pa$$word")
+
+	_, _, body := ts.get(t, "/snippet/view/1")
+	assert.StringContains(t, body, `action="/comment/delete/2"`)
+	if strings.Contains(body, `action="/comment/delete/1"`) {
+		t.Errorf("expected no delete button for someone else's comment")
+	}
+	if strings.Contains(body, "Disable comments") {
+		t.Errorf("expected no comment settings on someone else's snippet")
+	}
+
+	csrfToken := extractCSRFToken(t, body)
+
+	code, _, _ := ts.post(t, "/comment/delete/1", url.Values{"csrf_token": {csrfToken}})
+	assert.Equal(t, code, http.StatusForbidden)
+
+	code, _, _ = ts.post(t, "/comment/delete/2", url.Values{"csrf_token": {csrfToken}})
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	// Comments can't be posted without a CSRF token.
+	code, _, _ = ts.post(t, "/snippet/comment/1", url.Values{"body": {"Hi"}})
+	assert.Equal(t, code, http.StatusBadRequest)
+}
+
+func TestStars(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Home", func(t *testing.T) {
+		code, _, body := ts.get(t, "/")
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "Most Starred This Week")
+	})
+
+	t.Run("Unauthenticated", func(t *testing.T) {
+		_, _, body := ts.get(t, "/snippet/view/1")
+		assert.StringContains(t, body, "1 star")
+		if strings.Contains(body, `action="/snippet/star/1"`) {
+			t.Errorf("expected no star button when logged out")
+		}
+
+		code, header, _ := ts.get(t, "/account/starred")
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/user/login")
+	})
+
+	// The third mock user has starred snippet 1.
+	ts.login(t, "otheruser@mail.com", "pa$$word")
+
+	t.Run("Starred", func(t *testing.T) {
+		_, _, body := ts.get(t, "/snippet/view/1")
+		assert.StringContains(t, body, "Unstar")
+
+		code, _, body := ts.get(t, "/account/starred")
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, `<a href="/snippet/view/1">Mock snippet</a>`)
+	})
+
+	t.Run("Not starred", func(t *testing.T) {
+		_, _, body := ts.get(t, "/snippet/view/2")
+		assert.StringContains(t, body, `<input type="hidden" name="starred" value="true" />`)
+	})
+
+	tests := []struct {
+		name     string
+		endpoint string
+		starred  string
+		wantCode int
+	}{
+		{name: "Star", endpoint: "/snippet/star/2", starred: "true", wantCode: http.StatusSeeOther},
+		{name: "Unstar", endpoint: "/snippet/star/1", starred: "false", wantCode: http.StatusSeeOther},
+		{name: "Missing snippet", endpoint: "/snippet/star/999", starred: "true", wantCode: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, body := ts.get(t, "/snippet/view/1")
+			form := url.Values{
+				"starred":    {tt.starred},
+				"csrf_token": {extractCSRFToken(t, body)},
+			}
+			code, _, _ := ts.post(t, tt.endpoint, form)
+			assert.Equal(t, code, tt.wantCode)
+		})
+	}
+}
+
+// A viewcount.Store that records the views written to it.
+type recordingViewStore struct {
+	counts map[int]int
+}
+
+func (s *recordingViewStore) AddViews(counts map[int]int, at time.Time) error {
+	for id, n := range counts {
+		s.counts[id] += n
+	}
+	return nil
+}
+
+func TestViews(t *testing.T) {
+	app := newTestApplication(t)
+	store := &recordingViewStore{counts: map[int]int{}}
+	app.views = viewcount.New(store, viewDedupWindow)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Trending", func(t *testing.T) {
+		code, _, body := ts.get(t, "/trending")
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "Trending Snippets")
+		assert.StringContains(t, body, `<a href="/snippet/view/1">Mock snippet</a>`)
+	})
+
+	t.Run("Count", func(t *testing.T) {
+		_, _, body := ts.get(t, "/snippet/view/1")
+		assert.StringContains(t, body, "10 views")
+	})
+
+	// Repeated views by the same viewer are only counted once.
+	ts.get(t, "/snippet/view/1")
+	ts.get(t, "/snippet/view/2")
+	ts.get(t, "/snippet/view/999")
+
+	err := app.views.Flush()
+	assert.IsNil(t, err)
+	assert.Equal(t, store.counts[1], 1)
+	assert.Equal(t, store.counts[2], 1)
+	assert.Equal(t, len(store.counts), 2)
+
+	// Logging in starts a new session, so the viewer is counted again.
+	ts.login(t, "testuser@mail.com", "pa$$word")
+	ts.get(t, "/snippet/view/1")
+	ts.get(t, "/snippet/view/1")
+
+	err = app.views.Flush()
+	assert.IsNil(t, err)
+	assert.Equal(t, store.counts[1], 2)
+}
+
+func TestTeams(t *testing.T) {
+	app := newTestApplication(t)
+
+	// The first mock user owns the mock team, and the third is a member. The
+	// mock team's snippet has ID 5.
+	anon := newTestServer(t, app.routes())
+	defer anon.Close()
+	owner := newTestServer(t, app.routes())
+	defer owner.Close()
+	owner.login(t, "testuser@mail.com", "pa$$word")
+	member := newTestServer(t, app.routes())
+	defer member.Close()
+	member.login(t, "otheruser@mail.com", "pa$$word")
+
+	_, _, body := owner.get(t, "/teams")
+	csrfToken := extractCSRFToken(t, body)
+	_, _, body = member.get(t, "/teams")
+	memberCSRFToken := extractCSRFToken(t, body)
+
+	t.Run("Team snippet visibility", func(t *testing.T) {
+		for _, endpoint := range []string{
+			"/snippet/view/5",
+			"/snippet/raw/5/team.txt",
+			"/snippet/history/5",
+			"/download?id=5",
+			"/snippet/create?fork=5",
+		} {
+			code, _, _ := anon.get(t, endpoint)
+			if endpoint == "/snippet/create?fork=5" {
+				// Logged out users are redirected to log in first.
+				assert.Equal(t, code, http.StatusSeeOther)
+				continue
+			}
+			assert.Equal(t, code, http.StatusNotFound)
+		}
+
+		code, _, body := member.get(t, "/snippet/view/5")
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "Only visible to")
+
+		// The team's owner can edit the snippet, even though they didn't write it.
+		code, _, _ = owner.get(t, "/snippet/edit/5")
+		assert.Equal(t, code, http.StatusOK)
+
+		// The team snippet isn't included in its author's public downloads.
+		_, header, _ := member.get(t, "/download?owner=3")
+		assert.Equal(t, header.Get("Content-Disposition"), `attachment; filename="user-3.zip"`)
+	})
+
+	t.Run("Team pages", func(t *testing.T) {
+		code, _, body := owner.get(t, "/teams")
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, `<a href="/team/view/1">Mock team</a>`)
+		assert.StringContains(t, body, `<form class="team-switcher" action="/team/switch" method="POST">`)
+
+		code, _, body = owner.get(t, "/team/view/1")
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, `<a href="/snippet/view/5">Mock team snippet</a>`)
+		assert.StringContains(t, body, "Create invitation link")
+		assert.StringContains(t, body, "Delete team")
+
+		code, _, body = member.get(t, "/team/view/1")
+		assert.Equal(t, code, http.StatusOK)
+		if strings.Contains(body, "Create invitation link") {
+			t.Errorf("expected no invitation form for members")
+		}
+
+		code, _, _ = owner.get(t, "/team/view/2")
+		assert.Equal(t, code, http.StatusNotFound)
+
+		code, header, _ := anon.get(t, "/team/view/1")
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/user/login")
+	})
+
+	t.Run("Invitations", func(t *testing.T) {
+		form := url.Values{}
+		form.Add("role", models.TeamRoleMember)
+		form.Add("csrf_token", csrfToken)
+		code, _, body := owner.post(t, "/team/invite/1", form)
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "https://snippetbox.test/team/join/"+mocks.MockInvitation)
+
+		form.Set("role", models.TeamRoleOwner)
+		code, _, _ = owner.post(t, "/team/invite/1", form)
+		assert.Equal(t, code, http.StatusBadRequest)
+
+		form.Set("role", models.TeamRoleMember)
+		form.Set("csrf_token", memberCSRFToken)
+		code, _, _ = member.post(t, "/team/invite/1", form)
+		assert.Equal(t, code, http.StatusForbidden)
+
+		code, _, body = owner.get(t, "/team/join/"+mocks.MockInvitation)
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "Join Mock team")
+
+		code, header, _ := owner.get(t, "/team/join/invalid")
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/teams")
+
+		code, header, _ = owner.post(t, "/team/join/"+mocks.MockInvitation, url.Values{"csrf_token": {csrfToken}})
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/team/view/1")
+	})
+
+	tests := []struct {
+		name     string
+		ts       *testServer
+		endpoint string
+		form     url.Values
+		wantCode int
+		wantLoc  string
+	}{
+		{"Promote member", owner, "/team/role/1", url.Values{"user": {"3"}, "role": {"admin"}}, http.StatusSeeOther, "/team/view/1"},
+		{"Demote last owner", owner, "/team/role/1", url.Values{"user": {"1"}, "role": {"member"}}, http.StatusSeeOther, "/team/view/1"},
+		{"Invalid role", owner, "/team/role/1", url.Values{"user": {"3"}, "role": {"boss"}}, http.StatusBadRequest, ""},
+		{"Non-member role", owner, "/team/role/1", url.Values{"user": {"2"}, "role": {"admin"}}, http.StatusNotFound, ""},
+		{"Member changes role", member, "/team/role/1", url.Values{"user": {"3"}, "role": {"admin"}}, http.StatusForbidden, ""},
+		{"Member removes owner", member, "/team/remove/1", url.Values{"user": {"1"}}, http.StatusForbidden, ""},
+		{"Remove last owner", owner, "/team/remove/1", url.Values{"user": {"1"}}, http.StatusSeeOther, "/team/view/1"},
+		{"Remove member", owner, "/team/remove/1", url.Values{"user": {"3"}}, http.StatusSeeOther, "/team/view/1"},
+		{"Leave", member, "/team/remove/1", url.Values{"user": {"3"}}, http.StatusSeeOther, "/teams"},
+		{"Member deletes team", member, "/team/delete/1", url.Values{}, http.StatusForbidden, ""},
+		{"Delete team", owner, "/team/delete/1", url.Values{}, http.StatusSeeOther, "/teams"},
+		{"Create team", owner, "/team/create", url.Values{"name": {"New team"}}, http.StatusSeeOther, "/team/view/2"},
+		{"Create unnamed team", owner, "/team/create", url.Values{"name": {" "}}, http.StatusUnprocessableEntity, ""},
+		{"Switch team", owner, "/team/switch", url.Values{"team": {"1"}}, http.StatusSeeOther, "/team/view/1"},
+		{"Switch to other team", owner, "/team/switch", url.Values{"team": {"2"}}, http.StatusNotFound, ""},
+		{"Switch to no team", member, "/team/switch", url.Values{"team": {"0"}}, http.StatusSeeOther, "/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.ts == owner {
+				tt.form.Add("csrf_token", csrfToken)
+			} else {
+				tt.form.Add("csrf_token", memberCSRFToken)
+			}
+
+			code, header, _ := tt.ts.post(t, tt.endpoint, tt.form)
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantLoc != "" {
+				assert.Equal(t, header.Get("Location"), tt.wantLoc)
+			}
+		})
+	}
+
+	t.Run("Create team snippet", func(t *testing.T) {
+		// The owner switched to the mock team above, so it's selected by default.
+		_, _, body := owner.get(t, "/snippet/create")
+		assert.StringContains(t, body, `<option value="1" selected>`)
+
+		form := url.Values{}
+		form.Add("title", "Team snippet")
+		form.Add("files[0].name", "team.txt")
+		form.Add("files[0].language", "text")
+		form.Add("files[0].content", "Content")
+		form.Add("expires", "7")
+		form.Add("team", "2")
+		form.Add("csrf_token", csrfToken)
+
+		code, _, body := owner.post(t, "/snippet/create", form)
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+		assert.StringContains(t, body, "You aren&#39;t a member of this team.")
+
+		// Forks of team snippets have to stay in the team.
+		form.Set("team", "0")
+		form.Add("parent", "5")
+		code, _, body = owner.post(t, "/snippet/create", form)
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+		assert.StringContains(t, body, "Forks of team snippets must stay in the team.")
+
+		form.Set("team", "1")
+		code, _, _ = owner.post(t, "/snippet/create", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+	})
+}
+
+func TestAdmin(t *testing.T) {
+	app := newTestApplication(t)
+
+	// The fourth mock user is an admin, the fifth is a moderator and the sixth
+	// has been disabled.
+	anon := newTestServer(t, app.routes())
+	defer anon.Close()
+	user := newTestServer(t, app.routes())
+	defer user.Close()
+	user.login(t, "testuser@mail.com", "pa$$word")
+	moderator := newTestServer(t, app.routes())
+	defer moderator.Close()
+	moderator.login(t, "moderator@mail.com", "pa$$word")
+	admin := newTestServer(t, app.routes())
+	defer admin.Close()
+	admin.login(t, "admin@mail.com", "pa$$word")
+
+	_, _, body := user.get(t, "/")
+	userCSRFToken := extractCSRFToken(t, body)
+	_, _, body = moderator.get(t, "/")
+	moderatorCSRFToken := extractCSRFToken(t, body)
+	_, _, body = admin.get(t, "/")
+	csrfToken := extractCSRFToken(t, body)
+
+	t.Run("Role gating", func(t *testing.T) {
+		for _, endpoint := range []string{"/admin", "/admin/audit"} {
+			code, header, _ := anon.get(t, endpoint)
+			assert.Equal(t, code, http.StatusSeeOther)
+			assert.Equal(t, header.Get("Location"), "/user/login")
+
+			code, _, _ = user.get(t, endpoint)
+			assert.Equal(t, code, http.StatusForbidden)
+
+			code, _, _ = moderator.get(t, endpoint)
+			assert.Equal(t, code, http.StatusForbidden)
+
+			code, _, _ = admin.get(t, endpoint)
+			assert.Equal(t, code, http.StatusOK)
+		}
+
+		form := url.Values{}
+		form.Add("csrf_token", userCSRFToken)
+		code, _, _ := user.post(t, "/admin/snippet/delete/1", form)
+		assert.Equal(t, code, http.StatusForbidden)
+	})
+
+	t.Run("Admin pages", func(t *testing.T) {
+		_, _, body := admin.get(t, "/")
+		assert.StringContains(t, body, `<a href="/admin">Admin</a>`)
+		_, _, body = moderator.get(t, "/")
+		if strings.Contains(body, `<a href="/admin">Admin</a>`) {
+			t.Errorf("expected no admin link for moderators")
+		}
+
+		_, _, body = admin.get(t, "/admin")
+		assert.StringContains(t, body, "Disabled User")
+		assert.StringContains(t, body, `<form action="/admin/users/disable/1" method="POST">`)
+		if strings.Contains(body, `action="/admin/users/disable/4"`) {
+			t.Errorf("expected no form for the admin to disable themselves")
+		}
+
+		_, _, body = admin.get(t, "/admin/audit")
+		assert.StringContains(t, body, models.AuditUserDisabled)
+		assert.StringContains(t, body, "Admin User")
+	})
+
+	t.Run("Disabled login", func(t *testing.T) {
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		_, _, body := ts.get(t, "/user/login")
+		form := url.Values{}
+		form.Add("email", "disabled@mail.com")
+		form.Add("password", "pa$$word")
+		form.Add("csrf_token", extractCSRFToken(t, body))
+
+		code, _, body := ts.post(t, "/user/login", form)
+		assert.Equal(t, code, http.StatusForbidden)
+		assert.StringContains(t, body, "Your account has been disabled.")
+	})
+
+	tests := []struct {
+		name      string
+		ts        *testServer
+		endpoint  string
+		form      url.Values
+		wantCode  int
+		wantFlash string
+	}{
+		{
+			name:      "Disable user",
+			ts:        admin,
+			endpoint:  "/admin/users/disable/1",
+			form:      url.Values{"disabled": {"true"}, "csrf_token": {csrfToken}},
+			wantCode:  http.StatusSeeOther,
+			wantFlash: "Account disabled.",
+		},
+		{
+			name:      "Enable user",
+			ts:        admin,
+			endpoint:  "/admin/users/disable/6",
+			form:      url.Values{"csrf_token": {csrfToken}},
+			wantCode:  http.StatusSeeOther,
+			wantFlash: "Account enabled.",
+		},
+		{
+			name:     "Disable self",
+			ts:       admin,
+			endpoint: "/admin/users/disable/4",
+			form:     url.Values{"disabled": {"true"}, "csrf_token": {csrfToken}},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "Disable missing user",
+			ts:       admin,
+			endpoint: "/admin/users/disable/99",
+			form:     url.Values{"disabled": {"true"}, "csrf_token": {csrfToken}},
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:      "Change role",
+			ts:        admin,
+			endpoint:  "/admin/users/role/3",
+			form:      url.Values{"role": {models.RoleModerator}, "csrf_token": {csrfToken}},
+			wantCode:  http.StatusSeeOther,
+			wantFlash: "Role updated.",
+		},
+		{
+			name:     "Invalid role",
+			ts:       admin,
+			endpoint: "/admin/users/role/3",
+			form:     url.Values{"role": {"superuser"}, "csrf_token": {csrfToken}},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Change own role",
+			ts:       admin,
+			endpoint: "/admin/users/role/4",
+			form:     url.Values{"role": {models.RoleUser}, "csrf_token": {csrfToken}},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:      "Moderator deletes snippet",
+			ts:        moderator,
+			endpoint:  "/admin/snippet/delete/1",
+			form:      url.Values{"csrf_token": {moderatorCSRFToken}},
+			wantCode:  http.StatusSeeOther,
+			wantFlash: "Snippet deleted.",
+		},
+		{
+			name:      "Moderator deletes team snippet",
+			ts:        moderator,
+			endpoint:  "/admin/snippet/delete/5",
+			form:      url.Values{"csrf_token": {moderatorCSRFToken}},
+			wantCode:  http.StatusSeeOther,
+			wantFlash: "Snippet deleted.",
+		},
+		{
+			name:     "Delete missing snippet",
+			ts:       moderator,
+			endpoint: "/admin/snippet/delete/99",
+			form:     url.Values{"csrf_token": {moderatorCSRFToken}},
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, sub := range tests {
+		t.Run(sub.name, func(t *testing.T) {
+			code, _, _ := sub.ts.post(t, sub.endpoint, sub.form)
+			assert.Equal(t, code, sub.wantCode)
+
+			if sub.wantFlash != "" {
+				_, _, body := sub.ts.get(t, "/about")
+				assert.StringContains(t, body, sub.wantFlash)
+			}
+		})
+	}
+
+	t.Run("Audit events", func(t *testing.T) {
+		audit := app.audit.(*mocks.AuditModel)
+		want := []string{
+			models.AuditLogin,
+			models.AuditLogin,
+			models.AuditLogin,
+			models.AuditLoginFailed,
+			models.AuditUserDisabled,
+			models.AuditUserEnabled,
+			models.AuditUserRole,
+			models.AuditSnippetDelete,
+			models.AuditSnippetDelete,
+		}
+		assert.Equal(t, strings.Join(audit.Actions(), ","), strings.Join(want, ","))
+	})
+}
+
+func TestAuditLog(t *testing.T) {
+	app := newTestApplication(t)
+	audit := app.audit.(*mocks.AuditModel)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/signup")
+	form := url.Values{}
+	form.Add("name", "New User")
+	form.Add("email", "newuser@mail.com")
+	form.Add("password", "pa$$word")
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	code, _, _ := ts.post(t, "/user/signup", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	// Failed logins are recorded against the account, if there is one.
+	for _, email := range []string{"testuser@mail.com", "nobody@mail.com"} {
+		_, _, body = ts.get(t, "/user/login")
+		form = url.Values{}
+		form.Add("email", email)
+		form.Add("password", "wrongpassword")
+		form.Add("csrf_token", extractCSRFToken(t, body))
+		code, _, _ = ts.post(t, "/user/login", form)
+		assert.Equal(t, code, http.StatusUnauthorized)
+	}
+
+	ts.login(t, "testuser@mail.com", "pa$$word")
+
+	_, _, body = ts.get(t, "/account/password/update")
+	form = url.Values{}
+	form.Add("currentPassword", "pa$$word")
+	form.Add("newPassword", "newpa$$word")
+	form.Add("confirmPassword", "newpa$$word")
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	code, _, _ = ts.post(t, "/account/password/update", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	t.Run("Account page", func(t *testing.T) {
+		code, _, body := ts.get(t, "/account/view")
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "Recent Activity")
+		assert.StringContains(t, body, "user.password_changed")
+		assert.StringContains(t, body, "user.login_failed (incorrect password)")
+		assert.StringContains(t, body, "Go-http-client")
+
+		// Only the user's own events are shown.
+		if strings.Contains(body, "user.signup") {
+			t.Errorf("expected no events from other accounts")
+		}
+	})
+
+	_, _, body = ts.get(t, "/")
+	form = url.Values{}
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	code, _, _ = ts.post(t, "/user/logout", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	t.Run("Recorded events", func(t *testing.T) {
+		want := []string{
+			models.AuditSignup,
+			models.AuditLoginFailed,
+			models.AuditLoginFailed,
+			models.AuditLogin,
+			models.AuditPasswordChange,
+			models.AuditLogout,
+		}
+		assert.Equal(t, strings.Join(audit.Actions(), ","), strings.Join(want, ","))
+
+		events, err := audit.ForUser(1, 10)
+		assert.IsNil(t, err)
+		assert.Equal(t, len(events), 4)
+		assert.Equal(t, events[0].ActorID, 1)
+		assert.Equal(t, events[0].IP, "127.0.0.1")
+
+		// Failed logins are anonymous.
+		assert.Equal(t, events[3].ActorID, 0)
+		assert.Equal(t, events[3].Target, "user:1")
+
+		events, err = audit.ForUser(7, 10)
+		assert.IsNil(t, err)
+		assert.Equal(t, len(events), 1)
+		assert.Equal(t, events[0].Action, models.AuditSignup)
+	})
+
+	t.Run("Admin view", func(t *testing.T) {
+		admin := newTestServer(t, app.routes())
+		defer admin.Close()
+		admin.login(t, "admin@mail.com", "pa$$word")
+
+		code, _, body := admin.get(t, "/admin/audit?action=user.disabled")
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, `<option value="user.disabled" selected>user.disabled</option>`)
+		assert.StringContains(t, body, "<td>user:6</td>")
+
+		_, _, body = admin.get(t, "/admin/audit?action=user.login")
+		assert.StringContains(t, body, "No events match.")
+
+		code, header, body := admin.get(t, "/admin/audit/export?action=user.disabled")
+		assert.Equal(t, code, http.StatusOK)
+		assert.Equal(t, header.Get("Content-Type"), "text/csv; charset=utf-8")
+		assert.StringContains(t, body, "id,time,actor_id,actor,action,target,detail,ip,user_agent")
+		assert.StringContains(t, body, ",4,Admin User,user.disabled,user:6,,127.0.0.1,Mock browser")
+
+		code, _, _ = ts.get(t, "/admin/audit/export")
+		assert.Equal(t, code, http.StatusSeeOther)
+	})
+}
+
+func TestCSVSafe(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"Mozilla/5.0", "Mozilla/5.0"},
+		{"=HYPERLINK(\"http://evil.test\")", "'=HYPERLINK(\"http://evil.test\")"},
+		{"+1", "'+1"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"", ""},
+	}
+
+	for _, sub := range tests {
+		t.Run(sub.input, func(t *testing.T) {
+			assert.Equal(t, csvSafe(sub.input), sub.want)
 		})
 	}
 }
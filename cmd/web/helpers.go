@@ -5,12 +5,16 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"runtime/debug"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/go-playground/form/v4"
 	"github.com/justinas/nosurf"
+	"github.com/kvnloughead/snippetbox/internal/models"
 )
 
 /*
@@ -85,14 +89,55 @@ func (app *application) render(w http.ResponseWriter, r *http.Request, status in
 	buf.WriteTo(w) // write contents of buffer to http.ResponseWriter
 }
 
-// Initialize templateData struct with the CurrentYear.
+// Initialize templateData struct with the CurrentYear, and the state of the
+// logged in user, if there is one.
 func (app *application) newTemplateData(r *http.Request) templateData {
-	return templateData{
+	data := templateData{
 		CurrentYear:     time.Now().Year(),
 		Flash:           app.sessionManager.PopString(r.Context(), string(flash)),
 		IsAuthenticated: app.isAuthenticated(r),
+		UserRole:        app.userRole(r),
 		CSRFToken:       nosurf.Token(r),
+		OIDCProviders:   app.oidcProviders,
 	}
+	if data.IsAuthenticated {
+		data.UserID = app.sessionManager.GetInt(r.Context(), string(authenticatedUserID))
+
+		// The user's teams are only needed for the team switcher, so if they
+		// can't be loaded, the error is logged and the switcher is left out,
+		// rather than failing the request.
+		teams, err := app.teams.ForUser(data.UserID)
+		if err != nil {
+			app.logger.Error(err.Error(), "method", r.Method, "uri", r.URL.RequestURI())
+		}
+		data.Teams = teams
+
+		// The current team is ignored if the user has since left it.
+		id := app.sessionManager.GetInt(r.Context(), string(currentTeamID))
+		for _, t := range teams {
+			if t.ID == id {
+				data.CurrentTeamID = id
+			}
+		}
+	}
+	return data
+}
+
+// Returns the logged in user's role in the team. If the user isn't logged in,
+// or isn't a member of the team, a models.ErrNoRecord error is returned.
+func (app *application) teamRole(r *http.Request, teamID int) (string, error) {
+	if !app.isAuthenticated(r) {
+		return "", models.ErrNoRecord
+	}
+
+	userID := app.sessionManager.GetInt(r.Context(), string(authenticatedUserID))
+	return app.teams.Role(teamID, userID)
+}
+
+// Returns true if members with the role can manage the team's members and
+// snippets.
+func isTeamAdmin(role string) bool {
+	return role == models.TeamRoleOwner || role == models.TeamRoleAdmin
 }
 
 /* Parses form with r.ParseForm() and then attempts to decode r.PostForm into the target destination dst. If dst is invalid, an InvalidDecodeError occurs, a panic ensues. Otherwise, the error is returned to the caller. */
@@ -125,3 +170,325 @@ func (app *application) isAuthenticated(r *http.Request) bool {
 	}
 	return isAuthenticated
 }
+
+// Returns the role of the authenticated user, such as models.RoleAdmin, or an
+// empty string if the request isn't authenticated.
+func (app *application) userRole(r *http.Request) string {
+	role, _ := r.Context().Value(userRoleContextKey).(string)
+	return role
+}
+
+// Records an action taken by the logged in user, or by an anonymous client if
+// no one is logged in, in the audit log. The client's IP and user agent are
+// recorded with it. The target and detail are truncated to fit in the DB.
+func (app *application) recordAudit(r *http.Request, action, target, detail string) error {
+	return app.audit.Insert(models.AuditEvent{
+		ActorID:   app.sessionManager.GetInt(r.Context(), string(authenticatedUserID)),
+		Action:    action,
+		Target:    truncate(target, 255),
+		Detail:    truncate(detail, 255),
+		IP:        clientIP(r),
+		UserAgent: userAgent(r),
+	})
+}
+
+// Records a failed attempt to log in with the given email in the audit log. If
+// there's an account with the email, the attempt is recorded against it, so
+// that its owner can see it. Otherwise the email itself is the target.
+func (app *application) recordFailedLogin(r *http.Request, email, reason string) error {
+	target := "email:" + email
+	user, err := app.users.GetByEmail(email)
+	if err == nil {
+		target = models.AuditUserTarget(user.ID)
+	} else if !errors.Is(err, models.ErrNoRecord) {
+		return err
+	}
+
+	return app.recordAudit(r, models.AuditLoginFailed, target, reason)
+}
+
+// Runs fn in a background goroutine, recovering from any panic and logging it,
+// since the recoverPanic middleware only protects the request's goroutine.
+// The goroutine is tracked by app.wg, so callers can wait for it to finish.
+func (app *application) background(fn func()) {
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.Error(fmt.Sprint(err))
+			}
+		}()
+
+		fn()
+	}()
+}
+
+/*
+Starts an authenticated session for the user with the given ID, and records it
+so that the user can see it on their sessions page and revoke it.
+
+rememberSeries is the series of the remember me token that the session was
+started with, or was issued alongside it. It is revoked along with the session.
+*/
+func (app *application) startSession(r *http.Request, userID int, rememberSeries string) error {
+	// When authentication state or privilege levels change, the session ID should
+	// be changed, via the RenewToken method.
+	err := app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		return err
+	}
+
+	// Add user ID to session data to indicate their logged in status.
+	app.sessionManager.Put(r.Context(), string(authenticatedUserID), userID)
+
+	// The record expires at the same time as the session itself.
+	deadline := app.sessionManager.Deadline(r.Context())
+	id, err := app.sessions.Insert(userID, clientIP(r), userAgent(r), deadline, rememberSeries)
+	if err != nil {
+		return err
+	}
+	app.sessionManager.Put(r.Context(), string(sessionID), id)
+	app.sessionManager.Put(r.Context(), string(sessionLastSeen), time.Now().Unix())
+
+	return nil
+}
+
+// Logs in the user with the given ID, once their password or identity
+// provider has been checked. If they have two-factor authentication enabled,
+// they aren't logged in until they've entered a code, so they are sent on to
+// enter it. Until then, only the pending user's ID is stored in the session.
+func (app *application) beginLogin(w http.ResponseWriter, r *http.Request, userID int, remember bool) {
+	_, err := app.totp.Get(userID)
+	if err == nil {
+		err = app.sessionManager.RenewToken(r.Context())
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		app.sessionManager.Put(r.Context(), string(twoFactorUserID), userID)
+		app.sessionManager.Put(r.Context(), string(twoFactorExpiry), time.Now().Add(twoFactorTTL).Unix())
+		app.sessionManager.Put(r.Context(), string(twoFactorRememberMe), remember)
+		app.sessionManager.Remove(r.Context(), string(twoFactorAttempts))
+
+		http.Redirect(w, r, "/user/login/totp", http.StatusSeeOther)
+		return
+	} else if !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.completeLogin(w, r, userID, remember)
+}
+
+// Logs in the user with the given ID and redirects them to the page they were
+// trying to access before logging in, or to the create snippet page.
+//
+// If remember is true, a remember me cookie is also set, so that the user is
+// logged back in when their session expires.
+func (app *application) completeLogin(w http.ResponseWriter, r *http.Request, userID int, remember bool) {
+	var series string
+	if remember {
+		token, err := app.rememberTokens.New(userID, rememberMeTTL)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		setRememberMeCookie(w, token)
+		series = token.Series
+	}
+
+	err := app.startSession(r, userID, series)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.recordAudit(r, models.AuditLogin, models.AuditUserTarget(userID), "")
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), string(flash), "Login successful.")
+
+	dest := app.sessionManager.PopString(r.Context(), string(redirectAfterLogin))
+	if dest != "" {
+		http.Redirect(w, r, dest, http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
+}
+
+// Logs the user out of the current session, revoking its user_sessions record
+// and its remember me token, if it has one.
+func (app *application) logOut(w http.ResponseWriter, r *http.Request) error {
+	// When authentication state or privilege levels change, the session ID should
+	// be changed, via the RenewToken method.
+	err := app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		return err
+	}
+
+	userID := app.sessionManager.GetInt(r.Context(), string(authenticatedUserID))
+	id := app.sessionManager.GetString(r.Context(), string(sessionID))
+	err = app.sessions.Delete(id, userID)
+	if err != nil {
+		return err
+	}
+
+	app.sessionManager.Remove(r.Context(), string(authenticatedUserID))
+	app.sessionManager.Remove(r.Context(), string(sessionID))
+	app.sessionManager.Remove(r.Context(), string(sessionLastSeen))
+
+	return app.forgetRememberMe(w, r)
+}
+
+// How long a remember me token keeps the user logged in for.
+const rememberMeTTL = 30 * 24 * time.Hour
+
+// How long a remember me token is still accepted for after it's replaced, so
+// that requests sent at the same time with the same cookie all succeed.
+const rememberMeGrace = 30 * time.Second
+
+// Name of the cookie holding the remember me token, as "series:token".
+const rememberMeCookie = "remember_me"
+
+// Sets the remember me cookie to the given token. The cookie expires at the
+// same time as the token.
+func setRememberMeCookie(w http.ResponseWriter, token models.RememberToken) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     rememberMeCookie,
+		Value:    token.Series + ":" + token.Plaintext,
+		Path:     "/",
+		Expires:  token.Expires,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// Returns the series and token from the remember me cookie. If there is no
+// cookie, or it is malformed, ok is false.
+func readRememberMeCookie(r *http.Request) (series, token string, ok bool) {
+	cookie, err := r.Cookie(rememberMeCookie)
+	if err != nil {
+		return "", "", false
+	}
+
+	series, token, ok = strings.Cut(cookie.Value, ":")
+	if !ok || series == "" || token == "" {
+		return "", "", false
+	}
+	return series, token, true
+}
+
+// Revokes the remember me token in the request's cookie, if there is one,
+// and tells the client to delete the cookie.
+func (app *application) forgetRememberMe(w http.ResponseWriter, r *http.Request) error {
+	series, _, ok := readRememberMeCookie(r)
+	if !ok {
+		return nil
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     rememberMeCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return app.rememberTokens.Delete(series)
+}
+
+/*
+Logs the user in with the token in their remember me cookie, if they have one.
+The token is replaced with a new one each time it is used. Returns the user's
+ID, or 0 if they weren't logged in.
+
+If a token that has already been used is presented, the cookie must have been
+copied, so the user is logged out everywhere and all of their remember me
+tokens are revoked. The exception is a token that was replaced less than
+rememberMeGrace ago. Its request is logged in, but the cookie isn't changed, as
+the client was sent the new token in response to the request that replaced it.
+*/
+func (app *application) loginFromRememberMe(w http.ResponseWriter, r *http.Request) (int, error) {
+	series, plaintext, ok := readRememberMeCookie(r)
+	if !ok {
+		return 0, nil
+	}
+
+	token, err := app.rememberTokens.Rotate(series, plaintext, rememberMeGrace)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrNoRecord):
+			return 0, app.forgetRememberMe(w, r)
+		case errors.Is(err, models.ErrTokenReused):
+			app.logger.Warn("remember me token reused", "userID", token.UserID, "ip", clientIP(r))
+			err = app.sessions.DeleteAllForUser(token.UserID, "")
+			if err != nil {
+				return 0, err
+			}
+			return 0, app.forgetRememberMe(w, r)
+		default:
+			return 0, err
+		}
+	}
+
+	err = app.startSession(r, token.UserID, token.Series)
+	if err != nil {
+		return 0, err
+	}
+	if token.Plaintext != "" {
+		setRememberMeCookie(w, token)
+	}
+
+	return token.UserID, nil
+}
+
+// Returns the IP address of the client that made the request, without the
+// port.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Returns the request's User-Agent header, truncated to 255 characters so
+// that it fits in the DB.
+func userAgent(r *http.Request) string {
+	return truncate(r.UserAgent(), 255)
+}
+
+// Returns s, truncated to at most n characters.
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
+
+// How long a viewer's repeated views of a snippet are counted as one view.
+const viewDedupWindow = time.Hour
+
+// How often buffered views are written to the DB.
+const viewFlushInterval = time.Minute
+
+// How far back views are counted when ranking trending snippets.
+const trendingPeriod = 24 * time.Hour
+
+// Identifies the viewer of a snippet, for deduplicating views. Viewers are
+// identified by their session token, if they have one, or else by their IP.
+func (app *application) viewer(r *http.Request) string {
+	if token := app.sessionManager.Token(r.Context()); token != "" {
+		return "session:" + token
+	}
+	return "ip:" + clientIP(r)
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/kvnloughead/snippetbox/internal/bundle"
+	"github.com/kvnloughead/snippetbox/internal/models"
+	"github.com/kvnloughead/snippetbox/internal/validator"
+)
+
+// The maximum size of an import file, and the maximum number of snippets it
+// can contain.
+const (
+	maxImportSize  = 10 << 20
+	maxImportItems = 1000
+)
+
+// The validation errors of one of the snippets in an import file.
+type importError struct {
+	Item   int // 1-based
+	Title  string
+	Errors []string // as "field: message", sorted by field
+}
+
+func (e importError) String() string {
+	return fmt.Sprintf("item %d (%q): %s", e.Item, e.Title, strings.Join(e.Errors, "; "))
+}
+
+/*
+Validates the snippets in an import file with the same rules as the snippet
+create form, and converts them to snippets that can be inserted. Tags are
+normalized as in the form. Snippets without a format are plain, and those
+without an expiry expire in a year.
+
+If any snippet is invalid, its errors are returned, and the snippets should not
+be inserted.
+*/
+func checkImport(entries []bundle.Entry) ([]models.NewSnippet, []importError) {
+	var snippets []models.NewSnippet
+	var errs []importError
+
+	for i, e := range entries {
+		s := models.NewSnippet{
+			Title:   e.Title,
+			Format:  e.Format,
+			Files:   e.Files,
+			Tags:    parseTags(strings.Join(e.Tags, ",")),
+			Expires: e.Expires,
+		}
+		if s.Format == "" {
+			s.Format = models.FormatPlain
+		}
+		if s.Expires == 0 {
+			s.Expires = 365
+		}
+
+		var v validator.Validator
+		checkSnippet(&v, s.Title, s.Files)
+		checkFormat(&v, s.Format)
+		checkTags(&v, s.Tags)
+		v.CheckField(validator.PermittedValue(s.Expires, 1, 7, 365), "expires", "This field must equal 1, 7, or 365.")
+
+		// Unlike form values, the contents of an uploaded file may not be text.
+		for j, f := range s.Files {
+			v.CheckField(utf8.ValidString(f.Content), fmt.Sprintf("files[%d].content", j), "This file isn't UTF-8 text.")
+		}
+
+		if !v.Valid() {
+			e := importError{Item: i + 1, Title: s.Title}
+			for field, message := range v.FieldErrors {
+				e.Errors = append(e.Errors, field+": "+message)
+			}
+			sort.Strings(e.Errors)
+			errs = append(errs, e)
+			continue
+		}
+
+		snippets = append(snippets, s)
+	}
+
+	return snippets, errs
+}
+
+// Returned by importSnippets if any of the snippets are invalid.
+var errInvalidImport = errors.New("invalid snippets")
+
+// Returned by importSnippets if the file can't be imported. The message is
+// suitable for the user.
+type importFileError string
+
+func (e importFileError) Error() string {
+	return string(e)
+}
+
+/*
+Parses, validates and inserts the snippets in an import file, owned by the user
+with the given ID. The snippets are inserted in a single transaction, so either
+all of them are imported or none are.
+
+If the file can't be parsed, an importFileError is returned. If any snippets
+are invalid, errInvalidImport is returned along with their errors.
+*/
+func (app *application) importSnippets(userID int, data []byte) ([]int, []importError, error) {
+	entries, err := bundle.Parse(data)
+	if err != nil {
+		if errors.Is(err, bundle.ErrUnknownFormat) {
+			return nil, nil, importFileError("This file isn't a JSON array, gist or zip archive.")
+		}
+		return nil, nil, importFileError("This file couldn't be read: " + strings.TrimPrefix(err.Error(), "bundle: "))
+	}
+
+	switch {
+	case len(entries) == 0:
+		return nil, nil, importFileError("This file doesn't contain any snippets.")
+	case len(entries) > maxImportItems:
+		return nil, nil, importFileError(fmt.Sprintf("This file can't contain more than %d snippets.", maxImportItems))
+	}
+
+	snippets, errs := checkImport(entries)
+	if len(errs) > 0 {
+		return nil, errs, errInvalidImport
+	}
+
+	ids, err := app.snippets.InsertMany(userID, snippets)
+	return ids, nil, err
+}
+
+/*
+Runs the import subcommand, which imports the snippets in a file for a user:
+
+	web import -email=alice@example.com [-dsn=...] snippets.json
+
+The file is parsed and validated as by the upload form. Returns the exit code.
+*/
+func runImport(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	dsn := fs.String(
+		"dsn",
+		"web:devpass@/snippetbox?parseTime=true",
+		"MySQL data source name (aka 'connection string')")
+	email := fs.String("email", "", "Email of the user who will own the snippets")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: web import -email=<owner's email> [-dsn=<dsn>] <file>")
+		fs.PrintDefaults()
+	}
+
+	err := fs.Parse(args)
+	if err != nil {
+		return 2
+	}
+	if *email == "" || fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	db, err := openDB(*dsn)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	defer db.Close()
+
+	app := newImportApplication(db, stderr)
+	return app.importFile(*email, fs.Arg(0), stdout, stderr)
+}
+
+// Returns an application with the dependencies that the import subcommand
+// needs.
+func newImportApplication(db *sql.DB, stderr io.Writer) *application {
+	return &application{
+		logger:   slog.New(slog.NewTextHandler(stderr, nil)),
+		snippets: &models.SnippetModel{DB: db},
+		users:    &models.UserModel{DB: db},
+	}
+}
+
+// Imports the snippets in the file at path for the user with the given email,
+// reporting the result on stdout and any errors on stderr. Returns the exit
+// code of the import subcommand.
+func (app *application) importFile(email, path string, stdout, stderr io.Writer) int {
+	user, err := app.users.GetByEmail(email)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			fmt.Fprintf(stderr, "no user with email %s\n", email)
+		} else {
+			fmt.Fprintln(stderr, err)
+		}
+		return 1
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, maxImportSize+1))
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	if len(data) > maxImportSize {
+		fmt.Fprintf(stderr, "%s is larger than %d bytes\n", path, maxImportSize)
+		return 1
+	}
+
+	ids, errs, err := app.importSnippets(user.ID, data)
+	if err != nil {
+		if errors.Is(err, errInvalidImport) {
+			for _, e := range errs {
+				fmt.Fprintln(stderr, e)
+			}
+			fmt.Fprintf(stderr, "%d snippets are invalid, so none were imported\n", len(errs))
+			return 1
+		}
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	fmt.Fprintf(stdout, "imported %d snippets for %s\n", len(ids), email)
+	return 0
+}
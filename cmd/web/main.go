@@ -1,19 +1,30 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"database/sql"
+	"errors"
 	"flag"
+	"fmt"
 	"html/template"
 	"log/slog"
 	"net/http"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/alexedwards/scs/mysqlstore"
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-playground/form/v4"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/kvnloughead/snippetbox/internal/blob"
+	"github.com/kvnloughead/snippetbox/internal/hasher"
+	"github.com/kvnloughead/snippetbox/internal/mailer"
 	"github.com/kvnloughead/snippetbox/internal/models"
+	"github.com/kvnloughead/snippetbox/internal/validator"
+	"github.com/kvnloughead/snippetbox/internal/viewcount"
+	"golang.org/x/crypto/bcrypt"
 
 	// Aliasing with a blank identifier because the driver isn't used explicitly.
 
@@ -24,20 +35,70 @@ import (
 type application struct {
 	logger         *slog.Logger
 	snippets       models.SnippetModelInterface
+	comments       models.CommentModelInterface
+	stars          models.StarModelInterface
+	teams          models.TeamModelInterface
 	users          models.UserModelInterface
+	tokens         models.TokenModelInterface
+	totp           models.TOTPModelInterface
+	sessions       models.SessionModelInterface
+	rememberTokens models.RememberTokenModelInterface
+	identities     models.IdentityModelInterface
+	passkeys       models.PasskeyModelInterface
+	audit          models.AuditModelInterface
+	views          *viewcount.Counter // buffers snippet views until they're flushed
+	oidcProviders  []*oidcProvider    // identity providers that users can log in with
+	webauthn       *webauthn.WebAuthn // relying party for passkey registrations and logins
+	passwordPolicy validator.PasswordPolicy
+	blobs          blob.Store // uploaded files, such as avatars
 	templateCache  map[string]*template.Template
 	formDecoder    *form.Decoder
 	sessionManager *scs.SessionManager
+	mailer         mailer.Mailer
+	baseURL        string // used to build absolute links, such as in emails
 	debug          bool
+	wg             sync.WaitGroup // tracks goroutines started by app.background
 }
 
 func main() {
+	// The import subcommand imports snippets from a file, instead of running the
+	// server.
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		os.Exit(runImport(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
 	addr := flag.String("addr", ":4000", "HTTP Network Address")
 	dsn := flag.String(
 		"dsn",
 		"web:devpass@/snippetbox?parseTime=true",
 		"MySQL data source name (aka 'connection string')")
 	debug := flag.Bool("debug", false, "Run in debug mode")
+	baseURL := flag.String("base-url", "https://localhost:4000", "Public URL of the app, used for links in emails and to bind passkeys")
+
+	// If no SMTP host is provided, emails are written to the log instead.
+	smtpHost := flag.String("smtp-host", "", "SMTP host")
+	smtpPort := flag.Int("smtp-port", 25, "SMTP port")
+	smtpUsername := flag.String("smtp-username", "", "SMTP username")
+	smtpPassword := flag.String("smtp-password", "", "SMTP password")
+	smtpSender := flag.String("smtp-sender", "Snippetbox <no-reply@snippetbox.local>", "SMTP sender")
+
+	// If no OIDC config is provided, users can only log in with passwords.
+	oidcConfig := flag.String("oidc-config", "", "Path to a JSON file of OpenID Connect providers")
+
+	uploadDir := flag.String("upload-dir", "./uploads", "Directory to store uploaded files, such as avatars, in")
+
+	// New passwords can't be common passwords. The embedded list of the most
+	// common ones can be replaced with a larger one, such as Pwned Passwords.
+	passwordMinLength := flag.Int("password-min-length", validator.DefaultPasswordPolicy.MinLength, "Minimum length of new passwords")
+	passwordDenyList := flag.String("password-deny-list", "", "Path to a file of SHA-1 hashes of passwords that can't be used")
+
+	// Passwords are hashed with the chosen algorithm and costs. Existing hashes
+	// made by the other algorithm, or with lower costs, are rehashed on login.
+	passwordHasher := flag.String("password-hasher", "bcrypt", "Password hashing algorithm, bcrypt or argon2id")
+	bcryptCost := flag.Int("bcrypt-cost", 12, "bcrypt cost, from 10 to 31")
+	argon2Memory := flag.Uint("argon2-memory", uint(hasher.DefaultArgon2id.Memory), "argon2id memory, in KiB")
+	argon2Iterations := flag.Uint("argon2-iterations", uint(hasher.DefaultArgon2id.Iterations), "argon2id iterations")
+	argon2Parallelism := flag.Uint("argon2-parallelism", uint(hasher.DefaultArgon2id.Parallelism), "argon2id parallelism")
 	flag.Parse()
 
 	// Initialize structured logger to stdout with default settings.
@@ -69,13 +130,74 @@ func main() {
 
 	formDecoder := form.NewDecoder()
 
+	var m mailer.Mailer = &mailer.LogMailer{Logger: logger}
+	if *smtpHost != "" {
+		m = mailer.New(*smtpHost, *smtpPort, *smtpUsername, *smtpPassword, *smtpSender)
+	}
+
+	var oidcProviders []*oidcProvider
+	if *oidcConfig != "" {
+		oidcProviders, err = loadOIDCProviders(context.Background(), *oidcConfig, *baseURL)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+	}
+
+	passwordPolicy := validator.DefaultPasswordPolicy
+	passwordPolicy.MinLength = *passwordMinLength
+	if *passwordDenyList != "" {
+		passwordPolicy.DenyList, err = loadDenyList(*passwordDenyList)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+	}
+
+	blobs, err := blob.NewDiskStore(*uploadDir)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	h, err := newHasher(*passwordHasher, *bcryptCost, *argon2Memory, *argon2Iterations, *argon2Parallelism)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	webAuthn, err := newWebAuthn(*baseURL)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	snippetModel := &models.SnippetModel{DB: db}
+
 	app := &application{
 		logger:         logger,
-		snippets:       &models.SnippetModel{DB: db},
-		users:          &models.UserModel{DB: db},
+		snippets:       snippetModel,
+		comments:       &models.CommentModel{DB: db},
+		stars:          &models.StarModel{DB: db},
+		teams:          &models.TeamModel{DB: db},
+		users:          &models.UserModel{DB: db, Hasher: h},
+		tokens:         &models.TokenModel{DB: db},
+		totp:           &models.TOTPModel{DB: db},
+		sessions:       &models.SessionModel{DB: db},
+		rememberTokens: &models.RememberTokenModel{DB: db},
+		identities:     &models.IdentityModel{DB: db},
+		passkeys:       &models.PasskeyModel{DB: db},
+		audit:          &models.AuditModel{DB: db},
+		views:          viewcount.New(snippetModel, viewDedupWindow),
+		oidcProviders:  oidcProviders,
+		webauthn:       webAuthn,
+		passwordPolicy: passwordPolicy,
+		blobs:          blobs,
 		templateCache:  templateCache,
 		formDecoder:    formDecoder,
 		sessionManager: sessionManager,
+		mailer:         m,
+		baseURL:        *baseURL,
 		debug:          *debug,
 	}
 
@@ -101,11 +223,47 @@ func main() {
 	/* Info level log statement. Arguments after the first can either be variadic, key/value pairs, or attribute pairs created by slog.String, or a similar method. */
 	logger.Info("starting server", slog.String("addr", srv.Addr))
 
-	// Run the HTTPS server, passing it the self-signed TLS certificate and key.
-	// If an error occurs, log it and exit.
-	err = srv.ListenAndServeTLS("./tls/cert.pem", "./tls/key.pem")
-	logger.Error(err.Error())
-	os.Exit(1)
+	// Run the HTTPS server, passing it the self-signed TLS certificate and key,
+	// until it's shut down. If an error occurs, log it and exit.
+	err = app.serve(srv, "./tls/cert.pem", "./tls/key.pem")
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+// Reads the password deny list at path.
+func loadDenyList(path string) (*validator.DenyList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return validator.ParseDenyList(f)
+}
+
+// Returns a password hasher for the named algorithm, with the given costs.
+// Only the costs of the chosen algorithm are used.
+func newHasher(algorithm string, bcryptCost int, argon2Memory, argon2Iterations, argon2Parallelism uint) (hasher.Hasher, error) {
+	switch algorithm {
+	case "bcrypt":
+		if bcryptCost < 10 || bcryptCost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("bcrypt cost must be from 10 to %d", bcrypt.MaxCost)
+		}
+		return hasher.Bcrypt{Cost: bcryptCost}, nil
+	case "argon2id":
+		if argon2Memory < 8*argon2Parallelism || argon2Iterations < 1 || argon2Parallelism < 1 || argon2Parallelism > 255 {
+			return nil, errors.New("argon2id costs are invalid")
+		}
+		h := hasher.DefaultArgon2id
+		h.Memory = uint32(argon2Memory)
+		h.Iterations = uint32(argon2Iterations)
+		h.Parallelism = uint8(argon2Parallelism)
+		return h, nil
+	default:
+		return nil, fmt.Errorf("unknown password hasher %q", algorithm)
+	}
 }
 
 // Returns an sql.DB connection pool for the supplied data source name (DSN).
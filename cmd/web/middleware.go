@@ -2,10 +2,14 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"slices"
+	"time"
 
 	"github.com/justinas/nosurf"
+	"github.com/kvnloughead/snippetbox/internal/models"
 )
 
 // Sets secure headers, per OWASP guidelines.
@@ -94,6 +98,64 @@ func (app *application) requireAuthentication(next http.Handler) http.Handler {
 	})
 }
 
+// Returns middleware that only lets through users with one of the given roles.
+// Other users get a 403 Forbidden response. It must come after
+// requireAuthentication, so that anonymous users are asked to log in instead.
+func (app *application) requireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !slices.Contains(roles, app.userRole(r)) {
+				app.clientError(w, http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Returns middleware that limits request bodies to n bytes. Reading past the
+// limit fails, so a form that is too large can't be read into memory or onto
+// disk. It must come before noSurf, which parses the form to find the token.
+func limitRequestBody(n int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Body = http.MaxBytesReader(w, r.Body, n)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// How long requests that upload files have to be read and responded to.
+const uploadTimeout = 5 * time.Minute
+
+// Returns middleware that gives the request d to be read and responded to, in
+// place of the server's read and write timeouts, which are too short for large
+// uploads on slow connections. It must come before noSurf, which reads the
+// whole body to find the token.
+func (app *application) extendDeadlines(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rc := http.NewResponseController(w)
+			deadline := time.Now().Add(d)
+
+			err := rc.SetReadDeadline(deadline)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+
+			err = rc.SetWriteDeadline(deadline)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 // Middleware function that uses the nosurf package to prevent CSRF attacks.
 // This middleware should be used on all pages that contain a potentially
 // vulnerable route (non-GET/HEAD/OPTIONS/TRACE).
@@ -118,35 +180,100 @@ func noSurf(next http.Handler) http.Handler {
 // the session data contains an authenticatedUserID). If so, then
 // `isAuthenticatedContextKey: true` is added to the request context.
 //
-// If there is no authenticatedUserID, the next handler called with no
+// If there is no authenticatedUserID, but the user has a remember me cookie,
+// they are logged back in with it. Otherwise, the next handler called with no
 // modification to the request.
 //
 // If there is an authenticatedUserID, but there is no corresponding user in
 // the DB, a 500 error is returned.
+//
+// If the session has been revoked, or the user's account has been disabled,
+// the user is logged out of it. Otherwise, the user's role is also added to
+// the request context.
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Attempt to retrieve authenticated ID from the session.
 		// If no authenticated ID is found in the session, 0 will be returned.
 		id := app.sessionManager.GetInt(r.Context(), string(authenticatedUserID))
+		if id == 0 {
+			// If the user has a remember me cookie, log them back in with it.
+			var err error
+			id, err = app.loginFromRememberMe(w, r)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+		}
 		if id == 0 {
 			next.ServeHTTP(w, r) // Carry on, without authentication.
 			return
 		}
 
 		// Make sure that a user with this ID exists in the DB.
-		exists, err := app.users.Exists(id)
+		exists := true
+		user, err := app.users.Get(id)
+		if err != nil {
+			if !errors.Is(err, models.ErrNoRecord) {
+				app.serverError(w, r, err)
+				return
+			}
+			exists = false
+		}
+
+		// Make sure that the session hasn't been revoked, e.g. from the user's
+		// sessions page or by a password change. If it has, the user is logged out.
+		// So are users whose account has been disabled.
+		sid := app.sessionManager.GetString(r.Context(), string(sessionID))
+		valid, err := app.sessions.Exists(sid, id)
 		if err != nil {
 			app.serverError(w, r, err)
 			return
 		}
 
+		if !valid || user.Disabled {
+			err = app.sessionManager.RenewToken(r.Context())
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+			app.sessionManager.Remove(r.Context(), string(authenticatedUserID))
+			app.sessionManager.Remove(r.Context(), string(sessionID))
+			app.sessionManager.Remove(r.Context(), string(sessionLastSeen))
+
+			err = app.forgetRememberMe(w, r)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Update the session's last seen time, at most once per
+		// sessionTouchInterval, to avoid a DB write on every request.
+		lastSeen := app.sessionManager.GetInt64(r.Context(), string(sessionLastSeen))
+		if now := time.Now().Unix(); now-lastSeen >= int64(sessionTouchInterval.Seconds()) {
+			err = app.sessions.Touch(sid, clientIP(r), userAgent(r))
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+			app.sessionManager.Put(r.Context(), string(sessionLastSeen), now)
+		}
+
 		// If an authenticated ID is present and corresponds to an existing user
 		// indicate this in the request's context.
+		// The user's role is added too, for requireRole.
 		if exists {
 			ctx := context.WithValue(r.Context(), isAuthenticatedContextKey, true)
+			ctx = context.WithValue(ctx, userRoleContextKey, user.Role)
 			r = r.WithContext(ctx)
 		}
 
 		next.ServeHTTP(w, r)
 	})
 }
+
+// How often a session's last seen time is updated.
+const sessionTouchInterval = time.Minute
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	assert "github.com/kvnloughead/snippetbox/internal"
 )
@@ -52,3 +53,59 @@ func TestSecureHeaders(t *testing.T) {
 	body = bytes.TrimSpace(body)
 	assert.Equal(t, string(body), "OK")
 }
+
+func TestExtendDeadlines(t *testing.T) {
+	app := newTestApplication(t)
+
+	// Reads the whole body, and echoes it back.
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Write(body)
+	})
+
+	tests := []struct {
+		name     string
+		handler  http.Handler
+		wantCode int
+	}{
+		{
+			name:     "Server timeout",
+			handler:  next,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Extended",
+			handler:  app.extendDeadlines(time.Second)(next),
+			wantCode: http.StatusOK,
+		},
+	}
+
+	for _, sub := range tests {
+		t.Run(sub.name, func(t *testing.T) {
+			ts := httptest.NewUnstartedServer(sub.handler)
+			ts.Config.ReadTimeout = 50 * time.Millisecond
+			ts.Start()
+			defer ts.Close()
+
+			// Sends the body slower than the server's read timeout allows.
+			pr, pw := io.Pipe()
+			go func() {
+				pw.Write([]byte("slow"))
+				time.Sleep(200 * time.Millisecond)
+				pw.Write([]byte("body"))
+				pw.Close()
+			}()
+
+			res, err := http.Post(ts.URL, "text/plain", pr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			assert.Equal(t, res.StatusCode, sub.wantCode)
+		})
+	}
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/julienschmidt/httprouter"
+	"github.com/kvnloughead/snippetbox/internal/models"
+	"golang.org/x/oauth2"
+)
+
+/*
+The configuration of an OpenID Connect provider that users can log in with, as
+read from the file given by the -oidc-config flag. The file contains a JSON
+array of providers, such as:
+
+	[
+	  {
+	    "name": "company",
+	    "display_name": "Company SSO",
+	    "issuer": "https://sso.example.com",
+	    "client_id": "snippetbox",
+	    "client_secret": "secret"
+	  }
+	]
+
+The provider's redirect URL is the app's base URL, followed by
+/user/login/oidc/<name>/callback. Scopes default to openid, email and profile.
+*/
+type oidcProviderConfig struct {
+	Name         string   `json:"name"`
+	DisplayName  string   `json:"display_name"`
+	Issuer       string   `json:"issuer"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"`
+	Scopes       []string `json:"scopes"`
+}
+
+// An OpenID Connect provider that users can log in with.
+type oidcProvider struct {
+	Name        string // used in URLs and to link accounts, so it must not change
+	DisplayName string
+	oauth2      oauth2.Config
+	verifier    *oidc.IDTokenVerifier
+}
+
+// The claims we use from a provider's ID tokens.
+type oidcClaims struct {
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+}
+
+// Provider names can only contain lowercase letters, digits and dashes, so
+// that they can be used in URLs.
+var oidcProviderNameRX = regexp.MustCompile(`^[a-z0-9-]{1,50}$`)
+
+// Reads the providers in the config file at path, and fetches their discovery
+// documents. baseURL is used to build their redirect URLs.
+func loadOIDCProviders(ctx context.Context, path, baseURL string) ([]*oidcProvider, error) {
+	f, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var configs []oidcProviderConfig
+	err = json.Unmarshal(f, &configs)
+	if err != nil {
+		return nil, fmt.Errorf("oidc config: %w", err)
+	}
+
+	var providers []*oidcProvider
+	seen := map[string]bool{}
+	for _, cfg := range configs {
+		if seen[cfg.Name] {
+			return nil, fmt.Errorf("oidc config: duplicate provider %q", cfg.Name)
+		}
+		seen[cfg.Name] = true
+
+		p, err := newOIDCProvider(ctx, cfg, baseURL)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, p)
+	}
+
+	return providers, nil
+}
+
+// Returns the provider with the given config, after fetching its discovery
+// document from its issuer. baseURL is used to build its redirect URL.
+func newOIDCProvider(ctx context.Context, cfg oidcProviderConfig, baseURL string) (*oidcProvider, error) {
+	if !oidcProviderNameRX.MatchString(cfg.Name) {
+		return nil, fmt.Errorf("oidc config: invalid provider name %q", cfg.Name)
+	}
+	if cfg.Issuer == "" || cfg.ClientID == "" {
+		return nil, fmt.Errorf("oidc config: provider %q needs an issuer and a client_id", cfg.Name)
+	}
+	if cfg.DisplayName == "" {
+		cfg.DisplayName = cfg.Name
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{oidc.ScopeOpenID, "email", "profile"}
+	}
+
+	provider, err := oidc.NewProvider(ctx, cfg.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("oidc config: provider %q: %w", cfg.Name, err)
+	}
+
+	return &oidcProvider{
+		Name:        cfg.Name,
+		DisplayName: cfg.DisplayName,
+		oauth2: oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			Endpoint:     provider.Endpoint(),
+			RedirectURL:  strings.TrimSuffix(baseURL, "/") + "/user/login/oidc/" + cfg.Name + "/callback",
+			Scopes:       cfg.Scopes,
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: cfg.ClientID}),
+	}, nil
+}
+
+// Returns a random string for the state and nonce of a login, which can't be
+// guessed by an attacker.
+func oidcRandomString() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Returns the provider whose name is in the URL. If there's no such provider,
+// a 404 NotFound response is sent and ok is false.
+func (app *application) oidcProviderFromURL(w http.ResponseWriter, r *http.Request) (provider *oidcProvider, ok bool) {
+	name := httprouter.ParamsFromContext(r.Context()).ByName("provider")
+	for _, p := range app.oidcProviders {
+		if p.Name == name {
+			return p, true
+		}
+	}
+
+	app.notFound(w)
+	return nil, false
+}
+
+/*
+Starts logging the user in with an OpenID Connect provider, by redirecting them
+to it with an authorization code request.
+
+The state, nonce and PKCE verifier of the request are kept in the session, to
+be checked when the provider redirects the user back.
+*/
+func (app *application) userLoginOIDC(w http.ResponseWriter, r *http.Request) {
+	provider, ok := app.oidcProviderFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	state, err := oidcRandomString()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	nonce, err := oidcRandomString()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	verifier := oauth2.GenerateVerifier()
+
+	app.sessionManager.Put(r.Context(), string(oidcProviderName), provider.Name)
+	app.sessionManager.Put(r.Context(), string(oidcState), state)
+	app.sessionManager.Put(r.Context(), string(oidcNonce), nonce)
+	app.sessionManager.Put(r.Context(), string(oidcVerifier), verifier)
+
+	url := provider.oauth2.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+	http.Redirect(w, r, url, http.StatusSeeOther)
+}
+
+// Occurs when a provider's ID token doesn't have a verified email, so it
+// can't be linked to an account.
+var errOIDCEmailNotVerified = errors.New("oidc: email not verified")
+
+// Sends the user back to the login page with a flash message, after a login
+// with a provider has failed, and records the failure in the audit log.
+func (app *application) oidcLoginFailed(w http.ResponseWriter, r *http.Request, provider *oidcProvider, reason, message string) {
+	err := app.recordAudit(r, models.AuditLoginFailed, "oidc:"+provider.Name, reason)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), string(flash), message)
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+/*
+Completes a login with an OpenID Connect provider, after it redirects the user
+back to us with an authorization code. The code is exchanged for an ID token,
+which is verified, and the user is logged in to the account it's linked to.
+
+If the provider's account isn't linked to one yet, it's linked to the account
+with the same email, or to a new account if there isn't one. Providers can
+vouch for any email, so this is only done if the provider says that the email
+has been verified.
+
+Users with two-factor authentication enabled still need to enter a code.
+*/
+func (app *application) userLoginOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	provider, ok := app.oidcProviderFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	// The login can only be completed once, so its values are removed from the
+	// session, whatever happens.
+	name := app.sessionManager.PopString(r.Context(), string(oidcProviderName))
+	state := app.sessionManager.PopString(r.Context(), string(oidcState))
+	nonce := app.sessionManager.PopString(r.Context(), string(oidcNonce))
+	verifier := app.sessionManager.PopString(r.Context(), string(oidcVerifier))
+
+	qs := r.URL.Query()
+	if name != provider.Name || state == "" || subtle.ConstantTimeCompare([]byte(qs.Get("state")), []byte(state)) != 1 {
+		app.oidcLoginFailed(w, r, provider, "invalid state", "Your login has expired, please try again.")
+		return
+	}
+
+	if qs.Get("error") != "" {
+		app.oidcLoginFailed(w, r, provider, qs.Get("error"), fmt.Sprintf("Login with %s was cancelled.", provider.DisplayName))
+		return
+	}
+
+	failed := fmt.Sprintf("Login with %s failed, please try again.", provider.DisplayName)
+
+	token, err := provider.oauth2.Exchange(r.Context(), qs.Get("code"), oauth2.VerifierOption(verifier))
+	if err != nil {
+		app.logger.Warn("oidc code exchange failed", "provider", provider.Name, "error", err.Error())
+		app.oidcLoginFailed(w, r, provider, "code exchange failed", failed)
+		return
+	}
+
+	rawIDToken, _ := token.Extra("id_token").(string)
+	idToken, err := provider.verifier.Verify(r.Context(), rawIDToken)
+	if err == nil && subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(nonce)) != 1 {
+		err = errors.New("oidc: nonce doesn't match")
+	}
+	if err != nil {
+		app.logger.Warn("oidc id token rejected", "provider", provider.Name, "error", err.Error())
+		app.oidcLoginFailed(w, r, provider, "invalid id token", failed)
+		return
+	}
+
+	var claims oidcClaims
+	err = idToken.Claims(&claims)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	id, err := app.oidcUser(r, provider, idToken.Subject, claims)
+	if err != nil {
+		if errors.Is(err, errOIDCEmailNotVerified) {
+			app.oidcLoginFailed(w, r, provider, "email not verified",
+				fmt.Sprintf("Your email must be verified by %s before you can log in with it.", provider.DisplayName))
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.users.Get(id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if user.Disabled {
+		err = app.recordAudit(r, models.AuditLoginFailed, models.AuditUserTarget(id), "account disabled")
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		app.sessionManager.Put(r.Context(), string(flash), "Your account has been disabled.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	app.beginLogin(w, r, id, false)
+}
+
+/*
+Returns the ID of the user that the provider's account with the given subject
+is linked to. If it isn't linked yet, it's linked to the user with the same
+email, who is created if they don't exist.
+
+If the account has to be linked, but its email isn't verified, an
+errOIDCEmailNotVerified error is returned.
+*/
+func (app *application) oidcUser(r *http.Request, provider *oidcProvider, subject string, claims oidcClaims) (int, error) {
+	id, err := app.identities.Get(provider.Name, subject)
+	if err == nil {
+		return id, nil
+	} else if !errors.Is(err, models.ErrNoRecord) {
+		return 0, err
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		return 0, errOIDCEmailNotVerified
+	}
+
+	user, err := app.users.GetByEmail(claims.Email)
+	if err == nil {
+		id = user.ID
+	} else if errors.Is(err, models.ErrNoRecord) {
+		id, err = app.oidcSignup(r, provider, claims)
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		return 0, err
+	}
+
+	err = app.identities.Insert(provider.Name, subject, id)
+	if err != nil {
+		return 0, err
+	}
+
+	err = app.recordAudit(r, models.AuditIdentityLinked, models.AuditUserTarget(id), provider.Name)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// Creates an account for a user logging in with a provider for the first
+// time. The account gets a random password, so it can only be logged in to
+// with the provider, until the user resets their password.
+func (app *application) oidcSignup(r *http.Request, provider *oidcProvider, claims oidcClaims) (int, error) {
+	name := claims.Name
+	if name == "" {
+		name, _, _ = strings.Cut(claims.Email, "@")
+	}
+
+	password, err := oidcRandomString()
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := app.users.Insert(truncate(name, 255), claims.Email, password)
+	if err != nil {
+		return 0, err
+	}
+
+	err = app.recordAudit(r, models.AuditSignup, models.AuditUserTarget(id), provider.Name)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
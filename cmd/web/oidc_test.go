@@ -0,0 +1,418 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	assert "github.com/kvnloughead/snippetbox/internal"
+	"github.com/kvnloughead/snippetbox/internal/models"
+	"github.com/kvnloughead/snippetbox/internal/models/mocks"
+)
+
+// The account that logs in at the fake OIDC provider. If Subject is empty,
+// the user denies the login instead.
+type fakeOIDCUser struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// An authorization code issued by the fake OIDC provider.
+type fakeOIDCCode struct {
+	user        fakeOIDCUser
+	nonce       string
+	challenge   string
+	redirectURI string
+}
+
+/*
+An in-process OpenID Connect provider, for testing logins without a real one.
+It supports discovery, the authorization code flow with PKCE, and RS256 signed
+ID tokens.
+
+Its authorization endpoint doesn't display a login page. Instead, it logs in
+as whoever its user is and redirects straight back.
+*/
+type fakeOIDCServer struct {
+	*httptest.Server
+	clientID     string
+	clientSecret string
+	key          *rsa.PrivateKey
+
+	mu       sync.Mutex
+	user     fakeOIDCUser
+	badNonce bool // if true, ID tokens have the wrong nonce
+	codes    map[string]fakeOIDCCode
+}
+
+func newFakeOIDCServer(t *testing.T) *fakeOIDCServer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &fakeOIDCServer{
+		clientID:     "snippetbox",
+		clientSecret: "secret",
+		key:          key,
+		codes:        map[string]fakeOIDCCode{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", s.discovery)
+	mux.HandleFunc("/authorize", s.authorize)
+	mux.HandleFunc("/token", s.token)
+	mux.HandleFunc("/jwks", s.jwks)
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+// Sets the account that logs in next.
+func (s *fakeOIDCServer) setUser(user fakeOIDCUser) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.user = user
+}
+
+func (s *fakeOIDCServer) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]any{
+		"issuer":                                s.URL,
+		"authorization_endpoint":                s.URL + "/authorize",
+		"token_endpoint":                        s.URL + "/token",
+		"jwks_uri":                              s.URL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (s *fakeOIDCServer) authorize(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	if qs.Get("client_id") != s.clientID || qs.Get("response_type") != "code" || qs.Get("code_challenge_method") != "S256" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	redirect, err := url.Parse(qs.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	params := url.Values{"state": {qs.Get("state")}}
+	if s.user.Subject == "" {
+		params.Set("error", "access_denied")
+	} else {
+		code, err := oidcRandomString()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.codes[code] = fakeOIDCCode{
+			user:        s.user,
+			nonce:       qs.Get("nonce"),
+			challenge:   qs.Get("code_challenge"),
+			redirectURI: qs.Get("redirect_uri"),
+		}
+		params.Set("code", code)
+	}
+
+	redirect.RawQuery = params.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (s *fakeOIDCServer) token(w http.ResponseWriter, r *http.Request) {
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		id, secret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+	}
+	if id != s.clientID || secret != s.clientSecret {
+		http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+		return
+	}
+
+	s.mu.Lock()
+	code, ok := s.codes[r.PostFormValue("code")]
+	delete(s.codes, r.PostFormValue("code")) // codes can only be used once
+	badNonce := s.badNonce
+	s.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+	if !ok || r.PostFormValue("grant_type") != "authorization_code" ||
+		r.PostFormValue("redirect_uri") != code.redirectURI || challenge != code.challenge {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+
+	nonce := code.nonce
+	if badNonce {
+		nonce = "wrong"
+	}
+
+	now := time.Now()
+	idToken, err := s.sign(map[string]any{
+		"iss":            s.URL,
+		"sub":            code.user.Subject,
+		"aud":            s.clientID,
+		"exp":            now.Add(time.Hour).Unix(),
+		"iat":            now.Unix(),
+		"nonce":          nonce,
+		"email":          code.user.Email,
+		"email_verified": code.user.EmailVerified,
+		"name":           code.user.Name,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token": "access-token",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+func (s *fakeOIDCServer) jwks(w http.ResponseWriter, r *http.Request) {
+	pub := s.key.PublicKey
+	json.NewEncoder(w).Encode(map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+// Returns the claims as a JWT signed with RS256.
+func (s *fakeOIDCServer) sign(claims map[string]any) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// Logs in to ts with the fake provider, following the redirects to the
+// provider and back. Returns the response to the callback.
+func (s *fakeOIDCServer) login(t *testing.T, ts *testServer) (int, http.Header, string) {
+	t.Helper()
+
+	code, header, _ := ts.get(t, "/user/login/oidc/test")
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	response, err := client.Get(header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	assert.Equal(t, response.StatusCode, http.StatusFound)
+
+	callback, err := url.Parse(response.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ts.get(t, callback.RequestURI())
+}
+
+func TestOIDCLogin(t *testing.T) {
+	fake := newFakeOIDCServer(t)
+
+	app := newTestApplication(t)
+	provider, err := newOIDCProvider(context.Background(), oidcProviderConfig{
+		Name:         "test",
+		DisplayName:  "Test SSO",
+		Issuer:       fake.URL,
+		ClientID:     fake.clientID,
+		ClientSecret: fake.clientSecret,
+	}, app.baseURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.oidcProviders = []*oidcProvider{provider}
+	audit := app.audit.(*mocks.AuditModel)
+
+	t.Run("Login page", func(t *testing.T) {
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		_, _, body := ts.get(t, "/user/login")
+		assert.StringContains(t, body, `<a class="button" href="/user/login/oidc/test">Log in with Test SSO</a>`)
+
+		code, _, _ := ts.get(t, "/user/login/oidc/missing")
+		assert.Equal(t, code, http.StatusNotFound)
+	})
+
+	t.Run("Redirect to provider", func(t *testing.T) {
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		_, header, _ := ts.get(t, "/user/login/oidc/test")
+		location, err := url.Parse(header.Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		qs := location.Query()
+		assert.Equal(t, location.Path, "/authorize")
+		assert.Equal(t, qs.Get("redirect_uri"), "https://snippetbox.test/user/login/oidc/test/callback")
+		assert.Equal(t, qs.Get("scope"), "openid email profile")
+		assert.Equal(t, qs.Get("code_challenge_method"), "S256")
+		if qs.Get("state") == "" || qs.Get("nonce") == "" || qs.Get("code_challenge") == "" {
+			t.Errorf("expected state, nonce and code_challenge; got %q", location.RawQuery)
+		}
+	})
+
+	tests := []struct {
+		name         string
+		user         fakeOIDCUser
+		badNonce     bool
+		wantLocation string
+		wantFlash    string
+		wantActions  []string
+	}{
+		{
+			name:         "Link by verified email",
+			user:         fakeOIDCUser{Subject: "user-1", Email: "testuser@mail.com", EmailVerified: true},
+			wantLocation: "/snippet/create",
+			wantActions:  []string{models.AuditIdentityLinked, models.AuditLogin},
+		},
+		{
+			name:         "Linked account with a new email",
+			user:         fakeOIDCUser{Subject: "user-1", Email: "changed@mail.com"},
+			wantLocation: "/snippet/create",
+			wantActions:  []string{models.AuditLogin},
+		},
+		{
+			name:         "Unverified email",
+			user:         fakeOIDCUser{Subject: "user-3", Email: "otheruser@mail.com"},
+			wantLocation: "/user/login",
+			wantFlash:    "Your email must be verified by Test SSO before you can log in with it.",
+			wantActions:  []string{models.AuditLoginFailed},
+		},
+		{
+			name:         "New user",
+			user:         fakeOIDCUser{Subject: "new", Email: "new@mail.com", EmailVerified: true, Name: "New User"},
+			wantLocation: "/snippet/create",
+			wantActions:  []string{models.AuditSignup, models.AuditIdentityLinked, models.AuditLogin},
+		},
+		{
+			name:         "Two-factor user",
+			user:         fakeOIDCUser{Subject: "user-2", Email: "totpuser@mail.com", EmailVerified: true},
+			wantLocation: "/user/login/totp",
+			wantActions:  []string{models.AuditIdentityLinked},
+		},
+		{
+			name:         "Disabled user",
+			user:         fakeOIDCUser{Subject: "user-6", Email: "disabled@mail.com", EmailVerified: true},
+			wantLocation: "/user/login",
+			wantFlash:    "Your account has been disabled.",
+			wantActions:  []string{models.AuditIdentityLinked, models.AuditLoginFailed},
+		},
+		{
+			name:         "Denied",
+			user:         fakeOIDCUser{},
+			wantLocation: "/user/login",
+			wantFlash:    "Login with Test SSO was cancelled.",
+			wantActions:  []string{models.AuditLoginFailed},
+		},
+		{
+			name:         "Wrong nonce",
+			user:         fakeOIDCUser{Subject: "user-1", Email: "testuser@mail.com", EmailVerified: true},
+			badNonce:     true,
+			wantLocation: "/user/login",
+			wantFlash:    "Login with Test SSO failed, please try again.",
+			wantActions:  []string{models.AuditLoginFailed},
+		},
+	}
+
+	for _, sub := range tests {
+		t.Run(sub.name, func(t *testing.T) {
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			fake.setUser(sub.user)
+			fake.mu.Lock()
+			fake.badNonce = sub.badNonce
+			fake.mu.Unlock()
+
+			before := len(audit.Actions())
+
+			code, header, _ := fake.login(t, ts)
+			assert.Equal(t, code, http.StatusSeeOther)
+			assert.Equal(t, header.Get("Location"), sub.wantLocation)
+
+			if sub.wantFlash != "" {
+				_, _, body := ts.get(t, "/about")
+				assert.StringContains(t, body, sub.wantFlash)
+			}
+
+			actions := audit.Actions()[before:]
+			assert.Equal(t, strings.Join(actions, ","), strings.Join(sub.wantActions, ","))
+		})
+	}
+
+	t.Run("Linked logins", func(t *testing.T) {
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+		ts.login(t, "testuser@mail.com", "pa$$word")
+
+		_, _, body := ts.get(t, "/account/view")
+		assert.StringContainsMatch(t, body, regexp.MustCompile(`Linked Logins</th>\s*<td>\s*test\s*</td>`))
+	})
+
+	t.Run("Expired state", func(t *testing.T) {
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		code, header, _ := ts.get(t, "/user/login/oidc/test/callback?code=code&state=state")
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/user/login")
+
+		_, _, body := ts.get(t, "/about")
+		assert.StringContains(t, body, "Your login has expired, please try again.")
+	})
+}
@@ -0,0 +1,478 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/kvnloughead/snippetbox/internal/models"
+	"github.com/kvnloughead/snippetbox/internal/validator"
+)
+
+// The name of the app shown by browsers when creating or using a passkey.
+const passkeyRPName = "Snippetbox"
+
+// How long the user has to complete a passkey registration or login, once it
+// has begun.
+const passkeyTimeout = 5 * time.Minute
+
+/*
+Returns the WebAuthn relying party for the app at baseURL. Passkeys are bound
+to the host of the base URL, so they stop working if it changes.
+
+Passkeys must be discoverable, so that users can log in without entering their
+email, and they must verify the user, with a PIN or biometric. Since a passkey
+is then something the user has as well as something they know or are, logging
+in with one doesn't need a second factor.
+*/
+func newWebAuthn(baseURL string) (*webauthn.WebAuthn, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("webauthn: invalid base URL %q", baseURL)
+	}
+
+	timeout := webauthn.TimeoutConfig{Enforce: true, Timeout: passkeyTimeout, TimeoutUVD: passkeyTimeout}
+
+	return webauthn.New(&webauthn.Config{
+		RPID:          u.Hostname(),
+		RPDisplayName: passkeyRPName,
+		RPOrigins:     []string{u.Scheme + "://" + u.Host},
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			ResidentKey:        protocol.ResidentKeyRequirementRequired,
+			RequireResidentKey: protocol.ResidentKeyRequired(),
+			UserVerification:   protocol.VerificationRequired,
+		},
+		Timeouts: webauthn.TimeoutsConfig{Login: timeout, Registration: timeout},
+	})
+}
+
+// Adapts a user and their passkeys to the webauthn.User interface.
+type passkeyUser struct {
+	user     models.User
+	passkeys []models.Passkey
+}
+
+// Returns the WebAuthn user handle of the user with the given ID, which
+// authenticators store with their passkeys, and return when they're used to
+// log in.
+func passkeyUserHandle(id int) []byte {
+	return []byte(strconv.Itoa(id))
+}
+
+func (u passkeyUser) WebAuthnID() []byte {
+	return passkeyUserHandle(u.user.ID)
+}
+
+func (u passkeyUser) WebAuthnName() string {
+	return u.user.Email
+}
+
+func (u passkeyUser) WebAuthnDisplayName() string {
+	return u.user.Name
+}
+
+func (u passkeyUser) WebAuthnIcon() string {
+	return ""
+}
+
+func (u passkeyUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, len(u.passkeys))
+	for i, p := range u.passkeys {
+		transports := make([]protocol.AuthenticatorTransport, len(p.Transports))
+		for j, t := range p.Transports {
+			transports[j] = protocol.AuthenticatorTransport(t)
+		}
+
+		credentials[i] = webauthn.Credential{
+			ID:              p.ID,
+			PublicKey:       p.PublicKey,
+			AttestationType: p.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				BackupEligible: p.BackupEligible,
+				BackupState:    p.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    p.AAGUID,
+				SignCount: p.SignCount,
+			},
+		}
+	}
+	return credentials
+}
+
+// Returns the user with the given ID, along with their passkeys.
+func (app *application) passkeyUser(id int) (passkeyUser, error) {
+	user, err := app.users.Get(id)
+	if err != nil {
+		return passkeyUser{}, err
+	}
+
+	passkeys, err := app.passkeys.ForUser(id)
+	if err != nil {
+		return passkeyUser{}, err
+	}
+
+	return passkeyUser{user, passkeys}, nil
+}
+
+// Keeps the session data of a passkey registration or login in the user's
+// session, under the given key, until it's completed.
+func (app *application) putPasskeySession(r *http.Request, key sessionKey, session *webauthn.SessionData) error {
+	js, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	app.sessionManager.Put(r.Context(), string(key), string(js))
+	return nil
+}
+
+// Removes the session data of a passkey registration or login from the user's
+// session, and returns it. If there is none, ok is false.
+func (app *application) popPasskeySession(r *http.Request, key sessionKey) (session webauthn.SessionData, ok bool) {
+	js := app.sessionManager.PopString(r.Context(), string(key))
+	if js == "" {
+		return webauthn.SessionData{}, false
+	}
+
+	err := json.Unmarshal([]byte(js), &session)
+	if err != nil {
+		return webauthn.SessionData{}, false
+	}
+	return session, true
+}
+
+// Sends the options of a passkey registration or login to the browser, which
+// passes them to navigator.credentials.
+func (app *application) writePasskeyOptions(w http.ResponseWriter, r *http.Request, options any) {
+	js, err := json.Marshal(options)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(js)
+}
+
+// Displays the user's passkeys, and the form to register a new one, in
+// response to GET /account/passkeys.
+func (app *application) accountPasskeys(w http.ResponseWriter, r *http.Request) {
+	id := app.sessionManager.GetInt(r.Context(), string(authenticatedUserID))
+
+	passkeys, err := app.passkeys.ForUser(id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Passkeys = passkeys
+	data.Form = accountPasskeyRegisterForm{}
+
+	app.render(w, r, http.StatusOK, "passkeys.tmpl", data)
+}
+
+// Begins registering a passkey, in response to a POST request from the
+// passkey registration form's script. Responds with the options to pass to
+// navigator.credentials.create, as JSON.
+//
+// The user's existing passkeys are excluded, so that an authenticator can't
+// be registered twice.
+func (app *application) accountPasskeyRegisterBegin(w http.ResponseWriter, r *http.Request) {
+	id := app.sessionManager.GetInt(r.Context(), string(authenticatedUserID))
+
+	user, err := app.passkeyUser(id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	var exclusions []protocol.CredentialDescriptor
+	for _, c := range user.WebAuthnCredentials() {
+		exclusions = append(exclusions, c.Descriptor())
+	}
+
+	options, session, err := app.webauthn.BeginRegistration(user, webauthn.WithExclusions(exclusions))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.putPasskeySession(r, passkeyRegistration, session)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.writePasskeyOptions(w, r, options)
+}
+
+// Struct containing form fields for the passkey registration form. Credential
+// is the JSON encoded PublicKeyCredential created by the browser.
+type accountPasskeyRegisterForm struct {
+	Name                string     `form:"name"`
+	Credential          string     `form:"credential"`
+	validator.Validator `form:"-"` // "-" tells formDecoder to ignore the field
+}
+
+// Completes registering a passkey, with the credential created by the browser.
+func (app *application) accountPasskeyRegisterPost(w http.ResponseWriter, r *http.Request) {
+	var form accountPasskeyRegisterForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// The registration can only be completed once, so its session data is
+	// removed, whatever happens.
+	session, ok := app.popPasskeySession(r, passkeyRegistration)
+
+	form.CheckField(validator.NotBlank(form.Name), "name", "This field can't be blank.")
+	form.CheckField(validator.MaxChars(form.Name, 100), "name", "This field can't be more than 100 characters long.")
+	if form.Credential == "" {
+		form.AddNonFieldError("Your browser didn't create a passkey. Please try again.")
+	} else if !ok {
+		form.AddNonFieldError("Your passkey registration has expired. Please try again.")
+	}
+
+	id := app.sessionManager.GetInt(r.Context(), string(authenticatedUserID))
+	user, err := app.passkeyUser(id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	var credential *webauthn.Credential
+	if form.Valid() {
+		var parsed *protocol.ParsedCredentialCreationData
+		parsed, err = protocol.ParseCredentialCreationResponseBody(strings.NewReader(form.Credential))
+		if err == nil {
+			credential, err = app.webauthn.CreateCredential(user, session, parsed)
+		}
+		if err != nil {
+			app.logger.Warn("passkey registration rejected", "userID", id, "error", passkeyError(err))
+			form.AddNonFieldError("Your passkey couldn't be registered. Please try again.")
+		}
+	}
+
+	if !form.Valid() {
+		form.Credential = ""
+		data := app.newTemplateData(r)
+		data.Passkeys = user.passkeys
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "passkeys.tmpl", data)
+		return
+	}
+
+	transports := make([]string, len(credential.Transport))
+	for i, t := range credential.Transport {
+		transports[i] = string(t)
+	}
+
+	err = app.passkeys.Insert(models.Passkey{
+		ID:              credential.ID,
+		UserID:          id,
+		Name:            form.Name,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		Transports:      transports,
+		AAGUID:          credential.Authenticator.AAGUID,
+		SignCount:       credential.Authenticator.SignCount,
+		BackupEligible:  credential.Flags.BackupEligible,
+		BackupState:     credential.Flags.BackupState,
+	})
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.recordAudit(r, models.AuditPasskeyAdded, models.AuditUserTarget(id), form.Name)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), string(flash), "Passkey added.")
+	http.Redirect(w, r, "/account/passkeys", http.StatusSeeOther)
+}
+
+// Struct containing form fields for the passkey deletion form. ID is the
+// base64url encoded ID of the passkey.
+type accountPasskeyDeleteForm struct {
+	ID string `form:"id"`
+}
+
+// Deletes one of the user's passkeys.
+func (app *application) accountPasskeyDeletePost(w http.ResponseWriter, r *http.Request) {
+	var form accountPasskeyDeleteForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	passkeyID, err := base64.RawURLEncoding.DecodeString(form.ID)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	id := app.sessionManager.GetInt(r.Context(), string(authenticatedUserID))
+	name, err := app.passkeys.Delete(passkeyID, id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	err = app.recordAudit(r, models.AuditPasskeyRemoved, models.AuditUserTarget(id), name)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), string(flash), "Passkey removed.")
+	http.Redirect(w, r, "/account/passkeys", http.StatusSeeOther)
+}
+
+// Begins logging in with a passkey, in response to a POST request from the
+// login page's script. Responds with the options to pass to
+// navigator.credentials.get, as JSON.
+//
+// The options don't list any passkeys, so the browser offers all of the
+// user's passkeys for the app, and the user is identified by the one they
+// choose.
+func (app *application) userLoginPasskeyBegin(w http.ResponseWriter, r *http.Request) {
+	options, session, err := app.webauthn.BeginDiscoverableLogin()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.putPasskeySession(r, passkeyLogin, session)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.writePasskeyOptions(w, r, options)
+}
+
+// Struct containing form fields for the passkey login form. Credential is the
+// JSON encoded PublicKeyCredential returned by the browser.
+type userLoginPasskeyForm struct {
+	Credential string `form:"credential"`
+}
+
+// Sends the user back to the login page with a flash message, after a login
+// with a passkey has failed, and records the failure in the audit log.
+func (app *application) passkeyLoginFailed(w http.ResponseWriter, r *http.Request, target, reason, message string) {
+	err := app.recordAudit(r, models.AuditLoginFailed, target, reason)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), string(flash), message)
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+/*
+Completes a login with a passkey, with the assertion returned by the browser.
+The user is identified by the user handle that the authenticator stored with
+the passkey, and logged in if the assertion is signed by one of their passkeys.
+
+If the passkey's signature counter has gone backwards, the authenticator may
+have been cloned, so the login is rejected.
+*/
+func (app *application) userLoginPasskeyPost(w http.ResponseWriter, r *http.Request) {
+	var form userLoginPasskeyForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	session, ok := app.popPasskeySession(r, passkeyLogin)
+	if !ok {
+		app.passkeyLoginFailed(w, r, "passkey", "invalid session", "Your login has expired, please try again.")
+		return
+	}
+
+	failed := "Login with your passkey failed, please try again."
+
+	parsed, err := protocol.ParseCredentialRequestResponseBody(strings.NewReader(form.Credential))
+	if err != nil {
+		app.passkeyLoginFailed(w, r, "passkey", "invalid assertion", failed)
+		return
+	}
+
+	var user passkeyUser
+	credential, err := app.webauthn.ValidateDiscoverableLogin(func(_, userHandle []byte) (webauthn.User, error) {
+		id, err := strconv.Atoi(string(userHandle))
+		if err != nil {
+			return nil, err
+		}
+		user, err = app.passkeyUser(id)
+		return user, err
+	}, session, parsed)
+	if err != nil {
+		app.logger.Warn("passkey login rejected", "error", passkeyError(err))
+		target := "passkey"
+		if user.user.ID != 0 {
+			target = models.AuditUserTarget(user.user.ID)
+		}
+		app.passkeyLoginFailed(w, r, target, "passkey rejected", failed)
+		return
+	}
+
+	target := models.AuditUserTarget(user.user.ID)
+
+	if credential.Authenticator.CloneWarning {
+		app.logger.Warn("passkey sign count went backwards", "userID", user.user.ID, "ip", clientIP(r))
+		app.passkeyLoginFailed(w, r, target, "passkey may be cloned", failed)
+		return
+	}
+
+	if user.user.Disabled {
+		app.passkeyLoginFailed(w, r, target, "account disabled", "Your account has been disabled.")
+		return
+	}
+
+	err = app.passkeys.RecordUse(credential.ID, credential.Authenticator.SignCount, credential.Flags.BackupState)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.completeLogin(w, r, user.user.ID, false)
+}
+
+// Returns the details of an error returned by the webauthn package, for
+// logging. Its errors only describe their kind, such as "Error validating
+// challenge", with the specifics in their details.
+func passkeyError(err error) string {
+	var perr *protocol.Error
+	if errors.As(err, &perr) && perr.Details != "" {
+		return perr.Details + ": " + perr.DevInfo
+	}
+	return err.Error()
+}
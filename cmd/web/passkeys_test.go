@@ -0,0 +1,551 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+	assert "github.com/kvnloughead/snippetbox/internal"
+	"github.com/kvnloughead/snippetbox/internal/models"
+	"github.com/kvnloughead/snippetbox/internal/models/mocks"
+)
+
+// A software authenticator for testing passkeys. It holds a single P-256 key
+// pair, and creates credentials with "none" attestation, as a platform
+// authenticator that verifies the user would.
+type softAuthenticator struct {
+	rpID         string
+	origin       string
+	credentialID []byte
+	key          *ecdsa.PrivateKey
+	userHandle   []byte // set when a credential is created
+	signCount    uint32
+}
+
+func newSoftAuthenticator(t *testing.T, baseURL string) *softAuthenticator {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := make([]byte, 16)
+	_, err = rand.Read(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &softAuthenticator{
+		rpID:         u.Hostname(),
+		origin:       baseURL,
+		credentialID: id,
+		key:          key,
+	}
+}
+
+// Returns the authenticator's public key in COSE format.
+func (a *softAuthenticator) publicKey(t *testing.T) []byte {
+	key, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  int64(webauthncose.P256),
+		XCoord: a.key.X.FillBytes(make([]byte, 32)),
+		YCoord: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// Returns the authenticator's credential as a stored passkey of the user, as
+// if it had been registered already.
+func (a *softAuthenticator) passkey(t *testing.T, userID int) models.Passkey {
+	a.userHandle = passkeyUserHandle(userID)
+	return models.Passkey{
+		ID:              a.credentialID,
+		UserID:          userID,
+		Name:            "Soft key",
+		PublicKey:       a.publicKey(t),
+		AttestationType: "none",
+		AAGUID:          make([]byte, 16),
+		SignCount:       a.signCount,
+	}
+}
+
+// Returns authenticator data with the user present and verified flags set,
+// and the given attested credential data, if any.
+func (a *softAuthenticator) authData(attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+
+	flags := byte(protocol.FlagUserPresent | protocol.FlagUserVerified)
+	if attested != nil {
+		flags |= byte(protocol.FlagAttestedCredentialData)
+	}
+
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	return append(data, attested...)
+}
+
+// Returns the JSON encoded client data of a ceremony with the given type and
+// challenge.
+func (a *softAuthenticator) clientData(t *testing.T, ceremony, challenge string) []byte {
+	js, err := json.Marshal(map[string]any{
+		"type":      ceremony,
+		"challenge": challenge,
+		"origin":    a.origin,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return js
+}
+
+// Creates a credential in response to the options of a registration, as
+// navigator.credentials.create would. Returns the credential, encoded as JSON
+// by the app's script.
+func (a *softAuthenticator) create(t *testing.T, options string) string {
+	var creation protocol.CredentialCreation
+	err := json.Unmarshal([]byte(options), &creation)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userHandle, err := base64.RawURLEncoding.DecodeString(creation.Response.User.ID.(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.userHandle = userHandle
+
+	attested := make([]byte, 16) // the AAGUID, which is zero for "none" attestation
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.credentialID)))
+	attested = append(attested, a.credentialID...)
+	attested = append(attested, a.publicKey(t)...)
+
+	attestation, err := webauthncbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": a.authData(attested),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a.encode(t, map[string]any{
+		"clientDataJSON":    a.clientData(t, "webauthn.create", creation.Response.Challenge.String()),
+		"attestationObject": attestation,
+	})
+}
+
+// Signs the challenge in the options of a login, as navigator.credentials.get
+// would. Returns the assertion, encoded as JSON by the app's script.
+func (a *softAuthenticator) get(t *testing.T, options string) string {
+	var assertion protocol.CredentialAssertion
+	err := json.Unmarshal([]byte(options), &assertion)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a.signCount++
+	authData := a.authData(nil)
+	clientData := a.clientData(t, "webauthn.get", assertion.Response.Challenge.String())
+
+	hash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(authData, hash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a.encode(t, map[string]any{
+		"clientDataJSON":    clientData,
+		"authenticatorData": authData,
+		"signature":         signature,
+		"userHandle":        a.userHandle,
+	})
+}
+
+// Encodes a PublicKeyCredential with the given response fields as JSON, as
+// the app's script does. Byte slices are base64url encoded.
+func (a *softAuthenticator) encode(t *testing.T, fields map[string]any) string {
+	response := map[string]string{}
+	for k, v := range fields {
+		response[k] = base64.RawURLEncoding.EncodeToString(v.([]byte))
+	}
+
+	id := base64.RawURLEncoding.EncodeToString(a.credentialID)
+	js, err := json.Marshal(map[string]any{
+		"id":       id,
+		"rawId":    id,
+		"type":     "public-key",
+		"response": response,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(js)
+}
+
+// Begins a passkey registration or login by posting to the begin endpoint
+// with the CSRF token from the page at pagePath. Returns the CSRF token and
+// the options.
+func beginPasskeyCeremony(t *testing.T, ts *testServer, pagePath, beginPath string) (string, string) {
+	t.Helper()
+
+	_, _, body := ts.get(t, pagePath)
+	csrfToken := extractCSRFToken(t, body)
+
+	code, header, options := ts.post(t, beginPath, url.Values{"csrf_token": {csrfToken}})
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, header.Get("Content-Type"), "application/json")
+
+	return csrfToken, options
+}
+
+func TestPasskeyRegistration(t *testing.T) {
+	app := newTestApplication(t)
+	passkeys := app.passkeys.(*mocks.PasskeyModel)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	ts.login(t, "testuser@mail.com", "pa$$word")
+
+	authenticator := newSoftAuthenticator(t, app.baseURL)
+
+	t.Run("Options", func(t *testing.T) {
+		_, options := beginPasskeyCeremony(t, ts, "/account/passkeys", "/account/passkeys/register/begin")
+
+		var creation protocol.CredentialCreation
+		err := json.Unmarshal([]byte(options), &creation)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, creation.Response.RelyingParty.ID, "snippetbox.test")
+		assert.Equal(t, creation.Response.User.Name, "testuser@mail.com")
+		assert.Equal(t, creation.Response.AuthenticatorSelection.ResidentKey, protocol.ResidentKeyRequirementRequired)
+		assert.Equal(t, creation.Response.AuthenticatorSelection.UserVerification, protocol.VerificationRequired)
+	})
+
+	tests := []struct {
+		name      string
+		begin     bool // false if the registration's session data is gone
+		formName  string
+		tamper    bool
+		wantCode  int
+		wantError string
+	}{
+		{"Blank name", true, "", false, http.StatusUnprocessableEntity, "This field can&#39;t be blank."},
+		{"Not begun", false, "Laptop", false, http.StatusUnprocessableEntity, "Your passkey registration has expired. Please try again."},
+		{"Wrong challenge", true, "Laptop", true, http.StatusUnprocessableEntity, "Your passkey couldn&#39;t be registered. Please try again."},
+		{"Valid", true, "Laptop", false, http.StatusSeeOther, ""},
+	}
+
+	for _, sub := range tests {
+		t.Run(sub.name, func(t *testing.T) {
+			csrfToken, options := beginPasskeyCeremony(t, ts, "/account/passkeys", "/account/passkeys/register/begin")
+			if !sub.begin {
+				// The registration is used up by an earlier attempt.
+				ts.post(t, "/account/passkeys/register", url.Values{"csrf_token": {csrfToken}})
+			}
+			if sub.tamper {
+				// The credential is created for the challenge of an earlier
+				// registration.
+				beginPasskeyCeremony(t, ts, "/account/passkeys", "/account/passkeys/register/begin")
+			}
+
+			form := url.Values{}
+			form.Add("csrf_token", csrfToken)
+			form.Add("name", sub.formName)
+			form.Add("credential", authenticator.create(t, options))
+
+			code, header, body := ts.post(t, "/account/passkeys/register", form)
+			assert.Equal(t, code, sub.wantCode)
+
+			if sub.wantError != "" {
+				assert.StringContains(t, body, sub.wantError)
+				return
+			}
+			assert.Equal(t, header.Get("Location"), "/account/passkeys")
+
+			_, _, body = ts.get(t, "/account/passkeys")
+			assert.StringContains(t, body, "Passkey added.")
+			assert.StringContains(t, body, "<td>Laptop</td>")
+
+			stored, err := passkeys.ForUser(1)
+			assert.IsNil(t, err)
+			assert.Equal(t, len(stored), 1)
+			assert.Equal(t, string(stored[0].ID), string(authenticator.credentialID))
+			assert.Equal(t, string(stored[0].PublicKey), string(authenticator.publicKey(t)))
+			assert.Equal(t, string(authenticator.userHandle), "1")
+		})
+	}
+
+	t.Run("Registered passkeys are excluded", func(t *testing.T) {
+		_, options := beginPasskeyCeremony(t, ts, "/account/passkeys", "/account/passkeys/register/begin")
+
+		var creation protocol.CredentialCreation
+		err := json.Unmarshal([]byte(options), &creation)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, len(creation.Response.CredentialExcludeList), 1)
+		assert.Equal(t, string(creation.Response.CredentialExcludeList[0].CredentialID), string(authenticator.credentialID))
+	})
+
+	t.Run("Audit log", func(t *testing.T) {
+		_, _, body := ts.get(t, "/account/view")
+		assert.StringContains(t, body, models.AuditPasskeyAdded+" (Laptop)")
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		_, _, body := ts.get(t, "/account/passkeys")
+		id := base64.RawURLEncoding.EncodeToString(authenticator.credentialID)
+		assert.StringContains(t, body, `<input type="hidden" name="id" value="`+id+`" />`)
+
+		other := newTestServer(t, app.routes())
+		defer other.Close()
+		other.login(t, "otheruser@mail.com", "pa$$word")
+		_, _, otherBody := other.get(t, "/account/passkeys")
+
+		// Users can't delete other users' passkeys.
+		code, _, _ := other.post(t, "/account/passkeys/delete", url.Values{
+			"csrf_token": {extractCSRFToken(t, otherBody)},
+			"id":         {id},
+		})
+		assert.Equal(t, code, http.StatusNotFound)
+
+		code, header, _ := ts.post(t, "/account/passkeys/delete", url.Values{
+			"csrf_token": {extractCSRFToken(t, body)},
+			"id":         {id},
+			"name":       {"Forged"},
+		})
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/account/passkeys")
+
+		_, _, body = ts.get(t, "/account/passkeys")
+		assert.StringContains(t, body, "Passkey removed.")
+		assert.StringContains(t, body, "You haven't added any passkeys yet.")
+
+		// The audit log records the stored name, not the one in the form.
+		_, _, body = ts.get(t, "/account/view")
+		assert.StringContains(t, body, models.AuditPasskeyRemoved+" (Laptop)")
+		if strings.Contains(body, "Forged") {
+			t.Errorf("audit log contains the name from the form")
+		}
+	})
+}
+
+func TestPasskeyLogin(t *testing.T) {
+	app := newTestApplication(t)
+	audit := app.audit.(*mocks.AuditModel)
+
+	// Registers a new authenticator for the user with the given ID.
+	register := func(t *testing.T, userID int) *softAuthenticator {
+		a := newSoftAuthenticator(t, app.baseURL)
+		err := app.passkeys.Insert(a.passkey(t, userID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return a
+	}
+
+	t.Run("Login page", func(t *testing.T) {
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		_, _, body := ts.get(t, "/user/login")
+		assert.StringContains(t, body, `data-begin="/user/login/passkey/begin"`)
+	})
+
+	t.Run("Options", func(t *testing.T) {
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		_, options := beginPasskeyCeremony(t, ts, "/user/login", "/user/login/passkey/begin")
+
+		var assertion protocol.CredentialAssertion
+		err := json.Unmarshal([]byte(options), &assertion)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, assertion.Response.RelyingPartyID, "snippetbox.test")
+		assert.Equal(t, assertion.Response.UserVerification, protocol.VerificationRequired)
+		assert.Equal(t, len(assertion.Response.AllowedCredentials), 0)
+	})
+
+	tests := []struct {
+		name         string
+		userID       int
+		setup        func(a *softAuthenticator)
+		wantLocation string
+		wantFlash    string
+		wantActions  []string
+	}{
+		{
+			name:         "Valid",
+			userID:       1,
+			wantLocation: "/snippet/create",
+			wantActions:  []string{models.AuditLogin},
+		},
+		{
+			// A passkey is already a second factor, so users with two-factor
+			// authentication enabled aren't asked for a code.
+			name:         "Two-factor user",
+			userID:       2,
+			wantLocation: "/snippet/create",
+			wantActions:  []string{models.AuditLogin},
+		},
+		{
+			name:         "Disabled user",
+			userID:       6,
+			wantLocation: "/user/login",
+			wantFlash:    "Your account has been disabled.",
+			wantActions:  []string{models.AuditLoginFailed},
+		},
+		{
+			name:   "Cloned authenticator",
+			userID: 1,
+			setup: func(a *softAuthenticator) {
+				// A copy of the authenticator has been used since this one was.
+				app.passkeys.RecordUse(a.credentialID, 5, false)
+			},
+			wantLocation: "/user/login",
+			wantFlash:    "Login with your passkey failed, please try again.",
+			wantActions:  []string{models.AuditLoginFailed},
+		},
+		{
+			name:   "Passkey of another user",
+			userID: 1,
+			setup: func(a *softAuthenticator) {
+				a.userHandle = passkeyUserHandle(3)
+			},
+			wantLocation: "/user/login",
+			wantFlash:    "Login with your passkey failed, please try again.",
+			wantActions:  []string{models.AuditLoginFailed},
+		},
+		{
+			name:   "Unknown user",
+			userID: 1,
+			setup: func(a *softAuthenticator) {
+				a.userHandle = []byte("not a user")
+			},
+			wantLocation: "/user/login",
+			wantFlash:    "Login with your passkey failed, please try again.",
+			wantActions:  []string{models.AuditLoginFailed},
+		},
+	}
+
+	for _, sub := range tests {
+		t.Run(sub.name, func(t *testing.T) {
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			a := register(t, sub.userID)
+			if sub.setup != nil {
+				sub.setup(a)
+			}
+
+			csrfToken, options := beginPasskeyCeremony(t, ts, "/user/login", "/user/login/passkey/begin")
+
+			before := len(audit.Actions())
+
+			code, header, _ := ts.post(t, "/user/login/passkey", url.Values{
+				"csrf_token": {csrfToken},
+				"credential": {a.get(t, options)},
+			})
+			assert.Equal(t, code, http.StatusSeeOther)
+			assert.Equal(t, header.Get("Location"), sub.wantLocation)
+
+			if sub.wantFlash != "" {
+				_, _, body := ts.get(t, "/about")
+				assert.StringContains(t, body, sub.wantFlash)
+			}
+
+			actions := audit.Actions()[before:]
+			assert.Equal(t, strings.Join(actions, ","), strings.Join(sub.wantActions, ","))
+		})
+	}
+
+	t.Run("Sign count and last use are recorded", func(t *testing.T) {
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		a := register(t, 3)
+		csrfToken, options := beginPasskeyCeremony(t, ts, "/user/login", "/user/login/passkey/begin")
+		code, _, _ := ts.post(t, "/user/login/passkey", url.Values{
+			"csrf_token": {csrfToken},
+			"credential": {a.get(t, options)},
+		})
+		assert.Equal(t, code, http.StatusSeeOther)
+
+		stored, err := app.passkeys.ForUser(3)
+		assert.IsNil(t, err)
+		assert.Equal(t, stored[0].SignCount, a.signCount)
+		assert.Equal(t, stored[0].LastUsed.IsZero(), false)
+
+		code, _, _ = ts.get(t, "/account/view")
+		assert.Equal(t, code, http.StatusOK)
+	})
+
+	t.Run("Replayed assertion", func(t *testing.T) {
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		a := register(t, 1)
+		csrfToken, options := beginPasskeyCeremony(t, ts, "/user/login", "/user/login/passkey/begin")
+		credential := a.get(t, options)
+		form := url.Values{"csrf_token": {csrfToken}, "credential": {credential}}
+
+		code, header, _ := ts.post(t, "/user/login/passkey", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/snippet/create")
+
+		// The assertion signs the challenge of the first login, so it can't
+		// be used for another one.
+		other := newTestServer(t, app.routes())
+		defer other.Close()
+		csrfToken, _ = beginPasskeyCeremony(t, other, "/user/login", "/user/login/passkey/begin")
+		form.Set("csrf_token", csrfToken)
+
+		code, header, _ = other.post(t, "/user/login/passkey", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/user/login")
+
+		_, _, body := other.get(t, "/about")
+		assert.StringContains(t, body, "Login with your passkey failed, please try again.")
+	})
+
+	t.Run("Not begun", func(t *testing.T) {
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		_, _, body := ts.get(t, "/user/login")
+		code, header, _ := ts.post(t, "/user/login/passkey", url.Values{
+			"csrf_token": {extractCSRFToken(t, body)},
+			"credential": {"{}"},
+		})
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/user/login")
+
+		_, _, body = ts.get(t, "/about")
+		assert.StringContains(t, body, "Your login has expired, please try again.")
+	})
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/julienschmidt/httprouter"
 	"github.com/justinas/alice"
 
+	"github.com/kvnloughead/snippetbox/internal/models"
 	"github.com/kvnloughead/snippetbox/ui"
 )
 
@@ -14,24 +15,95 @@ Returns a servemux that serves files from ./ui/static and contains the following
 
 Static unprotected routes
   - GET  /static/*filepath    serve a static file
+  - GET  /avatars/:file       serve an avatar image
 
 Dynamic unprotected routes:
   - GET  /														display the home page
   - GET  /about												display the about page
+  - GET  /trending										display the most viewed snippets of the last day
   - GET  /ping 							  				responses with 200 OK
   - GET  /snippet/view/:id    				display a specific snippet
+  - GET  /snippet/raw/:id/:name				display one of a snippet's files as plain text
+  - GET  /snippet/history/:id					list a snippet's revisions, and diff two of them
+  - GET  /download										download snippets by ID, tag or owner as an archive
+  - GET  /tags												list all tags
+  - GET  /tags/:name									list snippets with a specific tag
+  - GET  /u/:handle										display a user's profile, with a page of their public snippets
   - GET  /user/signup									display the signup form
   - POST /user/signup									create a new user
   - GET  /user/login									display the login form
   - POST /user/login									authenticate and login a user
+  - GET  /user/login/totp							display form to enter second factor
+  - POST /user/login/totp							complete login with second factor
+  - GET  /user/login/oidc/:provider		log in with an OpenID Connect provider
+  - GET  /user/login/oidc/:provider/callback	complete login with an OpenID Connect provider
+  - POST /user/login/passkey/begin		begin logging in with a passkey
+  - POST /user/login/passkey					complete login with a passkey
+  - GET  /user/password/forgot				display form to request a password reset
+  - POST /user/password/forgot				email a password reset link
+  - GET  /user/password/reset/:token	display form to choose a new password
+  - POST /user/password/reset/:token	reset password
+  - GET  /user/email/confirm/:token		display form to confirm a new email address
+  - POST /user/email/confirm/:token		change email to the confirmed address
 
 Protected routes (only available to authenticated users):
   - POST /user/logout         				logout the user
   - GET  /snippet/create   				    display form to create snippets
   - POST /snippet/create      				create a new snippet
+  - GET  /snippet/edit/:id            display form to edit one of the user's snippets
+  - POST /snippet/edit/:id            edit one of the user's snippets
+  - POST /snippet/restore/:id         restore an earlier revision of one of the user's snippets
+  - POST /snippet/star/:id            star or unstar a snippet
+  - POST /snippet/comment/:id         comment on a snippet
+  - POST /snippet/comments/:id        disable or enable comments on one of the user's snippets
+  - GET  /comment/edit/:id            display form to edit one of the user's comments
+  - POST /comment/edit/:id            edit one of the user's comments
+  - POST /comment/delete/:id          delete a comment by the user, or on one of their snippets
+  - GET  /snippet/import              display form to import snippets from a file
+  - POST /snippet/import              import snippets from a JSON, gist or zip file
+  - GET  /teams                       list the user's teams, with a form to create one
+  - POST /team/create                 create a team
+  - POST /team/switch                 switch to one of the user's teams, or to no team
+  - GET  /team/view/:id               display a team's snippets and members
+  - POST /team/invite/:id             create an invitation link to a team
+  - POST /team/role/:id               change the role of a team's member
+  - POST /team/remove/:id             remove a member from a team, or leave it
+  - POST /team/delete/:id             delete a team and its snippets
+  - GET  /team/join/:token            display the team an invitation link is for
+  - POST /team/join/:token            join a team with an invitation link
   - GET  /account/view        				view current user's account info
+  - GET  /account/edit                display form to edit name, handle, profile visibility and email
+  - POST /account/edit                update name, handle and profile visibility, and email once it's confirmed
+  - POST /account/avatar              upload an avatar
+  - POST /account/avatar/delete       remove the user's avatar
+  - GET  /account/starred             list the snippets the user has starred
   - GET  /account/password/update     view form to change password
   - POST /account/password/update     change password
+  - GET  /account/totp/setup          display form to enable two-factor auth
+  - POST /account/totp/setup          enable two-factor auth
+  - GET  /account/totp/qrcode         QR code of the TOTP secret being enrolled
+  - GET  /account/totp/disable        display form to disable two-factor auth
+  - POST /account/totp/disable        disable two-factor auth
+  - GET  /account/passkeys            view current user's passkeys, with a form to add one
+  - POST /account/passkeys/register/begin  begin registering a passkey
+  - POST /account/passkeys/register   complete registering a passkey
+  - POST /account/passkeys/delete     delete one of the user's passkeys
+  - GET  /account/sessions            view current user's active sessions
+  - POST /account/sessions/revoke     log out one of the user's sessions
+  - POST /account/sessions/revoke-all log out all of the user's sessions
+  - GET  /account/delete              display form to delete account
+  - POST /account/delete              delete account
+  - GET  /account/export              download user's data as JSON or zip
+
+Moderator routes (only available to moderators and admins):
+  - POST /admin/snippet/delete/:id    delete any snippet
+
+Admin routes (only available to admins):
+  - GET  /admin                       list all users
+  - POST /admin/users/disable/:id     disable or re-enable a user's account
+  - POST /admin/users/role/:id        change a user's role
+  - GET  /admin/audit                 view the audit log, filtered by action, actor or target
+  - GET  /admin/audit/export          download the filtered audit log as CSV
 */
 func (app *application) routes() http.Handler {
 	router := httprouter.New()
@@ -51,6 +123,7 @@ func (app *application) routes() http.Handler {
 	)
 
 	router.HandlerFunc(http.MethodGet, "/ping", ping)
+	router.HandlerFunc(http.MethodGet, "/avatars/:file", app.avatarView)
 
 	// Middleware chain for dynamic routes only (not static files).
 	dynamic := alice.New(app.sessionManager.LoadAndSave, noSurf, app.authenticate)
@@ -60,11 +133,30 @@ func (app *application) routes() http.Handler {
 	// router.HandlerFunc.
 	router.Handler(http.MethodGet, "/", dynamic.ThenFunc(app.home))
 	router.Handler(http.MethodGet, "/about", dynamic.ThenFunc(app.about))
+	router.Handler(http.MethodGet, "/trending", dynamic.ThenFunc(app.trending))
 	router.Handler(http.MethodGet, "/snippet/view/:id", dynamic.ThenFunc(app.snippetView))
+	router.Handler(http.MethodGet, "/snippet/raw/:id/:name", dynamic.ThenFunc(app.snippetRaw))
+	router.Handler(http.MethodGet, "/snippet/history/:id", dynamic.ThenFunc(app.snippetHistory))
+	router.Handler(http.MethodGet, "/download", dynamic.ThenFunc(app.snippetDownload))
+	router.Handler(http.MethodGet, "/tags", dynamic.ThenFunc(app.tagList))
+	router.Handler(http.MethodGet, "/tags/:name", dynamic.ThenFunc(app.tagView))
+	router.Handler(http.MethodGet, "/u/:handle", dynamic.ThenFunc(app.userProfile))
 	router.Handler(http.MethodGet, "/user/signup", dynamic.ThenFunc(app.userSignup))
 	router.Handler(http.MethodPost, "/user/signup", dynamic.ThenFunc(app.userSignupPost))
 	router.Handler(http.MethodGet, "/user/login", dynamic.ThenFunc(app.userLogin))
 	router.Handler(http.MethodPost, "/user/login", dynamic.ThenFunc(app.userLoginPost))
+	router.Handler(http.MethodGet, "/user/login/totp", dynamic.ThenFunc(app.userLoginTOTP))
+	router.Handler(http.MethodPost, "/user/login/totp", dynamic.ThenFunc(app.userLoginTOTPPost))
+	router.Handler(http.MethodGet, "/user/login/oidc/:provider", dynamic.ThenFunc(app.userLoginOIDC))
+	router.Handler(http.MethodGet, "/user/login/oidc/:provider/callback", dynamic.ThenFunc(app.userLoginOIDCCallback))
+	router.Handler(http.MethodPost, "/user/login/passkey/begin", dynamic.ThenFunc(app.userLoginPasskeyBegin))
+	router.Handler(http.MethodPost, "/user/login/passkey", dynamic.ThenFunc(app.userLoginPasskeyPost))
+	router.Handler(http.MethodGet, "/user/password/forgot", dynamic.ThenFunc(app.userPasswordForgot))
+	router.Handler(http.MethodPost, "/user/password/forgot", dynamic.ThenFunc(app.userPasswordForgotPost))
+	router.Handler(http.MethodGet, "/user/password/reset/:token", dynamic.ThenFunc(app.userPasswordReset))
+	router.Handler(http.MethodPost, "/user/password/reset/:token", dynamic.ThenFunc(app.userPasswordResetPost))
+	router.Handler(http.MethodGet, "/user/email/confirm/:token", dynamic.ThenFunc(app.userEmailConfirm))
+	router.Handler(http.MethodPost, "/user/email/confirm/:token", dynamic.ThenFunc(app.userEmailConfirmPost))
 
 	// Middleware chain for protected routes. Includes all middleware from dynamic
 	// chain, as well as app.requireAuthentication.
@@ -73,9 +165,68 @@ func (app *application) routes() http.Handler {
 	router.Handler(http.MethodPost, "/user/logout", protected.ThenFunc(app.userLogoutPost))
 	router.Handler(http.MethodGet, "/snippet/create", protected.ThenFunc(app.snippetCreate))
 	router.Handler(http.MethodPost, "/snippet/create", protected.ThenFunc(app.snippetCreatePost))
+	router.Handler(http.MethodGet, "/snippet/edit/:id", protected.ThenFunc(app.snippetEdit))
+	router.Handler(http.MethodPost, "/snippet/edit/:id", protected.ThenFunc(app.snippetEditPost))
+	router.Handler(http.MethodPost, "/snippet/restore/:id", protected.ThenFunc(app.snippetRestorePost))
+	router.Handler(http.MethodPost, "/snippet/star/:id", protected.ThenFunc(app.snippetStarPost))
+	router.Handler(http.MethodPost, "/snippet/comment/:id", protected.ThenFunc(app.snippetCommentPost))
+	router.Handler(http.MethodPost, "/snippet/comments/:id", protected.ThenFunc(app.snippetCommentsPost))
+	router.Handler(http.MethodGet, "/comment/edit/:id", protected.ThenFunc(app.commentEdit))
+	router.Handler(http.MethodPost, "/comment/edit/:id", protected.ThenFunc(app.commentEditPost))
+	router.Handler(http.MethodPost, "/comment/delete/:id", protected.ThenFunc(app.commentDeletePost))
+	// Middleware chain for routes that accept file uploads. The body limit and
+	// the longer deadlines have to come before noSurf, which reads the form.
+	upload := alice.New(app.sessionManager.LoadAndSave, limitRequestBody(maxImportSize+1<<20), app.extendDeadlines(uploadTimeout), noSurf, app.authenticate, app.requireAuthentication)
+	avatarUpload := alice.New(app.sessionManager.LoadAndSave, limitRequestBody(maxAvatarSize+1<<20), app.extendDeadlines(uploadTimeout), noSurf, app.authenticate, app.requireAuthentication)
+
+	router.Handler(http.MethodGet, "/snippet/import", protected.ThenFunc(app.snippetImport))
+	router.Handler(http.MethodPost, "/snippet/import", upload.ThenFunc(app.snippetImportPost))
+	router.Handler(http.MethodPost, "/account/avatar", avatarUpload.ThenFunc(app.accountAvatarPost))
+	router.Handler(http.MethodPost, "/account/avatar/delete", protected.ThenFunc(app.accountAvatarDeletePost))
+	router.Handler(http.MethodGet, "/teams", protected.ThenFunc(app.teamList))
+	router.Handler(http.MethodPost, "/team/create", protected.ThenFunc(app.teamCreatePost))
+	router.Handler(http.MethodPost, "/team/switch", protected.ThenFunc(app.teamSwitchPost))
+	router.Handler(http.MethodGet, "/team/view/:id", protected.ThenFunc(app.teamView))
+	router.Handler(http.MethodPost, "/team/invite/:id", protected.ThenFunc(app.teamInvitePost))
+	router.Handler(http.MethodPost, "/team/role/:id", protected.ThenFunc(app.teamMemberRolePost))
+	router.Handler(http.MethodPost, "/team/remove/:id", protected.ThenFunc(app.teamMemberRemovePost))
+	router.Handler(http.MethodPost, "/team/delete/:id", protected.ThenFunc(app.teamDeletePost))
+	router.Handler(http.MethodGet, "/team/join/:token", protected.ThenFunc(app.teamJoin))
+	router.Handler(http.MethodPost, "/team/join/:token", protected.ThenFunc(app.teamJoinPost))
 	router.Handler(http.MethodGet, "/account/view", protected.ThenFunc(app.accountView))
+	router.Handler(http.MethodGet, "/account/edit", protected.ThenFunc(app.accountEdit))
+	router.Handler(http.MethodPost, "/account/edit", protected.ThenFunc(app.accountEditPost))
+	router.Handler(http.MethodGet, "/account/starred", protected.ThenFunc(app.accountStarred))
 	router.Handler(http.MethodGet, "/account/password/update", protected.ThenFunc(app.accountPasswordUpdate))
 	router.Handler(http.MethodPost, "/account/password/update", protected.ThenFunc(app.accountPasswordUpdatePost))
+	router.Handler(http.MethodGet, "/account/totp/setup", protected.ThenFunc(app.accountTOTPSetup))
+	router.Handler(http.MethodPost, "/account/totp/setup", protected.ThenFunc(app.accountTOTPSetupPost))
+	router.Handler(http.MethodGet, "/account/totp/qrcode", protected.ThenFunc(app.accountTOTPQRCode))
+	router.Handler(http.MethodGet, "/account/totp/disable", protected.ThenFunc(app.accountTOTPDisable))
+	router.Handler(http.MethodPost, "/account/totp/disable", protected.ThenFunc(app.accountTOTPDisablePost))
+	router.Handler(http.MethodGet, "/account/passkeys", protected.ThenFunc(app.accountPasskeys))
+	router.Handler(http.MethodPost, "/account/passkeys/register/begin", protected.ThenFunc(app.accountPasskeyRegisterBegin))
+	router.Handler(http.MethodPost, "/account/passkeys/register", protected.ThenFunc(app.accountPasskeyRegisterPost))
+	router.Handler(http.MethodPost, "/account/passkeys/delete", protected.ThenFunc(app.accountPasskeyDeletePost))
+	router.Handler(http.MethodGet, "/account/sessions", protected.ThenFunc(app.accountSessions))
+	router.Handler(http.MethodPost, "/account/sessions/revoke", protected.ThenFunc(app.accountSessionRevokePost))
+	router.Handler(http.MethodPost, "/account/sessions/revoke-all", protected.ThenFunc(app.accountSessionRevokeAllPost))
+	router.Handler(http.MethodGet, "/account/delete", protected.ThenFunc(app.accountDelete))
+	router.Handler(http.MethodPost, "/account/delete", protected.ThenFunc(app.accountDeletePost))
+	router.Handler(http.MethodGet, "/account/export", protected.ThenFunc(app.accountExport))
+
+	// Middleware chains for routes that require a role. They include all
+	// middleware from the protected chain, so that anonymous users are asked to
+	// log in, rather than being forbidden.
+	moderator := protected.Append(app.requireRole(models.RoleModerator, models.RoleAdmin))
+	admin := protected.Append(app.requireRole(models.RoleAdmin))
+
+	router.Handler(http.MethodPost, "/admin/snippet/delete/:id", moderator.ThenFunc(app.adminSnippetDeletePost))
+	router.Handler(http.MethodGet, "/admin", admin.ThenFunc(app.adminUsers))
+	router.Handler(http.MethodPost, "/admin/users/disable/:id", admin.ThenFunc(app.adminUserDisablePost))
+	router.Handler(http.MethodPost, "/admin/users/role/:id", admin.ThenFunc(app.adminUserRolePost))
+	router.Handler(http.MethodGet, "/admin/audit", admin.ThenFunc(app.adminAudit))
+	router.Handler(http.MethodGet, "/admin/audit/export", admin.ThenFunc(app.adminAuditExport))
 
 	// Initialize chain of standard pre-request middlewares.
 	standard := alice.New(app.recoverPanic, app.logRequest, secureHeaders)
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// How long in-flight requests have to complete when the server shuts down.
+const shutdownTimeout = 30 * time.Second
+
+/*
+Runs the HTTPS server until it receives a SIGINT or SIGTERM signal, and then
+shuts it down gracefully. In-flight requests are given shutdownTimeout to
+complete, background tasks, such as sending emails, are waited for, and
+buffered views are written to the DB.
+
+The error that stopped the server, or that occurred while shutting it down, is
+returned.
+*/
+func (app *application) serve(srv *http.Server, certFile, keyFile string) error {
+	// Write buffered views to the DB periodically, and once more after the
+	// server has stopped.
+	done := make(chan struct{})
+	flushed := make(chan struct{})
+	go func() {
+		defer close(flushed)
+		app.views.Run(viewFlushInterval, done, func(err error) {
+			app.logger.Error("flushing view counts", "error", err.Error())
+		})
+	}()
+
+	shutdownErr := make(chan error)
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		s := <-quit
+
+		app.logger.Info("shutting down server", "signal", s.String())
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		shutdownErr <- srv.Shutdown(ctx)
+	}()
+
+	err := srv.ListenAndServeTLS(certFile, keyFile)
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	err = <-shutdownErr
+
+	// Even if some requests didn't complete in time, the work that the others
+	// started is finished.
+	app.wg.Wait()
+	close(done)
+	<-flushed
+
+	app.logger.Info("stopped server")
+	return err
+}
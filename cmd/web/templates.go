@@ -1,11 +1,14 @@
 package main
 
 import (
+	"encoding/base64"
+	"fmt"
 	"html/template"
 	"io/fs"
 	"path/filepath"
 	"time"
 
+	"github.com/kvnloughead/snippetbox/internal/diff"
 	"github.com/kvnloughead/snippetbox/internal/models"
 	"github.com/kvnloughead/snippetbox/ui"
 )
@@ -21,23 +24,81 @@ func humanDate(t time.Time) string {
 	return t.UTC().Format("02 Jan 2006 at 15:04")
 }
 
+// Returns the CSS class of a line in a diff.
+func diffClass(kind diff.Kind) string {
+	switch kind {
+	case diff.Delete:
+		return "delete"
+	case diff.Insert:
+		return "insert"
+	default:
+		return "equal"
+	}
+}
+
+// Returns the URL of the user's avatar at the given size, which must be one of
+// avatar.Sizes, or "" if the user has no avatar.
+func avatarURL(user models.User, size int) string {
+	if user.Avatar == "" {
+		return ""
+	}
+	return fmt.Sprintf("/avatars/%s-%d.png", user.Avatar, size)
+}
+
 // template.FuncMap struct provides a string keyed map of template functions.
 // Must be registered with the template before calling ParseFiles.
 var functions = template.FuncMap{
-	"humanDate": humanDate,
+	"humanDate":    humanDate,
+	"diffClass":    diffClass,
+	"languages":    func() []string { return languages },
+	"auditActions": func() []string { return models.AuditActions },
+	"base64url":    base64.RawURLEncoding.EncodeToString,
+	"avatarURL":    avatarURL,
 }
 
 // Go templates only allow a single data argument, so we create a struct to
 // store all necessary template data.
 type templateData struct {
-	CurrentYear     int
-	Snippet         models.Snippet
-	Snippets        []models.Snippet
-	Form            any
-	Flash           string
-	IsAuthenticated bool
-	CSRFToken       string
-	User            models.User
+	CurrentYear      int
+	Snippet          models.Snippet
+	Snippets         []models.Snippet
+	Pagination       pagination // the pages before and after the current page of Snippets
+	MostStarred      []models.Snippet
+	Markdown         []template.HTML // the rendered files of a Markdown snippet
+	Revisions        []models.SnippetRevision
+	Comment          models.Comment
+	Comments         []models.Comment
+	Diff             *revisionDiff
+	IsOwner          bool
+	Starred          bool // true if the logged in user has starred Snippet
+	Tag              string
+	Tags             []models.Tag
+	ImportErrors     []importError
+	Team             models.Team   // the team being viewed, with the user's role
+	Teams            []models.Team // the logged in user's teams, for the team switcher
+	CurrentTeamID    int           // the ID of the user's current team, or 0
+	TeamMembers      []models.TeamMember
+	Invitation       models.TeamInvitation
+	InvitationURL    string // the link to a newly created invitation
+	Form             any
+	Flash            string
+	IsAuthenticated  bool
+	UserID           int    // the ID of the logged in user, or 0
+	UserRole         string // the role of the logged in user, or empty
+	CSRFToken        string
+	OIDCProviders    []*oidcProvider // providers the user can log in with
+	Identities       []models.Identity
+	Passkeys         []models.Passkey
+	User             models.User
+	Token            string // the plaintext of a token from the URL, such as an email confirmation link's
+	TOTPEnabled      bool
+	TOTPSecret       string
+	RecoveryCodes    []string
+	Sessions         []models.Session
+	CurrentSessionID string
+	Users            []models.User
+	AuditEvents      []models.AuditEvent
+	AuditFilter      models.AuditFilter
 }
 
 func newTemplateCache() (map[string]*template.Template, error) {
@@ -5,17 +5,22 @@ import (
 	"html"
 	"io"
 	"log/slog"
+	"mime/multipart"
 	"net/http"
 	"net/http/cookiejar"
 	"net/http/httptest"
 	"net/url"
 	"regexp"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-playground/form/v4"
+	"github.com/kvnloughead/snippetbox/internal/blob"
 	"github.com/kvnloughead/snippetbox/internal/models/mocks"
+	"github.com/kvnloughead/snippetbox/internal/validator"
+	"github.com/kvnloughead/snippetbox/internal/viewcount"
 )
 
 // Regex to capture CSRF from input on signup page.
@@ -47,16 +52,67 @@ func newTestApplication(t *testing.T) *application {
 	sessionManager.Lifetime = 12 * time.Hour
 	sessionManager.Cookie.Secure = true
 
+	baseURL := "https://snippetbox.test"
+
+	webAuthn, err := newWebAuthn(baseURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blobs, err := blob.NewDiskStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snippets := &mocks.SnippetModel{}
+
 	return &application{
 		logger:         slog.New(slog.NewTextHandler(io.Discard, nil)),
-		snippets:       &mocks.SnippetModel{},
+		snippets:       snippets,
+		comments:       &mocks.CommentModel{},
+		stars:          &mocks.StarModel{},
+		teams:          &mocks.TeamModel{},
 		users:          &mocks.UserModel{},
+		tokens:         &mocks.TokenModel{},
+		totp:           &mocks.TOTPModel{},
+		sessions:       &mocks.SessionModel{},
+		rememberTokens: &mocks.RememberTokenModel{},
+		identities:     &mocks.IdentityModel{},
+		passkeys:       &mocks.PasskeyModel{},
+		audit:          &mocks.AuditModel{},
+		views:          viewcount.New(snippets, viewDedupWindow),
+		webauthn:       webAuthn,
+		passwordPolicy: validator.DefaultPasswordPolicy,
+		blobs:          blobs,
 		templateCache:  templateCache,
 		formDecoder:    formDecoder,
 		sessionManager: sessionManager,
+		mailer:         &mockMailer{},
+		baseURL:        baseURL,
 	}
 }
 
+// An email recorded by mockMailer.
+type mockEmail struct {
+	recipient    string
+	templateFile string
+	data         any
+}
+
+// A mailer.Mailer that records emails instead of sending them. Emails are sent
+// in background goroutines, so call app.wg.Wait() before inspecting them.
+type mockMailer struct {
+	mu   sync.Mutex
+	sent []mockEmail
+}
+
+func (m *mockMailer) Send(recipient, templateFile string, data any) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, mockEmail{recipient, templateFile, data})
+	return nil
+}
+
 // Custom server struct for testing.
 type testServer struct {
 	*httptest.Server
@@ -108,6 +164,45 @@ func (ts *testServer) post(t *testing.T, endpoint string, formVals url.Values) (
 	return response.StatusCode, response.Header, body
 }
 
+// Sends a multipart POST request to the given endpoint, with the given form
+// values and a file in the named field. Returns the status code, headers and
+// body of the response.
+func (ts *testServer) postFile(t *testing.T, endpoint string, formVals url.Values, field, filename string, content []byte) (int, http.Header, string) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for key, values := range formVals {
+		for _, v := range values {
+			err := mw.WriteField(key, v)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if field != "" {
+		fw, err := mw.CreateFormFile(field, filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = fw.Write(content)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := mw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := ts.Client().Post(ts.URL+endpoint, mw.FormDataContentType(), &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := readBodyAsString(t, response)
+
+	return response.StatusCode, response.Header, body
+}
+
 // Reads the body of an HTTP response and returns it as a string
 // whitespace-trimmed string. Closes the response's body when returning.
 //
@@ -121,3 +216,48 @@ func readBodyAsString(t *testing.T, r *http.Response) string {
 	}
 	return string(bytes.TrimSpace(body))
 }
+
+// Logs in with the given credentials, using the CSRF token from the login
+// page. Fails the test if the login isn't accepted. The session cookie is kept
+// in the test server's cookie jar, so subsequent requests are authenticated.
+func (ts *testServer) login(t *testing.T, email, password string) {
+	t.Helper()
+
+	_, _, body := ts.get(t, "/user/login")
+
+	form := url.Values{}
+	form.Add("email", email)
+	form.Add("password", password)
+	form.Add("csrf_token", extractCSRFToken(t, body))
+
+	code, _, _ := ts.post(t, "/user/login", form)
+	if code != http.StatusSeeOther {
+		t.Fatalf("login failed with status %d", code)
+	}
+}
+
+// Returns the value of the named cookie in the test server client's cookie
+// jar, or an empty string if there is no such cookie.
+func (ts *testServer) cookie(t *testing.T, name string) string {
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range ts.Client().Jar.Cookies(u) {
+		if c.Name == name {
+			return c.Value
+		}
+	}
+	return ""
+}
+
+// Adds a cookie to the test server client's cookie jar.
+func (ts *testServer) setCookie(t *testing.T, name, value string) {
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts.Client().Jar.SetCookies(u, []*http.Cookie{{Name: name, Value: value}})
+}
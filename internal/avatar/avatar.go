@@ -0,0 +1,139 @@
+/*
+Package avatar checks uploaded profile pictures, and resizes them to the square
+sizes they're shown at.
+
+Only PNG, JPEG and GIF images are accepted. Their dimensions are checked before
+they're decoded, so that a small file can't make the server decode a huge
+image.
+*/
+package avatar
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"slices"
+
+	// Registers the decoders for the accepted formats with image.Decode.
+	_ "image/gif"
+	_ "image/jpeg"
+)
+
+// The sizes, in pixels, of the square images that avatars are resized to.
+var Sizes = []int{32, 96, 256}
+
+// The largest width or height of an image that can be uploaded.
+const MaxDimension = 4096
+
+// Occurs when an image isn't a PNG, JPEG or GIF, or is corrupt.
+var ErrUnsupportedFormat = errors.New("avatar: unsupported image format")
+
+// Occurs when an image is wider or taller than MaxDimension.
+var ErrTooLarge = errors.New("avatar: image too large")
+
+// The formats that are accepted, as named by image.DecodeConfig.
+var formats = []string{"png", "jpeg", "gif"}
+
+// Decodes a PNG, JPEG or GIF image. If the image is in another format, or is
+// corrupt, an ErrUnsupportedFormat error is returned. If it's wider or taller
+// than MaxDimension, an ErrTooLarge error is returned.
+func Decode(data []byte) (image.Image, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || !slices.Contains(formats, format) {
+		return nil, ErrUnsupportedFormat
+	}
+
+	if config.Width > MaxDimension || config.Height > MaxDimension {
+		return nil, ErrTooLarge
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedFormat
+	}
+
+	return img, nil
+}
+
+/*
+Returns a size by size copy of the largest square in the centre of the image.
+
+Each pixel of the copy is the average of the pixels of the square that it
+covers, which keeps detail when shrinking. When enlarging, each pixel of the
+copy covers part of one pixel, which is repeated.
+*/
+func Resize(src image.Image, size int) *image.RGBA {
+	bounds := src.Bounds()
+	side := min(bounds.Dx(), bounds.Dy())
+	x0 := bounds.Min.X + (bounds.Dx()-side)/2
+	y0 := bounds.Min.Y + (bounds.Dy()-side)/2
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+
+	for y := 0; y < size; y++ {
+		sy0, sy1 := span(y0, y, side, size)
+		for x := 0; x < size; x++ {
+			sx0, sx1 := span(x0, x, side, size)
+
+			var r, g, b, a, n uint64
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					// RGBA returns alpha-premultiplied values, so averaging
+					// them doesn't bleed the colour of transparent pixels.
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+
+			dst.SetRGBA64(x, y, color.RGBA64{
+				R: uint16(r / n),
+				G: uint16(g / n),
+				B: uint16(b / n),
+				A: uint16(a / n),
+			})
+		}
+	}
+
+	return dst
+}
+
+// Returns the range of source pixels, starting from start, that destination
+// pixel i covers, when side source pixels are scaled to size destination
+// pixels. The range always contains at least one pixel.
+func span(start, i, side, size int) (from, to int) {
+	from = start + i*side/size
+	to = start + (i+1)*side/size
+	return from, max(to, from+1)
+}
+
+// Decodes an uploaded image, and returns it resized to each of Sizes, encoded
+// as PNG and keyed by size. Errors are as for Decode.
+func Process(data []byte) (map[int][]byte, error) {
+	img, err := Decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each size is made from the next larger one, rather than the original,
+	// which is much faster for large uploads, and looks the same.
+	sizes := slices.Clone(Sizes)
+	slices.Sort(sizes)
+	slices.Reverse(sizes)
+
+	images := make(map[int][]byte, len(sizes))
+	for _, size := range sizes {
+		img = Resize(img, size)
+
+		var buf bytes.Buffer
+		err = png.Encode(&buf, img)
+		if err != nil {
+			return nil, err
+		}
+		images[size] = buf.Bytes()
+	}
+
+	return images, nil
+}
@@ -0,0 +1,93 @@
+package avatar
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	assert "github.com/kvnloughead/snippetbox/internal"
+)
+
+// Returns a w by h image, filled with c, encoded with the encoder.
+func testImage(t *testing.T, w, h int, c color.Color, encode func(*bytes.Buffer, image.Image) error) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, c)
+		}
+	}
+
+	var buf bytes.Buffer
+	err := encode(&buf, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func encodePNG(buf *bytes.Buffer, img image.Image) error {
+	return png.Encode(buf, img)
+}
+
+func encodeJPEG(buf *bytes.Buffer, img image.Image) error {
+	return jpeg.Encode(buf, img, nil)
+}
+
+func TestProcess(t *testing.T) {
+	red := color.RGBA{R: 255, A: 255}
+
+	for name, data := range map[string][]byte{
+		"PNG":       testImage(t, 300, 200, red, encodePNG),
+		"JPEG":      testImage(t, 40, 60, red, encodeJPEG),
+		"Tiny":      testImage(t, 1, 1, red, encodePNG),
+		"Landscape": testImage(t, 1000, 10, red, encodePNG),
+	} {
+		t.Run(name, func(t *testing.T) {
+			images, err := Process(data)
+			assert.IsNil(t, err)
+			assert.Equal(t, len(images), len(Sizes))
+
+			for _, size := range Sizes {
+				img, err := png.Decode(bytes.NewReader(images[size]))
+				assert.IsNil(t, err)
+				assert.Equal(t, img.Bounds(), image.Rect(0, 0, size, size))
+
+				r, _, _, a := img.At(size/2, size/2).RGBA()
+				assert.Equal(t, r>>8 > 250, true)
+				assert.Equal(t, a>>8, uint32(255))
+			}
+		})
+	}
+
+	_, err := Process([]byte("not an image"))
+	assert.Equal(t, errors.Is(err, ErrUnsupportedFormat), true)
+
+	_, err = Process(testImage(t, 300, 200, red, encodePNG)[:100])
+	assert.Equal(t, errors.Is(err, ErrUnsupportedFormat), true)
+
+	_, err = Process(testImage(t, MaxDimension+1, 1, red, encodePNG))
+	assert.Equal(t, errors.Is(err, ErrTooLarge), true)
+}
+
+func TestResize(t *testing.T) {
+	// A 4 by 2 image, with a black and a white pixel in each row of its
+	// central square, which averages to grey.
+	img := image.NewGray(image.Rect(0, 0, 4, 2))
+	img.Pix = []uint8{
+		0, 0, 255, 9,
+		9, 255, 0, 0,
+	}
+
+	got := Resize(img, 1)
+	assert.Equal(t, got.Bounds(), image.Rect(0, 0, 1, 1))
+	assert.Equal(t, got.RGBAAt(0, 0), color.RGBA{R: 127, G: 127, B: 127, A: 255})
+
+	// Enlarging repeats pixels.
+	got = Resize(img, 4)
+	assert.Equal(t, got.RGBAAt(0, 0), color.RGBA{A: 255})
+	assert.Equal(t, got.RGBAAt(3, 0), color.RGBA{R: 255, G: 255, B: 255, A: 255})
+}
@@ -0,0 +1,124 @@
+/*
+Package blob stores binary objects, such as uploaded images, by key.
+
+Keys are slash-separated paths, such as "avatars/1f2e3d-96.png". Each element
+of a key is made of letters, digits, dots, hyphens and underscores, and can't
+start with a dot, so keys can't escape the store or name hidden files.
+*/
+package blob
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// Occurs when no blob is stored under a key.
+var ErrNotFound = errors.New("blob: not found")
+
+// Occurs when a key isn't a valid path.
+var ErrInvalidKey = errors.New("blob: invalid key")
+
+var keyRX = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*(/[A-Za-z0-9_-][A-Za-z0-9._-]*)*$`)
+
+// Stores blobs by key. Implementations must be safe for concurrent use.
+type Store interface {
+	// Stores the contents of r under the key, replacing any blob already
+	// stored under it.
+	Put(key string, r io.Reader) error
+
+	// Returns the blob stored under the key, which must be closed after use.
+	// If there is none, an ErrNotFound error is returned.
+	Open(key string) (io.ReadCloser, error)
+
+	// Deletes the blob stored under the key. It isn't an error if there is
+	// none.
+	Delete(key string) error
+}
+
+// A Store that keeps each blob in a file, at the path given by its key,
+// within a directory.
+type DiskStore struct {
+	dir string
+}
+
+// Returns a DiskStore that keeps blobs in dir, creating it if it doesn't
+// exist.
+func NewDiskStore(dir string) (*DiskStore, error) {
+	err := os.MkdirAll(dir, 0750)
+	if err != nil {
+		return nil, err
+	}
+	return &DiskStore{dir: dir}, nil
+}
+
+// Returns the path of the file that the blob with the key is kept in.
+func (s *DiskStore) path(key string) (string, error) {
+	if !keyRX.MatchString(key) {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
+
+// Writes the blob to a temporary file, which is then renamed, so that readers
+// never see a partly written blob.
+func (s *DiskStore) Put(key string, r io.Reader) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0750)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	// Remove fails harmlessly once the file has been renamed.
+	defer os.Remove(f.Name())
+
+	_, err = io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+func (s *DiskStore) Open(key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+func (s *DiskStore) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
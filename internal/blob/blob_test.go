@@ -0,0 +1,52 @@
+package blob
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	assert "github.com/kvnloughead/snippetbox/internal"
+)
+
+func TestDiskStore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "blobs")
+	s, err := NewDiskStore(dir)
+	assert.IsNil(t, err)
+
+	read := func(key string) (string, error) {
+		f, err := s.Open(key)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		b, err := io.ReadAll(f)
+		return string(b), err
+	}
+
+	_, err = read("avatars/a.png")
+	assert.Equal(t, errors.Is(err, ErrNotFound), true)
+
+	assert.IsNil(t, s.Put("avatars/a.png", strings.NewReader("first")))
+	assert.IsNil(t, s.Put("avatars/a.png", strings.NewReader("second")))
+	got, err := read("avatars/a.png")
+	assert.IsNil(t, err)
+	assert.Equal(t, got, "second")
+
+	// No temporary files are left behind.
+	entries, err := os.ReadDir(filepath.Join(dir, "avatars"))
+	assert.IsNil(t, err)
+	assert.Equal(t, len(entries), 1)
+
+	assert.IsNil(t, s.Delete("avatars/a.png"))
+	assert.IsNil(t, s.Delete("avatars/a.png"))
+	_, err = read("avatars/a.png")
+	assert.Equal(t, errors.Is(err, ErrNotFound), true)
+
+	for _, key := range []string{"", "../a.png", "avatars/../../a.png", "/a.png", ".hidden", "a//b", "a\\b"} {
+		err = s.Put(key, strings.NewReader("x"))
+		assert.Equal(t, errors.Is(err, ErrInvalidKey), true)
+	}
+}
@@ -0,0 +1,248 @@
+/*
+Package bundle writes snippets to zip and tar.gz archives.
+
+Each snippet's files are written to a directory named after the snippet's ID
+and title, and a manifest.json file describing the snippets is written at the
+root of the archive, after their files. The archive is written as it is
+generated, from snippets fetched a page at a time, so neither it nor the
+snippets are ever held in memory in full.
+
+The package also parses the files that snippets are imported from, which are
+described by Parse.
+*/
+package bundle
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/kvnloughead/snippetbox/internal/models"
+)
+
+// An archive format.
+type Format string
+
+const (
+	Zip   Format = "zip"
+	TarGz Format = "tar.gz"
+)
+
+// Returns the MIME type of archives in the format.
+func (f Format) ContentType() string {
+	if f == TarGz {
+		return "application/gzip"
+	}
+	return "application/zip"
+}
+
+// The contents of manifest.json.
+type Manifest struct {
+	Generated time.Time         `json:"generated"`
+	Snippets  []ManifestSnippet `json:"snippets"`
+}
+
+// A snippet's metadata, as listed in manifest.json.
+type ManifestSnippet struct {
+	ID       int            `json:"id"`
+	Title    string         `json:"title"`
+	Format   string         `json:"format"`
+	UserID   int            `json:"user_id,omitempty"`
+	ParentID int            `json:"parent_id,omitempty"`
+	Tags     []string       `json:"tags"`
+	Created  time.Time      `json:"created"`
+	Expires  time.Time      `json:"expires"`
+	Dir      string         `json:"dir"` // relative to the root of the archive
+	Files    []ManifestFile `json:"files"`
+}
+
+// A file's metadata, as listed in manifest.json.
+type ManifestFile struct {
+	Name     string `json:"name"`
+	Language string `json:"language"`
+	Size     int    `json:"size"`
+}
+
+// The maximum length of a slug.
+const maxSlugLength = 50
+
+/*
+Returns a version of the title that is safe to use as a file name: lowercase
+letters and digits, with each run of other characters replaced by a single
+hyphen. If nothing is left, "snippet" is returned.
+*/
+func Slug(title string) string {
+	var sb strings.Builder
+	hyphen := false
+	for _, r := range strings.ToLower(title) {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			if hyphen && sb.Len() > 0 {
+				sb.WriteByte('-')
+			}
+			sb.WriteRune(r)
+			hyphen = false
+		} else {
+			hyphen = true
+		}
+		if sb.Len() >= maxSlugLength {
+			break
+		}
+	}
+
+	if sb.Len() == 0 {
+		return "snippet"
+	}
+	return sb.String()
+}
+
+// Returns the directory that a snippet's files are written to, relative to
+// the root of the archive. The ID keeps directories unique.
+func Dir(s models.Snippet) string {
+	return fmt.Sprintf("%d-%s", s.ID, Slug(s.Title))
+}
+
+// Returns the next page of snippets to be written to an archive, or no
+// snippets once all of them have been returned.
+type Pager func() ([]models.Snippet, error)
+
+// Returns a Pager that returns the snippets as a single page.
+func Slice(snippets []models.Snippet) Pager {
+	return func() ([]models.Snippet, error) {
+		page := snippets
+		snippets = nil
+		return page, nil
+	}
+}
+
+/*
+Writes an archive in the given format containing the snippets returned by next
+to w. All paths in the archive are inside a directory named root.
+
+Since the archive is written as it is generated, an error may occur after part
+of it has been written.
+*/
+func Write(w io.Writer, format Format, root string, next Pager, now time.Time) error {
+	var aw archiveWriter
+	switch format {
+	case Zip:
+		aw = &zipWriter{zip.NewWriter(w)}
+	case TarGz:
+		gw := gzip.NewWriter(w)
+		aw = &tarWriter{gw, tar.NewWriter(gw)}
+	default:
+		return fmt.Errorf("bundle: unknown format %q", format)
+	}
+
+	manifest := Manifest{Generated: now.UTC(), Snippets: []ManifestSnippet{}}
+	for {
+		snippets, err := next()
+		if err != nil {
+			return err
+		}
+		if len(snippets) == 0 {
+			break
+		}
+
+		for _, s := range snippets {
+			ms := ManifestSnippet{
+				ID:       s.ID,
+				Title:    s.Title,
+				Format:   s.Format,
+				UserID:   s.UserID,
+				ParentID: s.ParentID,
+				Tags:     s.Tags,
+				Created:  s.Created,
+				Expires:  s.Expires,
+				Dir:      Dir(s),
+				Files:    []ManifestFile{},
+			}
+			if ms.Tags == nil {
+				ms.Tags = []string{}
+			}
+
+			for _, f := range s.Files {
+				err = aw.add(path.Join(root, ms.Dir, f.Name), []byte(f.Content), s.Created)
+				if err != nil {
+					return err
+				}
+				ms.Files = append(ms.Files, ManifestFile{Name: f.Name, Language: f.Language, Size: len(f.Content)})
+			}
+
+			manifest.Snippets = append(manifest.Snippets, ms)
+		}
+	}
+
+	js, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = aw.add(path.Join(root, "manifest.json"), js, now)
+	if err != nil {
+		return err
+	}
+
+	return aw.close()
+}
+
+// The operations shared by the zip and tar.gz writers.
+type archiveWriter interface {
+	add(name string, content []byte, modified time.Time) error
+	close() error
+}
+
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+func (z *zipWriter) add(name string, content []byte, modified time.Time) error {
+	f, err := z.zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: modified,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(content)
+	return err
+}
+
+func (z *zipWriter) close() error {
+	return z.zw.Close()
+}
+
+type tarWriter struct {
+	gw *gzip.Writer
+	tw *tar.Writer
+}
+
+func (t *tarWriter) add(name string, content []byte, modified time.Time) error {
+	err := t.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     int64(len(content)),
+		ModTime:  modified,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = t.tw.Write(content)
+	return err
+}
+
+func (t *tarWriter) close() error {
+	err := t.tw.Close()
+	if err != nil {
+		return err
+	}
+	return t.gw.Close()
+}
@@ -0,0 +1,127 @@
+package bundle
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	assert "github.com/kvnloughead/snippetbox/internal"
+	"github.com/kvnloughead/snippetbox/internal/models"
+)
+
+func TestSlug(t *testing.T) {
+	tests := []struct {
+		title string
+		want  string
+	}{
+		{title: "An old silent pond", want: "an-old-silent-pond"},
+		{title: "  SQL: find duplicates!  ", want: "sql-find-duplicates"},
+		{title: "Ünïcode ☃", want: "n-code"},
+		{title: "!!!", want: "snippet"},
+		{title: strings.Repeat("a", 60), want: strings.Repeat("a", 50)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			assert.Equal(t, Slug(tt.title), tt.want)
+		})
+	}
+}
+
+var testSnippets = []models.Snippet{
+	{
+		ID:    1,
+		Title: "Build it",
+		Files: []models.SnippetFile{
+			{Name: "Dockerfile", Language: "dockerfile", Content: "FROM golang"},
+			{Name: "run.sh", Language: "shell", Content: "go run ."},
+		},
+		Tags: []string{"docker"},
+	},
+	{
+		ID:    2,
+		Title: "Build it",
+		Files: []models.SnippetFile{
+			{Name: "query.sql", Language: "sql", Content: "SELECT 1;"},
+		},
+	},
+}
+
+// The paths that testSnippets should be written to, in order.
+const wantPaths = "root/1-build-it/Dockerfile root/1-build-it/run.sh root/2-build-it/query.sql root/manifest.json"
+
+func TestWriteZip(t *testing.T) {
+	buf := new(bytes.Buffer)
+	err := Write(buf, Zip, "root", Slice(testSnippets), time.Now())
+	assert.IsNil(t, err)
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	assert.IsNil(t, err)
+
+	var paths []string
+	contents := make(map[string]string)
+	for _, f := range zr.File {
+		paths = append(paths, f.Name)
+		rc, err := f.Open()
+		assert.IsNil(t, err)
+		b, err := io.ReadAll(rc)
+		assert.IsNil(t, err)
+		rc.Close()
+		contents[f.Name] = string(b)
+	}
+
+	assert.Equal(t, strings.Join(paths, " "), wantPaths)
+	assert.Equal(t, contents["root/1-build-it/run.sh"], "go run .")
+
+	var m Manifest
+	err = json.Unmarshal([]byte(contents["root/manifest.json"]), &m)
+	assert.IsNil(t, err)
+	assert.Equal(t, len(m.Snippets), 2)
+	assert.Equal(t, m.Snippets[0].Dir, "1-build-it")
+	assert.Equal(t, m.Snippets[0].Files[1], ManifestFile{Name: "run.sh", Language: "shell", Size: 8})
+}
+
+func TestWriteTarGz(t *testing.T) {
+	buf := new(bytes.Buffer)
+	// Snippets are written a page at a time.
+	pages := [][]models.Snippet{testSnippets[:1], testSnippets[1:]}
+	next := func() ([]models.Snippet, error) {
+		if len(pages) == 0 {
+			return nil, nil
+		}
+		page := pages[0]
+		pages = pages[1:]
+		return page, nil
+	}
+
+	err := Write(buf, TarGz, "root", next, time.Now())
+	assert.IsNil(t, err)
+
+	gr, err := gzip.NewReader(buf)
+	assert.IsNil(t, err)
+	tr := tar.NewReader(gr)
+
+	var paths []string
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.IsNil(t, err)
+		paths = append(paths, h.Name)
+
+		if h.Name == "root/2-build-it/query.sql" {
+			b, err := io.ReadAll(tr)
+			assert.IsNil(t, err)
+			assert.Equal(t, string(b), "SELECT 1;")
+		}
+	}
+
+	assert.Equal(t, strings.Join(paths, " "), wantPaths)
+}
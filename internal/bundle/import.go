@@ -0,0 +1,322 @@
+package bundle
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/kvnloughead/snippetbox/internal/models"
+)
+
+// A snippet read from an import file. Entries haven't been validated.
+type Entry struct {
+	Title   string
+	Format  string // empty if not given
+	Files   []models.SnippetFile
+	Tags    []string
+	Expires int // in days, or 0 if not given
+}
+
+// The maximum size of a file in a zip archive being imported. Larger files
+// are rejected, rather than decompressed into memory.
+const MaxFileSize = 1 << 20
+
+// The maximum number of files in a zip archive being imported, and their
+// maximum total size. Larger archives are rejected before any of their files
+// are decompressed.
+const (
+	MaxZipFiles = 1000
+	MaxZipSize  = 10 << 20
+)
+
+// Returned by Parse if the data isn't in any of the supported formats.
+var ErrUnknownFormat = errors.New("bundle: unrecognized import format")
+
+/*
+Parses the snippets in an import file. Three formats are supported, and are
+detected from the data:
+
+  - A zip archive of text files. Each file becomes a snippet, titled with the
+    file's name without its extension. Markdown files become Markdown
+    snippets. Hidden files and directories, and the metadata that macOS adds
+    to archives, are skipped.
+
+  - A JSON array of objects with title, format, tags and expires keys, and either
+    content and language keys for a single file, or a files key holding an
+    array of objects with name, language and content keys.
+
+  - A GitHub gist, as returned by the gists API, or a JSON array of them. Each
+    gist becomes a snippet, titled with its description.
+*/
+func Parse(data []byte) ([]Entry, error) {
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		return parseZip(data)
+	}
+
+	data = bytes.TrimSpace(data)
+	switch {
+	case bytes.HasPrefix(data, []byte("[")):
+		var items []json.RawMessage
+		err := json.Unmarshal(data, &items)
+		if err != nil {
+			return nil, fmt.Errorf("bundle: invalid JSON: %w", err)
+		}
+
+		entries := make([]Entry, len(items))
+		for i, item := range items {
+			entries[i], err = parseJSONItem(item)
+			if err != nil {
+				return nil, fmt.Errorf("bundle: item %d: %w", i+1, err)
+			}
+		}
+		return entries, nil
+
+	case bytes.HasPrefix(data, []byte("{")):
+		entry, err := parseJSONItem(data)
+		if err != nil {
+			return nil, fmt.Errorf("bundle: %w", err)
+		}
+		return []Entry{entry}, nil
+
+	default:
+		return nil, ErrUnknownFormat
+	}
+}
+
+// A snippet in a JSON import file, either in our own format or as a gist.
+type jsonItem struct {
+	Title       string          `json:"title"`
+	Format      string          `json:"format"`
+	Description string          `json:"description"` // gists only
+	Content     string          `json:"content"`
+	Language    string          `json:"language"`
+	Files       json.RawMessage `json:"files"`
+	Tags        []string        `json:"tags"`
+	Expires     int             `json:"expires"`
+}
+
+// A file in a gist.
+type gistFile struct {
+	Filename string `json:"filename"`
+	Language string `json:"language"`
+	Content  string `json:"content"`
+}
+
+// Parses one snippet in a JSON import file. A gist is recognised by its files
+// being an object, keyed by file name, rather than an array.
+func parseJSONItem(data []byte) (Entry, error) {
+	var item jsonItem
+	err := json.Unmarshal(data, &item)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	entry := Entry{Title: item.Title, Format: item.Format, Tags: item.Tags, Expires: item.Expires}
+
+	files := bytes.TrimSpace(item.Files)
+	switch {
+	case bytes.HasPrefix(files, []byte("{")):
+		var gist map[string]gistFile
+		err = json.Unmarshal(files, &gist)
+		if err != nil {
+			return Entry{}, err
+		}
+
+		if entry.Title == "" {
+			entry.Title = item.Description
+		}
+
+		// Gist files are listed in alphabetical order, as on GitHub.
+		names := make([]string, 0, len(gist))
+		for name := range gist {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			f := gist[name]
+			if f.Filename == "" {
+				f.Filename = name
+			}
+			entry.Files = append(entry.Files, models.SnippetFile{
+				Name:     f.Filename,
+				Language: gistLanguage(f.Language, f.Filename),
+				Content:  f.Content,
+			})
+		}
+
+		// Gists may have no description, so fall back to the first file's name.
+		if entry.Title == "" && len(entry.Files) > 0 {
+			entry.Title = entry.Files[0].Name
+		}
+
+	case bytes.HasPrefix(files, []byte("[")):
+		err = json.Unmarshal(files, &entry.Files)
+		if err != nil {
+			return Entry{}, err
+		}
+
+	default:
+		language := item.Language
+		if language == "" {
+			language = "text"
+		}
+		entry.Files = []models.SnippetFile{{Name: "snippet.txt", Language: language, Content: item.Content}}
+	}
+
+	return entry, nil
+}
+
+// Parses a zip archive of text files, each of which becomes a snippet.
+func parseZip(data []byte) ([]Entry, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("bundle: invalid zip archive: %w", err)
+	}
+
+	// The archive's size is checked from its headers before anything is
+	// decompressed.
+	var files []*zip.File
+	var size uint64
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || skipZipPath(f.Name) {
+			continue
+		}
+		if f.UncompressedSize64 > MaxFileSize {
+			return nil, fmt.Errorf("bundle: %s is larger than %d bytes", f.Name, MaxFileSize)
+		}
+		size += f.UncompressedSize64
+		files = append(files, f)
+	}
+
+	switch {
+	case len(files) > MaxZipFiles:
+		return nil, fmt.Errorf("bundle: archive contains more than %d files", MaxZipFiles)
+	case size > MaxZipSize:
+		return nil, fmt.Errorf("bundle: archive is larger than %d bytes uncompressed", MaxZipSize)
+	}
+
+	var entries []Entry
+	size = 0
+	for _, f := range files {
+		name := path.Base(f.Name)
+
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("bundle: %s: %w", f.Name, err)
+		}
+		// The sizes in the headers can't be trusted, so they are checked
+		// again.
+		content, err := io.ReadAll(io.LimitReader(rc, MaxFileSize+1))
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("bundle: %s: %w", f.Name, err)
+		}
+		if len(content) > MaxFileSize {
+			return nil, fmt.Errorf("bundle: %s is larger than %d bytes", f.Name, MaxFileSize)
+		}
+		size += uint64(len(content))
+		if size > MaxZipSize {
+			return nil, fmt.Errorf("bundle: archive is larger than %d bytes uncompressed", MaxZipSize)
+		}
+
+		// Markdown files are assumed to be notes, to be rendered.
+		language := languageFromName(name)
+		format := models.FormatPlain
+		if language == "markdown" {
+			format = models.FormatMarkdown
+		}
+
+		entries = append(entries, Entry{
+			Title:  strings.TrimSuffix(name, path.Ext(name)),
+			Format: format,
+			Files: []models.SnippetFile{{
+				Name:     name,
+				Language: language,
+				Content:  string(content),
+			}},
+		})
+	}
+
+	return entries, nil
+}
+
+// Returns true if the path is hidden or contains macOS metadata.
+func skipZipPath(name string) bool {
+	for _, segment := range strings.Split(name, "/") {
+		if strings.HasPrefix(segment, ".") || segment == "__MACOSX" {
+			return true
+		}
+	}
+	return false
+}
+
+// The languages of files with these extensions.
+var extensionLanguages = map[string]string{
+	".c":    "c",
+	".h":    "c",
+	".cpp":  "cpp",
+	".cc":   "cpp",
+	".hpp":  "cpp",
+	".css":  "css",
+	".go":   "go",
+	".html": "html",
+	".java": "java",
+	".js":   "javascript",
+	".json": "json",
+	".md":   "markdown",
+	".py":   "python",
+	".rb":   "ruby",
+	".rs":   "rust",
+	".sh":   "shell",
+	".bash": "shell",
+	".sql":  "sql",
+	".toml": "toml",
+	".ts":   "typescript",
+	".yaml": "yaml",
+	".yml":  "yaml",
+}
+
+// Guesses a file's language from its name, defaulting to text.
+func languageFromName(name string) string {
+	if name == "Dockerfile" || strings.HasSuffix(name, ".dockerfile") {
+		return "dockerfile"
+	}
+	if language, ok := extensionLanguages[strings.ToLower(path.Ext(name))]; ok {
+		return language
+	}
+	return "text"
+}
+
+// Gist language names that differ from ours, in lower case.
+var gistLanguages = map[string]string{
+	"c++":        "cpp",
+	"html+erb":   "html",
+	"text":       "text",
+	"plain text": "text",
+}
+
+// Returns our name for a gist file's language. Gists support many more
+// languages than we do, so if the language isn't one we know, it is guessed
+// from the file's name instead.
+func gistLanguage(language, name string) string {
+	language = strings.ToLower(language)
+	if l, ok := gistLanguages[language]; ok {
+		return l
+	}
+	for _, l := range extensionLanguages {
+		if l == language {
+			return l
+		}
+	}
+	if language == "dockerfile" {
+		return language
+	}
+	return languageFromName(name)
+}
@@ -0,0 +1,150 @@
+package bundle
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	assert "github.com/kvnloughead/snippetbox/internal"
+	"github.com/kvnloughead/snippetbox/internal/models"
+)
+
+func TestParseJSON(t *testing.T) {
+	data := []byte(`[
+		{"title": "One file", "content": "SELECT 1;", "language": "sql", "tags": ["sql"], "expires": 7},
+		{"title": "Many files", "files": [
+			{"name": "main.go", "language": "go", "content": "package main"},
+			{"name": "go.mod", "language": "text", "content": "module x"}
+		]},
+		{"title": "No language", "content": "hello"}
+	]`)
+
+	entries, err := Parse(data)
+	assert.IsNil(t, err)
+	assert.Equal(t, len(entries), 3)
+
+	assert.Equal(t, entries[0].Title, "One file")
+	assert.Equal(t, entries[0].Expires, 7)
+	assert.Equal(t, strings.Join(entries[0].Tags, ","), "sql")
+	assert.Equal(t, len(entries[0].Files), 1)
+	assert.Equal(t, entries[0].Files[0].Name, "snippet.txt")
+	assert.Equal(t, entries[0].Files[0].Language, "sql")
+	assert.Equal(t, entries[0].Files[0].Content, "SELECT 1;")
+
+	assert.Equal(t, len(entries[1].Files), 2)
+	assert.Equal(t, entries[1].Files[1].Name, "go.mod")
+	assert.Equal(t, entries[1].Expires, 0)
+
+	assert.Equal(t, entries[2].Files[0].Language, "text")
+}
+
+func TestParseGist(t *testing.T) {
+	data := []byte(`{
+		"description": "",
+		"files": {
+			"run.sh": {"filename": "run.sh", "language": "Shell", "content": "go run ."},
+			"Dockerfile": {"filename": "Dockerfile", "language": "Dockerfile", "content": "FROM golang"},
+			"notes.adoc": {"filename": "notes.adoc", "language": "AsciiDoc", "content": "= Notes"}
+		}
+	}`)
+
+	entries, err := Parse(data)
+	assert.IsNil(t, err)
+	assert.Equal(t, len(entries), 1)
+
+	e := entries[0]
+	assert.Equal(t, e.Title, "Dockerfile") // no description
+	assert.Equal(t, len(e.Files), 3)
+	assert.Equal(t, e.Files[0].Language, "dockerfile")
+	assert.Equal(t, e.Files[1].Name, "notes.adoc")
+	assert.Equal(t, e.Files[1].Language, "text")
+	assert.Equal(t, e.Files[2].Language, "shell")
+}
+
+// Returns a zip archive containing the given files.
+func newZip(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		assert.IsNil(t, err)
+		_, err = w.Write([]byte(content))
+		assert.IsNil(t, err)
+	}
+	assert.IsNil(t, zw.Close())
+	return buf.Bytes()
+}
+
+func TestParseZip(t *testing.T) {
+	t.Run("Valid", func(t *testing.T) {
+		data := newZip(t, map[string]string{
+			"src/query.sql":        "SELECT 1;",
+			".git/config":          "[core]",
+			"__MACOSX/._query.sql": "junk",
+			"src/.hidden":          "secret",
+			"notes/todo.md":        "- [ ] todo",
+		})
+
+		entries, err := Parse(data)
+		assert.IsNil(t, err)
+		assert.Equal(t, len(entries), 2)
+
+		titles := map[string]Entry{}
+		for _, e := range entries {
+			titles[e.Title] = e
+		}
+		assert.Equal(t, titles["query"].Files[0].Name, "query.sql")
+		assert.Equal(t, titles["query"].Files[0].Language, "sql")
+		assert.Equal(t, titles["todo"].Files[0].Language, "markdown")
+		assert.Equal(t, titles["todo"].Format, models.FormatMarkdown)
+		assert.Equal(t, titles["query"].Format, models.FormatPlain)
+	})
+
+	t.Run("File too large", func(t *testing.T) {
+		data := newZip(t, map[string]string{"big.txt": strings.Repeat("a", MaxFileSize+1)})
+
+		_, err := Parse(data)
+		if err == nil || !strings.Contains(err.Error(), "larger than") {
+			t.Errorf("got %v; want a size error", err)
+		}
+	})
+
+	t.Run("Too many files", func(t *testing.T) {
+		files := make(map[string]string)
+		for i := 0; i <= MaxZipFiles; i++ {
+			files[fmt.Sprintf("%d.txt", i)] = "a"
+		}
+
+		_, err := Parse(newZip(t, files))
+		if err == nil || !strings.Contains(err.Error(), "more than") {
+			t.Errorf("got %v; want a file count error", err)
+		}
+	})
+
+	t.Run("Archive too large", func(t *testing.T) {
+		files := make(map[string]string)
+		for i := 0; i <= MaxZipSize/MaxFileSize; i++ {
+			files[fmt.Sprintf("%d.txt", i)] = strings.Repeat("a", MaxFileSize)
+		}
+
+		_, err := Parse(newZip(t, files))
+		if err == nil || !strings.Contains(err.Error(), "uncompressed") {
+			t.Errorf("got %v; want a size error", err)
+		}
+	})
+}
+
+func TestParseUnknownFormat(t *testing.T) {
+	for _, data := range []string{"", "hello", "<xml/>"} {
+		_, err := Parse([]byte(data))
+		assert.Equal(t, errors.Is(err, ErrUnknownFormat), true)
+	}
+
+	_, err := Parse([]byte(`[{"title": 1}]`))
+	if err == nil || errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("got %v; want a JSON error", err)
+	}
+}
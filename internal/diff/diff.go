@@ -0,0 +1,251 @@
+// Package diff computes line based diffs between two texts, and groups them
+// into the hunks of a unified diff.
+package diff
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// The largest number of lines that can be changed between two texts for a diff
+// to be computed. Texts that differ by more lines are too costly to diff.
+const MaxEdits = 1000
+
+// Occurs when two texts differ by more than MaxEdits lines.
+var ErrTooManyChanges = errors.New("diff: too many changes")
+
+// The kind of an edit to a line.
+type Kind int
+
+const (
+	Equal  Kind = iota // the line is in both texts
+	Delete             // the line is only in the old text
+	Insert             // the line is only in the new text
+)
+
+// A line of a diff.
+type Line struct {
+	Kind Kind
+	Text string
+}
+
+// Returns the line as it would appear in a unified diff, prefixed with ' ',
+// '-' or '+'.
+func (l Line) String() string {
+	switch l.Kind {
+	case Delete:
+		return "-" + l.Text
+	case Insert:
+		return "+" + l.Text
+	default:
+		return " " + l.Text
+	}
+}
+
+/*
+A group of changes, along with the unchanged lines surrounding them.
+
+OldStart and NewStart are the 1-based numbers of the hunk's first line in the
+old and new texts. As in diff(1), if a hunk has no lines from one of the
+texts, its start is the number of the line before the hunk.
+*/
+type Hunk struct {
+	OldStart, OldLines int
+	NewStart, NewLines int
+	Lines              []Line
+}
+
+// Returns the hunk's header, e.g. "@@ -1,4 +1,5 @@".
+func (h Hunk) Header() string {
+	return fmt.Sprintf("@@ -%d,%d +%d,%d @@", h.OldStart, h.OldLines, h.NewStart, h.NewLines)
+}
+
+// Splits s into lines. Line endings are removed, and a trailing newline
+// doesn't produce an empty final line.
+func SplitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+/*
+Returns a shortest edit script that turns a into b, as a slice of lines, using
+Myers' algorithm. Deletions come before insertions in each run of changes.
+
+The algorithm takes O((N+M)D) time and O(N+M+D²) space, where D is the number
+of lines changed. If D is more than MaxEdits, an ErrTooManyChanges error is
+returned.
+*/
+func Lines(a, b []string) ([]Line, error) {
+	n, m := len(a), len(b)
+	max := n + m
+	if max == 0 {
+		return nil, nil
+	}
+
+	// v[offset+k] is the furthest x reached on diagonal k. Before round d, the
+	// part of v that it reads, diagonals -d-1 to d+1, is saved in trace[d], so
+	// that the path can be retraced afterwards.
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	var trace [][]int
+
+search:
+	for d := 0; d <= max; d++ {
+		if d > MaxEdits {
+			return nil, ErrTooManyChanges
+		}
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1] // move down, i.e. insert
+			} else {
+				x = v[offset+k-1] + 1 // move right, i.e. delete
+			}
+			y := x - k
+
+			// Follow the diagonal while lines match.
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	// Retrace the path from the end, building the script in reverse.
+	var lines []Line
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v, offset := trace[d], d+1
+		k := x - y
+
+		var prevK int
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			lines = append(lines, Line{Equal, a[x-1]})
+			x--
+			y--
+		}
+
+		if d > 0 {
+			if x == prevX {
+				lines = append(lines, Line{Insert, b[y-1]})
+			} else {
+				lines = append(lines, Line{Delete, a[x-1]})
+			}
+		}
+
+		x, y = prevX, prevY
+	}
+
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+	return lines, nil
+}
+
+/*
+Returns the hunks of a unified diff between the texts a and b, with up to
+context unchanged lines before and after each change. Changes separated by no
+more than 2*context unchanged lines share a hunk.
+
+If the texts are the same, no hunks are returned. If they differ by more than
+MaxEdits lines, an ErrTooManyChanges error is returned.
+*/
+func Hunks(a, b string, context int) ([]Hunk, error) {
+	lines, err := Lines(SplitLines(a), SplitLines(b))
+	if err != nil {
+		return nil, err
+	}
+
+	// The number of lines of each text that come before each line of the diff.
+	oldPos := make([]int, len(lines)+1)
+	newPos := make([]int, len(lines)+1)
+	for i, l := range lines {
+		oldPos[i+1], newPos[i+1] = oldPos[i], newPos[i]
+		if l.Kind != Insert {
+			oldPos[i+1]++
+		}
+		if l.Kind != Delete {
+			newPos[i+1]++
+		}
+	}
+
+	var hunks []Hunk
+	for i := 0; i < len(lines); {
+		if lines[i].Kind == Equal {
+			i++
+			continue
+		}
+
+		// Find the end of this group of changes.
+		start := max(0, i-context)
+		end := i
+		for j := i; j < len(lines); j++ {
+			if lines[j].Kind == Equal {
+				continue
+			}
+			if j-end > 2*context {
+				break
+			}
+			end = j + 1
+		}
+		end = min(len(lines), end+context)
+
+		h := Hunk{
+			OldStart: oldPos[start],
+			OldLines: oldPos[end] - oldPos[start],
+			NewStart: newPos[start],
+			NewLines: newPos[end] - newPos[start],
+			Lines:    lines[start:end],
+		}
+		if h.OldLines > 0 {
+			h.OldStart++
+		}
+		if h.NewLines > 0 {
+			h.NewStart++
+		}
+		hunks = append(hunks, h)
+
+		i = end
+	}
+
+	return hunks, nil
+}
+
+// Returns a unified diff between the texts a and b, with the given file names
+// and 3 lines of context. If the texts are the same, an empty string is
+// returned. Errors are as for Hunks.
+func Unified(oldName, newName, a, b string) (string, error) {
+	hunks, err := Hunks(a, b, 3)
+	if err != nil || len(hunks) == 0 {
+		return "", err
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", oldName, newName)
+	for _, h := range hunks {
+		sb.WriteString(h.Header() + "\n")
+		for _, l := range h.Lines {
+			sb.WriteString(l.String() + "\n")
+		}
+	}
+	return sb.String(), nil
+}
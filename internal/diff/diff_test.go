@@ -0,0 +1,111 @@
+package diff
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	assert "github.com/kvnloughead/snippetbox/internal"
+)
+
+func TestUnified(t *testing.T) {
+	tests := []struct {
+		name string
+		a    string
+		b    string
+		want string
+	}{
+		{
+			name: "Same",
+			a:    "a\nb\n",
+			b:    "a\nb\n",
+			want: "",
+		},
+		{
+			name: "Change",
+			a:    "a\nb\nc\n",
+			b:    "a\nB\nc\n",
+			want: "--- old\n+++ new\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n",
+		},
+		{
+			name: "From empty",
+			a:    "",
+			b:    "a\nb\n",
+			want: "--- old\n+++ new\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name: "To empty",
+			a:    "a\n",
+			b:    "",
+			want: "--- old\n+++ new\n@@ -1,1 +0,0 @@\n-a\n",
+		},
+		{
+			name: "Separate hunks",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			b:    "one\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\ntwelve\n",
+			want: "--- old\n+++ new\n@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-12\n+twelve\n",
+		},
+		{
+			name: "Merged hunks",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n",
+			b:    "one\n2\n3\n4\n5\n6\n7\neight\n",
+			want: "--- old\n+++ new\n@@ -1,8 +1,8 @@\n-1\n+one\n 2\n 3\n 4\n 5\n 6\n 7\n-8\n+eight\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Unified("old", "new", tt.a, tt.b)
+			assert.IsNil(t, err)
+			assert.Equal(t, got, tt.want)
+		})
+	}
+}
+
+// Applying the edit script to the old text must give the new text.
+func TestLines(t *testing.T) {
+	a := strings.Split("the quick brown fox jumps over the lazy dog", " ")
+	b := strings.Split("a quick brown dog jumps over the lazy fox again", " ")
+
+	lines, err := Lines(a, b)
+	assert.IsNil(t, err)
+
+	var old, new []string
+	changes := 0
+	for _, l := range lines {
+		if l.Kind != Insert {
+			old = append(old, l.Text)
+		}
+		if l.Kind != Delete {
+			new = append(new, l.Text)
+		}
+		if l.Kind != Equal {
+			changes++
+		}
+	}
+
+	assert.Equal(t, strings.Join(old, " "), strings.Join(a, " "))
+	assert.Equal(t, strings.Join(new, " "), strings.Join(b, " "))
+	assert.Equal(t, changes, 7)
+}
+
+// Texts that differ by more than MaxEdits lines aren't diffed.
+func TestLinesTooManyChanges(t *testing.T) {
+	a := make([]string, MaxEdits)
+	b := make([]string, MaxEdits)
+	for i := range a {
+		a[i] = fmt.Sprintf("a%d", i)
+		b[i] = fmt.Sprintf("b%d", i)
+	}
+
+	_, err := Lines(a, b)
+	assert.Equal(t, errors.Is(err, ErrTooManyChanges), true)
+
+	// Only the changed lines count, so changing half of the lines is
+	// MaxEdits changes.
+	half := MaxEdits / 2
+	lines, err := Lines(a, append(a[:half:half], b[:half]...))
+	assert.IsNil(t, err)
+	assert.Equal(t, len(lines), 3*half)
+}
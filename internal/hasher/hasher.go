@@ -0,0 +1,214 @@
+// Package hasher hashes passwords with bcrypt or argon2id, and verifies
+// passwords against hashes made by either, so that the algorithm and its costs
+// can be changed without invalidating existing hashes.
+package hasher
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Occurs when a password doesn't match a hash.
+var ErrMismatch = errors.New("hasher: password doesn't match hash")
+
+// Occurs when a hash wasn't made by any supported algorithm, or is malformed.
+var ErrUnknownHash = errors.New("hasher: unknown hash format")
+
+// A password hashing algorithm, with the costs that new hashes are made with.
+type Hasher interface {
+	// Returns a hash of the password, which encodes the algorithm and costs
+	// used to make it.
+	Hash(password string) ([]byte, error)
+
+	// Returns true if the hash was made by this hasher's algorithm, with any
+	// costs.
+	Recognizes(hash []byte) bool
+
+	// Returns ErrMismatch if the password doesn't match the hash, which must
+	// have been made by this hasher's algorithm.
+	Compare(hash []byte, password string) error
+
+	// Returns true if the hash, which must have been made by this hasher's
+	// algorithm, was made with lower costs than this hasher's.
+	Weaker(hash []byte) bool
+}
+
+// The hasher used if none is configured.
+var Default Hasher = Bcrypt{Cost: 12}
+
+/*
+Compares the password with a hash made by any supported algorithm. If it
+doesn't match, an ErrMismatch error is returned.
+
+If it matches, rehash is true if the hash should be replaced with one made by
+current, because it was made by another algorithm, or with lower costs.
+*/
+func Verify(current Hasher, hash []byte, password string) (rehash bool, err error) {
+	for _, h := range []Hasher{current, Bcrypt{}, Argon2id{}} {
+		if !h.Recognizes(hash) {
+			continue
+		}
+
+		err = h.Compare(hash, password)
+		if err != nil {
+			return false, err
+		}
+
+		return !current.Recognizes(hash) || current.Weaker(hash), nil
+	}
+
+	return false, ErrUnknownHash
+}
+
+// Hashes passwords with bcrypt, at the given cost. Passwords longer than 72
+// bytes can't be hashed.
+type Bcrypt struct {
+	Cost int
+}
+
+func (b Bcrypt) Hash(password string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte(password), b.Cost)
+}
+
+func (b Bcrypt) Recognizes(hash []byte) bool {
+	return bytes.HasPrefix(hash, []byte("$2a$")) ||
+		bytes.HasPrefix(hash, []byte("$2b$")) ||
+		bytes.HasPrefix(hash, []byte("$2y$"))
+}
+
+func (b Bcrypt) Compare(hash []byte, password string) error {
+	err := bcrypt.CompareHashAndPassword(hash, []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return ErrMismatch
+	}
+	return err
+}
+
+func (b Bcrypt) Weaker(hash []byte) bool {
+	cost, err := bcrypt.Cost(hash)
+	return err != nil || cost < b.Cost
+}
+
+/*
+Hashes passwords with argon2id, with the given memory (in KiB), number of
+iterations and degree of parallelism. Hashes are encoded in the PHC string
+format used by the reference implementation, such as:
+
+	$argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+
+where the salt and key are unpadded base64.
+*/
+type Argon2id struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// The argon2id costs recommended by RFC 9106 for memory-constrained
+// environments.
+var DefaultArgon2id = Argon2id{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 4,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// The costs, salt and key of an argon2id hash.
+type argon2idHash struct {
+	Argon2id
+	salt []byte
+	key  []byte
+}
+
+func (a Argon2id) Hash(password string) ([]byte, error) {
+	salt := make([]byte, a.SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, a.Iterations, a.Memory, a.Parallelism, a.KeyLength)
+
+	hash := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, a.Memory, a.Iterations, a.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key))
+
+	return []byte(hash), nil
+}
+
+func (a Argon2id) Recognizes(hash []byte) bool {
+	return bytes.HasPrefix(hash, []byte("$argon2id$"))
+}
+
+// Returns the costs, salt and key of an argon2id hash.
+func parseArgon2id(hash []byte) (argon2idHash, error) {
+	var h argon2idHash
+	var version int
+
+	// Splitting on $ gives "", "argon2id", "v=19", the costs, the salt and the
+	// key.
+	parts := bytes.Split(hash, []byte("$"))
+	if len(parts) != 6 {
+		return h, ErrUnknownHash
+	}
+
+	_, err := fmt.Sscanf(string(parts[2]), "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return h, ErrUnknownHash
+	}
+
+	// argon2.IDKey panics if there are no iterations or threads.
+	_, err = fmt.Sscanf(string(parts[3]), "m=%d,t=%d,p=%d", &h.Memory, &h.Iterations, &h.Parallelism)
+	if err != nil || h.Memory == 0 || h.Iterations == 0 || h.Parallelism == 0 {
+		return h, ErrUnknownHash
+	}
+
+	h.salt, err = base64.RawStdEncoding.DecodeString(string(parts[4]))
+	if err != nil {
+		return h, ErrUnknownHash
+	}
+
+	h.key, err = base64.RawStdEncoding.DecodeString(string(parts[5]))
+	if err != nil || len(h.key) == 0 {
+		return h, ErrUnknownHash
+	}
+
+	h.SaltLength = uint32(len(h.salt))
+	h.KeyLength = uint32(len(h.key))
+
+	return h, nil
+}
+
+func (a Argon2id) Compare(hash []byte, password string) error {
+	h, err := parseArgon2id(hash)
+	if err != nil {
+		return err
+	}
+
+	key := argon2.IDKey([]byte(password), h.salt, h.Iterations, h.Memory, h.Parallelism, h.KeyLength)
+	if subtle.ConstantTimeCompare(key, h.key) != 1 {
+		return ErrMismatch
+	}
+	return nil
+}
+
+func (a Argon2id) Weaker(hash []byte) bool {
+	h, err := parseArgon2id(hash)
+	return err != nil ||
+		h.Memory < a.Memory ||
+		h.Iterations < a.Iterations ||
+		h.Parallelism < a.Parallelism ||
+		h.SaltLength < a.SaltLength ||
+		h.KeyLength < a.KeyLength
+}
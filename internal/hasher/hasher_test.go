@@ -0,0 +1,85 @@
+package hasher
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	assert "github.com/kvnloughead/snippetbox/internal"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Hashers with low costs, so that the tests run quickly.
+var (
+	testBcrypt   = Bcrypt{Cost: bcrypt.MinCost}
+	testArgon2id = Argon2id{Memory: 8, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+)
+
+func TestHashers(t *testing.T) {
+	for _, h := range []Hasher{testBcrypt, testArgon2id} {
+		hash, err := h.Hash("pa$$word")
+		assert.IsNil(t, err)
+
+		assert.Equal(t, h.Recognizes(hash), true)
+		assert.IsNil(t, h.Compare(hash, "pa$$word"))
+		assert.Equal(t, errors.Is(h.Compare(hash, "pa$$w0rd"), ErrMismatch), true)
+		assert.Equal(t, h.Weaker(hash), false)
+
+		// Hashes are salted, so the same password hashes differently each time.
+		again, err := h.Hash("pa$$word")
+		assert.IsNil(t, err)
+		assert.Equal(t, string(again) == string(hash), false)
+	}
+}
+
+func TestArgon2idFormat(t *testing.T) {
+	hash, err := testArgon2id.Hash("pa$$word")
+	assert.IsNil(t, err)
+	assert.Equal(t, strings.HasPrefix(string(hash), "$argon2id$v=19$m=8,t=1,p=1$"), true)
+
+	for _, malformed := range []string{
+		"$argon2id$v=19$m=8,t=1,p=1$c2FsdA",
+		"$argon2id$v=16$m=8,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=eight,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=0,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=8,t=0,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=8,t=1,p=0$c2FsdA$a2V5",
+		"$argon2id$v=19$m=8,t=1,p=1$c2FsdA$not base64",
+	} {
+		assert.Equal(t, errors.Is(testArgon2id.Compare([]byte(malformed), "pa$$word"), ErrUnknownHash), true)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	bcryptHash, err := testBcrypt.Hash("pa$$word")
+	assert.IsNil(t, err)
+	argon2idHash, err := testArgon2id.Hash("pa$$word")
+	assert.IsNil(t, err)
+
+	tests := []struct {
+		name       string
+		current    Hasher
+		hash       []byte
+		password   string
+		wantRehash bool
+		wantErr    error
+	}{
+		{"Same hasher", testBcrypt, bcryptHash, "pa$$word", false, nil},
+		{"Higher bcrypt cost", Bcrypt{Cost: bcrypt.MinCost + 1}, bcryptHash, "pa$$word", true, nil},
+		{"Lower bcrypt cost", Bcrypt{Cost: bcrypt.MinCost - 1}, bcryptHash, "pa$$word", false, nil},
+		{"bcrypt to argon2id", testArgon2id, bcryptHash, "pa$$word", true, nil},
+		{"argon2id to bcrypt", testBcrypt, argon2idHash, "pa$$word", true, nil},
+		{"More argon2id memory", Argon2id{Memory: 16, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}, argon2idHash, "pa$$word", true, nil},
+		{"More argon2id iterations", Argon2id{Memory: 8, Iterations: 2, Parallelism: 1, SaltLength: 16, KeyLength: 32}, argon2idHash, "pa$$word", true, nil},
+		{"Wrong password", testArgon2id, bcryptHash, "pa$$w0rd", false, ErrMismatch},
+		{"Unknown hash", testBcrypt, []byte("5f4dcc3b5aa765d61d8327deb882cf99"), "pa$$word", false, ErrUnknownHash},
+	}
+
+	for _, sub := range tests {
+		t.Run(sub.name, func(t *testing.T) {
+			rehash, err := Verify(sub.current, sub.hash, sub.password)
+			assert.Equal(t, rehash, sub.wantRehash)
+			assert.Equal(t, errors.Is(err, sub.wantErr), true)
+		})
+	}
+}
@@ -0,0 +1,99 @@
+// Package mailer sends the application's transactional emails. Each email is
+// rendered from a template in ./templates, which must define a "subject" and
+// a "plainBody" block.
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"strconv"
+	"text/template"
+)
+
+//go:embed "templates"
+var templateFS embed.FS
+
+// Mailer is implemented by anything that can deliver a templated email to a
+// single recipient.
+type Mailer interface {
+	Send(recipient, templateFile string, data any) error
+}
+
+// Renders the subject and plain text body of the given template.
+func render(templateFile string, data any) (subject, body string, err error) {
+	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return "", "", err
+	}
+
+	buf := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(buf, "subject", data)
+	if err != nil {
+		return "", "", err
+	}
+	subject = buf.String()
+
+	buf.Reset()
+	err = tmpl.ExecuteTemplate(buf, "plainBody", data)
+	if err != nil {
+		return "", "", err
+	}
+	body = buf.String()
+
+	return subject, body, nil
+}
+
+// A Mailer that delivers email through an SMTP server.
+type SMTPMailer struct {
+	addr   string
+	auth   smtp.Auth
+	sender string
+}
+
+// Returns an SMTPMailer for the given server. If username is empty, no
+// authentication is attempted.
+func New(host string, port int, username, password, sender string) *SMTPMailer {
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return &SMTPMailer{
+		addr:   net.JoinHostPort(host, strconv.Itoa(port)),
+		auth:   auth,
+		sender: sender,
+	}
+}
+
+func (m *SMTPMailer) Send(recipient, templateFile string, data any) error {
+	subject, body, err := render(templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	msg := fmt.Sprintf("To: %s\r\nFrom: %s\r\nSubject: %s\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s",
+		recipient, m.sender, subject, body)
+
+	return smtp.SendMail(m.addr, m.auth, m.sender, []string{recipient}, []byte(msg))
+}
+
+// A Mailer that writes emails to a logger instead of sending them. Useful in
+// development, when no SMTP server is configured.
+type LogMailer struct {
+	Logger *slog.Logger
+}
+
+func (m *LogMailer) Send(recipient, templateFile string, data any) error {
+	subject, body, err := render(templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	m.Logger.Info("email not sent (no SMTP server configured)",
+		"recipient", recipient, "subject", subject, "body", body)
+	return nil
+}
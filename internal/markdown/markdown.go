@@ -0,0 +1,143 @@
+/*
+Package markdown renders Markdown to sanitized HTML.
+
+Raw HTML in the Markdown is never rendered, and the output is sanitized as well,
+so that it only contains formatting elements and links. It has no scripts,
+styles or event handlers, so it can be shown under the app's Content Security
+Policy. Fenced code blocks are rendered with a language-* class, and headings
+are given IDs and anchor links, so that they can be linked to.
+*/
+package markdown
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"regexp"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// The prefix of heading IDs, which keeps them from clashing with the IDs of
+// the page that the Markdown is shown on.
+const idPrefix = "md-"
+
+var md = goldmark.New(
+	goldmark.WithExtensions(extension.Table, extension.Strikethrough, extension.Linkify),
+	goldmark.WithParserOptions(
+		parser.WithAutoHeadingID(),
+		parser.WithASTTransformers(util.Prioritized(anchorTransformer{}, 100)),
+	),
+)
+
+// The sanitization policy, based on bluemonday's policy for user generated
+// content.
+var policy = newPolicy()
+
+func newPolicy() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[\w.+#-]+$`)).OnElements("code")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^anchor$`)).OnElements("a")
+	p.AllowAttrs("id").Matching(regexp.MustCompile(`^`+idPrefix+`[a-z0-9-]+$`)).OnElements("h1", "h2", "h3", "h4", "h5", "h6")
+	return p
+}
+
+/*
+Renders Markdown documents that are shown on the same page, such as the files
+of a snippet, to sanitized HTML. Heading IDs are unique across the documents.
+
+The result is marked as safe HTML for html/template, which is sound only
+because it has been sanitized.
+*/
+func Render(sources ...string) ([]template.HTML, error) {
+	ids := newIDs()
+	out := make([]template.HTML, len(sources))
+
+	for i, src := range sources {
+		var buf bytes.Buffer
+		ctx := parser.NewContext(parser.WithIDs(ids))
+		err := md.Convert([]byte(src), &buf, parser.WithContext(ctx))
+		if err != nil {
+			return nil, fmt.Errorf("markdown: %w", err)
+		}
+		out[i] = template.HTML(policy.SanitizeBytes(buf.Bytes()))
+	}
+
+	return out, nil
+}
+
+// Generates heading IDs, made up of the prefix and the heading's text in lower
+// case, with runs of other characters than ASCII letters and digits replaced
+// by hyphens. Duplicate IDs are given a numeric suffix.
+type ids struct {
+	used map[string]bool
+}
+
+func newIDs() *ids {
+	return &ids{used: map[string]bool{}}
+}
+
+func (s *ids) Generate(value []byte, kind ast.NodeKind) []byte {
+	var b strings.Builder
+	hyphen := false
+	for _, c := range strings.ToLower(string(value)) {
+		if c >= 'a' && c <= 'z' || c >= '0' && c <= '9' {
+			if hyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(c)
+			hyphen = false
+		} else {
+			hyphen = true
+		}
+	}
+
+	base := idPrefix + b.String()
+	if b.Len() == 0 {
+		base = idPrefix + "section"
+	}
+
+	id := base
+	for n := 1; s.used[id]; n++ {
+		id = fmt.Sprintf("%s-%d", base, n)
+	}
+	s.used[id] = true
+
+	return []byte(id)
+}
+
+func (s *ids) Put(value []byte) {
+	s.used[string(value)] = true
+}
+
+// Adds an anchor link to each heading, pointing at the heading's ID.
+type anchorTransformer struct{}
+
+func (anchorTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		heading, ok := n.(*ast.Heading)
+		if !ok || !entering {
+			return ast.WalkContinue, nil
+		}
+
+		id, ok := heading.AttributeString("id")
+		if !ok {
+			return ast.WalkSkipChildren, nil
+		}
+
+		link := ast.NewLink()
+		link.Destination = append([]byte("#"), id.([]byte)...)
+		link.SetAttributeString("class", []byte("anchor"))
+		link.AppendChild(link, ast.NewString([]byte("#")))
+		heading.AppendChild(heading, link)
+
+		return ast.WalkSkipChildren, nil
+	})
+}
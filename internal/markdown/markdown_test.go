@@ -0,0 +1,78 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+
+	assert "github.com/kvnloughead/snippetbox/internal"
+)
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		want    []string
+		notWant []string
+	}{
+		{
+			name: "Fenced code block",
+			src:  "```go\nfmt.Println(\"<hi>\")\n```",
+			want: []string{`<pre><code class="language-go">fmt.Println(&#34;&lt;hi&gt;&#34;)`},
+		},
+		{
+			name: "Heading anchors",
+			src:  "# Getting Started!\n\n## Getting started",
+			want: []string{
+				`<h1 id="md-getting-started">Getting Started!<a href="#md-getting-started" class="anchor"`,
+				`<h2 id="md-getting-started-1">`,
+			},
+		},
+		{
+			name:    "Raw HTML",
+			src:     "<script>alert(1)</script>\n\n<b onclick=\"alert(1)\">bold</b>",
+			notWant: []string{"<script", "onclick", "<b"},
+		},
+		{
+			name:    "Script link",
+			src:     "[click](javascript:alert(1))",
+			notWant: []string{"javascript:"},
+		},
+		{
+			name:    "Image with event handler",
+			src:     `![x](/a.png "t\" onerror=\"alert(1)")`,
+			notWant: []string{"onerror="},
+		},
+		{
+			name: "Table and links",
+			src:  "| a |\n|---|\n| b |\n\nhttps://example.com",
+			want: []string{"<table>", `<a href="https://example.com" rel="nofollow">`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := Render(tt.src)
+			assert.IsNil(t, err)
+			assert.Equal(t, len(out), 1)
+
+			html := string(out[0])
+			for _, want := range tt.want {
+				assert.StringContains(t, html, want)
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(html, notWant) {
+					t.Errorf("got %q; expected not to contain %q", html, notWant)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderUniqueIDs(t *testing.T) {
+	out, err := Render("# Usage", "# Usage", "# ???")
+	assert.IsNil(t, err)
+
+	assert.StringContains(t, string(out[0]), `id="md-usage"`)
+	assert.StringContains(t, string(out[1]), `id="md-usage-1"`)
+	assert.StringContains(t, string(out[2]), `id="md-section"`)
+}
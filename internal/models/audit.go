@@ -0,0 +1,176 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Audit actions, recorded as the Action of an AuditEvent.
+const (
+	AuditSignup         = "user.signup"
+	AuditLogin          = "user.login"
+	AuditLoginFailed    = "user.login_failed"
+	AuditLogout         = "user.logout"
+	AuditPasswordChange = "user.password_changed"
+	AuditPasswordReset  = "user.password_reset"
+	AuditEmailChange    = "user.email_changed"
+	AuditIdentityLinked = "user.identity_linked"
+	AuditPasskeyAdded   = "user.passkey_added"
+	AuditPasskeyRemoved = "user.passkey_removed"
+	AuditUserDisabled   = "user.disabled"
+	AuditUserEnabled    = "user.enabled"
+	AuditUserRole       = "user.role"
+	AuditSnippetDelete  = "snippet.deleted"
+)
+
+// All audit actions, for filtering the audit log by action.
+var AuditActions = []string{
+	AuditSignup,
+	AuditLogin,
+	AuditLoginFailed,
+	AuditLogout,
+	AuditPasswordChange,
+	AuditPasswordReset,
+	AuditEmailChange,
+	AuditIdentityLinked,
+	AuditPasskeyAdded,
+	AuditPasskeyRemoved,
+	AuditUserDisabled,
+	AuditUserEnabled,
+	AuditUserRole,
+	AuditSnippetDelete,
+}
+
+// Type representing an entry in the audit log, which records security-relevant
+// actions, such as an admin disabling an account.
+type AuditEvent struct {
+	ID        int
+	ActorID   int    // the ID of the user who acted, or 0 if they weren't logged in
+	ActorName string // empty if the actor wasn't logged in, or has been deleted
+	Action    string
+	Target    string // what was acted on, such as "user:3" or "snippet:12"
+	Detail    string // more about the action, such as the role a user was given
+	IP        string
+	UserAgent string
+	Created   time.Time
+}
+
+// A wrapper for our sql.DB connection pool.
+// Contains methods for interacting with the audit log. Events can only be
+// added to the log, never changed or removed. The DB enforces this with
+// triggers.
+type AuditModel struct {
+	DB *sql.DB
+}
+
+// Filters for searching the audit log. Empty fields match any event.
+type AuditFilter struct {
+	Action string
+	Actor  string // the email of the user who acted
+	Target string
+}
+
+type AuditModelInterface interface {
+	Insert(event AuditEvent) error
+	Latest(filter AuditFilter, limit int) ([]AuditEvent, error)
+	ForUser(userID, limit int) ([]AuditEvent, error)
+}
+
+// Adds an event to the audit log. Its ID, ActorName and Created fields are
+// ignored.
+func (m *AuditModel) Insert(e AuditEvent) error {
+	query := `INSERT INTO audit_events (actor_id, action, target, detail, ip, user_agent, created)
+	VALUES (NULLIF(?, 0), ?, ?, ?, ?, ?, UTC_TIMESTAMP())`
+
+	_, err := m.DB.Exec(query, e.ActorID, e.Action, e.Target, e.Detail, e.IP, e.UserAgent)
+	return err
+}
+
+// Columns selected by queries that return audit events, in the order expected
+// by scanAuditEvents. Events outlive their actors, so the actor's name is left
+// joined.
+const auditEventColumns = `audit_events.id, COALESCE(audit_events.actor_id, 0),
+	COALESCE(users.name, ''), audit_events.action, audit_events.target,
+	audit_events.detail, audit_events.ip, audit_events.user_agent, audit_events.created`
+
+// Scans the rows returned by a query that selects auditEventColumns.
+func scanAuditEvents(rows *sql.Rows) ([]AuditEvent, error) {
+	defer rows.Close()
+
+	var events []AuditEvent
+	for rows.Next() {
+		var e AuditEvent
+		err := rows.Scan(&e.ID, &e.ActorID, &e.ActorName, &e.Action, &e.Target, &e.Detail, &e.IP, &e.UserAgent, &e.Created)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// Returns the latest events in the audit log that match the filter, up to
+// limit, latest first.
+func (m *AuditModel) Latest(filter AuditFilter, limit int) ([]AuditEvent, error) {
+	var conditions []string
+	var args []any
+
+	if filter.Action != "" {
+		conditions = append(conditions, "audit_events.action = ?")
+		args = append(args, filter.Action)
+	}
+	if filter.Actor != "" {
+		conditions = append(conditions, "users.email = ?")
+		args = append(args, filter.Actor)
+	}
+	if filter.Target != "" {
+		conditions = append(conditions, "audit_events.target = ?")
+		args = append(args, filter.Target)
+	}
+
+	query := `SELECT ` + auditEventColumns + `
+	FROM audit_events
+	LEFT JOIN users ON users.id = audit_events.actor_id`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY audit_events.id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := m.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanAuditEvents(rows)
+}
+
+// Returns the latest events in the audit log that the user took, or that
+// were taken on their account, such as failed attempts to log in to it, up to
+// limit, latest first.
+func (m *AuditModel) ForUser(userID, limit int) ([]AuditEvent, error) {
+	query := `SELECT ` + auditEventColumns + `
+	FROM audit_events
+	LEFT JOIN users ON users.id = audit_events.actor_id
+	WHERE audit_events.actor_id = ? OR audit_events.target = ?
+	ORDER BY audit_events.id DESC LIMIT ?`
+
+	rows, err := m.DB.Query(query, userID, AuditUserTarget(userID), limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanAuditEvents(rows)
+}
+
+// Returns the Target of an AuditEvent for an action on the user's account.
+func AuditUserTarget(userID int) string {
+	return fmt.Sprintf("user:%d", userID)
+}
@@ -0,0 +1,73 @@
+package models
+
+import (
+	"testing"
+
+	assert "github.com/kvnloughead/snippetbox/internal"
+)
+
+func TestAuditModel(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	db := newTestDB(t)
+	m := AuditModel{db}
+
+	err := m.Insert(AuditEvent{ActorID: 1, Action: AuditUserDisabled, Target: "user:2", IP: "127.0.0.1", UserAgent: "test"})
+	assert.IsNil(t, err)
+	err = m.Insert(AuditEvent{ActorID: 999, Action: AuditSnippetDelete, Target: "snippet:1", IP: "127.0.0.1"})
+	assert.IsNil(t, err)
+	err = m.Insert(AuditEvent{Action: AuditLoginFailed, Target: "user:1", Detail: "alice@example.com", IP: "127.0.0.1"})
+	assert.IsNil(t, err)
+
+	events, err := m.Latest(AuditFilter{}, 10)
+	assert.IsNil(t, err)
+	assert.Equal(t, len(events), 3)
+
+	// Events are kept when their actor no longer exists.
+	assert.Equal(t, events[1].Action, AuditSnippetDelete)
+	assert.Equal(t, events[1].ActorName, "")
+	assert.Equal(t, events[2].ActorName, "Alice Jones")
+
+	events, err = m.Latest(AuditFilter{}, 1)
+	assert.IsNil(t, err)
+	assert.Equal(t, len(events), 1)
+
+	t.Run("Filters", func(t *testing.T) {
+		events, err := m.Latest(AuditFilter{Action: AuditSnippetDelete}, 10)
+		assert.IsNil(t, err)
+		assert.Equal(t, len(events), 1)
+
+		events, err = m.Latest(AuditFilter{Actor: "alice@example.com"}, 10)
+		assert.IsNil(t, err)
+		assert.Equal(t, len(events), 1)
+		assert.Equal(t, events[0].Target, "user:2")
+
+		events, err = m.Latest(AuditFilter{Action: AuditLoginFailed, Target: "user:2"}, 10)
+		assert.IsNil(t, err)
+		assert.Equal(t, len(events), 0)
+	})
+
+	t.Run("For user", func(t *testing.T) {
+		// Alice's events include the one she took, and the failed login to her
+		// account.
+		events, err := m.ForUser(1, 10)
+		assert.IsNil(t, err)
+		assert.Equal(t, len(events), 2)
+		assert.Equal(t, events[0].Action, AuditLoginFailed)
+		assert.Equal(t, events[1].Action, AuditUserDisabled)
+	})
+
+	t.Run("Append only", func(t *testing.T) {
+		_, err := db.Exec(`UPDATE audit_events SET action = 'tampered'`)
+		if err == nil {
+			t.Error("expected an error updating an audit event")
+		}
+
+		_, err = db.Exec(`DELETE FROM audit_events`)
+		if err == nil {
+			t.Error("expected an error deleting an audit event")
+		}
+	})
+}
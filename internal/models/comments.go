@@ -0,0 +1,139 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Type representing a comment on a snippet.
+type Comment struct {
+	ID         int
+	SnippetID  int
+	UserID     int
+	AuthorName string
+	Body       string
+	Created    time.Time
+	Updated    time.Time // the zero time if the comment hasn't been edited
+}
+
+// A wrapper for our sql.DB connection pool.
+// Contains methods for interacting with the comments collection.
+type CommentModel struct {
+	DB *sql.DB
+}
+
+type CommentModelInterface interface {
+	Insert(snippetID, userID int, body string) (int, error)
+	Get(id int) (Comment, error)
+	GetForSnippet(snippetID int) ([]Comment, error)
+	Update(id int, body string) error
+	Delete(id int) error
+}
+
+// The columns selected by comment queries, in the order expected by
+// scanComment.
+const commentColumns = `comments.id, comments.snippet_id, comments.user_id,
+	users.name, comments.body, comments.created, comments.updated`
+
+// Scans a row containing commentColumns into a comment.
+func scanComment(row interface{ Scan(...any) error }) (Comment, error) {
+	var c Comment
+	var updated sql.NullTime
+	err := row.Scan(&c.ID, &c.SnippetID, &c.UserID, &c.AuthorName, &c.Body, &c.Created, &updated)
+	c.Updated = updated.Time
+	return c, err
+}
+
+// Inserts a comment by the given user on the snippet with the given ID.
+// Returns the ID of the inserted record.
+func (m *CommentModel) Insert(snippetID, userID int, body string) (int, error) {
+	query := `INSERT INTO comments (snippet_id, user_id, body, created)
+	VALUES (?, ?, ?, UTC_TIMESTAMP())`
+
+	result, err := m.DB.Exec(query, snippetID, userID, body)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// Returns the comment with the given ID. If there's no matching comment,
+// ErrNoRecord is returned.
+func (m *CommentModel) Get(id int) (Comment, error) {
+	query := `SELECT ` + commentColumns + ` FROM comments
+	INNER JOIN users ON users.id = comments.user_id
+	WHERE comments.id = ?`
+
+	c, err := scanComment(m.DB.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Comment{}, ErrNoRecord
+		}
+		return Comment{}, err
+	}
+
+	return c, nil
+}
+
+// Returns the comments on the snippet with the given ID, oldest first.
+func (m *CommentModel) GetForSnippet(snippetID int) ([]Comment, error) {
+	query := `SELECT ` + commentColumns + ` FROM comments
+	INNER JOIN users ON users.id = comments.user_id
+	WHERE comments.snippet_id = ?
+	ORDER BY comments.id`
+
+	rows, err := m.DB.Query(query, snippetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var comments []Comment
+
+	for rows.Next() {
+		c, err := scanComment(rows)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return comments, nil
+}
+
+// Changes the body of a comment, recording when it was edited.
+func (m *CommentModel) Update(id int, body string) error {
+	query := `UPDATE comments SET body = ?, updated = UTC_TIMESTAMP() WHERE id = ?`
+
+	_, err := m.DB.Exec(query, body, id)
+	return err
+}
+
+// Deletes a comment. If there's no matching comment, ErrNoRecord is returned.
+func (m *CommentModel) Delete(id int) error {
+	result, err := m.DB.Exec(`DELETE FROM comments WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"errors"
+	"testing"
+
+	assert "github.com/kvnloughead/snippetbox/internal"
+)
+
+func TestCommentModel(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	db := newTestDB(t)
+	snippets := SnippetModel{db}
+	m := CommentModel{db}
+
+	snippetID, err := snippets.Insert(1, NewSnippet{Title: "Title", Files: oneFile("Content"), Expires: 7}, 0)
+	assert.IsNil(t, err)
+
+	first, err := m.Insert(snippetID, 1, "First")
+	assert.IsNil(t, err)
+	_, err = m.Insert(snippetID, 1, "Second")
+	assert.IsNil(t, err)
+
+	comments, err := m.GetForSnippet(snippetID)
+	assert.IsNil(t, err)
+	assert.Equal(t, len(comments), 2)
+	assert.Equal(t, comments[0].Body, "First")
+	assert.Equal(t, comments[0].AuthorName, "Alice Jones")
+	assert.Equal(t, comments[0].Updated.IsZero(), true)
+
+	err = m.Update(first, "Edited")
+	assert.IsNil(t, err)
+
+	c, err := m.Get(first)
+	assert.IsNil(t, err)
+	assert.Equal(t, c.Body, "Edited")
+	assert.Equal(t, c.Updated.IsZero(), false)
+
+	err = m.Delete(first)
+	assert.IsNil(t, err)
+	err = m.Delete(first)
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+
+	_, err = m.Get(first)
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+
+	// Disabling comments doesn't delete them.
+	err = snippets.SetCommentsDisabled(snippetID, true)
+	assert.IsNil(t, err)
+
+	s, err := snippets.Get(snippetID)
+	assert.IsNil(t, err)
+	assert.Equal(t, s.CommentsDisabled, true)
+
+	comments, err = m.GetForSnippet(snippetID)
+	assert.IsNil(t, err)
+	assert.Equal(t, len(comments), 1)
+}
@@ -8,5 +8,23 @@ var ErrNoRecord = errors.New("models: no matching record found")
 // Occurs when a user with the given email already exists.
 var ErrDuplicateEmail = errors.New("models: duplicate email")
 
+// Occurs when a user with the given handle already exists.
+var ErrDuplicateHandle = errors.New("models: duplicate handle")
+
 // Occurs when login credentials are invalid.
 var ErrInvalidCredentials = errors.New("models: invalid credentials")
+
+// Occurs when the credentials of a disabled account are used to log in.
+var ErrAccountDisabled = errors.New("models: account disabled")
+
+// Occurs when a remember me token that has already been used is presented
+// again, which suggests that it was stolen.
+var ErrTokenReused = errors.New("models: token reused")
+
+// Occurs when removing or demoting a team's last owner, which would leave the
+// team without one.
+var ErrLastOwner = errors.New("models: team's last owner")
+
+// Occurs when linking an account at an identity provider that is already
+// linked to a user.
+var ErrDuplicateIdentity = errors.New("models: duplicate identity")
@@ -0,0 +1,98 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Type representing an account at an OpenID Connect provider that has been
+// linked to a user, so that they can log in with it. Subject is the provider's
+// ID for the account, which unlike its email never changes.
+type Identity struct {
+	Provider string
+	Subject  string
+	UserID   int
+	Created  time.Time
+}
+
+// A wrapper for our sql.DB connection pool.
+// Contains methods for interacting with the user_identities collection.
+type IdentityModel struct {
+	DB *sql.DB
+}
+
+type IdentityModelInterface interface {
+	Insert(provider, subject string, userID int) error
+	Get(provider, subject string) (int, error)
+	ForUser(userID int) ([]Identity, error)
+}
+
+// Links the account with the given subject at the provider to the user. If
+// the account is already linked to a user, a models.ErrDuplicateIdentity error
+// is returned.
+func (m *IdentityModel) Insert(provider, subject string, userID int) error {
+	query := `INSERT INTO user_identities (provider, subject, user_id, created)
+	VALUES (?, ?, ?, UTC_TIMESTAMP())`
+
+	_, err := m.DB.Exec(query, provider, subject, userID)
+	if err != nil {
+		var mySQLError *mysql.MySQLError
+		if errors.As(err, &mySQLError) && mySQLError.Number == 1062 {
+			return ErrDuplicateIdentity
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Returns the ID of the user that the account with the given subject at the
+// provider is linked to. If it isn't linked, a models.ErrNoRecord error is
+// returned.
+func (m *IdentityModel) Get(provider, subject string) (int, error) {
+	var userID int
+
+	query := `SELECT user_id FROM user_identities WHERE provider = ? AND subject = ?`
+
+	err := m.DB.QueryRow(query, provider, subject).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNoRecord
+		} else {
+			return 0, err
+		}
+	}
+
+	return userID, nil
+}
+
+// Returns the accounts linked to the user, ordered by provider.
+func (m *IdentityModel) ForUser(userID int) ([]Identity, error) {
+	query := `SELECT provider, subject, user_id, created FROM user_identities
+	WHERE user_id = ? ORDER BY provider, created`
+
+	rows, err := m.DB.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var identities []Identity
+	for rows.Next() {
+		var i Identity
+		err = rows.Scan(&i.Provider, &i.Subject, &i.UserID, &i.Created)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, i)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return identities, nil
+}
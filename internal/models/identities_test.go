@@ -0,0 +1,40 @@
+package models
+
+import (
+	"errors"
+	"testing"
+
+	assert "github.com/kvnloughead/snippetbox/internal"
+)
+
+func TestIdentityModel(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	db := newTestDB(t)
+	m := IdentityModel{db}
+
+	_, err := m.Get("company", "alice-subject")
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+
+	err = m.Insert("company", "alice-subject", 1)
+	assert.IsNil(t, err)
+
+	userID, err := m.Get("company", "alice-subject")
+	assert.IsNil(t, err)
+	assert.Equal(t, userID, 1)
+
+	// The same subject at another provider is a different account.
+	_, err = m.Get("other", "alice-subject")
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+
+	err = m.Insert("company", "alice-subject", 1)
+	assert.Equal(t, errors.Is(err, ErrDuplicateIdentity), true)
+
+	identities, err := m.ForUser(1)
+	assert.IsNil(t, err)
+	assert.Equal(t, len(identities), 1)
+	assert.Equal(t, identities[0].Provider, "company")
+	assert.Equal(t, identities[0].Subject, "alice-subject")
+}
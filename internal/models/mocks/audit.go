@@ -0,0 +1,68 @@
+package mocks
+
+import (
+	"sync"
+	"time"
+
+	"github.com/kvnloughead/snippetbox/internal/models"
+)
+
+// A mock event in the audit log, of the mock admin disabling a user.
+var mockAuditEvent = models.AuditEvent{
+	ID:        1,
+	ActorID:   4,
+	ActorName: "Admin User",
+	Action:    models.AuditUserDisabled,
+	Target:    "user:6",
+	IP:        "127.0.0.1",
+	UserAgent: "Mock browser",
+	Created:   time.Now(),
+}
+
+// A mock of our audit model, which records the events inserted into it.
+type AuditModel struct {
+	mu     sync.Mutex
+	events []models.AuditEvent
+}
+
+func (m *AuditModel) Insert(event models.AuditEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = append(m.events, event)
+	return nil
+}
+
+// Returns the actions of the events inserted so far, in order.
+func (m *AuditModel) Actions() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	actions := make([]string, len(m.events))
+	for i, e := range m.events {
+		actions[i] = e.Action
+	}
+	return actions
+}
+
+// Returns the mock event, if it matches the filter's action.
+func (m *AuditModel) Latest(filter models.AuditFilter, limit int) ([]models.AuditEvent, error) {
+	if filter.Action != "" && filter.Action != mockAuditEvent.Action {
+		return nil, nil
+	}
+	return []models.AuditEvent{mockAuditEvent}, nil
+}
+
+// Returns the events inserted so far that the user took, or that were taken
+// on their account, latest first.
+func (m *AuditModel) ForUser(userID, limit int) ([]models.AuditEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var events []models.AuditEvent
+	for i := len(m.events) - 1; i >= 0 && len(events) < limit; i-- {
+		e := m.events[i]
+		if e.ActorID == userID || e.Target == models.AuditUserTarget(userID) {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
@@ -0,0 +1,63 @@
+package mocks
+
+import (
+	"time"
+
+	"github.com/kvnloughead/snippetbox/internal/models"
+)
+
+// Comments on mockSnippet, one by its owner and one by the third mock user.
+var mockComments = []models.Comment{
+	{
+		ID:         1,
+		SnippetID:  1,
+		UserID:     1,
+		AuthorName: "User",
+		Body:       "This is a mock comment.",
+		Created:    time.Now(),
+	},
+	{
+		ID:         2,
+		SnippetID:  1,
+		UserID:     3,
+		AuthorName: "Other User",
+		Body:       "This is another mock comment.",
+		Created:    time.Now(),
+		Updated:    time.Now(),
+	},
+}
+
+// A mock of our comment model.
+type CommentModel struct{}
+
+func (m *CommentModel) Insert(snippetID, userID int, body string) (int, error) {
+	return 3, nil
+}
+
+func (m *CommentModel) Get(id int) (models.Comment, error) {
+	for _, c := range mockComments {
+		if c.ID == id {
+			return c, nil
+		}
+	}
+	return models.Comment{}, models.ErrNoRecord
+}
+
+func (m *CommentModel) GetForSnippet(snippetID int) ([]models.Comment, error) {
+	var comments []models.Comment
+	for _, c := range mockComments {
+		if c.SnippetID == snippetID {
+			comments = append(comments, c)
+		}
+	}
+	return comments, nil
+}
+
+func (m *CommentModel) Update(id int, body string) error {
+	return nil
+}
+
+func (m *CommentModel) Delete(id int) error {
+	_, err := m.Get(id)
+	return err
+}
@@ -0,0 +1,55 @@
+package mocks
+
+import (
+	"sync"
+	"time"
+
+	"github.com/kvnloughead/snippetbox/internal/models"
+)
+
+// A mock of our identity model, which records the identities linked with it.
+// It starts with none.
+type IdentityModel struct {
+	mu         sync.Mutex
+	identities []models.Identity
+}
+
+func (m *IdentityModel) Insert(provider, subject string, userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, i := range m.identities {
+		if i.Provider == provider && i.Subject == subject {
+			return models.ErrDuplicateIdentity
+		}
+	}
+	m.identities = append(m.identities, models.Identity{
+		Provider: provider,
+		Subject:  subject,
+		UserID:   userID,
+		Created:  time.Now(),
+	})
+	return nil
+}
+
+func (m *IdentityModel) Get(provider, subject string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, i := range m.identities {
+		if i.Provider == provider && i.Subject == subject {
+			return i.UserID, nil
+		}
+	}
+	return 0, models.ErrNoRecord
+}
+
+func (m *IdentityModel) ForUser(userID int) ([]models.Identity, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var identities []models.Identity
+	for _, i := range m.identities {
+		if i.UserID == userID {
+			identities = append(identities, i)
+		}
+	}
+	return identities, nil
+}
@@ -0,0 +1,62 @@
+package mocks
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	"github.com/kvnloughead/snippetbox/internal/models"
+)
+
+// A mock of our passkey model, which records the passkeys registered with it.
+// It starts with none.
+type PasskeyModel struct {
+	mu       sync.Mutex
+	passkeys []models.Passkey
+}
+
+func (m *PasskeyModel) Insert(p models.Passkey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p.Created = time.Now()
+	p.LastUsed = time.Time{}
+	m.passkeys = append(m.passkeys, p)
+	return nil
+}
+
+func (m *PasskeyModel) ForUser(userID int) ([]models.Passkey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var passkeys []models.Passkey
+	for _, p := range m.passkeys {
+		if p.UserID == userID {
+			passkeys = append(passkeys, p)
+		}
+	}
+	return passkeys, nil
+}
+
+func (m *PasskeyModel) RecordUse(id []byte, signCount uint32, backupState bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, p := range m.passkeys {
+		if bytes.Equal(p.ID, id) {
+			m.passkeys[i].SignCount = signCount
+			m.passkeys[i].BackupState = backupState
+			m.passkeys[i].LastUsed = time.Now()
+		}
+	}
+	return nil
+}
+
+func (m *PasskeyModel) Delete(id []byte, userID int) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, p := range m.passkeys {
+		if bytes.Equal(p.ID, id) && p.UserID == userID {
+			m.passkeys = append(m.passkeys[:i], m.passkeys[i+1:]...)
+			return p.Name, nil
+		}
+	}
+	return "", models.ErrNoRecord
+}
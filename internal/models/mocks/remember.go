@@ -0,0 +1,96 @@
+package mocks
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/kvnloughead/snippetbox/internal/models"
+)
+
+// A mock of our remember token model. Like the session mock, it keeps state,
+// so that tests can check that tokens are rotated and revoked. The zero value
+// is ready to use.
+type RememberTokenModel struct {
+	mu     sync.Mutex
+	nextID int
+	tokens map[string]rememberSeries // keyed by series
+}
+
+type rememberSeries struct {
+	models.RememberToken
+	previous string    // the plaintext of the token that was last replaced
+	rotated  time.Time // when it was replaced
+}
+
+func (m *RememberTokenModel) New(userID int, ttl time.Duration) (models.RememberToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.tokens == nil {
+		m.tokens = make(map[string]rememberSeries)
+	}
+
+	m.nextID++
+	t := models.RememberToken{
+		Series:    fmt.Sprintf("SERIES%d", m.nextID),
+		Plaintext: fmt.Sprintf("TOKEN%d", m.nextID),
+		UserID:    userID,
+		Expires:   time.Now().Add(ttl),
+	}
+	m.tokens[t.Series] = rememberSeries{RememberToken: t}
+
+	return t, nil
+}
+
+func (m *RememberTokenModel) Rotate(series, plaintext string, grace time.Duration) (models.RememberToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.tokens[series]
+	if !ok {
+		return models.RememberToken{}, models.ErrNoRecord
+	}
+
+	if s.Plaintext != plaintext {
+		if s.previous != "" && s.previous == plaintext && time.Since(s.rotated) < grace {
+			t := s.RememberToken
+			t.Plaintext = ""
+			return t, nil
+		}
+
+		for k, other := range m.tokens {
+			if other.UserID == s.UserID {
+				delete(m.tokens, k)
+			}
+		}
+		return models.RememberToken{UserID: s.UserID}, models.ErrTokenReused
+	}
+
+	m.nextID++
+	s.previous, s.rotated = s.Plaintext, time.Now()
+	s.Plaintext = fmt.Sprintf("TOKEN%d", m.nextID)
+	m.tokens[series] = s
+
+	return s.RememberToken, nil
+}
+
+func (m *RememberTokenModel) Delete(series string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.tokens, series)
+	return nil
+}
+
+func (m *RememberTokenModel) DeleteAllForUser(userID int, exceptSeries string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for s, t := range m.tokens {
+		if t.UserID == userID && s != exceptSeries {
+			delete(m.tokens, s)
+		}
+	}
+	return nil
+}
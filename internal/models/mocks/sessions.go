@@ -0,0 +1,89 @@
+package mocks
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/kvnloughead/snippetbox/internal/models"
+)
+
+// A mock of our session model. Unlike the other mocks it keeps state, so that
+// tests can check that revoked sessions are logged out. The zero value is
+// ready to use.
+type SessionModel struct {
+	mu       sync.Mutex
+	nextID   int
+	sessions map[string]models.Session
+}
+
+func (m *SessionModel) Insert(userID int, ip, userAgent string, expires time.Time, rememberSeries string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.sessions == nil {
+		m.sessions = make(map[string]models.Session)
+	}
+
+	m.nextID++
+	id := fmt.Sprintf("SESSION%d", m.nextID)
+	m.sessions[id] = models.Session{
+		ID:             id,
+		UserID:         userID,
+		Created:        time.Now(),
+		LastSeen:       time.Now(),
+		Expires:        expires,
+		IP:             ip,
+		UserAgent:      userAgent,
+		RememberSeries: rememberSeries,
+	}
+
+	return id, nil
+}
+
+func (m *SessionModel) Exists(id string, userID int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[id]
+	return ok && s.UserID == userID, nil
+}
+
+func (m *SessionModel) Touch(id string, ip, userAgent string) error {
+	return nil
+}
+
+func (m *SessionModel) GetAllForUser(userID int) ([]models.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var sessions []models.Session
+	for _, s := range m.sessions {
+		if s.UserID == userID {
+			sessions = append(sessions, s)
+		}
+	}
+	return sessions, nil
+}
+
+func (m *SessionModel) Delete(id string, userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if s, ok := m.sessions[id]; ok && s.UserID == userID {
+		delete(m.sessions, id)
+	}
+	return nil
+}
+
+func (m *SessionModel) DeleteAllForUser(userID int, exceptID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, s := range m.sessions {
+		if s.UserID == userID && id != exceptID {
+			delete(m.sessions, id)
+		}
+	}
+	return nil
+}
@@ -7,24 +7,114 @@ import (
 )
 
 var mockSnippet = models.Snippet{
-	ID:      1,
-	Title:   "Mock snippet",
-	Content: "This is a mock snippet.",
+	ID:     1,
+	UserID: 1,
+	Title:  "Mock snippet",
+	Format: models.FormatPlain,
+	Files: []models.SnippetFile{
+		{Name: "mock.txt", Language: "text", Content: "This is a mock snippet."},
+	},
+	Tags:    []string{"mock"},
+	Forks:   1,
+	Stars:   1,
+	Views:   10,
 	Created: time.Now(),
-	Expires: time.Now(),
+	Expires: time.Now().AddDate(1, 0, 0),
+}
+
+// A fork of mockSnippet, owned by the third mock user.
+var mockFork = models.Snippet{
+	ID:       2,
+	UserID:   3,
+	ParentID: 1,
+	Title:    "Mock snippet",
+	Format:   models.FormatPlain,
+	Files: []models.SnippetFile{
+		{Name: "mock.txt", Language: "text", Content: "This is a forked mock snippet."},
+	},
+	Created: time.Now(),
+	Expires: time.Now().AddDate(1, 0, 0),
+}
+
+// A Markdown snippet, owned by the first mock user, with comments disabled.
+var mockMarkdownSnippet = models.Snippet{
+	ID:               4,
+	UserID:           1,
+	Title:            "Mock notes",
+	Format:           models.FormatMarkdown,
+	CommentsDisabled: true,
+	Files: []models.SnippetFile{
+		{Name: "notes.md", Language: "markdown", Content: "# Mock notes\n\n<script>alert(1)</script>\n\n```go\nfmt.Println()\n```"},
+	},
+	Created: time.Now(),
+	Expires: time.Now().AddDate(1, 0, 0),
+}
+
+// A snippet of the mock team, owned by the third mock user.
+var mockTeamSnippet = models.Snippet{
+	ID:     5,
+	UserID: 3,
+	TeamID: 1,
+	Title:  "Mock team snippet",
+	Format: models.FormatPlain,
+	Files: []models.SnippetFile{
+		{Name: "team.txt", Language: "text", Content: "This is a mock team snippet."},
+	},
+	Created: time.Now(),
+	Expires: time.Now().AddDate(1, 0, 0),
+}
+
+// The revisions of mockSnippet, latest first.
+var mockRevisions = []models.SnippetRevision{
+	{
+		ID:         2,
+		SnippetID:  1,
+		UserID:     1,
+		AuthorName: "User",
+		Title:      "Mock snippet",
+		Files: []models.SnippetFile{
+			{Name: "mock.txt", Language: "text", Content: "This is a mock snippet."},
+		},
+		Created: time.Now(),
+	},
+	{
+		ID:         1,
+		SnippetID:  1,
+		UserID:     1,
+		AuthorName: "User",
+		Title:      "Mock snippet",
+		Files: []models.SnippetFile{
+			{Name: "mock.txt", Language: "text", Content: "This is an old mock snippet."},
+		},
+		Created: time.Now().Add(-time.Hour),
+	},
 }
 
 // A mock of our snippet model.
 type SnippetModel struct{}
 
-func (m *SnippetModel) Insert(title string, content string, expires int) (int, error) {
-	return 2, nil
+func (m *SnippetModel) Insert(userID int, snippet models.NewSnippet, parentID int) (int, error) {
+	return 3, nil
+}
+
+func (m *SnippetModel) InsertMany(userID int, snippets []models.NewSnippet) ([]int, error) {
+	ids := make([]int, len(snippets))
+	for i := range snippets {
+		ids[i] = 3 + i
+	}
+	return ids, nil
 }
 
 func (m *SnippetModel) Get(id int) (models.Snippet, error) {
 	switch id {
 	case 1:
 		return mockSnippet, nil
+	case 2:
+		return mockFork, nil
+	case 4:
+		return mockMarkdownSnippet, nil
+	case 5:
+		return mockTeamSnippet, nil
 	default:
 		return models.Snippet{}, models.ErrNoRecord
 	}
@@ -33,3 +123,105 @@ func (m *SnippetModel) Get(id int) (models.Snippet, error) {
 func (m *SnippetModel) Latest() ([]models.Snippet, error) {
 	return []models.Snippet{mockSnippet}, nil
 }
+
+func (m *SnippetModel) GetAllForUser(userID int) ([]models.Snippet, error) {
+	switch userID {
+	case mockSnippet.UserID:
+		return []models.Snippet{mockSnippet}, nil
+	case mockFork.UserID:
+		return []models.Snippet{mockFork}, nil
+	default:
+		return nil, nil
+	}
+}
+
+func (m *SnippetModel) GetPublicForUser(userID, limit, offset int) ([]models.Snippet, error) {
+	var snippets []models.Snippet
+	switch userID {
+	case mockSnippet.UserID:
+		snippets = []models.Snippet{mockMarkdownSnippet, mockSnippet}
+	case mockFork.UserID:
+		snippets = []models.Snippet{mockFork}
+	}
+
+	if offset >= len(snippets) {
+		return nil, nil
+	}
+	return snippets[offset:min(offset+limit, len(snippets))], nil
+}
+
+func (m *SnippetModel) GetByTag(tag string) ([]models.Snippet, error) {
+	if tag == "mock" {
+		return []models.Snippet{mockSnippet}, nil
+	}
+	return nil, nil
+}
+
+func (m *SnippetModel) GetPageByTag(tag string, limit, offset int) ([]models.Snippet, error) {
+	if tag == "mock" && offset == 0 {
+		return []models.Snippet{mockSnippet}, nil
+	}
+	return nil, nil
+}
+
+func (m *SnippetModel) GetForTeam(teamID int) ([]models.Snippet, error) {
+	if teamID == mockTeamSnippet.TeamID {
+		return []models.Snippet{mockTeamSnippet}, nil
+	}
+	return nil, nil
+}
+
+func (m *SnippetModel) AllTags() ([]models.Tag, error) {
+	return []models.Tag{{Name: "mock", Count: 1}}, nil
+}
+
+func (m *SnippetModel) Update(id, userID int, title string, files []models.SnippetFile) error {
+	if id == mockSnippet.ID {
+		return nil
+	}
+	return models.ErrNoRecord
+}
+
+func (m *SnippetModel) GetRevisions(id int) ([]models.SnippetRevision, error) {
+	if id == mockSnippet.ID {
+		return mockRevisions, nil
+	}
+	return nil, nil
+}
+
+func (m *SnippetModel) GetRevision(id, revisionID int) (models.SnippetRevision, error) {
+	for _, r := range mockRevisions {
+		if r.SnippetID == id && r.ID == revisionID {
+			return r, nil
+		}
+	}
+	return models.SnippetRevision{}, models.ErrNoRecord
+}
+
+func (m *SnippetModel) SetCommentsDisabled(id int, disabled bool) error {
+	return nil
+}
+
+func (m *SnippetModel) Starred(userID int) ([]models.Snippet, error) {
+	if userID == 3 {
+		return []models.Snippet{mockSnippet}, nil
+	}
+	return nil, nil
+}
+
+func (m *SnippetModel) MostStarred(since time.Time, limit int) ([]models.Snippet, error) {
+	return []models.Snippet{mockSnippet}, nil
+}
+
+func (m *SnippetModel) Trending(since time.Time, limit int) ([]models.Snippet, error) {
+	return []models.Snippet{mockSnippet}, nil
+}
+
+func (m *SnippetModel) AddViews(counts map[int]int, at time.Time) error {
+	return nil
+}
+
+func (m *SnippetModel) Delete(id int) error {
+	_, err := m.Get(id)
+	return err
+}
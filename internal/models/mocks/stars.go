@@ -0,0 +1,16 @@
+package mocks
+
+// A mock of our star model. The third mock user has starred mockSnippet.
+type StarModel struct{}
+
+func (m *StarModel) Star(userID, snippetID int) error {
+	return nil
+}
+
+func (m *StarModel) Unstar(userID, snippetID int) error {
+	return nil
+}
+
+func (m *StarModel) Exists(userID, snippetID int) (bool, error) {
+	return userID == 3 && snippetID == 1, nil
+}
@@ -0,0 +1,132 @@
+package mocks
+
+import (
+	"time"
+
+	"github.com/kvnloughead/snippetbox/internal/models"
+)
+
+// A mock team. The first mock user is its owner, and the third mock user is a
+// member. The second mock user isn't in any team.
+var mockTeam = models.Team{
+	ID:      1,
+	Name:    "Mock team",
+	Created: time.Now(),
+}
+
+var mockTeamRoles = map[int]string{
+	1: models.TeamRoleOwner,
+	3: models.TeamRoleMember,
+}
+
+// A mock invitation link to mockTeam.
+const MockInvitation = "mockinvitation"
+
+// A mock of our team model.
+type TeamModel struct{}
+
+func (m *TeamModel) Insert(name string, ownerID int) (int, error) {
+	return 2, nil
+}
+
+func (m *TeamModel) Get(id int) (models.Team, error) {
+	if id == mockTeam.ID {
+		return mockTeam, nil
+	}
+	return models.Team{}, models.ErrNoRecord
+}
+
+func (m *TeamModel) Delete(id int) error {
+	if id == mockTeam.ID {
+		return nil
+	}
+	return models.ErrNoRecord
+}
+
+func (m *TeamModel) ForUser(userID int) ([]models.Team, error) {
+	role, ok := mockTeamRoles[userID]
+	if !ok {
+		return nil, nil
+	}
+	team := mockTeam
+	team.Role = role
+	return []models.Team{team}, nil
+}
+
+func (m *TeamModel) Role(teamID, userID int) (string, error) {
+	role, ok := mockTeamRoles[userID]
+	if teamID != mockTeam.ID || !ok {
+		return "", models.ErrNoRecord
+	}
+	return role, nil
+}
+
+func (m *TeamModel) Members(teamID int) ([]models.TeamMember, error) {
+	if teamID != mockTeam.ID {
+		return nil, nil
+	}
+
+	var members []models.TeamMember
+	for _, u := range mockUsers {
+		if role, ok := mockTeamRoles[u.ID]; ok {
+			members = append(members, models.TeamMember{
+				UserID: u.ID,
+				Name:   u.Name,
+				Email:  u.Email,
+				Role:   role,
+				Joined: time.Now(),
+			})
+		}
+	}
+	return members, nil
+}
+
+func (m *TeamModel) SetRole(teamID, userID int, role string) error {
+	if _, err := m.Role(teamID, userID); err != nil {
+		return err
+	}
+	if userID == 1 && role != models.TeamRoleOwner {
+		return models.ErrLastOwner
+	}
+	return nil
+}
+
+func (m *TeamModel) RemoveMember(teamID, userID int) error {
+	if _, err := m.Role(teamID, userID); err != nil {
+		return err
+	}
+	if userID == 1 {
+		return models.ErrLastOwner
+	}
+	return nil
+}
+
+func (m *TeamModel) NewInvitation(teamID int, role string, ttl time.Duration) (models.TeamInvitation, error) {
+	return models.TeamInvitation{
+		Plaintext: MockInvitation,
+		TeamID:    teamID,
+		Role:      role,
+		Expires:   time.Now().Add(ttl),
+	}, nil
+}
+
+func (m *TeamModel) GetInvitation(plaintext string) (models.TeamInvitation, error) {
+	if plaintext != MockInvitation {
+		return models.TeamInvitation{}, models.ErrNoRecord
+	}
+	return models.TeamInvitation{
+		Plaintext: plaintext,
+		TeamID:    mockTeam.ID,
+		TeamName:  mockTeam.Name,
+		Role:      models.TeamRoleMember,
+		Expires:   time.Now().Add(time.Hour),
+	}, nil
+}
+
+func (m *TeamModel) AcceptInvitation(plaintext string, userID int) (int, error) {
+	inv, err := m.GetInvitation(plaintext)
+	if err != nil {
+		return 0, err
+	}
+	return inv.TeamID, nil
+}
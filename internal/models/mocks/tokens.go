@@ -0,0 +1,47 @@
+package mocks
+
+import (
+	"time"
+
+	"github.com/kvnloughead/snippetbox/internal/models"
+)
+
+// The plaintext of the only valid password reset token known to the mock.
+const ValidPasswordResetToken = "VALIDPASSWORDRESETTOKEN123"
+
+// The plaintext of the only valid email change token known to the mock.
+const ValidEmailChangeToken = "VALIDEMAILCHANGETOKEN12345"
+
+type TokenModel struct{}
+
+func (m *TokenModel) New(userID int, ttl time.Duration, scope string) (models.Token, error) {
+	plaintext := ValidPasswordResetToken
+	if scope == models.ScopeEmailChange {
+		plaintext = ValidEmailChangeToken
+	}
+
+	return models.Token{
+		Plaintext: plaintext,
+		UserID:    userID,
+		Expiry:    time.Now().Add(ttl),
+		Scope:     scope,
+	}, nil
+}
+
+func (m *TokenModel) GetUserID(scope, plaintext string) (int, error) {
+	if scope == models.ScopePasswordReset && plaintext == ValidPasswordResetToken {
+		return 1, nil
+	}
+	if scope == models.ScopeEmailChange && plaintext == ValidEmailChangeToken {
+		return 1, nil
+	}
+	return 0, models.ErrNoRecord
+}
+
+func (m *TokenModel) Consume(scope, plaintext string) (int, error) {
+	return m.GetUserID(scope, plaintext)
+}
+
+func (m *TokenModel) DeleteAllForUser(scope string, userID int) error {
+	return nil
+}
@@ -0,0 +1,41 @@
+package mocks
+
+import (
+	"time"
+
+	"github.com/kvnloughead/snippetbox/internal/models"
+)
+
+// The TOTP secret of the mock user with two-factor authentication enabled.
+const TOTPSecret = "JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"
+
+// The only recovery code accepted by the mock.
+const RecoveryCode = "abcde-fghij"
+
+type TOTPModel struct{}
+
+func (m *TOTPModel) Get(userID int) (models.TOTP, error) {
+	if userID == 2 {
+		return models.TOTP{UserID: 2, Secret: TOTPSecret, Created: time.Now()}, nil
+	}
+	return models.TOTP{}, models.ErrNoRecord
+}
+
+func (m *TOTPModel) Enable(userID int, secret string) ([]string, error) {
+	return []string{RecoveryCode}, nil
+}
+
+func (m *TOTPModel) Disable(userID int) error {
+	return nil
+}
+
+func (m *TOTPModel) UseStep(userID int, step int64) error {
+	return nil
+}
+
+func (m *TOTPModel) UseRecoveryCode(userID int, code string) error {
+	if userID == 2 && code == RecoveryCode {
+		return nil
+	}
+	return models.ErrInvalidCredentials
+}
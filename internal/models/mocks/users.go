@@ -1,52 +1,251 @@
 package mocks
 
 import (
+	"fmt"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/kvnloughead/snippetbox/internal/models"
 )
 
-type UserModel struct{}
+// Mock users. All have the password "pa$$word". The second user has
+// two-factor authentication enabled in the mock TOTPModel. The first user owns
+// the mock snippet. The third user doesn't own anything, and has hidden their
+// profile. The fourth user is an admin, the fifth is a moderator, and the sixth
+// has been disabled.
+var mockUsers = []models.User{
+	{
+		ID:            1,
+		Name:          "User",
+		Handle:        "user",
+		ProfilePublic: true,
+		Email:         "testuser@mail.com",
+		Role:          models.RoleUser,
+		Created:       time.Now(),
+	},
+	{
+		ID:            2,
+		Name:          "TOTP User",
+		Handle:        "totp-user",
+		ProfilePublic: true,
+		Email:         "totpuser@mail.com",
+		Role:          models.RoleUser,
+		Created:       time.Now(),
+	},
+	{
+		ID:      3,
+		Name:    "Other User",
+		Handle:  "other-user",
+		Email:   "otheruser@mail.com",
+		Role:    models.RoleUser,
+		Created: time.Now(),
+	},
+	{
+		ID:            4,
+		Name:          "Admin User",
+		Handle:        "admin-user",
+		ProfilePublic: true,
+		Email:         "admin@mail.com",
+		Role:          models.RoleAdmin,
+		Created:       time.Now(),
+	},
+	{
+		ID:            5,
+		Name:          "Moderator User",
+		Handle:        "moderator-user",
+		ProfilePublic: true,
+		Email:         "moderator@mail.com",
+		Role:          models.RoleModerator,
+		Created:       time.Now(),
+	},
+	{
+		ID:            6,
+		Name:          "Disabled User",
+		Handle:        "disabled-user",
+		ProfilePublic: true,
+		Email:         "disabled@mail.com",
+		Role:          models.RoleUser,
+		Disabled:      true,
+		Created:       time.Now(),
+	},
+}
 
-func (m *UserModel) Insert(name, email, password string) error {
-	switch email {
-	case "dupe@mail.com":
-		return models.ErrDuplicateEmail
-	default:
-		return nil
+// A mock of our user model. Users inserted into it are added to the mock
+// users, with IDs starting from 7. Changes to users, such as to their names
+// and emails, or disabling them, are kept, and returned in place of the mock
+// users.
+type UserModel struct {
+	mu       sync.Mutex
+	inserted []models.User
+	changed  map[int]models.User
+}
+
+func (m *UserModel) Insert(name, email, password string) (int, error) {
+	if email == "dupe@mail.com" {
+		return 0, models.ErrDuplicateEmail
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id := len(mockUsers) + len(m.inserted) + 1
+	u := models.User{
+		ID:            id,
+		Name:          name,
+		Email:         email,
+		Handle:        fmt.Sprintf("user-%d", id),
+		ProfilePublic: true,
+		Role:          models.RoleUser,
+		Created:       time.Now(),
+	}
+	m.inserted = append(m.inserted, u)
+	return u.ID, nil
+}
+
+// Returns the mock users, followed by the inserted users, with any changes.
+func (m *UserModel) users() []models.User {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	users := append(slices.Clone(mockUsers), m.inserted...)
+	for i, u := range users {
+		if c, ok := m.changed[u.ID]; ok {
+			users[i] = c
+		}
+	}
+	return users
+}
+
+// Applies the change to the user, and keeps the result.
+func (m *UserModel) change(id int, change func(u *models.User)) error {
+	u, err := m.Get(id)
+	if err != nil {
+		return err
+	}
+	change(&u)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.changed == nil {
+		m.changed = map[int]models.User{}
 	}
+	m.changed[id] = u
+	return nil
 }
 
 func (m *UserModel) Authenticate(email string, password string) (int, error) {
-	if email == "testuser@mail.com" && password == "pa$$word" {
-		return 1, nil
+	for _, u := range m.users() {
+		if email == u.Email && password == "pa$$word" {
+			if u.Disabled {
+				return 0, models.ErrAccountDisabled
+			}
+			return u.ID, nil
+		}
 	}
 	return 0, models.ErrInvalidCredentials
 }
 
 func (m *UserModel) Exists(id int) (bool, error) {
-	switch id {
-	case 1:
-		return true, nil
-	default:
-		return false, nil
-	}
+	_, err := m.Get(id)
+	return err == nil, nil
 }
 
 func (m *UserModel) Get(id int) (models.User, error) {
-	if id == 1 {
-		u := models.User{
-			ID:      1,
-			Name:    "User",
-			Email:   "testuser@mail.com",
-			Created: time.Now(),
+	for _, u := range m.users() {
+		if u.ID == id {
+			return u, nil
+		}
+	}
+	return models.User{}, models.ErrNoRecord
+}
+
+func (m *UserModel) GetByEmail(email string) (models.User, error) {
+	for _, u := range m.users() {
+		if u.Email == email {
+			return u, nil
 		}
-		return u, nil
-	} else {
-		return models.User{}, models.ErrNoRecord
 	}
+	return models.User{}, models.ErrNoRecord
+}
+
+func (m *UserModel) GetByHandle(handle string) (models.User, error) {
+	for _, u := range m.users() {
+		if u.Handle == handle {
+			return u, nil
+		}
+	}
+	return models.User{}, models.ErrNoRecord
 }
 
 func (m *UserModel) PasswordUpdate(id int, password string) error {
 	return nil
 }
+
+func (m *UserModel) NameUpdate(id int, name string) error {
+	return m.change(id, func(u *models.User) { u.Name = name })
+}
+
+func (m *UserModel) SetPendingEmail(id int, email string) error {
+	if u, err := m.GetByEmail(email); err == nil && u.ID != id {
+		return models.ErrDuplicateEmail
+	}
+	return m.change(id, func(u *models.User) { u.PendingEmail = email })
+}
+
+func (m *UserModel) ClearPendingEmail(id int) error {
+	return m.change(id, func(u *models.User) { u.PendingEmail = "" })
+}
+
+func (m *UserModel) ConfirmPendingEmail(id int) (string, error) {
+	u, err := m.Get(id)
+	if err != nil {
+		return "", err
+	}
+	if u.PendingEmail == "" {
+		return "", models.ErrNoRecord
+	}
+	if _, err := m.GetByEmail(u.PendingEmail); err == nil {
+		return "", models.ErrDuplicateEmail
+	}
+
+	email := u.PendingEmail
+	err = m.change(id, func(u *models.User) { u.Email, u.PendingEmail = email, "" })
+	return email, err
+}
+
+func (m *UserModel) SetHandle(id int, handle string) error {
+	if u, err := m.GetByHandle(handle); err == nil && u.ID != id {
+		return models.ErrDuplicateHandle
+	}
+	return m.change(id, func(u *models.User) { u.Handle = handle })
+}
+
+func (m *UserModel) SetProfilePublic(id int, public bool) error {
+	return m.change(id, func(u *models.User) { u.ProfilePublic = public })
+}
+
+func (m *UserModel) SetAvatar(id int, avatar string) error {
+	return m.change(id, func(u *models.User) { u.Avatar = avatar })
+}
+
+func (m *UserModel) Delete(id int, deleteSnippets bool) error {
+	if _, err := m.Get(id); err != nil {
+		return err
+	}
+	// The first mock user is the only owner of the mock team.
+	if id == 1 {
+		return models.ErrLastOwner
+	}
+	return nil
+}
+
+func (m *UserModel) All() ([]models.User, error) {
+	return m.users(), nil
+}
+
+func (m *UserModel) SetRole(id int, role string) error {
+	return nil
+}
+
+func (m *UserModel) SetDisabled(id int, disabled bool) error {
+	return m.change(id, func(u *models.User) { u.Disabled = disabled })
+}
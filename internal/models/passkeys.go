@@ -0,0 +1,133 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Type representing a passkey, a WebAuthn credential that a user has
+// registered to log in with instead of their password. ID is the credential
+// ID chosen by the authenticator, and PublicKey is the COSE encoded public key
+// that it signs logins with.
+//
+// SignCount is the authenticator's signature counter as of the last login. An
+// authenticator whose counter goes backwards may have been cloned.
+type Passkey struct {
+	ID              []byte
+	UserID          int
+	Name            string
+	PublicKey       []byte
+	AttestationType string
+	Transports      []string
+	AAGUID          []byte
+	SignCount       uint32
+	BackupEligible  bool
+	BackupState     bool
+	Created         time.Time
+	LastUsed        time.Time // the zero time if the passkey hasn't been used
+}
+
+// A wrapper for our sql.DB connection pool.
+// Contains methods for interacting with the webauthn_credentials collection.
+type PasskeyModel struct {
+	DB *sql.DB
+}
+
+type PasskeyModelInterface interface {
+	Insert(p Passkey) error
+	ForUser(userID int) ([]Passkey, error)
+	RecordUse(id []byte, signCount uint32, backupState bool) error
+	Delete(id []byte, userID int) (string, error)
+}
+
+// Stores a newly registered passkey. Its Created and LastUsed fields are
+// ignored.
+func (m *PasskeyModel) Insert(p Passkey) error {
+	query := `INSERT INTO webauthn_credentials (id, user_id, name, public_key,
+		attestation_type, transports, aaguid, sign_count, backup_eligible,
+		backup_state, created)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, UTC_TIMESTAMP())`
+
+	_, err := m.DB.Exec(query, p.ID, p.UserID, p.Name, p.PublicKey,
+		p.AttestationType, strings.Join(p.Transports, ","), p.AAGUID, p.SignCount,
+		p.BackupEligible, p.BackupState)
+	return err
+}
+
+// Returns the user's passkeys, ordered by when they were registered.
+func (m *PasskeyModel) ForUser(userID int) ([]Passkey, error) {
+	query := `SELECT id, user_id, name, public_key, attestation_type, transports,
+		aaguid, sign_count, backup_eligible, backup_state, created, last_used
+	FROM webauthn_credentials WHERE user_id = ? ORDER BY created, name`
+
+	rows, err := m.DB.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var passkeys []Passkey
+	for rows.Next() {
+		var p Passkey
+		var transports string
+		var lastUsed sql.NullTime
+		err = rows.Scan(&p.ID, &p.UserID, &p.Name, &p.PublicKey, &p.AttestationType,
+			&transports, &p.AAGUID, &p.SignCount, &p.BackupEligible, &p.BackupState,
+			&p.Created, &lastUsed)
+		if err != nil {
+			return nil, err
+		}
+		if transports != "" {
+			p.Transports = strings.Split(transports, ",")
+		}
+		p.LastUsed = lastUsed.Time
+		passkeys = append(passkeys, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return passkeys, nil
+}
+
+// Records a login with the passkey, saving the authenticator's new signature
+// counter and backup state.
+func (m *PasskeyModel) RecordUse(id []byte, signCount uint32, backupState bool) error {
+	query := `UPDATE webauthn_credentials
+	SET sign_count = ?, backup_state = ?, last_used = UTC_TIMESTAMP()
+	WHERE id = ?`
+
+	_, err := m.DB.Exec(query, signCount, backupState, id)
+	return err
+}
+
+// Deletes one of the user's passkeys, and returns its name. If the user has no
+// such passkey, a models.ErrNoRecord error is returned.
+func (m *PasskeyModel) Delete(id []byte, userID int) (string, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var name string
+	query := `SELECT name FROM webauthn_credentials WHERE id = ? AND user_id = ? FOR UPDATE`
+	err = tx.QueryRow(query, id, userID).Scan(&name)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrNoRecord
+		} else {
+			return "", err
+		}
+	}
+
+	_, err = tx.Exec(`DELETE FROM webauthn_credentials WHERE id = ?`, id)
+	if err != nil {
+		return "", err
+	}
+
+	return name, tx.Commit()
+}
@@ -0,0 +1,64 @@
+package models
+
+import (
+	"errors"
+	"testing"
+
+	assert "github.com/kvnloughead/snippetbox/internal"
+)
+
+func TestPasskeyModel(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	db := newTestDB(t)
+	m := PasskeyModel{db}
+
+	passkeys, err := m.ForUser(1)
+	assert.IsNil(t, err)
+	assert.Equal(t, len(passkeys), 0)
+
+	err = m.Insert(Passkey{
+		ID:              []byte{1, 2, 3},
+		UserID:          1,
+		Name:            "Laptop",
+		PublicKey:       []byte{4, 5, 6},
+		AttestationType: "none",
+		Transports:      []string{"internal", "hybrid"},
+		AAGUID:          make([]byte, 16),
+		SignCount:       1,
+		BackupEligible:  true,
+	})
+	assert.IsNil(t, err)
+
+	passkeys, err = m.ForUser(1)
+	assert.IsNil(t, err)
+	assert.Equal(t, len(passkeys), 1)
+	assert.Equal(t, string(passkeys[0].ID), string([]byte{1, 2, 3}))
+	assert.Equal(t, passkeys[0].Name, "Laptop")
+	assert.Equal(t, len(passkeys[0].Transports), 2)
+	assert.Equal(t, passkeys[0].SignCount, uint32(1))
+	assert.Equal(t, passkeys[0].LastUsed.IsZero(), true)
+
+	err = m.RecordUse([]byte{1, 2, 3}, 5, true)
+	assert.IsNil(t, err)
+
+	passkeys, err = m.ForUser(1)
+	assert.IsNil(t, err)
+	assert.Equal(t, passkeys[0].SignCount, uint32(5))
+	assert.Equal(t, passkeys[0].BackupState, true)
+	assert.Equal(t, passkeys[0].LastUsed.IsZero(), false)
+
+	// Users can only delete their own passkeys.
+	_, err = m.Delete([]byte{1, 2, 3}, 2)
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+
+	name, err := m.Delete([]byte{1, 2, 3}, 1)
+	assert.IsNil(t, err)
+	assert.Equal(t, name, passkeys[0].Name)
+
+	passkeys, err = m.ForUser(1)
+	assert.IsNil(t, err)
+	assert.Equal(t, len(passkeys), 0)
+}
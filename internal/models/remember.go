@@ -0,0 +1,166 @@
+package models
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+/*
+Type representing a "remember me" token, which logs the user back in after
+their session has expired.
+
+Tokens belong to a series, which is created when the user logs in and lasts
+until the token expires. Each time the token is used, it is replaced with a new
+one from the same series. If an old token from the series is presented, it must
+have been copied, so the whole series is revoked. The exception is the token
+that was just replaced, which is still accepted for a short grace period, as
+requests sent at the same time by the same browser all carry it.
+
+Only the SHA-256 hash of the token is stored in the DB. The plaintext is only
+available when the token is created or rotated.
+*/
+type RememberToken struct {
+	Series    string
+	Plaintext string
+	UserID    int
+	Expires   time.Time
+}
+
+// A wrapper for our sql.DB connection pool.
+// Contains methods for interacting with the remember_tokens collection.
+type RememberTokenModel struct {
+	DB *sql.DB
+}
+
+type RememberTokenModelInterface interface {
+	New(userID int, ttl time.Duration) (RememberToken, error)
+	Rotate(series, plaintext string, grace time.Duration) (RememberToken, error)
+	Delete(series string) error
+	DeleteAllForUser(userID int, exceptSeries string) error
+}
+
+// Starts a new series for the user and returns its first token.
+func (m *RememberTokenModel) New(userID int, ttl time.Duration) (RememberToken, error) {
+	series, err := randomString()
+	if err != nil {
+		return RememberToken{}, err
+	}
+
+	plaintext, err := randomString()
+	if err != nil {
+		return RememberToken{}, err
+	}
+
+	t := RememberToken{
+		Series:    series,
+		Plaintext: plaintext,
+		UserID:    userID,
+		Expires:   time.Now().Add(ttl).UTC(),
+	}
+
+	query := `INSERT INTO remember_tokens (series, user_id, hash, created, last_used, expires)
+	VALUES (?, ?, ?, UTC_TIMESTAMP(), UTC_TIMESTAMP(), ?)`
+
+	_, err = m.DB.Exec(query, t.Series, t.UserID, hashToken(t.Plaintext), t.Expires)
+	if err != nil {
+		return RememberToken{}, err
+	}
+
+	return t, nil
+}
+
+/*
+Uses up the token, replacing it with a new token from the same series. The new
+token expires at the same time as the series.
+
+If the token is the one that the series' current token replaced, less than
+grace ago, the series is left as it is, and a RememberToken without a Plaintext
+is returned. The client should keep the current token, which it was sent in
+response to the request that replaced this one.
+
+If the series doesn't exist or has expired, a models.ErrNoRecord error is
+returned. If the series exists but the token doesn't match, all of the user's
+series are deleted, and a models.ErrTokenReused error is returned along with a
+RememberToken containing the user's ID.
+*/
+func (m *RememberTokenModel) Rotate(series, plaintext string, grace time.Duration) (RememberToken, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return RememberToken{}, err
+	}
+	defer tx.Rollback()
+
+	t := RememberToken{Series: series}
+	var hash, previousHash []byte
+	var inGrace bool
+
+	query := `SELECT user_id, hash, previous_hash, expires,
+	last_used > UTC_TIMESTAMP() - INTERVAL ? SECOND
+	FROM remember_tokens
+	WHERE series = ? AND expires > UTC_TIMESTAMP() FOR UPDATE`
+
+	err = tx.QueryRow(query, int(grace.Seconds()), series).Scan(&t.UserID, &hash, &previousHash, &t.Expires, &inGrace)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return RememberToken{}, ErrNoRecord
+		} else {
+			return RememberToken{}, err
+		}
+	}
+
+	if subtle.ConstantTimeCompare(hash, hashToken(plaintext)) != 1 {
+		if inGrace && subtle.ConstantTimeCompare(previousHash, hashToken(plaintext)) == 1 {
+			return t, nil
+		}
+
+		_, err = tx.Exec(`DELETE FROM remember_tokens WHERE user_id = ?`, t.UserID)
+		if err != nil {
+			return RememberToken{}, err
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return RememberToken{}, err
+		}
+
+		return RememberToken{UserID: t.UserID}, ErrTokenReused
+	}
+
+	t.Plaintext, err = randomString()
+	if err != nil {
+		return RememberToken{}, err
+	}
+
+	query = `UPDATE remember_tokens
+	SET previous_hash = hash, hash = ?, last_used = UTC_TIMESTAMP()
+	WHERE series = ?`
+
+	_, err = tx.Exec(query, hashToken(t.Plaintext), series)
+	if err != nil {
+		return RememberToken{}, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return RememberToken{}, err
+	}
+
+	return t, nil
+}
+
+// Deletes a series, so its tokens can no longer be used.
+func (m *RememberTokenModel) Delete(series string) error {
+	_, err := m.DB.Exec(`DELETE FROM remember_tokens WHERE series = ?`, series)
+	return err
+}
+
+// Deletes all of the user's series, apart from exceptSeries. Pass an empty
+// exceptSeries to delete all of them.
+func (m *RememberTokenModel) DeleteAllForUser(userID int, exceptSeries string) error {
+	query := `DELETE FROM remember_tokens WHERE user_id = ? AND series <> ?`
+
+	_, err := m.DB.Exec(query, userID, exceptSeries)
+	return err
+}
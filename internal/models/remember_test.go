@@ -0,0 +1,51 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	assert "github.com/kvnloughead/snippetbox/internal"
+)
+
+func TestRememberTokenModelRotate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	db := newTestDB(t)
+	m := RememberTokenModel{db}
+
+	first, err := m.New(1, time.Hour)
+	assert.IsNil(t, err)
+
+	second, err := m.Rotate(first.Series, first.Plaintext, time.Minute)
+	assert.IsNil(t, err)
+	assert.Equal(t, second.UserID, 1)
+	assert.Equal(t, second.Series, first.Series)
+
+	// The first token is still accepted during the grace period, but isn't
+	// replaced again.
+	again, err := m.Rotate(first.Series, first.Plaintext, time.Minute)
+	assert.IsNil(t, err)
+	assert.Equal(t, again.UserID, 1)
+	assert.Equal(t, again.Plaintext, "")
+
+	_, err = m.Rotate(first.Series, first.Plaintext, 0)
+	assert.Equal(t, errors.Is(err, ErrTokenReused), true)
+
+	// Presenting an older token revokes the whole series.
+	first, err = m.New(1, time.Hour)
+	assert.IsNil(t, err)
+	second, err = m.Rotate(first.Series, first.Plaintext, time.Minute)
+	assert.IsNil(t, err)
+	_, err = m.Rotate(second.Series, second.Plaintext, time.Minute)
+	assert.IsNil(t, err)
+
+	stolen, err := m.Rotate(first.Series, first.Plaintext, time.Minute)
+	assert.Equal(t, errors.Is(err, ErrTokenReused), true)
+	assert.Equal(t, stolen.UserID, 1)
+
+	_, err = m.Rotate(second.Series, second.Plaintext, time.Minute)
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+}
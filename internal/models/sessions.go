@@ -0,0 +1,136 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Type representing a logged in session, as shown to the user on their
+// sessions page.
+//
+// The ID identifies the session to the user, and is stored in the session
+// data. It is not the session token, so it is safe to display.
+//
+// RememberSeries is the series of the remember me token associated with the
+// session, if any, so that it can be revoked along with the session.
+type Session struct {
+	ID             string
+	UserID         int
+	Created        time.Time
+	LastSeen       time.Time
+	Expires        time.Time
+	IP             string
+	UserAgent      string
+	RememberSeries string
+}
+
+// A wrapper for our sql.DB connection pool.
+// Contains methods for interacting with the user_sessions collection.
+type SessionModel struct {
+	DB *sql.DB
+}
+
+type SessionModelInterface interface {
+	Insert(userID int, ip, userAgent string, expires time.Time, rememberSeries string) (string, error)
+	Exists(id string, userID int) (bool, error)
+	Touch(id string, ip, userAgent string) error
+	GetAllForUser(userID int) ([]Session, error)
+	Delete(id string, userID int) error
+	DeleteAllForUser(userID int, exceptID string) error
+}
+
+// Records a new session for the user, returning its ID. The user's expired
+// sessions are deleted at the same time.
+func (m *SessionModel) Insert(userID int, ip, userAgent string, expires time.Time, rememberSeries string) (string, error) {
+	id, err := randomString()
+	if err != nil {
+		return "", err
+	}
+
+	query := `INSERT INTO user_sessions (id, user_id, created, last_seen, expires, ip, user_agent, remember_series)
+	VALUES (?, ?, UTC_TIMESTAMP(), UTC_TIMESTAMP(), ?, ?, ?, ?)`
+
+	_, err = m.DB.Exec(query, id, userID, expires.UTC(), ip, userAgent, rememberSeries)
+	if err != nil {
+		return "", err
+	}
+
+	query = `DELETE FROM user_sessions WHERE user_id = ? AND expires <= UTC_TIMESTAMP()`
+
+	_, err = m.DB.Exec(query, userID)
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// Returns true if the session exists, belongs to the user and hasn't expired.
+// A session stops existing when it is revoked.
+func (m *SessionModel) Exists(id string, userID int) (bool, error) {
+	var exists bool
+
+	query := `SELECT EXISTS(SELECT true FROM user_sessions
+	WHERE id = ? AND user_id = ? AND expires > UTC_TIMESTAMP())`
+
+	err := m.DB.QueryRow(query, id, userID).Scan(&exists)
+	return exists, err
+}
+
+// Updates the time the session was last seen, and the IP and user agent it
+// was last seen with.
+func (m *SessionModel) Touch(id string, ip, userAgent string) error {
+	query := `UPDATE user_sessions SET last_seen = UTC_TIMESTAMP(), ip = ?, user_agent = ?
+	WHERE id = ?`
+
+	_, err := m.DB.Exec(query, ip, userAgent, id)
+	return err
+}
+
+// Returns the user's unexpired sessions, most recently seen first.
+func (m *SessionModel) GetAllForUser(userID int) ([]Session, error) {
+	query := `SELECT id, user_id, created, last_seen, expires, ip, user_agent, remember_series
+	FROM user_sessions WHERE user_id = ? AND expires > UTC_TIMESTAMP()
+	ORDER BY last_seen DESC`
+
+	rows, err := m.DB.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []Session
+
+	for rows.Next() {
+		var s Session
+		err = rows.Scan(&s.ID, &s.UserID, &s.Created, &s.LastSeen, &s.Expires, &s.IP, &s.UserAgent, &s.RememberSeries)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// Revokes one of the user's sessions. Sessions belonging to other users are
+// left alone.
+func (m *SessionModel) Delete(id string, userID int) error {
+	query := `DELETE FROM user_sessions WHERE id = ? AND user_id = ?`
+
+	_, err := m.DB.Exec(query, id, userID)
+	return err
+}
+
+// Revokes all of the user's sessions, apart from the one with ID exceptID.
+// Pass an empty exceptID to revoke every session.
+func (m *SessionModel) DeleteAllForUser(userID int, exceptID string) error {
+	query := `DELETE FROM user_sessions WHERE user_id = ? AND id <> ?`
+
+	_, err := m.DB.Exec(query, userID, exceptID)
+	return err
+}
@@ -2,17 +2,73 @@ package models
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"strings"
 	"time"
 )
 
 // Type representing a snippet document.
 type Snippet struct {
-	ID      int
-	Title   string
-	Content string
-	Created time.Time
-	Expires time.Time
+	ID       int
+	UserID   int // 0 if the snippet has no owner
+	TeamID   int // the ID of the team the snippet belongs to, or 0 if it's public
+	Title    string
+	Format   string // FormatPlain or FormatMarkdown
+	Files    []SnippetFile
+	Tags     []string
+	ParentID int // the ID of the snippet this was forked from, or 0
+	Forks    int // the number of snippets forked from this one
+	Stars    int // the number of users who have starred this snippet
+	Views    int // the number of times this snippet has been viewed
+	// If true, comments can't be posted or edited, but can still be deleted.
+	CommentsDisabled bool
+	Created          time.Time
+	Expires          time.Time
+}
+
+// The formats of a snippet's files. Markdown snippets are rendered as HTML when
+// they are viewed, and plain snippets are shown as they are.
+const (
+	FormatPlain    = "plain"
+	FormatMarkdown = "markdown"
+)
+
+// Type representing one of a snippet's files. A snippet's file names are
+// unique.
+type SnippetFile struct {
+	Name     string `json:"name"`
+	Language string `json:"language"`
+	Content  string `json:"content"`
+}
+
+// Returns the snippet's file with the given name. If there is no such file,
+// ok is false.
+func (s Snippet) File(name string) (f SnippetFile, ok bool) {
+	for _, f := range s.Files {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return SnippetFile{}, false
+}
+
+// Type representing a tag, and the number of unexpired snippets that have it.
+type Tag struct {
+	Name  string
+	Count int
+}
+
+// Type representing an immutable revision of a snippet's title and files.
+// A revision is recorded whenever a snippet is created or edited.
+type SnippetRevision struct {
+	ID         int
+	SnippetID  int
+	UserID     int    // 0 if the author has no account
+	AuthorName string // empty if the author has no account
+	Title      string
+	Files      []SnippetFile
+	Created    time.Time
 }
 
 // A wrapper for our sql.DB connection pool.
@@ -22,24 +78,124 @@ type SnippetModel struct {
 }
 
 type SnippetModelInterface interface {
-	Insert(title string, content string, expires int) (int, error)
+	Insert(userID int, snippet NewSnippet, parentID int) (int, error)
+	InsertMany(userID int, snippets []NewSnippet) ([]int, error)
 	Get(id int) (Snippet, error)
 	Latest() ([]Snippet, error)
+	GetAllForUser(userID int) ([]Snippet, error)
+	GetPublicForUser(userID, limit, offset int) ([]Snippet, error)
+	GetByTag(tag string) ([]Snippet, error)
+	GetPageByTag(tag string, limit, offset int) ([]Snippet, error)
+	GetForTeam(teamID int) ([]Snippet, error)
+	Starred(userID int) ([]Snippet, error)
+	MostStarred(since time.Time, limit int) ([]Snippet, error)
+	Trending(since time.Time, limit int) ([]Snippet, error)
+	AddViews(counts map[int]int, at time.Time) error
+	AllTags() ([]Tag, error)
+	Update(id, userID int, title string, files []SnippetFile) error
+	GetRevisions(id int) ([]SnippetRevision, error)
+	GetRevision(id, revisionID int) (SnippetRevision, error)
+	SetCommentsDisabled(id int, disabled bool) error
+	Delete(id int) error
+}
+
+// The columns selected by snippet queries, in the order expected by
+// scanSnippet. Snippets created before snippets had owners have a NULL
+// user_id, snippets that aren't forks have a NULL parent_id, and public
+// snippets have a NULL team_id, which COALESCE turns into 0.
+const snippetColumns = `snippets.id, COALESCE(snippets.user_id, 0),
+	COALESCE(snippets.team_id, 0), snippets.title,
+	snippets.format, COALESCE(snippets.parent_id, 0),
+	(SELECT COUNT(*) FROM snippets AS forks WHERE forks.parent_id = snippets.id),
+	(SELECT COUNT(*) FROM stars WHERE stars.snippet_id = snippets.id),
+	snippets.views, snippets.comments_disabled, snippets.created, snippets.expires`
+
+// Scans a row containing snippetColumns into a snippet.
+func scanSnippet(row interface{ Scan(...any) error }) (Snippet, error) {
+	var s Snippet
+	err := row.Scan(&s.ID, &s.UserID, &s.TeamID, &s.Title, &s.Format, &s.ParentID, &s.Forks,
+		&s.Stars, &s.Views, &s.CommentsDisabled, &s.Created, &s.Expires)
+	return s, err
+}
+
+// A snippet to be inserted by Insert or InsertMany.
+type NewSnippet struct {
+	TeamID  int // the team the snippet belongs to, or 0 if it's public
+	Title   string
+	Format  string // FormatPlain if empty
+	Files   []SnippetFile
+	Tags    []string
+	Expires int // the number of days until the snippet expires
 }
 
-// Inserts a new snippet into the DB.
+// Inserts a new snippet into the DB, along with its files and tags. Tags that
+// don't exist yet are created. If the snippet is a fork, parentID is the ID of the
+// snippet it was forked from, otherwise it is 0.
 // Returns the ID of the inserted record or an error.
-func (m *SnippetModel) Insert(
-	title string,
-	content string,
-	expires int) (int, error) {
+func (m *SnippetModel) Insert(userID int, snippet NewSnippet, parentID int) (int, error) {
+
+	// The snippet, its files and its tags are inserted in a transaction, so that
+	// a snippet is never saved without them.
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	// Rollback is a no-op if the transaction has already been committed.
+	defer tx.Rollback()
+
+	id, err := insertSnippet(tx, userID, snippet, parentID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
 
+	return id, nil
+}
+
+// Inserts several snippets owned by the same user in a single transaction, so
+// that either all of them are inserted or none are.
+// Returns the IDs of the inserted records, in order, or an error.
+func (m *SnippetModel) InsertMany(userID int, snippets []NewSnippet) ([]int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	ids := make([]int, len(snippets))
+	for i, s := range snippets {
+		ids[i], err = insertSnippet(tx, userID, s, 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// Inserts a snippet, its files, its first revision and its tags, as part of a
+// transaction. Returns the ID of the inserted record.
+func insertSnippet(tx *sql.Tx, userID int, s NewSnippet, parentID int) (int, error) {
 	// The query to be executed. Query statements allow for '?' as placeholders.
-	query := `INSERT INTO snippets (title, content, created, expires)
-	VALUES(?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY))`
+	query := `INSERT INTO snippets (user_id, team_id, parent_id, title, format, created, expires)
+	VALUES(?, NULLIF(?, 0), NULLIF(?, 0), ?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY))`
+
+	format := s.Format
+	if format == "" {
+		format = FormatPlain
+	}
 
 	// Execute query. Exec accepts variadic values for the query placeholders.
-	result, err := m.DB.Exec(query, title, content, expires)
+	result, err := tx.Exec(query, userID, s.TeamID, parentID, s.Title, format, s.Expires)
 	if err != nil {
 		return 0, err
 	}
@@ -50,13 +206,39 @@ func (m *SnippetModel) Insert(
 		return 0, err
 	}
 
+	err = insertFiles(tx, int(id), s.Files)
+	if err != nil {
+		return 0, err
+	}
+
+	err = insertRevision(tx, int(id), userID, s.Title, s.Files)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, tag := range s.Tags {
+		// INSERT IGNORE does nothing if the tag already exists.
+		_, err = tx.Exec(`INSERT IGNORE INTO tags (name) VALUES (?)`, tag)
+		if err != nil {
+			return 0, err
+		}
+
+		query = `INSERT INTO snippet_tags (snippet_id, tag_id)
+		SELECT ?, id FROM tags WHERE name = ?`
+
+		_, err = tx.Exec(query, id, tag)
+		if err != nil {
+			return 0, err
+		}
+	}
+
 	return int(id), nil
 }
 
 // Get a snippet by its ID.
 // If no matching snippet is found, a models.ErrNoRecord error is returned.
 func (m *SnippetModel) Get(id int) (Snippet, error) {
-	query := `SELECT id, title, content, created, expires FROM snippets
+	query := `SELECT ` + snippetColumns + ` FROM snippets
 	WHERE expires > UTC_TIMESTAMP() AND id = ?`
 
 	// Executes a query statement that will return no more than one row.
@@ -66,8 +248,7 @@ func (m *SnippetModel) Get(id int) (Snippet, error) {
 	// Declare an empty snippet and populate it from the row returned by QueryRow.
 	// If no rows were found, an sql.ErrNoRows error is returned.
 	// If multiple rows were found, the first row is used.
-	var s Snippet
-	err := row.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+	s, err := scanSnippet(row)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return Snippet{}, ErrNoRecord
@@ -76,27 +257,417 @@ func (m *SnippetModel) Get(id int) (Snippet, error) {
 		}
 	}
 
-	return s, nil
+	snippets := []Snippet{s}
+	err = m.loadFiles(snippets)
+	if err != nil {
+		return Snippet{}, err
+	}
+	err = m.loadTags(snippets)
+	if err != nil {
+		return Snippet{}, err
+	}
+
+	return snippets[0], nil
 }
 
+// Returns the 10 latest unexpired public snippets.
 func (m *SnippetModel) Latest() ([]Snippet, error) {
-	query := `SELECT id, title, content, created, expires FROM snippets
-	WHERE expires > UTC_TIMESTAMP() ORDER BY id DESC LIMIT 10`
+	query := `SELECT ` + snippetColumns + ` FROM snippets
+	WHERE expires > UTC_TIMESTAMP() AND team_id IS NULL ORDER BY id DESC LIMIT 10`
 
 	// Query will return an sql.Rows result set containing 10 latest entries.
 	rows, err := m.DB.Query(query)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close() // don't defer closing until after handling the error
+
+	return m.scanSnippets(rows)
+}
+
+// Returns all of the user's snippets, including expired ones, oldest first.
+// Team snippets belong to their team, so they aren't included.
+func (m *SnippetModel) GetAllForUser(userID int) ([]Snippet, error) {
+	query := `SELECT ` + snippetColumns + ` FROM snippets
+	WHERE user_id = ? AND team_id IS NULL ORDER BY id`
+
+	rows, err := m.DB.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.scanSnippets(rows)
+}
+
+// Returns a page of the user's unexpired public snippets, latest first,
+// skipping the first offset snippets and returning at most limit.
+func (m *SnippetModel) GetPublicForUser(userID, limit, offset int) ([]Snippet, error) {
+	query := `SELECT ` + snippetColumns + ` FROM snippets
+	WHERE user_id = ? AND team_id IS NULL AND expires > UTC_TIMESTAMP()
+	ORDER BY id DESC LIMIT ? OFFSET ?`
+
+	rows, err := m.DB.Query(query, userID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.scanSnippets(rows)
+}
+
+// Selects the unexpired public snippets with the tag given by the query's
+// first argument, latest first.
+const snippetsByTagQuery = `SELECT ` + snippetColumns + ` FROM snippets
+	INNER JOIN snippet_tags ON snippet_tags.snippet_id = snippets.id
+	INNER JOIN tags ON tags.id = snippet_tags.tag_id
+	WHERE tags.name = ? AND snippets.expires > UTC_TIMESTAMP()
+	AND snippets.team_id IS NULL
+	ORDER BY snippets.id DESC`
+
+// Returns the unexpired public snippets with the given tag, latest first.
+func (m *SnippetModel) GetByTag(tag string) ([]Snippet, error) {
+	rows, err := m.DB.Query(snippetsByTagQuery, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.scanSnippets(rows)
+}
+
+// Returns a page of the unexpired public snippets with the given tag, latest
+// first, skipping the first offset snippets and returning at most limit.
+func (m *SnippetModel) GetPageByTag(tag string, limit, offset int) ([]Snippet, error) {
+	rows, err := m.DB.Query(snippetsByTagQuery+` LIMIT ? OFFSET ?`, tag, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.scanSnippets(rows)
+}
+
+// Returns the team's unexpired snippets, latest first.
+func (m *SnippetModel) GetForTeam(teamID int) ([]Snippet, error) {
+	query := `SELECT ` + snippetColumns + ` FROM snippets
+	WHERE team_id = ? AND expires > UTC_TIMESTAMP()
+	ORDER BY id DESC`
+
+	rows, err := m.DB.Query(query, teamID)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.scanSnippets(rows)
+}
+
+// Returns the unexpired snippets that the user has starred, most recently
+// starred first. Snippets of teams the user is no longer a member of aren't
+// included.
+func (m *SnippetModel) Starred(userID int) ([]Snippet, error) {
+	query := `SELECT ` + snippetColumns + ` FROM snippets
+	INNER JOIN stars ON stars.snippet_id = snippets.id
+	WHERE stars.user_id = ? AND snippets.expires > UTC_TIMESTAMP()
+	AND (snippets.team_id IS NULL OR snippets.team_id IN (
+		SELECT team_id FROM team_members WHERE user_id = ?
+	))
+	ORDER BY stars.created DESC`
+
+	rows, err := m.DB.Query(query, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.scanSnippets(rows)
+}
+
+// Returns up to limit unexpired public snippets with the most stars given
+// since the given time, most starred first. Snippets with no stars in that
+// time aren't included.
+func (m *SnippetModel) MostStarred(since time.Time, limit int) ([]Snippet, error) {
+	query := `SELECT ` + snippetColumns + ` FROM snippets
+	INNER JOIN (
+		SELECT snippet_id, COUNT(*) AS count FROM stars
+		WHERE created >= ? GROUP BY snippet_id
+	) AS recent ON recent.snippet_id = snippets.id
+	WHERE snippets.expires > UTC_TIMESTAMP() AND snippets.team_id IS NULL
+	ORDER BY recent.count DESC, snippets.id DESC LIMIT ?`
+
+	rows, err := m.DB.Query(query, since.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.scanSnippets(rows)
+}
+
+// Returns up to limit unexpired public snippets with the most views since the
+// given time, most viewed first. Views are counted by the hour, so views in
+// the hour containing since are included.
+func (m *SnippetModel) Trending(since time.Time, limit int) ([]Snippet, error) {
+	query := `SELECT ` + snippetColumns + ` FROM snippets
+	INNER JOIN (
+		SELECT snippet_id, SUM(views) AS views FROM snippet_views
+		WHERE hour >= ? GROUP BY snippet_id
+	) AS recent ON recent.snippet_id = snippets.id
+	WHERE snippets.expires > UTC_TIMESTAMP() AND snippets.team_id IS NULL
+	ORDER BY recent.views DESC, snippets.id DESC LIMIT ?`
+
+	rows, err := m.DB.Query(query, since.UTC().Truncate(time.Hour), limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.scanSnippets(rows)
+}
+
+/*
+Adds views to snippets, keyed by snippet ID, as views made at the given time.
+The views are added to each snippet's total, and to its views in the hour
+containing the given time, which Trending ranks snippets by. Views of snippets
+that have since been deleted are ignored.
+
+All the views are added in a single transaction.
+*/
+func (m *SnippetModel) AddViews(counts map[int]int, at time.Time) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	hour := at.UTC().Truncate(time.Hour)
+
+	for id, n := range counts {
+		_, err = tx.Exec(`UPDATE snippets SET views = views + ? WHERE id = ?`, n, id)
+		if err != nil {
+			return err
+		}
+
+		// Selecting the snippet, rather than inserting its ID, means that no row
+		// is inserted for a deleted snippet.
+		query := `INSERT INTO snippet_views (snippet_id, hour, views)
+		SELECT id, ?, ? FROM snippets WHERE id = ?
+		ON DUPLICATE KEY UPDATE views = snippet_views.views + ?`
+
+		_, err = tx.Exec(query, hour, n, id, n)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Returns every tag used by an unexpired public snippet, with the number of
+// unexpired public snippets using it, in alphabetical order.
+func (m *SnippetModel) AllTags() ([]Tag, error) {
+	query := `SELECT tags.name, COUNT(*) FROM tags
+	INNER JOIN snippet_tags ON snippet_tags.tag_id = tags.id
+	INNER JOIN snippets ON snippets.id = snippet_tags.snippet_id
+	WHERE snippets.expires > UTC_TIMESTAMP() AND snippets.team_id IS NULL
+	GROUP BY tags.name ORDER BY tags.name`
+
+	rows, err := m.DB.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []Tag
+
+	for rows.Next() {
+		var t Tag
+		err = rows.Scan(&t.Name, &t.Count)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// Changes the title and files of an unexpired snippet, and records the change
+// as a new revision by the given user. If there's no matching snippet,
+// ErrNoRecord is returned.
+func (m *SnippetModel) Update(id, userID int, title string, files []SnippetFile) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The row is locked, so that concurrent edits are recorded in order.
+	query := `SELECT id FROM snippets
+	WHERE id = ? AND expires > UTC_TIMESTAMP() FOR UPDATE`
+
+	err = tx.QueryRow(query, id).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoRecord
+		}
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE snippets SET title = ? WHERE id = ?`, title, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM snippet_files WHERE snippet_id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	err = insertFiles(tx, id, files)
+	if err != nil {
+		return err
+	}
+
+	err = insertRevision(tx, id, userID, title, files)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Disables or enables comments on a snippet.
+func (m *SnippetModel) SetCommentsDisabled(id int, disabled bool) error {
+	query := `UPDATE snippets SET comments_disabled = ? WHERE id = ?`
+
+	_, err := m.DB.Exec(query, disabled, id)
+	return err
+}
+
+// Inserts a snippet's files, keeping them in the given order.
+func insertFiles(tx *sql.Tx, snippetID int, files []SnippetFile) error {
+	query := `INSERT INTO snippet_files (snippet_id, position, name, language, content)
+	VALUES (?, ?, ?, ?, ?)`
+
+	for i, f := range files {
+		_, err := tx.Exec(query, snippetID, i, f.Name, f.Language, f.Content)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Records a revision of a snippet. A userID of 0 records a revision with no
+// author. Revisions are never changed, so the files are stored as a single
+// JSON document.
+func insertRevision(tx *sql.Tx, snippetID, userID int, title string, files []SnippetFile) error {
+	js, err := json.Marshal(files)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO snippet_revisions (snippet_id, user_id, title, files, created)
+	VALUES (?, NULLIF(?, 0), ?, ?, UTC_TIMESTAMP())`
+
+	_, err = tx.Exec(query, snippetID, userID, title, js)
+	return err
+}
+
+// The columns selected by revision queries, in the order expected by
+// scanRevision.
+const revisionColumns = `snippet_revisions.id, snippet_revisions.snippet_id,
+	COALESCE(snippet_revisions.user_id, 0), COALESCE(users.name, ''),
+	snippet_revisions.title, snippet_revisions.files, snippet_revisions.created`
+
+// Scans a row containing revisionColumns into a revision.
+func scanRevision(row interface{ Scan(...any) error }) (SnippetRevision, error) {
+	var r SnippetRevision
+	var files []byte
+	err := row.Scan(&r.ID, &r.SnippetID, &r.UserID, &r.AuthorName, &r.Title, &files, &r.Created)
+	if err != nil {
+		return SnippetRevision{}, err
+	}
+
+	err = json.Unmarshal(files, &r.Files)
+	return r, err
+}
+
+// Deletes a snippet, along with its files, revisions, tags, comments and
+// stars. If there's no matching snippet, ErrNoRecord is returned.
+func (m *SnippetModel) Delete(id int) error {
+	result, err := m.DB.Exec(`DELETE FROM snippets WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
+
+// Returns the revisions of the snippet with the given ID, latest first.
+func (m *SnippetModel) GetRevisions(id int) ([]SnippetRevision, error) {
+	query := `SELECT ` + revisionColumns + ` FROM snippet_revisions
+	LEFT JOIN users ON users.id = snippet_revisions.user_id
+	WHERE snippet_revisions.snippet_id = ?
+	ORDER BY snippet_revisions.id DESC`
+
+	rows, err := m.DB.Query(query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []SnippetRevision
+
+	for rows.Next() {
+		r, err := scanRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return revisions, nil
+}
+
+// Returns a revision of the snippet with the given ID. If the revision doesn't
+// exist, or belongs to another snippet, ErrNoRecord is returned.
+func (m *SnippetModel) GetRevision(id, revisionID int) (SnippetRevision, error) {
+	query := `SELECT ` + revisionColumns + ` FROM snippet_revisions
+	LEFT JOIN users ON users.id = snippet_revisions.user_id
+	WHERE snippet_revisions.snippet_id = ? AND snippet_revisions.id = ?`
+
+	r, err := scanRevision(m.DB.QueryRow(query, id, revisionID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return SnippetRevision{}, ErrNoRecord
+		}
+		return SnippetRevision{}, err
+	}
+
+	return r, nil
+}
+
+// Scans each row of the result set into a snippet, closes it, and loads the
+// snippets' files and tags. The rows must contain snippetColumns.
+func (m *SnippetModel) scanSnippets(rows *sql.Rows) ([]Snippet, error) {
+	defer rows.Close()
 
 	// Iterate through result set, calling rows.Scan on each row. Create a snippet
 	// Create a snippet for each row and add it to the snippets slice.
 	var snippets []Snippet
 
 	for rows.Next() {
-		var s Snippet
-		err = rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+		s, err := scanSnippet(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -105,9 +676,97 @@ func (m *SnippetModel) Latest() ([]Snippet, error) {
 
 	// rows.Err() contains any errors that occurred during iteration, including
 	// including errors that wouldn't be returned by rows.Scan().
-	if err = rows.Err(); err != nil {
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	err := m.loadFiles(snippets)
+	if err != nil {
+		return nil, err
+	}
+	err = m.loadTags(snippets)
+	if err != nil {
 		return nil, err
 	}
 
 	return snippets, nil
 }
+
+// Returns a comma separated list of placeholders for the snippets' IDs, the
+// IDs themselves, and a map from each ID to the snippet's index in the slice.
+func snippetIDs(snippets []Snippet) (string, []any, map[int]int) {
+	placeholders := make([]string, len(snippets))
+	args := make([]any, len(snippets))
+	index := make(map[int]int, len(snippets))
+	for i, s := range snippets {
+		placeholders[i] = "?"
+		args[i] = s.ID
+		index[s.ID] = i
+	}
+	return strings.Join(placeholders, ", "), args, index
+}
+
+// Populates the Files field of each snippet, using a single query.
+func (m *SnippetModel) loadFiles(snippets []Snippet) error {
+	if len(snippets) == 0 {
+		return nil
+	}
+
+	placeholders, args, index := snippetIDs(snippets)
+
+	query := `SELECT snippet_id, name, language, content FROM snippet_files
+	WHERE snippet_id IN (` + placeholders + `)
+	ORDER BY snippet_id, position`
+
+	rows, err := m.DB.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		var f SnippetFile
+		err = rows.Scan(&id, &f.Name, &f.Language, &f.Content)
+		if err != nil {
+			return err
+		}
+		i := index[id]
+		snippets[i].Files = append(snippets[i].Files, f)
+	}
+
+	return rows.Err()
+}
+
+// Populates the Tags field of each snippet, using a single query.
+func (m *SnippetModel) loadTags(snippets []Snippet) error {
+	if len(snippets) == 0 {
+		return nil
+	}
+
+	placeholders, args, index := snippetIDs(snippets)
+
+	query := `SELECT snippet_tags.snippet_id, tags.name FROM snippet_tags
+	INNER JOIN tags ON tags.id = snippet_tags.tag_id
+	WHERE snippet_tags.snippet_id IN (` + placeholders + `)
+	ORDER BY tags.name`
+
+	rows, err := m.DB.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		var name string
+		err = rows.Scan(&id, &name)
+		if err != nil {
+			return err
+		}
+		i := index[id]
+		snippets[i].Tags = append(snippets[i].Tags, name)
+	}
+
+	return rows.Err()
+}
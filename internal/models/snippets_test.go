@@ -0,0 +1,207 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	assert "github.com/kvnloughead/snippetbox/internal"
+)
+
+// Returns the files of a snippet with a single text file.
+func oneFile(content string) []SnippetFile {
+	return []SnippetFile{{Name: "snippet.txt", Language: "text", Content: content}}
+}
+
+func TestSnippetModelTags(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	db := newTestDB(t)
+	m := SnippetModel{db}
+
+	first, err := m.Insert(1, NewSnippet{Title: "First", Files: oneFile("Content"), Expires: 7, Tags: []string{"sql", "shell"}}, 0)
+	assert.IsNil(t, err)
+
+	// Existing tags are reused.
+	second, err := m.Insert(1, NewSnippet{Title: "Second", Files: oneFile("Content"), Expires: 7, Tags: []string{"sql"}}, 0)
+	assert.IsNil(t, err)
+
+	s, err := m.Get(first)
+	assert.IsNil(t, err)
+	assert.Equal(t, strings.Join(s.Tags, " "), "shell sql")
+
+	snippets, err := m.GetByTag("sql")
+	assert.IsNil(t, err)
+	assert.Equal(t, len(snippets), 2)
+	assert.Equal(t, snippets[0].ID, second)
+
+	snippets, err = m.GetPageByTag("sql", 1, 1)
+	assert.IsNil(t, err)
+	assert.Equal(t, len(snippets), 1)
+	assert.Equal(t, snippets[0].ID, first)
+
+	tags, err := m.AllTags()
+	assert.IsNil(t, err)
+	assert.Equal(t, len(tags), 2)
+	assert.Equal(t, tags[1], Tag{Name: "sql", Count: 2})
+}
+
+func TestSnippetModelRevisions(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	db := newTestDB(t)
+	m := SnippetModel{db}
+
+	id, err := m.Insert(1, NewSnippet{Title: "Title", Files: oneFile("Old content"), Expires: 7}, 0)
+	assert.IsNil(t, err)
+
+	err = m.Update(id, 1, "New title", []SnippetFile{
+		{Name: "main.go", Language: "go", Content: "New content"},
+		{Name: "README", Language: "text", Content: "Read me"},
+	})
+	assert.IsNil(t, err)
+
+	err = m.Update(id+1, 1, "Title", oneFile("Content"))
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+
+	revisions, err := m.GetRevisions(id)
+	assert.IsNil(t, err)
+	assert.Equal(t, len(revisions), 2)
+	assert.Equal(t, len(revisions[0].Files), 2)
+	assert.Equal(t, revisions[0].Files[0].Content, "New content")
+	assert.Equal(t, revisions[1].Files[0].Content, "Old content")
+	assert.Equal(t, revisions[1].AuthorName, "Alice Jones")
+
+	s, err := m.Get(id)
+	assert.IsNil(t, err)
+	assert.Equal(t, s.Title, "New title")
+	assert.Equal(t, len(s.Files), 2)
+	assert.Equal(t, s.Files[1], SnippetFile{Name: "README", Language: "text", Content: "Read me"})
+
+	// Revisions can only be retrieved through their own snippet.
+	_, err = m.GetRevision(id+1, revisions[1].ID)
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+}
+
+func TestSnippetModelForks(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	db := newTestDB(t)
+	m := SnippetModel{db}
+
+	parent, err := m.Insert(1, NewSnippet{Title: "Parent", Files: oneFile("Content"), Expires: 7}, 0)
+	assert.IsNil(t, err)
+
+	fork, err := m.Insert(1, NewSnippet{Title: "Fork", Files: oneFile("Content"), Expires: 7}, parent)
+	assert.IsNil(t, err)
+
+	s, err := m.Get(parent)
+	assert.IsNil(t, err)
+	assert.Equal(t, s.ParentID, 0)
+	assert.Equal(t, s.Forks, 1)
+
+	s, err = m.Get(fork)
+	assert.IsNil(t, err)
+	assert.Equal(t, s.ParentID, parent)
+	assert.Equal(t, s.Forks, 0)
+}
+
+func TestSnippetModelInsertMany(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	db := newTestDB(t)
+	m := SnippetModel{db}
+
+	ids, err := m.InsertMany(1, []NewSnippet{
+		{Title: "First", Files: oneFile("One"), Tags: []string{"sql"}, Expires: 7},
+		{Title: "Second", Format: FormatMarkdown, Files: oneFile("# Two"), Expires: 7},
+	})
+	assert.IsNil(t, err)
+	assert.Equal(t, len(ids), 2)
+
+	s, err := m.Get(ids[1])
+	assert.IsNil(t, err)
+	assert.Equal(t, s.Title, "Second")
+	assert.Equal(t, s.Format, FormatMarkdown)
+
+	s, err = m.Get(ids[0])
+	assert.IsNil(t, err)
+	assert.Equal(t, s.Format, FormatPlain)
+
+	// If one snippet can't be inserted, none are.
+	_, err = m.InsertMany(1, []NewSnippet{
+		{Title: "Third", Files: oneFile("Three"), Expires: 7},
+		{Title: "Fourth", Files: append(oneFile("Four"), oneFile("Duplicate name")...), Expires: 7},
+	})
+	assert.Equal(t, err != nil, true)
+
+	snippets, err := m.GetAllForUser(1)
+	assert.IsNil(t, err)
+	assert.Equal(t, len(snippets), 2)
+}
+
+func TestSnippetModelViews(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	db := newTestDB(t)
+	m := SnippetModel{db}
+
+	first, err := m.Insert(1, NewSnippet{Title: "First", Files: oneFile("Content"), Expires: 7}, 0)
+	assert.IsNil(t, err)
+	second, err := m.Insert(1, NewSnippet{Title: "Second", Files: oneFile("Content"), Expires: 7}, 0)
+	assert.IsNil(t, err)
+
+	now := time.Now()
+	err = m.AddViews(map[int]int{first: 2, second: 1, 999: 5}, now.Add(-48*time.Hour))
+	assert.IsNil(t, err)
+	err = m.AddViews(map[int]int{second: 3}, now)
+	assert.IsNil(t, err)
+	err = m.AddViews(map[int]int{second: 1}, now)
+	assert.IsNil(t, err)
+
+	s, err := m.Get(second)
+	assert.IsNil(t, err)
+	assert.Equal(t, s.Views, 5)
+
+	// Only the second snippet was viewed in the last day.
+	trending, err := m.Trending(now.Add(-24*time.Hour), 10)
+	assert.IsNil(t, err)
+	assert.Equal(t, len(trending), 1)
+	assert.Equal(t, trending[0].ID, second)
+
+	trending, err = m.Trending(now.Add(-72*time.Hour), 10)
+	assert.IsNil(t, err)
+	assert.Equal(t, len(trending), 2)
+	assert.Equal(t, trending[0].ID, second)
+}
+
+func TestSnippetModelDelete(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	db := newTestDB(t)
+	m := SnippetModel{db}
+
+	id, err := m.Insert(1, NewSnippet{Title: "Title", Files: oneFile("Content"), Tags: []string{"tag"}, Expires: 7}, 0)
+	assert.IsNil(t, err)
+
+	assert.IsNil(t, m.Delete(id))
+
+	_, err = m.Get(id)
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+
+	err = m.Delete(id)
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"database/sql"
+)
+
+// A wrapper for our sql.DB connection pool.
+// Contains methods for interacting with the stars collection, which records
+// the snippets that each user has starred.
+type StarModel struct {
+	DB *sql.DB
+}
+
+type StarModelInterface interface {
+	Star(userID, snippetID int) error
+	Unstar(userID, snippetID int) error
+	Exists(userID, snippetID int) (bool, error)
+}
+
+// Stars a snippet for the user. Starring a snippet that is already starred
+// does nothing.
+func (m *StarModel) Star(userID, snippetID int) error {
+	query := `INSERT IGNORE INTO stars (user_id, snippet_id, created)
+	VALUES (?, ?, UTC_TIMESTAMP())`
+
+	_, err := m.DB.Exec(query, userID, snippetID)
+	return err
+}
+
+// Removes the user's star from a snippet. Unstarring a snippet that isn't
+// starred does nothing.
+func (m *StarModel) Unstar(userID, snippetID int) error {
+	query := `DELETE FROM stars WHERE user_id = ? AND snippet_id = ?`
+
+	_, err := m.DB.Exec(query, userID, snippetID)
+	return err
+}
+
+// Returns true if the user has starred the snippet.
+func (m *StarModel) Exists(userID, snippetID int) (bool, error) {
+	var exists bool
+
+	query := `SELECT EXISTS(SELECT true FROM stars WHERE user_id = ? AND snippet_id = ?)`
+
+	err := m.DB.QueryRow(query, userID, snippetID).Scan(&exists)
+	return exists, err
+}
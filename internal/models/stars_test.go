@@ -0,0 +1,60 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	assert "github.com/kvnloughead/snippetbox/internal"
+)
+
+func TestStarModel(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	db := newTestDB(t)
+	snippets := SnippetModel{db}
+	m := StarModel{db}
+
+	first, err := snippets.Insert(1, NewSnippet{Title: "First", Files: oneFile("Content"), Expires: 7}, 0)
+	assert.IsNil(t, err)
+	second, err := snippets.Insert(1, NewSnippet{Title: "Second", Files: oneFile("Content"), Expires: 7}, 0)
+	assert.IsNil(t, err)
+
+	// Starring twice is the same as starring once.
+	assert.IsNil(t, m.Star(1, first))
+	assert.IsNil(t, m.Star(1, first))
+	assert.IsNil(t, m.Star(1, second))
+
+	exists, err := m.Exists(1, first)
+	assert.IsNil(t, err)
+	assert.Equal(t, exists, true)
+
+	s, err := snippets.Get(first)
+	assert.IsNil(t, err)
+	assert.Equal(t, s.Stars, 1)
+
+	starred, err := snippets.Starred(1)
+	assert.IsNil(t, err)
+	assert.Equal(t, len(starred), 2)
+
+	popular, err := snippets.MostStarred(time.Now().AddDate(0, 0, -7), 10)
+	assert.IsNil(t, err)
+	assert.Equal(t, len(popular), 2)
+
+	popular, err = snippets.MostStarred(time.Now().Add(time.Hour), 10)
+	assert.IsNil(t, err)
+	assert.Equal(t, len(popular), 0)
+
+	assert.IsNil(t, m.Unstar(1, first))
+	assert.IsNil(t, m.Unstar(1, first))
+
+	exists, err = m.Exists(1, first)
+	assert.IsNil(t, err)
+	assert.Equal(t, exists, false)
+
+	starred, err = snippets.Starred(1)
+	assert.IsNil(t, err)
+	assert.Equal(t, len(starred), 1)
+	assert.Equal(t, starred[0].ID, second)
+}
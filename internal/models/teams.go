@@ -0,0 +1,450 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Team roles, from most to least privileged. Owners can do anything, including
+// deleting the team. Admins can invite, remove and change the role of members
+// and admins. Members can view and create the team's snippets.
+const (
+	TeamRoleOwner  = "owner"
+	TeamRoleAdmin  = "admin"
+	TeamRoleMember = "member"
+)
+
+// Type representing a team. Role is the role of the user the team was
+// retrieved for, if any.
+type Team struct {
+	ID      int
+	Name    string
+	Role    string
+	Created time.Time
+}
+
+// Type representing a member of a team.
+type TeamMember struct {
+	UserID int
+	Name   string
+	Email  string
+	Role   string
+	Joined time.Time
+}
+
+// Type representing an invitation link to a team. Anyone with the link can
+// join the team with the given role until it expires.
+//
+// Like other tokens, only the SHA-256 hash of the invitation is stored in the
+// DB. The plaintext is only available when the invitation is created.
+type TeamInvitation struct {
+	Plaintext string
+	TeamID    int
+	TeamName  string
+	Role      string
+	Expires   time.Time
+}
+
+// A wrapper for our sql.DB connection pool.
+// Contains methods for interacting with the teams collection, and with the
+// teams' members and invitations.
+type TeamModel struct {
+	DB *sql.DB
+}
+
+type TeamModelInterface interface {
+	Insert(name string, ownerID int) (int, error)
+	Get(id int) (Team, error)
+	Delete(id int) error
+	ForUser(userID int) ([]Team, error)
+	Role(teamID, userID int) (string, error)
+	Members(teamID int) ([]TeamMember, error)
+	SetRole(teamID, userID int, role string) error
+	RemoveMember(teamID, userID int) error
+	NewInvitation(teamID int, role string, ttl time.Duration) (TeamInvitation, error)
+	GetInvitation(plaintext string) (TeamInvitation, error)
+	AcceptInvitation(plaintext string, userID int) (int, error)
+}
+
+// Creates a team, with the given user as its owner.
+// Returns the ID of the new team.
+func (m *TeamModel) Insert(name string, ownerID int) (int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`INSERT INTO teams (name, created) VALUES (?, UTC_TIMESTAMP())`, name)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	query := `INSERT INTO team_members (team_id, user_id, role, joined)
+	VALUES (?, ?, ?, UTC_TIMESTAMP())`
+
+	_, err = tx.Exec(query, id, ownerID, TeamRoleOwner)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// Returns the team with the given ID. Its Role is empty.
+// If there's no such team, a models.ErrNoRecord error is returned.
+func (m *TeamModel) Get(id int) (Team, error) {
+	var t Team
+
+	query := `SELECT id, name, created FROM teams WHERE id = ?`
+
+	err := m.DB.QueryRow(query, id).Scan(&t.ID, &t.Name, &t.Created)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Team{}, ErrNoRecord
+		} else {
+			return Team{}, err
+		}
+	}
+
+	return t, nil
+}
+
+// Deletes a team, along with its members, invitations and snippets.
+// If there's no such team, a models.ErrNoRecord error is returned.
+func (m *TeamModel) Delete(id int) error {
+	result, err := m.DB.Exec(`DELETE FROM teams WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
+
+// Returns the teams the user is a member of, with the user's role in each,
+// ordered by name.
+func (m *TeamModel) ForUser(userID int) ([]Team, error) {
+	query := `SELECT teams.id, teams.name, team_members.role, teams.created
+	FROM teams
+	INNER JOIN team_members ON team_members.team_id = teams.id
+	WHERE team_members.user_id = ?
+	ORDER BY teams.name, teams.id`
+
+	rows, err := m.DB.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var teams []Team
+	for rows.Next() {
+		var t Team
+		err = rows.Scan(&t.ID, &t.Name, &t.Role, &t.Created)
+		if err != nil {
+			return nil, err
+		}
+		teams = append(teams, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return teams, nil
+}
+
+// Returns the user's role in the team. If the user isn't a member of the
+// team, a models.ErrNoRecord error is returned.
+func (m *TeamModel) Role(teamID, userID int) (string, error) {
+	var role string
+
+	query := `SELECT role FROM team_members WHERE team_id = ? AND user_id = ?`
+
+	err := m.DB.QueryRow(query, teamID, userID).Scan(&role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrNoRecord
+		} else {
+			return "", err
+		}
+	}
+
+	return role, nil
+}
+
+// Returns the members of the team, ordered by name.
+func (m *TeamModel) Members(teamID int) ([]TeamMember, error) {
+	query := `SELECT users.id, users.name, users.email, team_members.role, team_members.joined
+	FROM team_members
+	INNER JOIN users ON users.id = team_members.user_id
+	WHERE team_members.team_id = ?
+	ORDER BY users.name, users.id`
+
+	rows, err := m.DB.Query(query, teamID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []TeamMember
+	for rows.Next() {
+		var tm TeamMember
+		err = rows.Scan(&tm.UserID, &tm.Name, &tm.Email, &tm.Role, &tm.Joined)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, tm)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+// Returns a models.ErrLastOwner error if the user is the team's last owner,
+// locking the team's owners until the end of the transaction. If the user
+// isn't a member, a models.ErrNoRecord error is returned.
+func checkNotLastOwner(tx *sql.Tx, teamID, userID int) error {
+	var role string
+
+	query := `SELECT role FROM team_members WHERE team_id = ? AND user_id = ? FOR UPDATE`
+
+	err := tx.QueryRow(query, teamID, userID).Scan(&role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoRecord
+		} else {
+			return err
+		}
+	}
+
+	if role != TeamRoleOwner {
+		return nil
+	}
+
+	var owners int
+
+	query = `SELECT COUNT(*) FROM team_members WHERE team_id = ? AND role = ? FOR UPDATE`
+
+	err = tx.QueryRow(query, teamID, TeamRoleOwner).Scan(&owners)
+	if err != nil {
+		return err
+	}
+
+	if owners == 1 {
+		return ErrLastOwner
+	}
+
+	return nil
+}
+
+// Returns a models.ErrLastOwner error if the user is the last owner of any
+// team, locking the owners of the user's teams until the end of the
+// transaction.
+func checkNotLastOwnerOfAny(tx *sql.Tx, userID int) error {
+	query := `SELECT team_id FROM team_members WHERE user_id = ? AND role = ?`
+
+	rows, err := tx.Query(query, userID, TeamRoleOwner)
+	if err != nil {
+		return err
+	}
+
+	var teamIDs []int
+	for rows.Next() {
+		var id int
+		err = rows.Scan(&id)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		teamIDs = append(teamIDs, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, teamID := range teamIDs {
+		err = checkNotLastOwner(tx, teamID, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Changes the role of a member of the team. If the user isn't a member, a
+// models.ErrNoRecord error is returned. If the user is the team's last owner
+// and the role isn't owner, a models.ErrLastOwner error is returned, since a
+// team must always have an owner.
+func (m *TeamModel) SetRole(teamID, userID int, role string) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The last owner can't be demoted, but can be "promoted" to owner, which
+	// does nothing.
+	err = checkNotLastOwner(tx, teamID, userID)
+	if err != nil && !(errors.Is(err, ErrLastOwner) && role == TeamRoleOwner) {
+		return err
+	}
+
+	query := `UPDATE team_members SET role = ? WHERE team_id = ? AND user_id = ?`
+
+	_, err = tx.Exec(query, role, teamID, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Removes a member from the team. If the user isn't a member, a
+// models.ErrNoRecord error is returned. If the user is the team's last owner,
+// a models.ErrLastOwner error is returned.
+//
+// The snippets the user created in the team stay in the team.
+func (m *TeamModel) RemoveMember(teamID, userID int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = checkNotLastOwner(tx, teamID, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM team_members WHERE team_id = ? AND user_id = ?`, teamID, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Creates an invitation link to the team, which lets one person who has it
+// join the team with the given role until it expires. The returned invitation
+// contains the plaintext.
+func (m *TeamModel) NewInvitation(teamID int, role string, ttl time.Duration) (TeamInvitation, error) {
+	plaintext, err := randomString()
+	if err != nil {
+		return TeamInvitation{}, err
+	}
+
+	inv := TeamInvitation{
+		Plaintext: plaintext,
+		TeamID:    teamID,
+		Role:      role,
+		Expires:   time.Now().Add(ttl).UTC(),
+	}
+
+	query := `INSERT INTO team_invitations (hash, team_id, role, expires)
+	VALUES (?, ?, ?, ?)`
+
+	_, err = m.DB.Exec(query, hashToken(plaintext), inv.TeamID, inv.Role, inv.Expires)
+	if err != nil {
+		return TeamInvitation{}, err
+	}
+
+	return inv, nil
+}
+
+// Returns the invitation with the given plaintext, and the name of its team.
+// If the invitation doesn't exist or has expired, a models.ErrNoRecord error
+// is returned.
+func (m *TeamModel) GetInvitation(plaintext string) (TeamInvitation, error) {
+	inv := TeamInvitation{Plaintext: plaintext}
+
+	query := `SELECT teams.id, teams.name, team_invitations.role, team_invitations.expires
+	FROM team_invitations
+	INNER JOIN teams ON teams.id = team_invitations.team_id
+	WHERE team_invitations.hash = ? AND team_invitations.expires > UTC_TIMESTAMP()`
+
+	err := m.DB.QueryRow(query, hashToken(plaintext)).Scan(&inv.TeamID, &inv.TeamName, &inv.Role, &inv.Expires)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return TeamInvitation{}, ErrNoRecord
+		} else {
+			return TeamInvitation{}, err
+		}
+	}
+
+	return inv, nil
+}
+
+// Adds the user to the team of the invitation with the given plaintext, with
+// the invitation's role, and deletes the invitation so that it can't be used
+// again. Returns the ID of the team. Users who are already members keep their
+// current role, and the invitation is kept for someone else.
+//
+// If the invitation doesn't exist or has expired, a models.ErrNoRecord error
+// is returned.
+func (m *TeamModel) AcceptInvitation(plaintext string, userID int) (int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var teamID int
+	var role string
+
+	query := `SELECT team_id, role FROM team_invitations
+	WHERE hash = ? AND expires > UTC_TIMESTAMP() FOR UPDATE`
+
+	err = tx.QueryRow(query, hashToken(plaintext)).Scan(&teamID, &role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNoRecord
+		} else {
+			return 0, err
+		}
+	}
+
+	query = `INSERT IGNORE INTO team_members (team_id, user_id, role, joined)
+	VALUES (?, ?, ?, UTC_TIMESTAMP())`
+
+	result, err := tx.Exec(query, teamID, userID, role)
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if rows > 0 {
+		_, err = tx.Exec(`DELETE FROM team_invitations WHERE hash = ?`, hashToken(plaintext))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return teamID, tx.Commit()
+}
@@ -0,0 +1,170 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	assert "github.com/kvnloughead/snippetbox/internal"
+)
+
+func TestTeamModel(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	db := newTestDB(t)
+	users := UserModel{DB: db}
+	snippets := SnippetModel{db}
+	m := TeamModel{db}
+
+	// Alice, who has ID 1, is created by setup.sql.
+	_, err := users.Insert("Bob", "bob@example.com", "pa$$word")
+	assert.IsNil(t, err)
+	bob, err := users.Authenticate("bob@example.com", "pa$$word")
+	assert.IsNil(t, err)
+
+	id, err := m.Insert("Team", 1)
+	assert.IsNil(t, err)
+
+	role, err := m.Role(id, 1)
+	assert.IsNil(t, err)
+	assert.Equal(t, role, TeamRoleOwner)
+
+	_, err = m.Role(id, bob)
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+
+	t.Run("Invitations", func(t *testing.T) {
+		inv, err := m.NewInvitation(id, TeamRoleAdmin, time.Hour)
+		assert.IsNil(t, err)
+
+		got, err := m.GetInvitation(inv.Plaintext)
+		assert.IsNil(t, err)
+		assert.Equal(t, got.TeamName, "Team")
+
+		teamID, err := m.AcceptInvitation(inv.Plaintext, bob)
+		assert.IsNil(t, err)
+		assert.Equal(t, teamID, id)
+
+		role, err := m.Role(id, bob)
+		assert.IsNil(t, err)
+		assert.Equal(t, role, TeamRoleAdmin)
+
+		// Invitations can only be used once.
+		_, err = m.AcceptInvitation(inv.Plaintext, 1)
+		assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+
+		// Accepting an invitation doesn't change an existing member's role,
+		// and leaves the invitation for someone else.
+		again, err := m.NewInvitation(id, TeamRoleAdmin, time.Hour)
+		assert.IsNil(t, err)
+		_, err = m.AcceptInvitation(again.Plaintext, 1)
+		assert.IsNil(t, err)
+		role, err = m.Role(id, 1)
+		assert.IsNil(t, err)
+		assert.Equal(t, role, TeamRoleOwner)
+		_, err = m.GetInvitation(again.Plaintext)
+		assert.IsNil(t, err)
+
+		expired, err := m.NewInvitation(id, TeamRoleMember, -time.Hour)
+		assert.IsNil(t, err)
+		_, err = m.AcceptInvitation(expired.Plaintext, bob)
+		assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+
+		teams, err := m.ForUser(bob)
+		assert.IsNil(t, err)
+		assert.Equal(t, len(teams), 1)
+		assert.Equal(t, teams[0].Role, TeamRoleAdmin)
+
+		members, err := m.Members(id)
+		assert.IsNil(t, err)
+		assert.Equal(t, len(members), 2)
+	})
+
+	t.Run("Last owner", func(t *testing.T) {
+		err := m.SetRole(id, 1, TeamRoleMember)
+		assert.Equal(t, errors.Is(err, ErrLastOwner), true)
+		err = m.RemoveMember(id, 1)
+		assert.Equal(t, errors.Is(err, ErrLastOwner), true)
+		err = m.SetRole(id, 1, TeamRoleOwner)
+		assert.IsNil(t, err)
+
+		// Once there's another owner, the first can step down.
+		err = m.SetRole(id, bob, TeamRoleOwner)
+		assert.IsNil(t, err)
+		err = m.SetRole(id, 1, TeamRoleMember)
+		assert.IsNil(t, err)
+
+		err = m.RemoveMember(id, 999)
+		assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+	})
+
+	t.Run("Snippets", func(t *testing.T) {
+		public, err := snippets.Insert(1, NewSnippet{Title: "Public", Files: oneFile("Content"), Expires: 7}, 0)
+		assert.IsNil(t, err)
+		private, err := snippets.Insert(1, NewSnippet{TeamID: id, Title: "Private", Files: oneFile("Content"), Expires: 7}, 0)
+		assert.IsNil(t, err)
+
+		s, err := snippets.Get(private)
+		assert.IsNil(t, err)
+		assert.Equal(t, s.TeamID, id)
+
+		// Team snippets aren't listed publicly.
+		latest, err := snippets.Latest()
+		assert.IsNil(t, err)
+		assert.Equal(t, len(latest), 1)
+		assert.Equal(t, latest[0].ID, public)
+
+		team, err := snippets.GetForTeam(id)
+		assert.IsNil(t, err)
+		assert.Equal(t, len(team), 1)
+		assert.Equal(t, team[0].ID, private)
+
+		// Nor are they included in their author's export.
+		exported, err := snippets.GetAllForUser(1)
+		assert.IsNil(t, err)
+		for _, s := range exported {
+			if s.ID == private {
+				t.Errorf("team snippet included in its author's export")
+			}
+		}
+
+		// Stars on a team's snippets are hidden once the user leaves the team.
+		stars := StarModel{db}
+		assert.IsNil(t, stars.Star(1, private))
+		starred, err := snippets.Starred(1)
+		assert.IsNil(t, err)
+		assert.Equal(t, len(starred), 1)
+
+		assert.IsNil(t, m.RemoveMember(id, 1))
+		starred, err = snippets.Starred(1)
+		assert.IsNil(t, err)
+		assert.Equal(t, len(starred), 0)
+
+		// Deleting the team deletes its snippets.
+		assert.IsNil(t, m.Delete(id))
+		_, err = snippets.Get(private)
+		assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+	})
+	t.Run("Deleting an owner", func(t *testing.T) {
+		teamID, err := m.Insert("Other team", bob)
+		assert.IsNil(t, err)
+		private, err := snippets.Insert(bob, NewSnippet{TeamID: teamID, Title: "Private", Files: oneFile("Content"), Expires: 7}, 0)
+		assert.IsNil(t, err)
+
+		err = users.Delete(bob, true)
+		assert.Equal(t, errors.Is(err, ErrLastOwner), true)
+
+		// Once there's another owner, the user can be deleted, and their team
+		// snippets are kept.
+		inv, err := m.NewInvitation(teamID, TeamRoleOwner, time.Hour)
+		assert.IsNil(t, err)
+		_, err = m.AcceptInvitation(inv.Plaintext, 1)
+		assert.IsNil(t, err)
+
+		assert.IsNil(t, users.Delete(bob, true))
+		s, err := snippets.Get(private)
+		assert.IsNil(t, err)
+		assert.Equal(t, s.UserID, 0)
+	})
+}
@@ -1,29 +1,258 @@
 -- Setup before tests are run. 
 -- Note that Go ignores folders called testdata, so these will not be compiled.
 
+
+CREATE TABLE users (
+  id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+  name VARCHAR(255) NOT NULL,
+  email VARCHAR(255) NOT NULL,
+  pending_email VARCHAR(255),
+  handle VARCHAR(30) NOT NULL,
+  profile_public BOOLEAN NOT NULL DEFAULT TRUE,
+  avatar CHAR(32),
+  hashed_password VARCHAR(255) NOT NULL,
+  role VARCHAR(10) NOT NULL DEFAULT 'user',
+  disabled BOOLEAN NOT NULL DEFAULT FALSE,
+  created DATETIME NOT NULL
+);
+
+ALTER TABLE users ADD CONSTRAINT users_uc_email UNIQUE (email);
+ALTER TABLE users ADD CONSTRAINT users_uc_handle UNIQUE (handle);
+
+CREATE TABLE teams (
+  id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+  name VARCHAR(100) NOT NULL,
+  created DATETIME NOT NULL
+);
+
+-- A team's members, and their role in it: owner, admin or member.
+CREATE TABLE team_members (
+  team_id INTEGER NOT NULL,
+  user_id INTEGER NOT NULL,
+  role VARCHAR(10) NOT NULL,
+  joined DATETIME NOT NULL,
+  PRIMARY KEY (team_id, user_id),
+  CONSTRAINT fk_team_members_team FOREIGN KEY (team_id) REFERENCES teams(id) ON DELETE CASCADE,
+  CONSTRAINT fk_team_members_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_team_members_user_id ON team_members(user_id);
+
+-- Invitation links to teams. Only the SHA-256 hash of an invitation is stored.
+CREATE TABLE team_invitations (
+  hash BINARY(32) NOT NULL PRIMARY KEY,
+  team_id INTEGER NOT NULL,
+  role VARCHAR(10) NOT NULL,
+  expires DATETIME NOT NULL,
+  CONSTRAINT fk_team_invitations_team FOREIGN KEY (team_id) REFERENCES teams(id) ON DELETE CASCADE
+);
+
+-- Snippets created before snippets had owners have a NULL user_id. Snippets
+-- are kept when their owner deletes their account, unless they ask otherwise.
+-- Forks have the ID of the snippet they were forked from in parent_id.
+-- Team snippets, which only the team's members can see, have a team_id.
 CREATE TABLE snippets (
   id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+  user_id INTEGER NULL,
+  team_id INTEGER NULL,
+  parent_id INTEGER NULL,
   title VARCHAR(100) NOT NULL,
-  content TEXT NOT NULL,
+  format VARCHAR(10) NOT NULL DEFAULT 'plain',
+  comments_disabled BOOLEAN NOT NULL DEFAULT FALSE,
+  views INTEGER NOT NULL DEFAULT 0,
   created DATETIME NOT NULL,
-  expires DATETIME NOT NULL
+  expires DATETIME NOT NULL,
+  CONSTRAINT fk_snippets_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL,
+  CONSTRAINT fk_snippets_parent FOREIGN KEY (parent_id) REFERENCES snippets(id) ON DELETE SET NULL,
+  CONSTRAINT fk_snippets_team FOREIGN KEY (team_id) REFERENCES teams(id) ON DELETE CASCADE
 );
 
 CREATE INDEX idx_snippets_created ON snippets(created);
 
-CREATE TABLE users (
+-- A snippet's files, in the order given by position.
+CREATE TABLE snippet_files (
+  snippet_id INTEGER NOT NULL,
+  position INTEGER NOT NULL,
+  name VARCHAR(100) NOT NULL,
+  language VARCHAR(30) NOT NULL,
+  content MEDIUMTEXT NOT NULL,
+  PRIMARY KEY (snippet_id, position),
+  CONSTRAINT snippet_files_uc_name UNIQUE (snippet_id, name),
+  CONSTRAINT fk_snippet_files_snippet FOREIGN KEY (snippet_id) REFERENCES snippets(id) ON DELETE CASCADE
+);
+
+-- The files of a revision are stored as a JSON array of objects with name,
+-- language and content keys.
+CREATE TABLE snippet_revisions (
   id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
-  name VARCHAR(255) NOT NULL,
-  email VARCHAR(255) NOT NULL,
-  hashed_password CHAR(60) NOT NULL,
+  snippet_id INTEGER NOT NULL,
+  user_id INTEGER NULL,
+  title VARCHAR(100) NOT NULL,
+  files JSON NOT NULL,
+  created DATETIME NOT NULL,
+  CONSTRAINT fk_snippet_revisions_snippet FOREIGN KEY (snippet_id) REFERENCES snippets(id) ON DELETE CASCADE,
+  CONSTRAINT fk_snippet_revisions_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE TABLE tags (
+  id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+  name VARCHAR(30) NOT NULL
+);
+
+ALTER TABLE tags ADD CONSTRAINT tags_uc_name UNIQUE (name);
+
+CREATE TABLE snippet_tags (
+  snippet_id INTEGER NOT NULL,
+  tag_id INTEGER NOT NULL,
+  PRIMARY KEY (snippet_id, tag_id),
+  CONSTRAINT fk_snippet_tags_snippet FOREIGN KEY (snippet_id) REFERENCES snippets(id) ON DELETE CASCADE,
+  CONSTRAINT fk_snippet_tags_tag FOREIGN KEY (tag_id) REFERENCES tags(id) ON DELETE CASCADE
+);
+
+-- A comment's updated time is NULL until it is edited. Comments are deleted
+-- along with their snippet or author.
+CREATE TABLE comments (
+  id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+  snippet_id INTEGER NOT NULL,
+  user_id INTEGER NOT NULL,
+  body TEXT NOT NULL,
+  created DATETIME NOT NULL,
+  updated DATETIME NULL,
+  CONSTRAINT fk_comments_snippet FOREIGN KEY (snippet_id) REFERENCES snippets(id) ON DELETE CASCADE,
+  CONSTRAINT fk_comments_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_comments_snippet_id ON comments(snippet_id);
+
+CREATE TABLE stars (
+  user_id INTEGER NOT NULL,
+  snippet_id INTEGER NOT NULL,
+  created DATETIME NOT NULL,
+  PRIMARY KEY (user_id, snippet_id),
+  CONSTRAINT fk_stars_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+  CONSTRAINT fk_stars_snippet FOREIGN KEY (snippet_id) REFERENCES snippets(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_stars_snippet_id_created ON stars(snippet_id, created);
+
+-- The number of views of each snippet in each hour, used to rank trending
+-- snippets. Hours are stored in UTC, truncated to the hour.
+CREATE TABLE snippet_views (
+  snippet_id INTEGER NOT NULL,
+  hour DATETIME NOT NULL,
+  views INTEGER NOT NULL,
+  PRIMARY KEY (snippet_id, hour),
+  CONSTRAINT fk_snippet_views_snippet FOREIGN KEY (snippet_id) REFERENCES snippets(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_snippet_views_hour ON snippet_views(hour);
+
+CREATE TABLE tokens (
+  hash BINARY(32) NOT NULL PRIMARY KEY,
+  user_id INTEGER NOT NULL,
+  expiry DATETIME NOT NULL,
+  scope VARCHAR(32) NOT NULL,
+  CONSTRAINT fk_tokens_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE user_totp (
+  user_id INTEGER NOT NULL PRIMARY KEY,
+  secret VARCHAR(64) NOT NULL,
+  last_step BIGINT NOT NULL DEFAULT 0,
+  created DATETIME NOT NULL,
+  CONSTRAINT fk_user_totp_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE totp_recovery_codes (
+  id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+  user_id INTEGER NOT NULL,
+  hash BINARY(32) NOT NULL,
+  CONSTRAINT fk_totp_recovery_codes_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE user_sessions (
+  id VARCHAR(32) NOT NULL PRIMARY KEY,
+  user_id INTEGER NOT NULL,
+  created DATETIME NOT NULL,
+  last_seen DATETIME NOT NULL,
+  expires DATETIME NOT NULL,
+  ip VARCHAR(45) NOT NULL,
+  user_agent VARCHAR(255) NOT NULL,
+  remember_series VARCHAR(32) NOT NULL DEFAULT '',
+  CONSTRAINT fk_user_sessions_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_user_sessions_user_id ON user_sessions(user_id);
+
+CREATE TABLE remember_tokens (
+  series VARCHAR(32) NOT NULL PRIMARY KEY,
+  user_id INTEGER NOT NULL,
+  hash BINARY(32) NOT NULL,
+  previous_hash BINARY(32) NULL,
+  created DATETIME NOT NULL,
+  last_used DATETIME NOT NULL,
+  expires DATETIME NOT NULL,
+  CONSTRAINT fk_remember_tokens_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Accounts at OpenID Connect providers that users can log in with.
+CREATE TABLE user_identities (
+  provider VARCHAR(50) NOT NULL,
+  subject VARCHAR(255) NOT NULL,
+  user_id INTEGER NOT NULL,
+  created DATETIME NOT NULL,
+  PRIMARY KEY (provider, subject),
+  CONSTRAINT fk_user_identities_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_user_identities_user_id ON user_identities(user_id);
+
+-- Passkeys. Credential IDs are chosen by authenticators, and can be up to
+-- 1023 bytes long.
+CREATE TABLE webauthn_credentials (
+  id VARBINARY(1023) NOT NULL PRIMARY KEY,
+  user_id INTEGER NOT NULL,
+  name VARCHAR(100) NOT NULL,
+  public_key BLOB NOT NULL,
+  attestation_type VARCHAR(32) NOT NULL,
+  transports VARCHAR(255) NOT NULL DEFAULT '',
+  aaguid VARBINARY(16) NOT NULL,
+  sign_count INT UNSIGNED NOT NULL DEFAULT 0,
+  backup_eligible BOOLEAN NOT NULL DEFAULT FALSE,
+  backup_state BOOLEAN NOT NULL DEFAULT FALSE,
+  created DATETIME NOT NULL,
+  last_used DATETIME NULL,
+  CONSTRAINT fk_webauthn_credentials_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_webauthn_credentials_user_id ON webauthn_credentials(user_id);
+
+-- The audit log. Events outlive their actors, so actor_id isn't a foreign key.
+CREATE TABLE audit_events (
+  id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+  actor_id INTEGER NULL,
+  action VARCHAR(50) NOT NULL,
+  target VARCHAR(255) NOT NULL,
+  detail VARCHAR(255) NOT NULL DEFAULT '',
+  ip VARCHAR(45) NOT NULL,
+  user_agent VARCHAR(255) NOT NULL,
   created DATETIME NOT NULL
 );
 
-ALTER TABLE users ADD CONSTRAINT users_uc_email UNIQUE (email);
+CREATE INDEX idx_audit_events_actor_id ON audit_events(actor_id);
+CREATE INDEX idx_audit_events_target ON audit_events(target);
+
+-- The audit log is append-only, so changing or removing events is an error.
+CREATE TRIGGER audit_events_no_update BEFORE UPDATE ON audit_events FOR EACH ROW
+  SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'audit_events is append-only';
+
+CREATE TRIGGER audit_events_no_delete BEFORE DELETE ON audit_events FOR EACH ROW
+  SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'audit_events is append-only';
 
-INSERT INTO users (name, email, hashed_password, created) VALUES (
+INSERT INTO users (name, email, handle, hashed_password, created) VALUES (
   'Alice Jones',
   'alice@example.com',
+  'alice-jones',
   '$2a$12$NuTjWXm3KKntReFwyBVHyuf/to.HEwTy.eS206TNfkGfr6HzGJSWG',
   '2022-01-01 09:18:24'
 );
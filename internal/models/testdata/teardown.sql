@@ -1,6 +1,42 @@
 -- Teardown after tests are run.
 -- Note that Go ignores folders called testdata, so these will not be compiled.
 
-DROP TABLE users;
+DROP TABLE audit_events;
 
-DROP TABLE snippets;
\ No newline at end of file
+DROP TABLE webauthn_credentials;
+
+DROP TABLE user_identities;
+
+DROP TABLE remember_tokens;
+
+DROP TABLE user_sessions;
+
+DROP TABLE totp_recovery_codes;
+
+DROP TABLE user_totp;
+
+DROP TABLE tokens;
+
+DROP TABLE snippet_views;
+
+DROP TABLE stars;
+
+DROP TABLE comments;
+
+DROP TABLE snippet_tags;
+
+DROP TABLE tags;
+
+DROP TABLE snippet_revisions;
+
+DROP TABLE snippet_files;
+
+DROP TABLE snippets;
+
+DROP TABLE team_invitations;
+
+DROP TABLE team_members;
+
+DROP TABLE teams;
+
+DROP TABLE users;
\ No newline at end of file
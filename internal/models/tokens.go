@@ -0,0 +1,165 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"time"
+)
+
+// Token scopes. A token can only be used for the purpose it was issued for.
+const (
+	ScopePasswordReset = "password-reset"
+	ScopeEmailChange   = "email-change"
+)
+
+// Type representing a single-use token, such as a password reset token.
+//
+// Only the SHA-256 hash of the token is stored in the DB. The plaintext is
+// only available when the token is created, so that it can be sent to the
+// user.
+type Token struct {
+	Plaintext string
+	Hash      []byte
+	UserID    int
+	Expiry    time.Time
+	Scope     string
+}
+
+// A wrapper for our sql.DB connection pool.
+// Contains methods for interacting with the tokens collection.
+type TokenModel struct {
+	DB *sql.DB
+}
+
+type TokenModelInterface interface {
+	New(userID int, ttl time.Duration, scope string) (Token, error)
+	GetUserID(scope, plaintext string) (int, error)
+	Consume(scope, plaintext string) (int, error)
+	DeleteAllForUser(scope string, userID int) error
+}
+
+// Returns the SHA-256 hash of a token's plaintext.
+func hashToken(plaintext string) []byte {
+	hash := sha256.Sum256([]byte(plaintext))
+	return hash[:]
+}
+
+// Returns a random string with 128 bits of entropy, encoded as a 26
+// character base32 string.
+func randomString() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b), nil
+}
+
+// Generates a token with 128 bits of entropy.
+func generateToken(userID int, ttl time.Duration, scope string) (Token, error) {
+	plaintext, err := randomString()
+	if err != nil {
+		return Token{}, err
+	}
+
+	return Token{
+		Plaintext: plaintext,
+		Hash:      hashToken(plaintext),
+		UserID:    userID,
+		Expiry:    time.Now().Add(ttl).UTC(),
+		Scope:     scope,
+	}, nil
+}
+
+// Creates a new token for the user and stores its hash in the DB.
+// The returned token contains the plaintext.
+func (m *TokenModel) New(userID int, ttl time.Duration, scope string) (Token, error) {
+	token, err := generateToken(userID, ttl, scope)
+	if err != nil {
+		return Token{}, err
+	}
+
+	query := `INSERT INTO tokens (hash, user_id, expiry, scope) VALUES (?, ?, ?, ?)`
+
+	_, err = m.DB.Exec(query, token.Hash, token.UserID, token.Expiry, token.Scope)
+	if err != nil {
+		return Token{}, err
+	}
+
+	return token, nil
+}
+
+// Returns the ID of the user the token belongs to, without using up the token.
+// If the token doesn't exist, has expired, or has a different scope, a
+// models.ErrNoRecord error is returned.
+func (m *TokenModel) GetUserID(scope, plaintext string) (int, error) {
+	var userID int
+
+	query := `SELECT user_id FROM tokens
+	WHERE hash = ? AND scope = ? AND expiry > UTC_TIMESTAMP()`
+
+	err := m.DB.QueryRow(query, hashToken(plaintext), scope).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNoRecord
+		} else {
+			return 0, err
+		}
+	}
+
+	return userID, nil
+}
+
+// Uses up the token, returning the ID of the user it belongs to. The token is
+// deleted in the same transaction that reads it, so that it can't be used
+// twice. If the token doesn't exist, has expired, or has a different scope, a
+// models.ErrNoRecord error is returned.
+func (m *TokenModel) Consume(scope, plaintext string) (int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	// Rollback is a no-op if the transaction has already been committed.
+	defer tx.Rollback()
+
+	hash := hashToken(plaintext)
+
+	var userID int
+
+	// FOR UPDATE locks the row until the transaction ends, so concurrent
+	// requests with the same token are serialized.
+	query := `SELECT user_id FROM tokens
+	WHERE hash = ? AND scope = ? AND expiry > UTC_TIMESTAMP() FOR UPDATE`
+
+	err = tx.QueryRow(query, hash, scope).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNoRecord
+		} else {
+			return 0, err
+		}
+	}
+
+	_, err = tx.Exec(`DELETE FROM tokens WHERE hash = ?`, hash)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// Deletes all of the user's tokens with the given scope.
+func (m *TokenModel) DeleteAllForUser(scope string, userID int) error {
+	query := `DELETE FROM tokens WHERE scope = ? AND user_id = ?`
+
+	_, err := m.DB.Exec(query, scope, userID)
+	return err
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	assert "github.com/kvnloughead/snippetbox/internal"
+)
+
+func TestTokenModelConsume(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	db := newTestDB(t)
+	m := TokenModel{db}
+
+	token, err := m.New(1, time.Hour, ScopePasswordReset)
+	assert.IsNil(t, err)
+
+	// A token can't be used for a different purpose.
+	_, err = m.Consume("other-scope", token.Plaintext)
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+
+	userID, err := m.Consume(ScopePasswordReset, token.Plaintext)
+	assert.IsNil(t, err)
+	assert.Equal(t, userID, 1)
+
+	// A token can only be used once.
+	_, err = m.Consume(ScopePasswordReset, token.Plaintext)
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+}
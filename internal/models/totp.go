@@ -0,0 +1,190 @@
+package models
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Number of recovery codes generated when two-factor authentication is
+// enabled.
+const recoveryCodeCount = 10
+
+// Type representing a user's TOTP two-factor authentication enrollment.
+type TOTP struct {
+	UserID   int
+	Secret   string // base32 encoded
+	LastStep int64  // time step of the last code used, to prevent replays
+	Created  time.Time
+}
+
+// A wrapper for our sql.DB connection pool.
+// Contains methods for interacting with the user_totp and
+// totp_recovery_codes collections.
+type TOTPModel struct {
+	DB *sql.DB
+}
+
+type TOTPModelInterface interface {
+	Get(userID int) (TOTP, error)
+	Enable(userID int, secret string) ([]string, error)
+	Disable(userID int) error
+	UseStep(userID int, step int64) error
+	UseRecoveryCode(userID int, code string) error
+}
+
+// Get the user's TOTP enrollment.
+// If the user hasn't enabled two-factor authentication, a models.ErrNoRecord
+// error is returned.
+func (m *TOTPModel) Get(userID int) (TOTP, error) {
+	query := `SELECT user_id, secret, last_step, created FROM user_totp
+	WHERE user_id = ?`
+
+	var t TOTP
+	err := m.DB.QueryRow(query, userID).Scan(&t.UserID, &t.Secret, &t.LastStep, &t.Created)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return TOTP{}, ErrNoRecord
+		} else {
+			return TOTP{}, err
+		}
+	}
+
+	return t, nil
+}
+
+// Generates a recovery code of the form xxxxx-xxxxx.
+func generateRecoveryCode() (string, error) {
+	b := make([]byte, 7)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	s := strings.ToLower(base32.StdEncoding.EncodeToString(b))[:10]
+	return s[:5] + "-" + s[5:], nil
+}
+
+// Normalizes a recovery code as typed by the user, so that case and
+// surrounding whitespace don't matter.
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.TrimSpace(code))
+}
+
+// Enables two-factor authentication for the user with the given secret, which
+// should already have been verified. Any previous enrollment is replaced.
+//
+// Returns the plaintext of a fresh set of single-use recovery codes. Only
+// their hashes are stored, so they must be shown to the user now.
+func (m *TOTPModel) Enable(userID int, secret string) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `REPLACE INTO user_totp (user_id, secret, last_step, created)
+	VALUES (?, ?, 0, UTC_TIMESTAMP())`
+
+	_, err = tx.Exec(query, userID, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`DELETE FROM totp_recovery_codes WHERE user_id = ?`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, code := range codes {
+		_, err = tx.Exec(`INSERT INTO totp_recovery_codes (user_id, hash) VALUES (?, ?)`,
+			userID, hashToken(code))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// Disables two-factor authentication for the user, deleting their secret and
+// recovery codes.
+func (m *TOTPModel) Disable(userID int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM totp_recovery_codes WHERE user_id = ?`, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM user_totp WHERE user_id = ?`, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Records that the code for the given time step has been used. If a code for
+// the same or a later step has already been used, ErrInvalidCredentials is
+// returned, so that an intercepted code can't be replayed.
+func (m *TOTPModel) UseStep(userID int, step int64) error {
+	query := `UPDATE user_totp SET last_step = ? WHERE user_id = ? AND last_step < ?`
+
+	result, err := m.DB.Exec(query, step, userID, step)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrInvalidCredentials
+	}
+
+	return nil
+}
+
+// Uses up one of the user's recovery codes. If the code doesn't match an
+// unused recovery code, ErrInvalidCredentials is returned.
+func (m *TOTPModel) UseRecoveryCode(userID int, code string) error {
+	query := `DELETE FROM totp_recovery_codes WHERE user_id = ? AND hash = ?`
+
+	result, err := m.DB.Exec(query, userID, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrInvalidCredentials
+	}
+
+	return nil
+}
@@ -3,11 +3,22 @@ package models
 import (
 	"database/sql"
 	"errors"
+	"fmt"
+	"math/rand"
 	"strings"
 	"time"
+	"unicode"
 
 	"github.com/go-sql-driver/mysql"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/kvnloughead/snippetbox/internal/hasher"
+)
+
+// User roles. Moderators can delete any snippet, and admins can also manage
+// users and view the audit log.
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
 )
 
 // Type representing our user document.
@@ -15,7 +26,13 @@ type User struct {
 	ID              int
 	Name            string
 	Email           string
-	Hashed_password []byte // a bcrypt hash
+	PendingEmail    string // a new email that hasn't been confirmed yet, or ""
+	Handle          string // unique, used in the URL of the user's profile
+	ProfilePublic   bool   // if false, the user's profile can't be viewed
+	Avatar          string // the ID of the user's avatar in the blob store, or ""
+	Hashed_password []byte // a bcrypt or argon2id hash
+	Role            string // RoleUser, RoleModerator or RoleAdmin
+	Disabled        bool   // disabled users can't log in
 	Created         time.Time
 }
 
@@ -23,29 +40,60 @@ type User struct {
 // Contains methods for interacting with the users collection.
 type UserModel struct {
 	DB *sql.DB
+
+	// Hashes new passwords. Passwords hashed by another algorithm, or with
+	// lower costs, are rehashed when their users log in. If nil,
+	// hasher.Default is used.
+	Hasher hasher.Hasher
+}
+
+// Returns the hasher that new passwords are hashed with.
+func (m *UserModel) hasher() hasher.Hasher {
+	if m.Hasher == nil {
+		return hasher.Default
+	}
+	return m.Hasher
 }
 
 type UserModelInterface interface {
 	Authenticate(email string, password string) (int, error)
 	Get(id int) (User, error)
+	GetByEmail(email string) (User, error)
+	GetByHandle(handle string) (User, error)
 	Exists(id int) (bool, error)
-	Insert(name, email, password string) error
+	Insert(name, email, password string) (int, error)
 	PasswordUpdate(id int, password string) error
+	NameUpdate(id int, name string) error
+	SetPendingEmail(id int, email string) error
+	ClearPendingEmail(id int) error
+	ConfirmPendingEmail(id int) (string, error)
+	SetHandle(id int, handle string) error
+	SetProfilePublic(id int, public bool) error
+	SetAvatar(id int, avatar string) error
+	Delete(id int, deleteSnippets bool) error
+	All() ([]User, error)
+	SetRole(id int, role string) error
+	SetDisabled(id int, disabled bool) error
 }
 
 // Authenticate a user on login by comparing the plain text password to the
 // user's stored hashed password. If the email or password is incorrect, an
-// ErrInvalidCredentials error is returned.
+// ErrInvalidCredentials error is returned. If they are correct, but the user
+// has been disabled, an ErrAccountDisabled error is returned.
+//
+// If the stored hash was made by another algorithm, or with lower costs, than
+// the model's hasher, it's replaced with a new hash of the password.
 func (m *UserModel) Authenticate(email string, password string) (int, error) {
 	var id int
 	var hashedPassword []byte
+	var disabled bool
 
-	query := "SELECT id, hashed_password FROM users WHERE email = ?"
+	query := "SELECT id, hashed_password, disabled FROM users WHERE email = ?"
 
 	// QueryRow returns the first matching row. Scan copies the columns of the
 	// matched row into the specified locations. Scan returns ErrNoRows if no
 	// match was found.
-	err := m.DB.QueryRow(query, email).Scan(&id, &hashedPassword)
+	err := m.DB.QueryRow(query, email).Scan(&id, &hashedPassword, &disabled)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return 0, ErrInvalidCredentials
@@ -55,24 +103,62 @@ func (m *UserModel) Authenticate(email string, password string) (int, error) {
 	}
 
 	// Compare password to hash. If they don't match return ErrInvalidCredentials.
-	err = bcrypt.CompareHashAndPassword(hashedPassword, []byte(password))
+	rehash, err := hasher.Verify(m.hasher(), hashedPassword, password)
 	if err != nil {
-		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		if errors.Is(err, hasher.ErrMismatch) {
 			return 0, ErrInvalidCredentials
 		} else {
 			return 0, err
 		}
 	}
 
+	if disabled {
+		return 0, ErrAccountDisabled
+	}
+
+	if rehash {
+		err = m.rehash(id, hashedPassword, password)
+		if err != nil {
+			return 0, err
+		}
+	}
+
 	// If password is correct, return the user's ID.
 	return id, nil
 }
 
+// Replaces the user's hash with a new hash of the password, made by the
+// model's hasher. The hash is only replaced if it's still old, so that a
+// password changed since it was read isn't overwritten.
+func (m *UserModel) rehash(id int, old []byte, password string) error {
+	hash, err := m.hasher().Hash(password)
+	if err != nil {
+		return err
+	}
+
+	stmt := `UPDATE users SET hashed_password = ? WHERE id = ? AND hashed_password = ?`
+	_, err = m.DB.Exec(stmt, hash, id, old)
+	return err
+}
+
+// The columns selected by user queries, in the order expected by scanUser.
+// Users without a pending email or an avatar have a NULL pending_email or
+// avatar, which COALESCE turns into "".
+const userColumns = `id, name, email, COALESCE(pending_email, ''), handle,
+	profile_public, COALESCE(avatar, ''), role, disabled, created`
+
+// Scans a row containing userColumns into a user.
+func scanUser(row interface{ Scan(...any) error }) (User, error) {
+	var u User
+	err := row.Scan(&u.ID, &u.Name, &u.Email, &u.PendingEmail, &u.Handle,
+		&u.ProfilePublic, &u.Avatar, &u.Role, &u.Disabled, &u.Created)
+	return u, err
+}
+
 // Get a user by its ID.
 // If no matching snippet is found, a models.ErrNoRecord error is returned.
 func (m *UserModel) Get(id int) (User, error) {
-	query := `SELECT name, email, created FROM users
-	WHERE id = ?`
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = ?`
 
 	// Executes a query statement that will return no more than one row.
 	// Accepts the query statement and a variadic list of placeholder values.
@@ -81,8 +167,41 @@ func (m *UserModel) Get(id int) (User, error) {
 	// Declare an empty user struct and populate it from the returned row.
 	// If no rows were found, an sql.ErrNoRows error is returned.
 	// If multiple rows were found, the first row is used.
-	var u User
-	err := row.Scan(&u.Name, &u.Email, &u.Created)
+	u, err := scanUser(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return User{}, ErrNoRecord
+		} else {
+			return User{}, err
+		}
+	}
+
+	return u, nil
+}
+
+// Get a user by their email address.
+// If no matching user is found, a models.ErrNoRecord error is returned.
+func (m *UserModel) GetByEmail(email string) (User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE email = ?`
+
+	u, err := scanUser(m.DB.QueryRow(query, email))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return User{}, ErrNoRecord
+		} else {
+			return User{}, err
+		}
+	}
+
+	return u, nil
+}
+
+// Get a user by their handle.
+// If no matching user is found, a models.ErrNoRecord error is returned.
+func (m *UserModel) GetByHandle(handle string) (User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE handle = ?`
+
+	u, err := scanUser(m.DB.QueryRow(query, handle))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return User{}, ErrNoRecord
@@ -106,45 +225,288 @@ func (m *UserModel) Exists(id int) (bool, error) {
 	return exists, err
 }
 
-// Inserts a new user user the DB.
+// The longest handle that users can have.
+const MaxHandleLength = 30
+
+// The number of times Insert tries another handle when the one it made from
+// the user's name is taken.
+const handleAttempts = 10
+
+// Returns a handle made from the name, such as "mary-jane" from
+// "Mary-Jane O'Neil", with room to add a number to it. Names without letters
+// or digits give the handle "user".
+func handleFromName(name string) string {
+	var b strings.Builder
+	hyphen := false
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			if hyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			hyphen = false
+		default:
+			hyphen = true
+		}
+	}
+
+	handle := strings.TrimRight(b.String()[:min(b.Len(), MaxHandleLength-5)], "-")
+	if len(handle) < 3 {
+		return "user"
+	}
+	return handle
+}
+
+// Inserts a new user user the DB, with a handle made from their name. If the
+// handle is taken, a random number is added to it.
 // Returns the ID of the inserted record or an error.
-func (m *UserModel) Insert(name, email, password string) error {
-	// Generate hash from the password with bcrypt.
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+func (m *UserModel) Insert(name, email, password string) (int, error) {
+	// Generate hash from the password with the configured hasher.
+	hash, err := m.hasher().Hash(password)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	// The query to be executed. Query statements allow for '?' as placeholders.
-	query := `INSERT INTO users (name, email, hashed_password, created)
-	VALUES(?, ?, ?, UTC_TIMESTAMP())`
-
-	// Execute query. Exec accepts variadic values for the query placeholders.
-	_, err = m.DB.Exec(query, name, email, string(hash))
-	if err != nil {
-		// Handle duplicate email errors.
-		var mySQLError *mysql.MySQLError
-		if errors.As(err, &mySQLError) {
-			// If errors.As() is true, the error will be assigned to mySQLError.
-			if mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, "users_uc_email") {
-				return ErrDuplicateEmail
+	query := `INSERT INTO users (name, email, handle, hashed_password, created)
+	VALUES(?, ?, ?, ?, UTC_TIMESTAMP())`
+
+	base := handleFromName(name)
+	handle := base
+
+	for attempt := 1; ; attempt++ {
+		// Execute query. Exec accepts variadic values for the query placeholders.
+		result, err := m.DB.Exec(query, name, email, handle, string(hash))
+		if err != nil {
+			switch {
+			case isDuplicateKey(err, "users_uc_email"):
+				return 0, ErrDuplicateEmail
+			case isDuplicateKey(err, "users_uc_handle") && attempt < handleAttempts:
+				handle = fmt.Sprintf("%s-%d", base, rand.Intn(10000))
+				continue
+			default:
+				return 0, err
 			}
 		}
-		return err
+
+		id, err := result.LastInsertId()
+		if err != nil {
+			return 0, err
+		}
+
+		return int(id), nil
 	}
-	return nil
 }
 
 // Generates a hash from the supplied password and updates it in the DB.
 // The password is not validated, so make sure that it is valid before calling.
 func (m *UserModel) PasswordUpdate(id int, password string) error {
-	// Generate hash from the password with bcrypt.
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	// Generate hash from the password with the configured hasher.
+	hash, err := m.hasher().Hash(password)
 	if err != nil {
 		return err
 	}
 
 	stmt := `UPDATE users SET hashed_password = ? WHERE id = ?`
-	m.DB.Exec(stmt, hash, id)
-	return nil
+	_, err = m.DB.Exec(stmt, hash, id)
+	return err
+}
+
+// Returns true if the error is the DB rejecting a duplicate value of the
+// unique key, such as users_uc_email.
+func isDuplicateKey(err error, key string) bool {
+	var mySQLError *mysql.MySQLError
+	if errors.As(err, &mySQLError) {
+		// If errors.As() is true, the error will be assigned to mySQLError.
+		return mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, key)
+	}
+	return false
+}
+
+// Updates the user's name.
+func (m *UserModel) NameUpdate(id int, name string) error {
+	stmt := `UPDATE users SET name = ? WHERE id = ?`
+	_, err := m.DB.Exec(stmt, name, id)
+	return err
+}
+
+// Records a new email for the user, which replaces their current email once
+// it's confirmed with ConfirmPendingEmail. Any earlier pending email is
+// replaced. If another user already has the email, an ErrDuplicateEmail error
+// is returned.
+func (m *UserModel) SetPendingEmail(id int, email string) error {
+	var exists bool
+
+	query := "SELECT EXISTS(SELECT true FROM users WHERE email = ? AND id != ?)"
+	err := m.DB.QueryRow(query, email, id).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrDuplicateEmail
+	}
+
+	stmt := `UPDATE users SET pending_email = ? WHERE id = ?`
+	_, err = m.DB.Exec(stmt, email, id)
+	return err
+}
+
+// Forgets the user's pending email, if they have one.
+func (m *UserModel) ClearPendingEmail(id int) error {
+	_, err := m.DB.Exec(`UPDATE users SET pending_email = NULL WHERE id = ?`, id)
+	return err
+}
+
+// Replaces the user's email with their pending email, and returns it. If the
+// user has no pending email, an ErrNoRecord error is returned. If another user
+// has taken the email since it was requested, an ErrDuplicateEmail error is
+// returned, and the pending email is kept.
+func (m *UserModel) ConfirmPendingEmail(id int) (string, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var email sql.NullString
+
+	query := `SELECT pending_email FROM users WHERE id = ? FOR UPDATE`
+	err = tx.QueryRow(query, id).Scan(&email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrNoRecord
+		} else {
+			return "", err
+		}
+	}
+	if !email.Valid {
+		return "", ErrNoRecord
+	}
+
+	stmt := `UPDATE users SET email = pending_email, pending_email = NULL WHERE id = ?`
+	_, err = tx.Exec(stmt, id)
+	if err != nil {
+		if isDuplicateKey(err, "users_uc_email") {
+			return "", ErrDuplicateEmail
+		}
+		return "", err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return "", err
+	}
+
+	return email.String, nil
+}
+
+// Changes the user's handle. If another user has the handle, an
+// ErrDuplicateHandle error is returned.
+func (m *UserModel) SetHandle(id int, handle string) error {
+	stmt := `UPDATE users SET handle = ? WHERE id = ?`
+	_, err := m.DB.Exec(stmt, handle, id)
+	if isDuplicateKey(err, "users_uc_handle") {
+		return ErrDuplicateHandle
+	}
+	return err
+}
+
+// Sets whether the user's profile can be viewed by others.
+func (m *UserModel) SetProfilePublic(id int, public bool) error {
+	stmt := `UPDATE users SET profile_public = ? WHERE id = ?`
+	_, err := m.DB.Exec(stmt, public, id)
+	return err
+}
+
+// Sets the ID of the user's avatar in the blob store. If avatar is "", the
+// user's avatar is removed.
+func (m *UserModel) SetAvatar(id int, avatar string) error {
+	stmt := `UPDATE users SET avatar = ? WHERE id = ?`
+	_, err := m.DB.Exec(stmt, sql.NullString{String: avatar, Valid: avatar != ""}, id)
+	return err
+}
+
+// Deletes the user. Their tokens, sessions, two-factor authentication
+// settings and team memberships are deleted along with them by the DB's
+// foreign key constraints. If they are the last owner of a team, which would
+// be left without one, a models.ErrLastOwner error is returned instead.
+//
+// If deleteSnippets is true, the user's personal snippets are deleted too.
+// Otherwise they are kept, but no longer have an owner. Team snippets belong to
+// their team, so they are always kept.
+func (m *UserModel) Delete(id int, deleteSnippets bool) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = checkNotLastOwnerOfAny(tx, id)
+	if err != nil {
+		return err
+	}
+
+	if deleteSnippets {
+		_, err = tx.Exec(`DELETE FROM snippets WHERE user_id = ? AND team_id IS NULL`, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	result, err := tx.Exec(`DELETE FROM users WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNoRecord
+	}
+
+	return tx.Commit()
+}
+
+// Returns all users, oldest first.
+func (m *UserModel) All() ([]User, error) {
+	query := `SELECT id, name, email, role, disabled, created FROM users ORDER BY id`
+
+	rows, err := m.DB.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var u User
+		err = rows.Scan(&u.ID, &u.Name, &u.Email, &u.Role, &u.Disabled, &u.Created)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// Changes the user's role. The role is not validated, so make sure that it is
+// valid before calling.
+func (m *UserModel) SetRole(id int, role string) error {
+	_, err := m.DB.Exec(`UPDATE users SET role = ? WHERE id = ?`, role, id)
+	return err
+}
+
+// Disables or re-enables the user's account. Disabled users can't log in, but
+// their sessions aren't revoked, so callers should revoke them.
+func (m *UserModel) SetDisabled(id int, disabled bool) error {
+	_, err := m.DB.Exec(`UPDATE users SET disabled = ? WHERE id = ?`, disabled, id)
+	return err
 }
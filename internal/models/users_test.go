@@ -1,9 +1,14 @@
 package models
 
 import (
+	"errors"
+	"regexp"
+	"strings"
 	"testing"
 
 	assert "github.com/kvnloughead/snippetbox/internal"
+	"github.com/kvnloughead/snippetbox/internal/hasher"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func TestUserModelExists(t *testing.T) {
@@ -37,7 +42,7 @@ func TestUserModelExists(t *testing.T) {
 		t.Run(sub.name, func(t *testing.T) {
 			// Each test sets runs the setup and teardown scripts.
 			db := newTestDB(t)
-			m := UserModel{db}
+			m := UserModel{DB: db}
 
 			exists, err := m.Exists(sub.userID)
 
@@ -47,3 +52,208 @@ func TestUserModelExists(t *testing.T) {
 	}
 
 }
+
+func TestUserModelRoles(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	db := newTestDB(t)
+	m := UserModel{DB: db}
+
+	_, err := m.Insert("Bob", "bob@example.com", "pa$$word")
+	assert.IsNil(t, err)
+	id, err := m.Authenticate("bob@example.com", "pa$$word")
+	assert.IsNil(t, err)
+
+	user, err := m.Get(id)
+	assert.IsNil(t, err)
+	assert.Equal(t, user.Role, RoleUser)
+	assert.Equal(t, user.Disabled, false)
+
+	assert.IsNil(t, m.SetRole(id, RoleModerator))
+	user, err = m.Get(id)
+	assert.IsNil(t, err)
+	assert.Equal(t, user.Role, RoleModerator)
+
+	// Disabled users can't log in, but a wrong password is still reported as
+	// invalid credentials.
+	assert.IsNil(t, m.SetDisabled(id, true))
+	_, err = m.Authenticate("bob@example.com", "pa$$word")
+	assert.Equal(t, errors.Is(err, ErrAccountDisabled), true)
+	_, err = m.Authenticate("bob@example.com", "wrong")
+	assert.Equal(t, errors.Is(err, ErrInvalidCredentials), true)
+
+	assert.IsNil(t, m.SetDisabled(id, false))
+	_, err = m.Authenticate("bob@example.com", "pa$$word")
+	assert.IsNil(t, err)
+
+	users, err := m.All()
+	assert.IsNil(t, err)
+	assert.Equal(t, len(users), 2)
+}
+
+func TestUserModelRehash(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	db := newTestDB(t)
+	cheap := hasher.Argon2id{Memory: 8, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	m := UserModel{DB: db, Hasher: hasher.Bcrypt{Cost: bcrypt.MinCost}}
+
+	id, err := m.Insert("Bob", "bob@example.com", "pa$$word")
+	assert.IsNil(t, err)
+
+	hash := func() string {
+		var h string
+		err := db.QueryRow("SELECT hashed_password FROM users WHERE id = ?", id).Scan(&h)
+		assert.IsNil(t, err)
+		return h
+	}
+	old := hash()
+
+	// A hash made with the current hasher isn't replaced.
+	_, err = m.Authenticate("bob@example.com", "pa$$word")
+	assert.IsNil(t, err)
+	assert.Equal(t, hash(), old)
+
+	// Once the algorithm changes, the bcrypt hash still works, and is replaced
+	// with an argon2id hash.
+	m.Hasher = cheap
+	_, err = m.Authenticate("bob@example.com", "pa$$word")
+	assert.IsNil(t, err)
+	assert.Equal(t, strings.HasPrefix(hash(), "$argon2id$"), true)
+
+	// A wrong password doesn't replace the hash.
+	old = hash()
+	m.Hasher = hasher.Bcrypt{Cost: bcrypt.MinCost}
+	_, err = m.Authenticate("bob@example.com", "wrong")
+	assert.Equal(t, errors.Is(err, ErrInvalidCredentials), true)
+	assert.Equal(t, hash(), old)
+}
+
+func TestUserModelPendingEmail(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	db := newTestDB(t)
+	m := UserModel{DB: db, Hasher: hasher.Bcrypt{Cost: bcrypt.MinCost}}
+
+	id, err := m.Insert("Bob", "bob@example.com", "pa$$word")
+	assert.IsNil(t, err)
+	otherID, err := m.Insert("Carol", "carol@example.com", "pa$$word")
+	assert.IsNil(t, err)
+
+	_, err = m.ConfirmPendingEmail(id)
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+
+	err = m.SetPendingEmail(id, "carol@example.com")
+	assert.Equal(t, errors.Is(err, ErrDuplicateEmail), true)
+
+	assert.IsNil(t, m.SetPendingEmail(id, "rob@example.com"))
+	assert.IsNil(t, m.ClearPendingEmail(id))
+	_, err = m.ConfirmPendingEmail(id)
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+
+	// The email only changes once it's confirmed.
+	assert.IsNil(t, m.SetPendingEmail(id, "robert@example.com"))
+	user, err := m.Get(id)
+	assert.IsNil(t, err)
+	assert.Equal(t, user.Email, "bob@example.com")
+	assert.Equal(t, user.PendingEmail, "robert@example.com")
+
+	email, err := m.ConfirmPendingEmail(id)
+	assert.IsNil(t, err)
+	assert.Equal(t, email, "robert@example.com")
+	user, err = m.Get(id)
+	assert.IsNil(t, err)
+	assert.Equal(t, user.Email, "robert@example.com")
+	assert.Equal(t, user.PendingEmail, "")
+
+	// If someone else takes the email before it's confirmed, it isn't changed.
+	assert.IsNil(t, m.SetPendingEmail(otherID, "bobby@example.com"))
+	assert.IsNil(t, m.SetPendingEmail(id, "bobby@example.com"))
+	_, err = m.ConfirmPendingEmail(otherID)
+	assert.IsNil(t, err)
+	_, err = m.ConfirmPendingEmail(id)
+	assert.Equal(t, errors.Is(err, ErrDuplicateEmail), true)
+}
+
+func TestHandleFromName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Alice Jones", "alice-jones"},
+		{"Mary-Jane O'Neil", "mary-jane-o-neil"},
+		{"  bob  ", "bob"},
+		{"Zoë Li", "zo-li"},
+		{"Zoë", "user"},
+		{"李", "user"},
+		{"A Very Long Name That Goes On And On", "a-very-long-name-that-goe"},
+		{"Twenty-Four Characters-X", "twenty-four-characters-x"},
+	}
+
+	for _, sub := range tests {
+		t.Run(sub.name, func(t *testing.T) {
+			assert.Equal(t, handleFromName(sub.name), sub.want)
+		})
+	}
+}
+
+func TestUserModelHandles(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	db := newTestDB(t)
+	m := UserModel{DB: db, Hasher: hasher.Bcrypt{Cost: bcrypt.MinCost}}
+
+	// The setup script's user already has the handle alice-jones, so another
+	// Alice Jones gets a number added to it.
+	id, err := m.Insert("Alice Jones", "alice2@example.com", "pa$$word")
+	assert.IsNil(t, err)
+	user, err := m.Get(id)
+	assert.IsNil(t, err)
+	assert.StringContainsMatch(t, user.Handle, regexp.MustCompile(`^alice-jones-\d+$`))
+	assert.Equal(t, user.ProfilePublic, true)
+
+	err = m.SetHandle(id, "alice-jones")
+	assert.Equal(t, errors.Is(err, ErrDuplicateHandle), true)
+
+	assert.IsNil(t, m.SetHandle(id, "alice2"))
+	assert.IsNil(t, m.SetProfilePublic(id, false))
+	user, err = m.GetByHandle("alice2")
+	assert.IsNil(t, err)
+	assert.Equal(t, user.ID, id)
+	assert.Equal(t, user.ProfilePublic, false)
+
+	_, err = m.GetByHandle("nobody")
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+}
+
+func TestUserModelSetAvatar(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	db := newTestDB(t)
+	m := UserModel{DB: db}
+
+	user, err := m.Get(1)
+	assert.IsNil(t, err)
+	assert.Equal(t, user.Avatar, "")
+
+	avatar := "0123456789abcdef0123456789abcdef"
+	assert.IsNil(t, m.SetAvatar(1, avatar))
+	user, err = m.Get(1)
+	assert.IsNil(t, err)
+	assert.Equal(t, user.Avatar, avatar)
+
+	assert.IsNil(t, m.SetAvatar(1, ""))
+	user, err = m.Get(1)
+	assert.IsNil(t, err)
+	assert.Equal(t, user.Avatar, "")
+}
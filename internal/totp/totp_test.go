@@ -0,0 +1,62 @@
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+
+	assert "github.com/kvnloughead/snippetbox/internal"
+)
+
+// The SHA1 secret from the test vectors in RFC 6238 appendix B.
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestCode(t *testing.T) {
+	// The RFC vectors are 8 digits long. Our 6 digit codes are the last 6.
+	tests := []struct {
+		name string
+		unix int64
+		want string
+	}{
+		{name: "59", unix: 59, want: "287082"},
+		{name: "1111111109", unix: 1111111109, want: "081804"},
+		{name: "1111111111", unix: 1111111111, want: "050471"},
+		{name: "1234567890", unix: 1234567890, want: "005924"},
+		{name: "2000000000", unix: 2000000000, want: "279037"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+			assert.IsNil(t, err)
+			assert.Equal(t, code, tt.want)
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+
+	tests := []struct {
+		name   string
+		code   string
+		at     time.Time
+		wantOK bool
+	}{
+		{name: "Current step", code: "050471", at: now, wantOK: true},
+		{name: "Previous step", code: "050471", at: now.Add(Period * time.Second), wantOK: true},
+		{name: "Too old", code: "050471", at: now.Add(2 * Period * time.Second), wantOK: false},
+		{name: "Wrong code", code: "123456", at: now, wantOK: false},
+		{name: "Wrong length", code: "50471", at: now, wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(rfcSecret, tt.code, tt.at)
+			assert.Equal(t, ok, tt.wantOK)
+			if ok {
+				assert.Equal(t, step, Step(now))
+			}
+		})
+	}
+}
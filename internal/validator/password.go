@@ -0,0 +1,160 @@
+package validator
+
+import (
+	"bufio"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A policy that new passwords must meet.
+type PasswordPolicy struct {
+	MinLength int // in characters
+	MaxBytes  int // bcrypt only uses the first 72 bytes, so longer passwords are rejected
+
+	// Rejects passwords containing the user's name, or the local part of their
+	// email. Parts shorter than three characters are ignored.
+	DisallowPersonalInfo bool
+
+	// Rejects passwords in the list. If nil, no passwords are denied.
+	DenyList *DenyList
+}
+
+// The password policy used unless another is configured.
+var DefaultPasswordPolicy = PasswordPolicy{
+	MinLength:            8,
+	MaxBytes:             72,
+	DisallowPersonalInfo: true,
+	DenyList:             CommonPasswords,
+}
+
+/*
+Adds an error to the field if the password doesn't meet the policy, unless the
+field already has an error. email and name are those of the user whose password
+it is.
+
+Each rule has its own error message, so that users know what to change.
+*/
+func (v *Validator) CheckPassword(field, password string, policy PasswordPolicy, email, name string) {
+	switch {
+	case !NotBlank(password):
+		v.AddFieldError(field, "This field can't be blank.")
+	case !MinChars(password, policy.MinLength):
+		v.AddFieldError(field, fmt.Sprintf("Password must be at least %d characters.", policy.MinLength))
+	case policy.MaxBytes > 0 && len(password) > policy.MaxBytes:
+		v.AddFieldError(field, "Password is too long.")
+	case policy.DisallowPersonalInfo && containsPersonalInfo(password, localPart(email)):
+		v.AddFieldError(field, "Password can't contain your email address.")
+	case policy.DisallowPersonalInfo && containsPersonalInfo(password, strings.FieldsFunc(name, isNameSeparator)...):
+		v.AddFieldError(field, "Password can't contain your name.")
+	case policy.DenyList != nil && policy.DenyList.Contains(password):
+		v.AddFieldError(field, "This password is too common. Please choose another.")
+	}
+}
+
+// Returns the part of an email before the @.
+func localPart(email string) string {
+	local, _, _ := strings.Cut(email, "@")
+	return local
+}
+
+// Splits names into words, such as "Mary-Jane O'Neil" into Mary, Jane, O and
+// Neil.
+func isNameSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// Returns true if the password contains any of the parts, ignoring case.
+// Parts shorter than three characters are ignored, since they'd match too
+// many passwords by chance.
+func containsPersonalInfo(password string, parts ...string) bool {
+	password = strings.ToLower(password)
+	for _, part := range parts {
+		if utf8.RuneCountInString(part) >= 3 && strings.Contains(password, strings.ToLower(part)) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+A list of passwords that can't be used, such as those that are most common in
+data breaches.
+
+Only the SHA-1 hashes of the passwords are kept, grouped by the first five hex
+digits of their hash, like the Pwned Passwords range API. A password is looked
+up by its range, and then the rest of its hash is compared with those in the
+range, so the list can be replaced by a k-anonymity query to the API without
+changing how it's used.
+*/
+type DenyList struct {
+	ranges map[string][]string // hash suffixes, by prefix
+}
+
+// The length of the hash prefixes that passwords are grouped by.
+const denyListPrefixLen = 5
+
+//go:embed common-passwords.txt
+var commonPasswords string
+
+// The passwords most commonly found in data breaches, which is embedded in the
+// binary, so that checking it needs no network access.
+var CommonPasswords = mustParseDenyList(strings.NewReader(commonPasswords))
+
+/*
+Reads a deny list from r, in the format of Pwned Passwords downloads, with an
+uppercase hex SHA-1 hash per line, optionally followed by a colon and the
+number of times the password has been seen. Blank lines and lines starting
+with # are ignored.
+*/
+func ParseDenyList(r io.Reader) (*DenyList, error) {
+	d := &DenyList{ranges: map[string][]string{}}
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		hash, _, _ := strings.Cut(line, ":")
+		hash = strings.ToUpper(hash)
+		if len(hash) != 2*sha1.Size {
+			return nil, fmt.Errorf("deny list: line %d: invalid SHA-1 hash", n)
+		}
+		if _, err := hex.DecodeString(hash); err != nil {
+			return nil, fmt.Errorf("deny list: line %d: invalid SHA-1 hash", n)
+		}
+
+		prefix, suffix := hash[:denyListPrefixLen], hash[denyListPrefixLen:]
+		d.ranges[prefix] = append(d.ranges[prefix], suffix)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+func mustParseDenyList(r io.Reader) *DenyList {
+	d, err := ParseDenyList(r)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// Returns true if the password is in the list.
+func (d *DenyList) Contains(password string) bool {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	return slices.Contains(d.ranges[hash[:denyListPrefixLen]], hash[denyListPrefixLen:])
+}
@@ -0,0 +1,58 @@
+package validator
+
+import (
+	"strings"
+	"testing"
+
+	assert "github.com/kvnloughead/snippetbox/internal"
+)
+
+func TestCheckPassword(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		policy   PasswordPolicy
+		want     string
+	}{
+		{"Valid", "correct horse battery", DefaultPasswordPolicy, ""},
+		{"Blank", "   ", DefaultPasswordPolicy, "This field can't be blank."},
+		{"Short", "Xk3#", DefaultPasswordPolicy, "Password must be at least 8 characters."},
+		{"Custom minimum length", "correct horse", PasswordPolicy{MinLength: 15}, "Password must be at least 15 characters."},
+		{"Too long", strings.Repeat("é", 40), DefaultPasswordPolicy, "Password is too long."},
+		{"Email", "xx-ALICE.SMITH-xx", DefaultPasswordPolicy, "Password can't contain your email address."},
+		{"Name", "i am mary-jane", DefaultPasswordPolicy, "Password can't contain your name."},
+		{"Short name parts are ignored", "lightning strikes", DefaultPasswordPolicy, ""},
+		{"Personal info allowed", "i am mary-jane", PasswordPolicy{MinLength: 8}, ""},
+		{"Common", "password123", DefaultPasswordPolicy, "This password is too common. Please choose another."},
+		{"Common, without deny list", "password123", PasswordPolicy{MinLength: 8}, ""},
+	}
+
+	for _, sub := range tests {
+		t.Run(sub.name, func(t *testing.T) {
+			var v Validator
+			v.CheckPassword("password", sub.password, sub.policy, "alice.smith@mail.com", "Mary-Jane Li")
+			assert.Equal(t, v.FieldErrors["password"], sub.want)
+		})
+	}
+}
+
+func TestDenyList(t *testing.T) {
+	// The SHA-1 hashes of "hunter2", with a count, and "tr0ub4dor&3".
+	list := `# comment
+F3BBBD66A63D4BF1747940578EC3D0103530E21D:17
+
+281397B1F7880ADE0F53530A55D9AF0210B9AD7B
+`
+	d, err := ParseDenyList(strings.NewReader(list))
+	assert.IsNil(t, err)
+
+	assert.Equal(t, d.Contains("hunter2"), true)
+	assert.Equal(t, d.Contains("tr0ub4dor&3"), true)
+	assert.Equal(t, d.Contains("hunter3"), false)
+
+	_, err = ParseDenyList(strings.NewReader("not a hash\n"))
+	assert.Equal(t, err != nil, true)
+
+	assert.Equal(t, CommonPasswords.Contains("123456"), true)
+	assert.Equal(t, CommonPasswords.Contains("qwerty"), true)
+}
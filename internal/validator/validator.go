@@ -11,6 +11,17 @@ import (
 // https://html.spec.whatwg.org/multipage/input.html#valid-e-mail-address
 var EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
 
+// Tag pattern: lowercase letters, digits, hyphens and underscores, starting
+// with a letter or digit.
+var TagRX = regexp.MustCompile("^[a-z0-9][a-z0-9_-]*$")
+
+// Handle pattern: 3 to 30 lowercase letters, digits, hyphens and underscores,
+// starting with a letter or digit.
+var HandleRX = regexp.MustCompile("^[a-z0-9][a-z0-9_-]{2,29}$")
+
+// File name pattern: letters, digits, dots, hyphens and underscores.
+var FileNameRX = regexp.MustCompile("^[A-Za-z0-9._-]+$")
+
 type Validator struct {
 	// For errors that are associated with specific form fields.
 	FieldErrors map[string]string
@@ -79,3 +90,18 @@ func Matches(s string, rx *regexp.Regexp) bool {
 func PermittedValue[T comparable](value T, permittedValues ...T) bool {
 	return slices.Contains(permittedValues, value)
 }
+
+// Returns true if the slice contains no more than n items.
+func MaxItems[T any](items []T, n int) bool {
+	return len(items) <= n
+}
+
+// Returns true if ok returns true for every item in the slice.
+func All[T any](items []T, ok func(T) bool) bool {
+	for _, item := range items {
+		if !ok(item) {
+			return false
+		}
+	}
+	return true
+}
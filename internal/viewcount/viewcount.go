@@ -0,0 +1,130 @@
+/*
+Package viewcount counts views of snippets without writing to the DB on every
+view.
+
+Views are deduplicated, so that a viewer who reloads a snippet within the
+deduplication window is only counted once, and buffered in memory. The counts
+are written to a Store in batches when Flush is called, which is usually done
+periodically by Run. Counts that haven't been flushed are lost if the process
+exits, which is an acceptable trade-off for view counts.
+*/
+package viewcount
+
+import (
+	"sync"
+	"time"
+)
+
+// Stores view counts. AddViews adds the number of views of each snippet, keyed
+// by snippet ID, as views made at the given time.
+type Store interface {
+	AddViews(counts map[int]int, at time.Time) error
+}
+
+// A viewer's view of a snippet.
+type view struct {
+	snippetID int
+	viewer    string
+}
+
+// Counts views of snippets. It is safe for concurrent use.
+type Counter struct {
+	store  Store
+	window time.Duration
+	now    func() time.Time
+
+	mu      sync.Mutex
+	seen    map[view]time.Time // when each view was last counted
+	pending map[int]int        // views not yet flushed, by snippet ID
+}
+
+// Returns a Counter that writes to store, counting a viewer's views of a
+// snippet at most once per window.
+func New(store Store, window time.Duration) *Counter {
+	return &Counter{
+		store:   store,
+		window:  window,
+		now:     time.Now,
+		seen:    make(map[view]time.Time),
+		pending: make(map[int]int),
+	}
+}
+
+/*
+Records a view of a snippet by a viewer, such as a session or an IP address.
+Returns true if the view was counted, or false if the viewer's last counted
+view of the snippet was less than the window ago.
+*/
+func (c *Counter) Record(snippetID int, viewer string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	v := view{snippetID, viewer}
+	if last, ok := c.seen[v]; ok && now.Sub(last) < c.window {
+		return false
+	}
+
+	c.seen[v] = now
+	c.pending[snippetID]++
+	return true
+}
+
+/*
+Writes the views counted since the last flush to the store, and forgets views
+that are older than the window. If the store returns an error, the views are
+kept, so that they are written by the next flush.
+*/
+func (c *Counter) Flush() error {
+	c.mu.Lock()
+	pending := c.pending
+	c.pending = make(map[int]int)
+
+	now := c.now()
+	for v, last := range c.seen {
+		if now.Sub(last) >= c.window {
+			delete(c.seen, v)
+		}
+	}
+	c.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	// The store is written to without holding the lock, so that views can be
+	// recorded in the meantime.
+	err := c.store.AddViews(pending, now)
+	if err != nil {
+		c.mu.Lock()
+		for id, n := range pending {
+			c.pending[id] += n
+		}
+		c.mu.Unlock()
+		return err
+	}
+
+	return nil
+}
+
+// Flushes the counter every interval, until done is closed, when it is flushed
+// one last time. Errors are passed to logError.
+func (c *Counter) Run(interval time.Duration, done <-chan struct{}, logError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-done:
+			if err := c.Flush(); err != nil {
+				logError(err)
+			}
+			return
+		}
+
+		if err := c.Flush(); err != nil {
+			logError(err)
+		}
+	}
+}
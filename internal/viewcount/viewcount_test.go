@@ -0,0 +1,105 @@
+package viewcount
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	assert "github.com/kvnloughead/snippetbox/internal"
+)
+
+// A Store that records the counts written to it, or fails if err is set.
+type testStore struct {
+	mu      sync.Mutex
+	err     error
+	flushes []map[int]int
+}
+
+func (s *testStore) AddViews(counts map[int]int, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+	s.flushes = append(s.flushes, counts)
+	return nil
+}
+
+func TestCounter(t *testing.T) {
+	store := &testStore{}
+	c := New(store, time.Hour)
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	assert.Equal(t, c.Record(1, "alice"), true)
+	assert.Equal(t, c.Record(1, "alice"), false) // duplicate
+	assert.Equal(t, c.Record(1, "bob"), true)
+	assert.Equal(t, c.Record(2, "alice"), true)
+
+	assert.IsNil(t, c.Flush())
+	assert.Equal(t, len(store.flushes), 1)
+	assert.Equal(t, store.flushes[0][1], 2)
+	assert.Equal(t, store.flushes[0][2], 1)
+
+	// Flushing with nothing pending doesn't write to the store.
+	assert.IsNil(t, c.Flush())
+	assert.Equal(t, len(store.flushes), 1)
+
+	// Still within the window.
+	now = now.Add(59 * time.Minute)
+	assert.Equal(t, c.Record(1, "alice"), false)
+
+	// After the window, the view is counted again.
+	now = now.Add(time.Minute)
+	assert.Equal(t, c.Record(1, "alice"), true)
+
+	// If the store fails, the views are kept for the next flush.
+	store.err = errors.New("store is down")
+	assert.Equal(t, c.Flush() != nil, true)
+
+	assert.Equal(t, c.Record(1, "carol"), true)
+
+	store.err = nil
+	assert.IsNil(t, c.Flush())
+	assert.Equal(t, len(store.flushes), 2)
+	assert.Equal(t, store.flushes[1][1], 2)
+}
+
+func TestCounterForgetsOldViews(t *testing.T) {
+	c := New(&testStore{}, time.Minute)
+
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	c.Record(1, "alice")
+	c.Record(2, "alice")
+
+	now = now.Add(2 * time.Minute)
+	c.Record(3, "alice")
+	assert.IsNil(t, c.Flush())
+
+	assert.Equal(t, len(c.seen), 1)
+}
+
+func TestCounterRun(t *testing.T) {
+	store := &testStore{}
+	c := New(store, time.Hour)
+	c.Record(1, "alice")
+
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		c.Run(time.Hour, done, func(err error) { t.Error(err) })
+		close(finished)
+	}()
+
+	// The counter is flushed when it stops.
+	close(done)
+	<-finished
+
+	assert.Equal(t, len(store.flushes), 1)
+	assert.Equal(t, store.flushes[0][1], 1)
+}
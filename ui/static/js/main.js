@@ -5,4 +5,104 @@ for (var i = 0; i < navLinks.length; i++) {
 		link.classList.add("live");
 		break;
 	}
-}
\ No newline at end of file
+}
+
+// Passkeys. Forms with the passkey class fetch the options of a registration
+// or login from their data-begin URL, pass them to the browser's WebAuthn API,
+// and submit the resulting credential as JSON, in their credential field.
+function base64urlToBuffer(s) {
+	var binary = atob(s.replace(/-/g, "+").replace(/_/g, "/"));
+	var bytes = new Uint8Array(binary.length);
+	for (var i = 0; i < binary.length; i++) {
+		bytes[i] = binary.charCodeAt(i);
+	}
+	return bytes.buffer;
+}
+
+function bufferToBase64url(buffer) {
+	var bytes = new Uint8Array(buffer);
+	var binary = "";
+	for (var i = 0; i < bytes.length; i++) {
+		binary += String.fromCharCode(bytes[i]);
+	}
+	return btoa(binary).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+}
+
+function decodeCredentialList(list) {
+	for (var i = 0; list && i < list.length; i++) {
+		list[i].id = base64urlToBuffer(list[i].id);
+	}
+}
+
+function encodeCredential(credential) {
+	var response = {
+		clientDataJSON: bufferToBase64url(credential.response.clientDataJSON),
+	};
+	if (credential.response.attestationObject) {
+		response.attestationObject = bufferToBase64url(credential.response.attestationObject);
+		if (credential.response.getTransports) {
+			response.transports = credential.response.getTransports();
+		}
+	} else {
+		response.authenticatorData = bufferToBase64url(credential.response.authenticatorData);
+		response.signature = bufferToBase64url(credential.response.signature);
+		if (credential.response.userHandle) {
+			response.userHandle = bufferToBase64url(credential.response.userHandle);
+		}
+	}
+	return JSON.stringify({
+		id: credential.id,
+		rawId: bufferToBase64url(credential.rawId),
+		type: credential.type,
+		authenticatorAttachment: credential.authenticatorAttachment,
+		response: response,
+	});
+}
+
+function setUpPasskeyForm(form) {
+	var error = form.querySelector(".error[hidden]");
+
+	form.hidden = false;
+	form.addEventListener("submit", function (e) {
+		e.preventDefault();
+		error.hidden = true;
+
+		fetch(form.dataset.begin, {
+			method: "POST",
+			headers: { "X-CSRF-Token": form.elements.csrf_token.value },
+			credentials: "same-origin",
+		})
+			.then(function (res) {
+				if (!res.ok) {
+					throw new Error(res.statusText);
+				}
+				return res.json();
+			})
+			.then(function (options) {
+				var publicKey = options.publicKey;
+				publicKey.challenge = base64urlToBuffer(publicKey.challenge);
+				if (publicKey.user) {
+					publicKey.user.id = base64urlToBuffer(publicKey.user.id);
+					decodeCredentialList(publicKey.excludeCredentials);
+					return navigator.credentials.create({ publicKey: publicKey });
+				}
+				decodeCredentialList(publicKey.allowCredentials);
+				return navigator.credentials.get({ publicKey: publicKey });
+			})
+			.then(function (credential) {
+				form.elements.credential.value = encodeCredential(credential);
+				form.submit();
+			})
+			.catch(function () {
+				error.textContent = "Your passkey couldn't be used. Please try again.";
+				error.hidden = false;
+			});
+	});
+}
+
+var passkeyForms = document.querySelectorAll("form.passkey");
+for (var i = 0; i < passkeyForms.length; i++) {
+	if (window.PublicKeyCredential) {
+		setUpPasskeyForm(passkeyForms[i]);
+	}
+}